@@ -16,6 +16,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -43,11 +44,14 @@ import (
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/go-logr/zerologr"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
@@ -213,17 +217,68 @@ func main() {
 					SharedConfigState: session.SharedConfigEnable,
 				}))
 				asgClient := autoscaling.New(sess)
-				spotGuardInstance = spotguard.NewSpotGuard(asgClient, &nthConfig)
+				provider, err := newSpotGuardCapacityProvider(nthConfig, clientset, asgClient, ec2.New(sess), clusterConfig)
+				if err != nil {
+					log.Fatal().Err(err).Msg("Unable to create Spot Guard capacity provider,")
+				}
+				spotGuardInstance, err = spotguard.NewSpotGuard(provider, &nthConfig, metrics, recorder)
+				if err != nil {
+					log.Fatal().Err(err).Msg("Unable to instantiate Spot Guard,")
+				}
 				log.Info().Msgf("Spot Guard enabled - Spot ASG: %s, On-Demand ASG: %s", nthConfig.SpotAsgName, nthConfig.OnDemandAsgName)
+				if len(spotGuardInstance.SpotPools) > 1 {
+					log.Info().Msgf("Spot Guard spot pool chain: %s", nthConfig.SpotGuardSpotPools)
+				}
+
+				ledger, err := newSpotGuardCostLedger(clientset, nthConfig, metrics)
+				if err != nil {
+					log.Fatal().Err(err).Msg("Unable to instantiate Spot Guard cost ledger,")
+				}
+				http.Handle(spotguard.CostLedgerEndpoint, ledger)
+				tracker, err := newSpotGuardFallbackTracker(clientset, nthConfig)
+				if err != nil {
+					log.Fatal().Err(err).Msg("Unable to instantiate Spot Guard fallback tracker,")
+				}
+				spotGuardInstance.Tracker = tracker
+				status := spotguard.NewStatus(nthConfig.NodeName)
+				status.TrackFallbacks(tracker)
+				http.Handle(spotguard.StatusEndpoint, status)
+
+				if nthConfig.EnableSpotGuardPolicies {
+					dynamicClient, err := dynamic.NewForConfig(clusterConfig)
+					if err != nil {
+						log.Fatal().Err(err).Msg("Unable to create dynamic client for Spot Guard policies,")
+					}
+					reconciler := spotguard.NewPolicyReconciler(dynamicClient, provider, clientset, *node, nthConfig, metrics, recorder, ledger)
+					reconciler.ReportStatus(status)
+					reconciler.TrackFallbacks(tracker)
+					go reconciler.Start(context.Background())
+				} else if nthConfig.EnableSpotGuardController {
+					controller := spotguard.NewController(provider, clientset, *node, nthConfig, metrics, recorder, ledger)
+					controller.ReportStatus(status)
+					controller.TrackFallbacks(tracker)
+					go controller.Start(context.Background())
+				}
 
 				// Detect if this pod is running on an on-demand node
-				nodeDetector := spotguard.NewNodeDetector(imds, asgClient, clientset, nthConfig.NodeName)
+				var nodeDetector *spotguard.NodeDetector
+				if nthConfig.SpotGuardCapacityProvider == spotguard.CapacityProviderKarpenter {
+					nodeDetector = spotguard.NewNodeDetector(imds, nil, clientset, nthConfig.NodeName)
+				} else {
+					nodeDetector = spotguard.NewNodeDetector(imds, asgClient, clientset, nthConfig.NodeName)
+					nodeDetector.MixedInstances = nthConfig.SpotGuardMixedInstancesShift != ""
+				}
 				isOnDemandNode, err := nodeDetector.IsOnDemandNode(nthConfig.OnDemandAsgName)
+				status.SetNodeRole(isOnDemandNode, err)
 				if err != nil {
 					log.Warn().
 						Err(err).
 						Str("nodeName", nthConfig.NodeName).
 						Msg("Failed to detect node type, self-monitor will not start")
+				} else if isOnDemandNode && (nthConfig.EnableSpotGuardController || nthConfig.EnableSpotGuardPolicies) {
+					log.Info().
+						Str("nodeName", nthConfig.NodeName).
+						Msg("Detected on-demand node, scale-down is handled by the Spot Guard controller")
 				} else if isOnDemandNode {
 					// This pod is on an on-demand node - start self-monitor
 					log.Info().
@@ -233,7 +288,9 @@ func main() {
 						Str("onDemandASG", nthConfig.OnDemandAsgName).
 						Msg("Detected on-demand node, starting Spot Guard self-monitor")
 
-					selfMonitor := spotguard.NewSelfMonitor(asgClient, clientset, *node, nthConfig)
+					selfMonitor := spotguard.NewSelfMonitor(provider, clientset, *node, nthConfig, metrics, recorder, ledger)
+					selfMonitor.ReportStatus(status)
+					selfMonitor.TrackFallbacks(tracker)
 					go func() {
 						log.Info().Msg("Spot Guard self-monitor started for on-demand node")
 						selfMonitor.Start(context.Background())
@@ -246,7 +303,7 @@ func main() {
 						Msg("Detected spot node, self-monitor will not start (scale-up only mode)")
 
 					// Start CA protection for this spot node
-					caProtector := spotguard.NewCAProtector(clientset, nthConfig.NodeName, nthConfig)
+					caProtector := spotguard.NewCAProtector(clientset, nthConfig.NodeName, nthConfig, metrics, recorder)
 					go func() {
 						log.Info().
 							Msg("Starting Cluster-Autoscaler protection for spot node")
@@ -289,6 +346,24 @@ func main() {
 			EC2:                           ec2Client,
 			BeforeCompleteLifecycleAction: func() { <-time.After(completeLifecycleActionDelay) },
 		}
+		if nthConfig.EnableSpotGuard {
+			provider, err := newSpotGuardCapacityProvider(nthConfig, clientset, sqsMonitor.ASG, ec2Client, clusterConfig)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Unable to create Spot Guard capacity provider,")
+			}
+			spotGuardInstance, err := spotguard.NewSpotGuard(provider, &nthConfig, metrics, recorder)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Unable to instantiate Spot Guard,")
+			}
+			// Launch failures arrive on the queue, so scaling activities are only polled as a safety net
+			spotGuardInstance.LaunchFailureEvents = true
+			spotGuardInstance.Tracker, err = newSpotGuardFallbackTracker(clientset, nthConfig)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Unable to instantiate Spot Guard fallback tracker,")
+			}
+			sqsMonitor.SpotGuard = spotGuardInstance
+			log.Info().Msgf("Spot Guard enabled for queue events - Spot ASG: %s, On-Demand ASG: %s", spotGuardInstance.SpotAsgName, nthConfig.OnDemandAsgName)
+		}
 		monitoringFns[sqsEvents] = sqsMonitor
 	}
 
@@ -384,6 +459,43 @@ func watchForInterruptionEvents(interruptionChan <-chan monitor.InterruptionEven
 	}
 }
 
+// newSpotGuardCapacityProvider creates the Spot Guard capacity provider selected by spot-guard-capacity-provider
+// and spot-guard-mixed-instances-shift
+func newSpotGuardCapacityProvider(nthConfig config.Config, clientset kubernetes.Interface, asgClient autoscalingiface.AutoScalingAPI, ec2Client ec2iface.EC2API, clusterConfig *rest.Config) (spotguard.CapacityProvider, error) {
+	if nthConfig.SpotGuardMixedInstancesShift != "" {
+		return spotguard.NewMixedInstancesCapacityProvider(asgClient, ec2Client, nthConfig.SpotGuardMixedInstancesShift, spotguard.NewShiftLock(clientset, nthConfig)), nil
+	}
+	if nthConfig.SpotGuardCapacityProvider != spotguard.CapacityProviderKarpenter {
+		return spotguard.NewASGCapacityProvider(asgClient, ec2Client), nil
+	}
+	dynamicClient, err := dynamic.NewForConfig(clusterConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create dynamic client for Karpenter: %w", err)
+	}
+	return spotguard.NewKarpenterCapacityProvider(dynamicClient), nil
+}
+
+// newSpotGuardFallbackTracker creates the Spot Guard fallback tracker persisted in a ConfigMap,
+// nil when no ConfigMap is configured since events in memory would not reach the node that is scaled down
+func newSpotGuardFallbackTracker(clientset kubernetes.Interface, nthConfig config.Config) (*spotguard.FallbackTracker, error) {
+	if nthConfig.SpotGuardFallbackStateConfigMap == "" {
+		return nil, nil
+	}
+	return spotguard.NewPersistentFallbackTracker(context.Background(), clientset, nthConfig.PodNamespace, nthConfig.SpotGuardFallbackStateConfigMap)
+}
+
+// newSpotGuardCostLedger creates the Spot Guard cost ledger, persisted in a ConfigMap when one is configured
+func newSpotGuardCostLedger(clientset kubernetes.Interface, nthConfig config.Config, metrics observability.Metrics) (*spotguard.CostLedger, error) {
+	prices, err := spotguard.ParsePriceTable(nthConfig.SpotGuardPriceTable)
+	if err != nil {
+		return nil, err
+	}
+	if nthConfig.SpotGuardCostLedgerConfigMap == "" {
+		return spotguard.NewCostLedger(prices, metrics), nil
+	}
+	return spotguard.NewPersistentCostLedger(context.Background(), clientset, nthConfig.PodNamespace, nthConfig.SpotGuardCostLedgerConfigMap, prices, metrics)
+}
+
 func watchForCancellationEvents(cancelChan <-chan monitor.InterruptionEvent, interruptionEventStore *interruptioneventstore.Store, node *node.Node, metrics observability.Metrics, recorder observability.K8sEventRecorder) {
 	for {
 		interruptionEvent := <-cancelChan
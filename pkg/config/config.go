@@ -176,19 +176,39 @@ type Config struct {
 	HeartbeatUntil                      int
 
 	// Spot Guard configuration
-	EnableSpotGuard                bool
-	SpotAsgName                    string
-	OnDemandAsgName                string
-	SpotGuardScaleTimeout          int
-	SpotGuardCapacityCheckTimeout  int
-	SpotGuardCheckInterval         int
-	SpotGuardMinimumWaitDuration   int
-	SpotGuardSpotStabilityDuration int
-	SpotGuardMaxClusterUtilization int
-	SpotGuardPodEvictionTimeout    int
-	SpotGuardCleanupInterval       int
-	SpotGuardMaxEventAge           int
-	SpotGuardPodMigrationBuffer    int
+	EnableSpotGuard                     bool
+	SpotAsgName                         string
+	SpotGuardSpotPools                  string
+	OnDemandAsgName                     string
+	SpotGuardScaleTimeout               int
+	SpotGuardCapacityCheckTimeout       int
+	SpotGuardCheckInterval              int
+	SpotGuardMinimumWaitDuration        int
+	SpotGuardSpotStabilityDuration      int
+	SpotGuardMaxClusterUtilization      int
+	SpotGuardPodEvictionTimeout         int
+	SpotGuardCleanupInterval            int
+	SpotGuardMaxEventAge                int
+	SpotGuardPodMigrationBuffer         int
+	EnableSpotGuardController           bool
+	SpotGuardLeaseName                  string
+	SpotGuardMaxScaleDownsPerCycle      int
+	EnableSpotGuardPolicies             bool
+	SpotGuardFailureActions             string
+	SpotGuardPriceTable                 string
+	SpotGuardCostLedgerConfigMap        string
+	SpotGuardScaleDownWindows           string
+	SpotGuardScaleDownBlackouts         string
+	SpotGuardScheduleTimezone           string
+	SpotGuardMaxConcurrentDrains        int
+	SpotGuardMaxConcurrentDrainsPercent int
+	SpotGuardMinDrainSpacing            int
+	SpotGuardDrainLeaseName             string
+	SpotGuardCapacityProvider           string
+	SpotGuardMixedInstancesShift        string
+	SpotGuardSkipNodesWithLocalStorage  bool
+	SpotGuardInterruptionWindow         int
+	SpotGuardFallbackStateConfigMap     string
 
 	// Pre-scale configuration
 	EnablePreScale              bool
@@ -269,6 +289,7 @@ func ParseCliArgs() (config Config, err error) {
 	// Spot Guard flags
 	flag.BoolVar(&config.EnableSpotGuard, "enable-spot-guard", getBoolEnv("ENABLE_SPOT_GUARD", false), "If true, enable Spot Guard for automatic on-demand scale-down when spot capacity is restored.")
 	flag.StringVar(&config.SpotAsgName, "spot-asg-name", getEnv("SPOT_ASG_NAME", ""), "The name of the spot Auto Scaling Group to monitor.")
+	flag.StringVar(&config.SpotGuardSpotPools, "spot-guard-spot-pools", getEnv("SPOT_GUARD_SPOT_POOLS", ""), "Comma-separated, ordered list of spot Auto Scaling Groups to try before falling back to on-demand. Each entry may override the capacity check timeout as <asg-name>:<seconds>. Defaults to spot-asg-name.")
 	flag.StringVar(&config.OnDemandAsgName, "on-demand-asg-name", getEnv("ON_DEMAND_ASG_NAME", ""), "The name of the on-demand Auto Scaling Group to scale down.")
 	flag.IntVar(&config.SpotGuardScaleTimeout, "spot-guard-scale-timeout", getIntEnv("SPOT_GUARD_SCALE_TIMEOUT", 120), "Timeout in seconds for ASG scaling operations.")
 	flag.IntVar(&config.SpotGuardCapacityCheckTimeout, "spot-guard-capacity-check-timeout", getIntEnv("SPOT_GUARD_CAPACITY_CHECK_TIMEOUT", 120), "Timeout in seconds for waiting for new instances to reach InService state.")
@@ -280,6 +301,25 @@ func ParseCliArgs() (config Config, err error) {
 	flag.IntVar(&config.SpotGuardCleanupInterval, "spot-guard-cleanup-interval", getIntEnv("SPOT_GUARD_CLEANUP_INTERVAL", 3600), "Interval in seconds for cleaning up old fallback events.")
 	flag.IntVar(&config.SpotGuardMaxEventAge, "spot-guard-max-event-age", getIntEnv("SPOT_GUARD_MAX_EVENT_AGE", 24), "Maximum age in hours for fallback events before cleanup.")
 	flag.IntVar(&config.SpotGuardPodMigrationBuffer, "spot-guard-pod-migration-buffer", getIntEnv("SPOT_GUARD_POD_MIGRATION_BUFFER", 180), "Buffer time in seconds for pod migration after on-demand node drain (added to protection duration).")
+	flag.BoolVar(&config.EnableSpotGuardController, "enable-spot-guard-controller", getBoolEnv("ENABLE_SPOT_GUARD_CONTROLLER", false), "If true, a single leader-elected replica evaluates all on-demand nodes and retires them, instead of a self-monitor on every on-demand node.")
+	flag.StringVar(&config.SpotGuardLeaseName, "spot-guard-lease-name", getEnv("SPOT_GUARD_LEASE_NAME", "aws-node-termination-handler-spot-guard"), "Name of the coordination.k8s.io Lease used to elect the Spot Guard controller, created in pod-namespace.")
+	flag.IntVar(&config.SpotGuardMaxScaleDownsPerCycle, "spot-guard-max-scale-downs-per-cycle", getIntEnv("SPOT_GUARD_MAX_SCALE_DOWNS_PER_CYCLE", 1), "Maximum number of on-demand nodes the Spot Guard controller retires per check cycle.")
+	flag.BoolVar(&config.EnableSpotGuardPolicies, "enable-spot-guard-policies", getBoolEnv("ENABLE_SPOT_GUARD_POLICIES", false), "If true, a leader-elected replica reconciles SpotGuardPolicy resources, running Spot Guard for each ASG pair they describe.")
+	flag.StringVar(&config.SpotGuardFailureActions, "spot-guard-failure-actions", getEnv("SPOT_GUARD_FAILURE_ACTIONS", ""), "Comma-separated <class>=<action> overrides for scale-up failures. Classes: capacity, quota, launch-template, iam, max-size, timeout. Actions: fallback, alert, stop. Defaults: quota=alert, iam=stop, others fallback.")
+	flag.StringVar(&config.SpotGuardPriceTable, "spot-guard-price-table", getEnv("SPOT_GUARD_PRICE_TABLE", ""), "Comma-separated <instance-type>=<on-demand>:<spot> hourly prices in USD used by the Spot Guard cost ledger, e.g. m5.large=0.096:0.035. Instance types not listed are counted in node-hours only.")
+	flag.StringVar(&config.SpotGuardCostLedgerConfigMap, "spot-guard-cost-ledger-configmap", getEnv("SPOT_GUARD_COST_LEDGER_CONFIGMAP", ""), "Name of a ConfigMap in pod-namespace the Spot Guard cost ledger is persisted in, so totals survive restarts and are shared by all replicas. Empty keeps the ledger in memory.")
+	flag.StringVar(&config.SpotGuardScaleDownWindows, "spot-guard-scale-down-windows", getEnv("SPOT_GUARD_SCALE_DOWN_WINDOWS", ""), "Semicolon-separated windows on-demand nodes may be drained in, as <cron>=<duration>, e.g. \"0 22 * * MON-FRI=8h;0 0 * * SAT=48h\". Empty allows draining at any time.")
+	flag.StringVar(&config.SpotGuardScaleDownBlackouts, "spot-guard-scale-down-blackouts", getEnv("SPOT_GUARD_SCALE_DOWN_BLACKOUTS", ""), "Semicolon-separated blackout windows on-demand nodes are never drained in, as <cron>=<duration>. Blackouts win over spot-guard-scale-down-windows.")
+	flag.StringVar(&config.SpotGuardScheduleTimezone, "spot-guard-schedule-timezone", getEnv("SPOT_GUARD_SCHEDULE_TIMEZONE", "UTC"), "IANA time zone the Spot Guard scale-down and blackout windows are evaluated in, e.g. Europe/Berlin.")
+	flag.IntVar(&config.SpotGuardMaxConcurrentDrains, "spot-guard-max-concurrent-drains", getIntEnv("SPOT_GUARD_MAX_CONCURRENT_DRAINS", 0), "Maximum number of on-demand nodes Spot Guard drains at the same time across the cluster. 0 means no limit.")
+	flag.IntVar(&config.SpotGuardMaxConcurrentDrainsPercent, "spot-guard-max-concurrent-drains-percent", getIntEnv("SPOT_GUARD_MAX_CONCURRENT_DRAINS_PERCENT", 0), "Maximum percentage of the on-demand ASG desired capacity Spot Guard drains at the same time, at least one node. 0 means no limit.")
+	flag.IntVar(&config.SpotGuardMinDrainSpacing, "spot-guard-min-drain-spacing", getIntEnv("SPOT_GUARD_MIN_DRAIN_SPACING", 0), "Minimum number of seconds between the start of two Spot Guard drains across the cluster. 0 disables spacing.")
+	flag.StringVar(&config.SpotGuardDrainLeaseName, "spot-guard-drain-lease-name", getEnv("SPOT_GUARD_DRAIN_LEASE_NAME", "aws-node-termination-handler-spot-guard-drains"), "Name of the Lease in pod-namespace that holds the Spot Guard drain slots when concurrent drains are limited or spaced.")
+	flag.StringVar(&config.SpotGuardCapacityProvider, "spot-guard-capacity-provider", getEnv("SPOT_GUARD_CAPACITY_PROVIDER", "asg"), "How Spot Guard adds and removes capacity: asg scales Auto Scaling groups, karpenter creates and deletes NodeClaims of Karpenter NodePools. With karpenter, spot-asg-name, on-demand-asg-name and spot-guard-spot-pools name NodePools.")
+	flag.StringVar(&config.SpotGuardMixedInstancesShift, "spot-guard-mixed-instances-shift", getEnv("SPOT_GUARD_MIXED_INSTANCES_SHIFT", ""), "Fall back within a single MixedInstancesPolicy ASG named by spot-asg-name instead of scaling a separate on-demand ASG: base-capacity raises OnDemandBaseCapacity by one per fallback, percentage raises OnDemandPercentageAboveBaseCapacity to 100. The original values are restored as the extra on-demand instances are retired. Requires the Spot Guard controller or policies.")
+	flag.BoolVar(&config.SpotGuardSkipNodesWithLocalStorage, "spot-guard-skip-nodes-with-local-storage", getBoolEnv("SPOT_GUARD_SKIP_NODES_WITH_LOCAL_STORAGE", true), "If true, Spot Guard keeps on-demand nodes running pods with hostPath or emptyDir volumes, like the Cluster Autoscaler flag of the same name. If false, it only logs a warning.")
+	flag.IntVar(&config.SpotGuardInterruptionWindow, "spot-guard-interruption-window", getIntEnv("SPOT_GUARD_INTERRUPTION_WINDOW", 600), "Seconds a rebalance recommendation or spot interruption event on a spot node keeps spot capacity from being considered stable. Spot nodes tainted by NTH for an interruption block while tainted. 0 disables the interruption check.")
+	flag.StringVar(&config.SpotGuardFallbackStateConfigMap, "spot-guard-fallback-state-configmap", getEnv("SPOT_GUARD_FALLBACK_STATE_CONFIGMAP", ""), "Name of a ConfigMap in pod-namespace Spot Guard persists its fallback events in, so pending fallbacks survive restarts and are shared by all replicas. Empty keeps them in memory.")
 
 	// Pre-scale flags
 	flag.BoolVar(&config.EnablePreScale, "enable-pre-scale", getBoolEnv("ENABLE_PRE_SCALE", false), "If true, enable smart pre-scaling of spot ASG when cluster utilization is too high.")
@@ -355,6 +395,82 @@ func ParseCliArgs() (config Config, err error) {
 		return config, fmt.Errorf("invalid heartbeat configuration: heartbeat-interval should be less than or equal to heartbeat-until")
 	}
 
+	if config.EnableSpotGuardPolicies && config.PodNamespace == "" {
+		return config, fmt.Errorf("invalid spot guard configuration: pod-namespace is required to reconcile spot guard policies")
+	}
+
+	if (config.EnableSpotGuardPolicies || config.EnableSpotGuardController) && config.PodName == "" {
+		return config, fmt.Errorf("invalid spot guard configuration: pod-name is required as the spot guard leader election identity")
+	}
+
+	if config.SpotGuardCostLedgerConfigMap != "" && config.PodNamespace == "" {
+		return config, fmt.Errorf("invalid spot guard configuration: pod-namespace is required to persist the spot guard cost ledger")
+	}
+
+	if config.SpotGuardFallbackStateConfigMap != "" && config.PodNamespace == "" {
+		return config, fmt.Errorf("invalid spot guard configuration: pod-namespace is required to persist the spot guard fallback events")
+	}
+
+	if config.SpotGuardCostLedgerConfigMap != "" && config.SpotGuardCostLedgerConfigMap == config.SpotGuardFallbackStateConfigMap {
+		// The fallback event store owns every key of its ConfigMap and would drop the ledger
+		return config, fmt.Errorf("invalid spot guard configuration: spot-guard-cost-ledger-configmap and spot-guard-fallback-state-configmap must name different ConfigMaps")
+	}
+
+	if config.SpotGuardMaxConcurrentDrains < 0 || config.SpotGuardMinDrainSpacing < 0 {
+		return config, fmt.Errorf("invalid spot guard configuration: spot-guard-max-concurrent-drains and spot-guard-min-drain-spacing must not be negative")
+	}
+
+	if config.SpotGuardMaxConcurrentDrainsPercent < 0 || config.SpotGuardMaxConcurrentDrainsPercent > 100 {
+		return config, fmt.Errorf("invalid spot guard configuration: spot-guard-max-concurrent-drains-percent must be between 0 and 100")
+	}
+
+	if (config.SpotGuardMaxConcurrentDrains > 0 || config.SpotGuardMaxConcurrentDrainsPercent > 0 || config.SpotGuardMinDrainSpacing > 0) && config.PodNamespace == "" {
+		return config, fmt.Errorf("invalid spot guard configuration: pod-namespace is required to limit concurrent spot guard drains")
+	}
+
+	if config.SpotGuardCapacityProvider != "asg" && config.SpotGuardCapacityProvider != "karpenter" {
+		return config, fmt.Errorf("invalid spot-guard-capacity-provider passed: %s  Should be asg or karpenter", config.SpotGuardCapacityProvider)
+	}
+
+	if config.PreScaleFailureFallback != "increase_threshold" && config.PreScaleFailureFallback != "wait" && config.PreScaleFailureFallback != "keep_ondemand" {
+		return config, fmt.Errorf("invalid pre-scale-failure-fallback passed: %s  Should be increase_threshold, wait or keep_ondemand", config.PreScaleFailureFallback)
+	}
+
+	if config.SpotGuardInterruptionWindow < 0 {
+		return config, fmt.Errorf("invalid spot-guard-interruption-window passed: %d  Should not be negative", config.SpotGuardInterruptionWindow)
+	}
+
+	if config.PreScaleRetryBackoffSeconds < 0 {
+		return config, fmt.Errorf("invalid pre-scale-retry-backoff-seconds passed: %d  Should not be negative", config.PreScaleRetryBackoffSeconds)
+	}
+
+	if config.SpotGuardMixedInstancesShift != "" {
+		if config.SpotGuardMixedInstancesShift != "base-capacity" && config.SpotGuardMixedInstancesShift != "percentage" {
+			return config, fmt.Errorf("invalid spot-guard-mixed-instances-shift passed: %s  Should be base-capacity or percentage", config.SpotGuardMixedInstancesShift)
+		}
+		if config.SpotGuardCapacityProvider != "asg" {
+			return config, fmt.Errorf("invalid spot guard configuration: spot-guard-mixed-instances-shift requires the asg capacity provider")
+		}
+		if !config.EnableSpotGuardController && !config.EnableSpotGuardPolicies {
+			return config, fmt.Errorf("invalid spot guard configuration: spot-guard-mixed-instances-shift requires the spot guard controller or policies to pick the on-demand instances to retire")
+		}
+		if config.OnDemandAsgName == "" {
+			config.OnDemandAsgName = config.SpotAsgName
+		}
+		if config.OnDemandAsgName != config.SpotAsgName {
+			return config, fmt.Errorf("invalid spot guard configuration: with spot-guard-mixed-instances-shift, on-demand-asg-name must be empty or the same as spot-asg-name")
+		}
+	}
+
+	if config.EnableSpotGuardController {
+		if config.PodNamespace == "" {
+			return config, fmt.Errorf("invalid spot guard configuration: pod-namespace is required to elect the spot guard controller")
+		}
+		if config.SpotGuardMaxScaleDownsPerCycle < 1 {
+			return config, fmt.Errorf("invalid spot-guard-max-scale-downs-per-cycle passed: %d  Should be at least 1", config.SpotGuardMaxScaleDownsPerCycle)
+		}
+	}
+
 	// client-go expects these to be set in env vars
 	os.Setenv(kubernetesServiceHostConfigKey, config.KubernetesServiceHost)
 	os.Setenv(kubernetesServicePortConfigKey, config.KubernetesServicePort)
@@ -418,6 +534,7 @@ func (c Config) PrintJsonConfigArgs() {
 		Int("heartbeat_until", c.HeartbeatUntil).
 		Bool("enable_spot_guard", c.EnableSpotGuard).
 		Str("spot_asg_name", c.SpotAsgName).
+		Str("spot_guard_spot_pools", c.SpotGuardSpotPools).
 		Str("on_demand_asg_name", c.OnDemandAsgName).
 		Int("spot_guard_check_interval", c.SpotGuardCheckInterval).
 		Int("spot_guard_minimum_wait_duration", c.SpotGuardMinimumWaitDuration).
@@ -425,6 +542,25 @@ func (c Config) PrintJsonConfigArgs() {
 		Int("spot_guard_max_cluster_utilization", c.SpotGuardMaxClusterUtilization).
 		Int("spot_guard_pod_eviction_timeout", c.SpotGuardPodEvictionTimeout).
 		Int("spot_guard_pod_migration_buffer", c.SpotGuardPodMigrationBuffer).
+		Bool("enable_spot_guard_controller", c.EnableSpotGuardController).
+		Str("spot_guard_lease_name", c.SpotGuardLeaseName).
+		Int("spot_guard_max_scale_downs_per_cycle", c.SpotGuardMaxScaleDownsPerCycle).
+		Bool("enable_spot_guard_policies", c.EnableSpotGuardPolicies).
+		Str("spot_guard_failure_actions", c.SpotGuardFailureActions).
+		Str("spot_guard_price_table", c.SpotGuardPriceTable).
+		Str("spot_guard_cost_ledger_configmap", c.SpotGuardCostLedgerConfigMap).
+		Str("spot_guard_scale_down_windows", c.SpotGuardScaleDownWindows).
+		Str("spot_guard_scale_down_blackouts", c.SpotGuardScaleDownBlackouts).
+		Str("spot_guard_schedule_timezone", c.SpotGuardScheduleTimezone).
+		Int("spot_guard_max_concurrent_drains", c.SpotGuardMaxConcurrentDrains).
+		Int("spot_guard_max_concurrent_drains_percent", c.SpotGuardMaxConcurrentDrainsPercent).
+		Int("spot_guard_min_drain_spacing", c.SpotGuardMinDrainSpacing).
+		Str("spot_guard_drain_lease_name", c.SpotGuardDrainLeaseName).
+		Str("spot_guard_capacity_provider", c.SpotGuardCapacityProvider).
+		Str("spot_guard_mixed_instances_shift", c.SpotGuardMixedInstancesShift).
+		Bool("spot_guard_skip_nodes_with_local_storage", c.SpotGuardSkipNodesWithLocalStorage).
+		Int("spot_guard_interruption_window", c.SpotGuardInterruptionWindow).
+		Str("spot_guard_fallback_state_configmap", c.SpotGuardFallbackStateConfigMap).
 		Bool("enable_pre_scale", c.EnablePreScale).
 		Int("pre_scale_timeout_seconds", c.PreScaleTimeoutSeconds).
 		Int("pre_scale_target_utilization", c.PreScaleTargetUtilization).
@@ -489,6 +625,7 @@ func (c Config) PrintHumanConfigArgs() {
 			"\theartbeat-until: %d,\n"+
 			"\tenable-spot-guard: %t,\n"+
 			"\tspot-asg-name: %s,\n"+
+			"\tspot-guard-spot-pools: %s,\n"+
 			"\ton-demand-asg-name: %s,\n"+
 			"\tspot-guard-check-interval: %d,\n"+
 			"\tspot-guard-minimum-wait-duration: %d,\n"+
@@ -496,6 +633,25 @@ func (c Config) PrintHumanConfigArgs() {
 			"\tspot-guard-max-cluster-utilization: %d,\n"+
 			"\tspot-guard-pod-eviction-timeout: %d,\n"+
 			"\tspot-guard-pod-migration-buffer: %d,\n"+
+			"\tenable-spot-guard-controller: %t,\n"+
+			"\tspot-guard-lease-name: %s,\n"+
+			"\tspot-guard-max-scale-downs-per-cycle: %d,\n"+
+			"\tenable-spot-guard-policies: %t,\n"+
+			"\tspot-guard-failure-actions: %s,\n"+
+			"\tspot-guard-price-table: %s,\n"+
+			"\tspot-guard-cost-ledger-configmap: %s,\n"+
+			"\tspot-guard-scale-down-windows: %s,\n"+
+			"\tspot-guard-scale-down-blackouts: %s,\n"+
+			"\tspot-guard-schedule-timezone: %s,\n"+
+			"\tspot-guard-max-concurrent-drains: %d,\n"+
+			"\tspot-guard-max-concurrent-drains-percent: %d,\n"+
+			"\tspot-guard-min-drain-spacing: %d,\n"+
+			"\tspot-guard-drain-lease-name: %s,\n"+
+			"\tspot-guard-capacity-provider: %s,\n"+
+			"\tspot-guard-mixed-instances-shift: %s,\n"+
+			"\tspot-guard-skip-nodes-with-local-storage: %t,\n"+
+			"\tspot-guard-interruption-window: %d,\n"+
+			"\tspot-guard-fallback-state-configmap: %s,\n"+
 			"\tenable-pre-scale: %t,\n"+
 			"\tpre-scale-timeout-seconds: %d,\n"+
 			"\tpre-scale-target-utilization: %d,\n"+
@@ -548,6 +704,7 @@ func (c Config) PrintHumanConfigArgs() {
 		c.HeartbeatUntil,
 		c.EnableSpotGuard,
 		c.SpotAsgName,
+		c.SpotGuardSpotPools,
 		c.OnDemandAsgName,
 		c.SpotGuardCheckInterval,
 		c.SpotGuardMinimumWaitDuration,
@@ -555,6 +712,25 @@ func (c Config) PrintHumanConfigArgs() {
 		c.SpotGuardMaxClusterUtilization,
 		c.SpotGuardPodEvictionTimeout,
 		c.SpotGuardPodMigrationBuffer,
+		c.EnableSpotGuardController,
+		c.SpotGuardLeaseName,
+		c.SpotGuardMaxScaleDownsPerCycle,
+		c.EnableSpotGuardPolicies,
+		c.SpotGuardFailureActions,
+		c.SpotGuardPriceTable,
+		c.SpotGuardCostLedgerConfigMap,
+		c.SpotGuardScaleDownWindows,
+		c.SpotGuardScaleDownBlackouts,
+		c.SpotGuardScheduleTimezone,
+		c.SpotGuardMaxConcurrentDrains,
+		c.SpotGuardMaxConcurrentDrainsPercent,
+		c.SpotGuardMinDrainSpacing,
+		c.SpotGuardDrainLeaseName,
+		c.SpotGuardCapacityProvider,
+		c.SpotGuardMixedInstancesShift,
+		c.SpotGuardSkipNodesWithLocalStorage,
+		c.SpotGuardInterruptionWindow,
+		c.SpotGuardFallbackStateConfigMap,
 		c.EnablePreScale,
 		c.PreScaleTimeoutSeconds,
 		c.PreScaleTargetUtilization,
@@ -17,6 +17,7 @@ import (
 	"bytes"
 	"flag"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-node-termination-handler/pkg/config"
@@ -276,6 +277,16 @@ func TestParseCliArgsCreateFlagsFailure(t *testing.T) {
 	h.Assert(t, err != nil, "Failed to return error when creating flags")
 }
 
+func TestParseCliArgsSharedSpotGuardConfigMapFailure(t *testing.T) {
+	resetFlagsForTest()
+	t.Setenv("NODE_NAME", "node")
+	t.Setenv("NAMESPACE", "kube-system")
+	t.Setenv("SPOT_GUARD_COST_LEDGER_CONFIGMAP", "spot-guard-state")
+	t.Setenv("SPOT_GUARD_FALLBACK_STATE_CONFIGMAP", "spot-guard-state")
+	_, err := config.ParseCliArgs()
+	h.Assert(t, err != nil && strings.Contains(err.Error(), "must name different ConfigMaps"), "Failed to return error when the cost ledger and fallback events share a ConfigMap")
+}
+
 func TestParseCliArgsAWSSession(t *testing.T) {
 	resetFlagsForTest()
 	t.Setenv("ENABLE_SQS_TERMINATION_DRAINING", "true")
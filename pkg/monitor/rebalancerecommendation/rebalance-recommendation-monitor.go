@@ -88,18 +88,21 @@ func (m RebalanceRecommendationMonitor) checkForRebalanceRecommendation() (*moni
 
 	// Create the PreDrainTask based on whether SpotGuard is enabled
 	preDrainTask := setInterruptionTaint
+	var postDrainTask monitor.DrainTask
 	if m.SpotGuard != nil {
 		preDrainTask = m.spotGuardPreDrainTask
+		postDrainTask = m.spotGuardPostDrainTask
 	}
 
 	return &monitor.InterruptionEvent{
-		EventID:      fmt.Sprintf("rebalance-recommendation-%x", hash.Sum(nil)),
-		Kind:         monitor.RebalanceRecommendationKind,
-		Monitor:      RebalanceRecommendationMonitorKind,
-		StartTime:    noticeTime,
-		NodeName:     nodeName,
-		Description:  fmt.Sprintf("Rebalance recommendation received. Instance will be cordoned at %s \n", rebalanceRecommendation.NoticeTime),
-		PreDrainTask: preDrainTask,
+		EventID:       fmt.Sprintf("rebalance-recommendation-%x", hash.Sum(nil)),
+		Kind:          monitor.RebalanceRecommendationKind,
+		Monitor:       RebalanceRecommendationMonitorKind,
+		StartTime:     noticeTime,
+		NodeName:      nodeName,
+		Description:   fmt.Sprintf("Rebalance recommendation received. Instance will be cordoned at %s \n", rebalanceRecommendation.NoticeTime),
+		PreDrainTask:  preDrainTask,
+		PostDrainTask: postDrainTask,
 	}, nil
 }
 
@@ -108,7 +111,7 @@ func (m RebalanceRecommendationMonitor) spotGuardPreDrainTask(interruptionEvent
 	log.Info().Msg("Spot Guard: Starting pre-drain scaling workflow")
 
 	// Step 1: Scale up with fallback
-	err := m.SpotGuard.ScaleUpWithFallback()
+	err := m.SpotGuard.ScaleUpWithFallback(interruptionEvent.NodeName)
 	if err != nil {
 		log.Error().Err(err).Msg("Spot Guard: Failed to scale up replacement capacity")
 		// Continue with tainting even if scaling fails to ensure node is still cordoned
@@ -136,3 +139,9 @@ func setInterruptionTaint(interruptionEvent monitor.InterruptionEvent, n node.No
 
 	return nil
 }
+
+// spotGuardPostDrainTask lets the replacement capacity be disrupted again once the node was drained
+func (m RebalanceRecommendationMonitor) spotGuardPostDrainTask(_ monitor.InterruptionEvent, _ node.Node) error {
+	m.SpotGuard.ReleaseDrainProtection()
+	return nil
+}
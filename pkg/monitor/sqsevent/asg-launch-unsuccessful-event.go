@@ -0,0 +1,93 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package sqsevent
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-node-termination-handler/pkg/monitor"
+	"github.com/aws/aws-node-termination-handler/pkg/spotguard"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/rs/zerolog/log"
+)
+
+/* Example SQS ASG Launch Unsuccessful Event Message:
+{
+  "version": "0",
+  "id": "3e3c153a-8339-4e30-8c35-687ebef853fe",
+  "detail-type": "EC2 Instance Launch Unsuccessful",
+  "source": "aws.autoscaling",
+  "account": "123456789012",
+  "time": "2024-05-14T17:42:11Z",
+  "region": "us-east-1",
+  "resources": [
+    "arn:aws:autoscaling:us-east-1:123456789012:autoScalingGroup:6f3a6e2e-0b7f-4a48-9c2b-4a7d4e4e9c1a:autoScalingGroupName/my-spot-asg"
+  ],
+  "detail": {
+    "StatusCode": "Failed",
+    "AutoScalingGroupName": "my-spot-asg",
+    "ActivityId": "7c6a8b52-e3b1-4c35-9b49-6ab0d0e35f14",
+    "Details": {
+      "Availability Zone": "us-east-1a",
+      "Subnet ID": "subnet-0a1b2c3d"
+    },
+    "RequestId": "7c6a8b52-e3b1-4c35-9b49-6ab0d0e35f14",
+    "StatusMessage": "We currently do not have sufficient m5.large capacity in the Availability Zone you requested (us-east-1a).",
+    "EndTime": "2024-05-14T17:42:11.000Z",
+    "EC2InstanceId": "",
+    "StartTime": "2024-05-14T17:42:10.000Z",
+    "Cause": "At 2024-05-14T17:41:58Z a user request update of AutoScalingGroup constraints to min: 0, max: 5, desired: 3 changing the desired capacity from 2 to 3."
+  }
+}
+*/
+
+// ASGLaunchUnsuccessfulDetailType is the EventBridge detail-type of failed ASG instance launches
+const ASGLaunchUnsuccessfulDetailType = "EC2 Instance Launch Unsuccessful"
+
+// LaunchUnsuccessfulDetail provides the details of an unsuccessful ASG instance launch
+type LaunchUnsuccessfulDetail struct {
+	StatusCode           string `json:"StatusCode"`
+	AutoScalingGroupName string `json:"AutoScalingGroupName"`
+	ActivityID           string `json:"ActivityId"`
+	StatusMessage        string `json:"StatusMessage"`
+	Cause                string `json:"Cause"`
+	EC2InstanceID        string `json:"EC2InstanceId"`
+}
+
+// processLaunchUnsuccessfulEvent hands a failed launch to Spot Guard so a pending scale-up can fall back
+// right away. The event never results in an interruption, so the message is always dropped.
+func (m SQSMonitor) processLaunchUnsuccessfulEvent(event *EventBridgeEvent, message *sqs.Message) (*monitor.InterruptionEvent, error) {
+	launchDetail := &LaunchUnsuccessfulDetail{}
+	if err := json.Unmarshal(event.Detail, launchDetail); err != nil {
+		return nil, skip{fmt.Errorf("unmarshaling message, %s, from ASG launch unsuccessful event: %w", *message.MessageId, err)}
+	}
+
+	log.Info().
+		Str("asgName", launchDetail.AutoScalingGroupName).
+		Str("activityID", launchDetail.ActivityID).
+		Str("statusMessage", launchDetail.StatusMessage).
+		Msg("ASG instance launch unsuccessful")
+
+	if m.SpotGuard != nil {
+		m.SpotGuard.NotifyLaunchFailure(spotguard.LaunchFailure{
+			ASGName:       launchDetail.AutoScalingGroupName,
+			ActivityID:    launchDetail.ActivityID,
+			StatusMessage: launchDetail.StatusMessage,
+			Time:          event.getTime(),
+		})
+	}
+
+	return nil, nil
+}
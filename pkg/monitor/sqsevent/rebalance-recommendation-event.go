@@ -71,6 +71,9 @@ func (m SQSMonitor) rebalanceRecommendationToInterruptionEvent(event *EventBridg
 		Description:          fmt.Sprintf("Rebalance recommendation event received. Instance %s will be cordoned at %s \n", rebalanceRecDetail.InstanceID, event.getTime()),
 	}
 	interruptionEvent.PostDrainTask = func(interruptionEvent monitor.InterruptionEvent, n node.Node) error {
+		if m.SpotGuard != nil && m.SpotGuard.IsSpotPool(interruptionEvent.AutoScalingGroupName) {
+			m.SpotGuard.ReleaseDrainProtection()
+		}
 		errs := m.deleteMessages([]*sqs.Message{message})
 		if errs != nil {
 			return errs[0]
@@ -78,6 +81,14 @@ func (m SQSMonitor) rebalanceRecommendationToInterruptionEvent(event *EventBridg
 		return nil
 	}
 	interruptionEvent.PreDrainTask = func(interruptionEvent monitor.InterruptionEvent, n node.Node) error {
+		if m.SpotGuard != nil && m.SpotGuard.IsSpotPool(interruptionEvent.AutoScalingGroupName) {
+			// The scale-up can take minutes, taint right away so no new pods land on a node that may be reclaimed
+			if m.SpotGuard.StartScaleUpWithFallback(interruptionEvent.NodeName) {
+				log.Info().Str("asgName", interruptionEvent.AutoScalingGroupName).Msg("Spot Guard: Scaling up replacement capacity in the background")
+			} else {
+				log.Info().Str("nodeName", interruptionEvent.NodeName).Msg("Spot Guard: Replacement capacity was already requested for the node, not scaling up again")
+			}
+		}
 		err := n.TaintRebalanceRecommendation(interruptionEvent.NodeName, interruptionEvent.EventID)
 		if err != nil {
 			log.Err(err).Msgf("Unable to taint node with taint %s:%s", node.RebalanceRecommendationTaint, interruptionEvent.EventID)
@@ -20,6 +20,7 @@ import (
 
 	"github.com/aws/aws-node-termination-handler/pkg/logging"
 	"github.com/aws/aws-node-termination-handler/pkg/monitor"
+	"github.com/aws/aws-node-termination-handler/pkg/spotguard"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -54,6 +55,8 @@ type SQSMonitor struct {
 	CheckIfManaged                bool
 	ManagedTag                    string
 	BeforeCompleteLifecycleAction func()
+	// SpotGuard, when set, receives launch failures and scales up replacement capacity on rebalance recommendations
+	SpotGuard *spotguard.SpotGuard
 }
 
 // InterruptionEventWrapper is a convenience wrapper for associating an interruption event with its error, if any
@@ -203,6 +206,10 @@ func (m SQSMonitor) processEventBridgeEvent(eventBridgeEvent *EventBridgeEvent,
 
 	switch eventBridgeEvent.Source {
 	case "aws.autoscaling":
+		if eventBridgeEvent.DetailType == ASGLaunchUnsuccessfulDetailType {
+			interruptionEvent, err = m.processLaunchUnsuccessfulEvent(eventBridgeEvent, message)
+			return append(interruptionEventWrappers, InterruptionEventWrapper{interruptionEvent, err})
+		}
 		lifecycleEvent := LifecycleDetail{}
 		err = json.Unmarshal([]byte(eventBridgeEvent.Detail), &lifecycleEvent)
 		if err != nil {
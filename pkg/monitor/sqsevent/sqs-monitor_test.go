@@ -24,6 +24,7 @@ import (
 	"github.com/aws/aws-node-termination-handler/pkg/monitor"
 	"github.com/aws/aws-node-termination-handler/pkg/monitor/sqsevent"
 	"github.com/aws/aws-node-termination-handler/pkg/node"
+	"github.com/aws/aws-node-termination-handler/pkg/spotguard"
 	h "github.com/aws/aws-node-termination-handler/pkg/test"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -1055,3 +1056,54 @@ func getSQSMessageFromEvent(event sqsevent.EventBridgeEvent) (sqs.Message, error
 	messageId := "d7de6634-f672-ce5c-d87e-ae0b1b5b2510"
 	return sqs.Message{Body: &eventStr, MessageId: &messageId}, nil
 }
+
+var asgLaunchUnsuccessfulEvent = sqsevent.EventBridgeEvent{
+	Version:    "0",
+	ID:         "3e3c153a-8339-4e30-8c35-687ebef853fe",
+	DetailType: "EC2 Instance Launch Unsuccessful",
+	Source:     "aws.autoscaling",
+	Account:    "123456789012",
+	Time:       "2024-05-14T17:42:11Z",
+	Region:     "us-east-1",
+	Resources: []string{
+		"arn:aws:autoscaling:us-east-1:123456789012:autoScalingGroup:6f3a6e2e-0b7f-4a48-9c2b-4a7d4e4e9c1a:autoScalingGroupName/my-spot-asg",
+	},
+	Detail: []byte(`{
+		"StatusCode": "Failed",
+		"AutoScalingGroupName": "my-spot-asg",
+		"ActivityId": "7c6a8b52-e3b1-4c35-9b49-6ab0d0e35f14",
+		"StatusMessage": "We currently do not have sufficient m5.large capacity in the Availability Zone you requested (us-east-1a).",
+		"EC2InstanceId": ""
+	}`),
+}
+
+func TestMonitor_LaunchUnsuccessfulNotifiesSpotGuard(t *testing.T) {
+	msg, err := getSQSMessageFromEvent(asgLaunchUnsuccessfulEvent)
+	h.Ok(t, err)
+	sqsMock := h.MockedSQS{
+		ReceiveMessageResp: sqs.ReceiveMessageOutput{Messages: []*sqs.Message{&msg}},
+	}
+	drainChan := make(chan monitor.InterruptionEvent, 1)
+	spotGuard := &spotguard.SpotGuard{SpotPools: []spotguard.SpotPool{{ASGName: "my-spot-asg"}}}
+
+	sqsMonitor := sqsevent.SQSMonitor{
+		SQS:              sqsMock,
+		QueueURL:         "https://test-queue",
+		InterruptionChan: drainChan,
+		SpotGuard:        spotGuard,
+	}
+
+	err = sqsMonitor.Monitor()
+	h.Ok(t, err)
+
+	select {
+	case result := <-drainChan:
+		h.Ok(t, fmt.Errorf("Did not expect a result on the drain channel: %#v", result))
+	default:
+	}
+
+	failure, found := spotGuard.LatestLaunchFailure("my-spot-asg")
+	h.Assert(t, found, "expected Spot Guard to be notified of the launch failure")
+	h.Equals(t, "7c6a8b52-e3b1-4c35-9b49-6ab0d0e35f14", failure.ActivityID)
+	h.Equals(t, time.Date(2024, 5, 14, 17, 42, 11, 0, time.UTC), failure.Time.UTC())
+}
@@ -62,6 +62,32 @@ const (
 	CancelDrainMsg          = "Early exit task successfully executed"
 )
 
+// Spot Guard event reasons and messages
+const (
+	SpotGuardFallbackReason            = "SpotGuardFallback"
+	SpotGuardFallbackMsgFmt            = "No spot capacity in %s, scaled up on-demand ASG %s"
+	SpotGuardFallbackErrReason         = "SpotGuardFallbackError"
+	SpotGuardFallbackErrMsgFmt         = "There was a problem scaling up replacement capacity: %s"
+	SpotGuardScaleDownBlockedReason    = "SpotGuardScaleDownBlocked"
+	SpotGuardScaleDownBlockedMsgFmt    = "On-demand node cannot be scaled down yet (%s): %s"
+	SpotGuardPreScaleReason            = "SpotGuardPreScale"
+	SpotGuardPreScaleMsgFmt            = "Pre-scale level %d succeeded: %s"
+	SpotGuardPreScaleErrReason         = "SpotGuardPreScaleFailed"
+	SpotGuardPreScaleErrMsgFmt         = "Pre-scale level %d failed: %s"
+	SpotGuardCAProtectionAppliedReason = "SpotGuardCAProtectionApplied"
+	SpotGuardCAProtectionAppliedMsgFmt = "Cluster Autoscaler scale-down protection applied until about %s"
+	SpotGuardCAProtectionRemovedReason = "SpotGuardCAProtectionRemoved"
+	SpotGuardCAProtectionRemovedMsg    = "Cluster Autoscaler scale-down protection removed"
+	SpotGuardScaleDownVerifiedReason   = "SpotGuardScaleDownVerified"
+	SpotGuardScaleDownVerifiedMsgFmt   = "Instance %s confirmed terminating in on-demand ASG %s"
+	SpotGuardScaleDownTimeoutReason    = "SpotGuardScaleDownTimeout"
+	SpotGuardScaleDownTimeoutMsgFmt    = "Instance %s was not seen terminating in on-demand ASG %s: %s"
+	SpotGuardScaleDownErrReason        = "SpotGuardScaleDownError"
+	SpotGuardScaleDownErrMsgFmt        = "There was a problem scaling down the on-demand node: %s"
+	SpotGuardDryRunReason              = "SpotGuardDryRun"
+	SpotGuardDryRunMsgFmt              = "Would have %s, but dry-run flag was set"
+)
+
 // Interruption event reasons
 const (
 	scheduledEventReason          = "ScheduledEvent"
@@ -57,6 +57,7 @@ type Metrics struct {
 	errorEventsCounter      api.Int64Counter
 	nthTaggedNodesGauge     api.Int64Gauge
 	nthTaggedInstancesGauge api.Int64Gauge
+	spotGuard               spotGuardInstruments
 }
 
 // InitMetrics will initialize, register and expose, via http server, the metrics with Opentelemetry.
@@ -208,6 +209,11 @@ func registerMetricsWith(provider *metric.MeterProvider) (Metrics, error) {
 	}
 	nthTaggedInstancesGauge.Record(context.Background(), 0)
 
+	spotGuard, err := registerSpotGuardMetricsWith(meter)
+	if err != nil {
+		return Metrics{}, err
+	}
+
 	return Metrics{
 		meter:                   meter,
 		errorEventsCounter:      errorEventsCounter,
@@ -215,6 +221,7 @@ func registerMetricsWith(provider *metric.MeterProvider) (Metrics, error) {
 		actionsCounterV2:        actionsCounterV2,
 		nthTaggedNodesGauge:     nthTaggedNodesGauge,
 		nthTaggedInstancesGauge: nthTaggedInstancesGauge,
+		spotGuard:               spotGuard,
 	}, nil
 }
 
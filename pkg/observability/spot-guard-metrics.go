@@ -0,0 +1,260 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	api "go.opentelemetry.io/otel/metric"
+)
+
+// Spot Guard metric label values
+const (
+	SpotGuardCapacitySpot     = "spot"
+	SpotGuardCapacityOnDemand = "on-demand"
+
+	SpotGuardOutcomeSuccess = "success"
+	SpotGuardOutcomeFailure = "failure"
+	SpotGuardOutcomeBlocked = "blocked"
+	SpotGuardOutcomeDryRun  = "dry-run"
+
+	SpotGuardCAProtectionApplied = "applied"
+	SpotGuardCAProtectionRemoved = "removed"
+)
+
+var (
+	labelSpotGuardASGKey          = attribute.Key("spotguard/asg")
+	labelSpotGuardCapacityTypeKey = attribute.Key("spotguard/capacity-type")
+	labelSpotGuardOutcomeKey      = attribute.Key("spotguard/outcome")
+	labelSpotGuardReasonKey       = attribute.Key("spotguard/reason")
+	labelSpotGuardLevelKey        = attribute.Key("spotguard/level")
+	labelSpotGuardActionKey       = attribute.Key("spotguard/action")
+	labelSpotGuardInstanceTypeKey = attribute.Key("spotguard/instance-type")
+)
+
+// spotGuardInstruments are the metrics produced by Spot Guard
+type spotGuardInstruments struct {
+	scaleUpAttempts     api.Int64Counter
+	fallbacks           api.Int64Counter
+	timeToInService     api.Float64Histogram
+	onDemandRuntime     api.Float64Histogram
+	scaleDowns          api.Int64Counter
+	preScaleLevels      api.Int64Counter
+	caProtection        api.Int64Gauge
+	caProtectionChanges api.Int64Counter
+	onDemandNodeHours   api.Float64Counter
+	onDemandCost        api.Float64Counter
+	onDemandExtraCost   api.Float64Counter
+}
+
+// SpotGuardScaleUpInc counts a scale-up attempt of one ASG. The outcome is "success" or the failure class.
+func (m Metrics) SpotGuardScaleUpInc(asgName string, capacityType string, outcome string) {
+	if !m.enabled {
+		return
+	}
+	m.spotGuard.scaleUpAttempts.Add(context.Background(), 1, api.WithAttributes(
+		labelSpotGuardASGKey.String(asgName),
+		labelSpotGuardCapacityTypeKey.String(capacityType),
+		labelSpotGuardOutcomeKey.String(outcome),
+	))
+}
+
+// SpotGuardFallbackInc counts a fallback to the on-demand ASG
+func (m Metrics) SpotGuardFallbackInc(onDemandASGName string, outcome string) {
+	if !m.enabled {
+		return
+	}
+	m.spotGuard.fallbacks.Add(context.Background(), 1, api.WithAttributes(
+		labelSpotGuardASGKey.String(onDemandASGName),
+		labelSpotGuardOutcomeKey.String(outcome),
+	))
+}
+
+// SpotGuardTimeToInServiceRecord records how long a scale-up took to deliver an InService instance
+func (m Metrics) SpotGuardTimeToInServiceRecord(asgName string, capacityType string, duration time.Duration) {
+	if !m.enabled {
+		return
+	}
+	m.spotGuard.timeToInService.Record(context.Background(), duration.Seconds(), api.WithAttributes(
+		labelSpotGuardASGKey.String(asgName),
+		labelSpotGuardCapacityTypeKey.String(capacityType),
+	))
+}
+
+// SpotGuardOnDemandRuntimeRecord records how long an on-demand node ran before it was retired
+func (m Metrics) SpotGuardOnDemandRuntimeRecord(onDemandASGName string, runtime time.Duration) {
+	if !m.enabled {
+		return
+	}
+	m.spotGuard.onDemandRuntime.Record(context.Background(), runtime.Seconds(), api.WithAttributes(
+		labelSpotGuardASGKey.String(onDemandASGName),
+	))
+}
+
+// SpotGuardScaleDownInc counts an on-demand scale-down evaluation by outcome and reason
+func (m Metrics) SpotGuardScaleDownInc(outcome string, reason string) {
+	if !m.enabled {
+		return
+	}
+	m.spotGuard.scaleDowns.Add(context.Background(), 1, api.WithAttributes(
+		labelSpotGuardOutcomeKey.String(outcome),
+		labelSpotGuardReasonKey.String(reason),
+	))
+}
+
+// SpotGuardPreScaleInc counts a pre-scale fallback level reached and its outcome
+func (m Metrics) SpotGuardPreScaleInc(level int, outcome string) {
+	if !m.enabled {
+		return
+	}
+	m.spotGuard.preScaleLevels.Add(context.Background(), 1, api.WithAttributes(
+		labelSpotGuardLevelKey.String(strconv.Itoa(level)),
+		labelSpotGuardOutcomeKey.String(outcome),
+	))
+}
+
+// SpotGuardCAProtectionRecord records whether a spot node is protected from Cluster Autoscaler scale-down
+func (m Metrics) SpotGuardCAProtectionRecord(nodeName string, protected bool) {
+	if !m.enabled {
+		return
+	}
+	var value int64
+	if protected {
+		value = 1
+	}
+	m.spotGuard.caProtection.Record(context.Background(), value, api.WithAttributes(labelNodeNameKey.String(nodeName)))
+}
+
+// SpotGuardCAProtectionChangeInc counts Cluster Autoscaler protection being applied or removed
+func (m Metrics) SpotGuardCAProtectionChangeInc(action string) {
+	if !m.enabled {
+		return
+	}
+	m.spotGuard.caProtectionChanges.Add(context.Background(), 1, api.WithAttributes(labelSpotGuardActionKey.String(action)))
+}
+
+// SpotGuardOnDemandCostAdd adds a retired on-demand node to the cost ledger totals.
+// The extra cost is what the node cost on top of the same hours on spot.
+func (m Metrics) SpotGuardOnDemandCostAdd(onDemandASGName string, instanceType string, nodeHours float64, cost float64, extraCost float64) {
+	if !m.enabled {
+		return
+	}
+	attributes := api.WithAttributes(
+		labelSpotGuardASGKey.String(onDemandASGName),
+		labelSpotGuardInstanceTypeKey.String(instanceType),
+	)
+	m.spotGuard.onDemandNodeHours.Add(context.Background(), nodeHours, attributes)
+	m.spotGuard.onDemandCost.Add(context.Background(), cost, attributes)
+	m.spotGuard.onDemandExtraCost.Add(context.Background(), extraCost, attributes)
+}
+
+func registerSpotGuardMetricsWith(meter api.Meter) (spotGuardInstruments, error) {
+	name := "spotguard.scaleup.attempts"
+	scaleUpAttempts, err := meter.Int64Counter(name, api.WithDescription("Number of Spot Guard scale-up attempts by ASG, capacity type and outcome"))
+	if err != nil {
+		return spotGuardInstruments{}, fmt.Errorf("failed to create Prometheus counter %q: %w", name, err)
+	}
+	scaleUpAttempts.Add(context.Background(), 0)
+
+	name = "spotguard.fallbacks"
+	fallbacks, err := meter.Int64Counter(name, api.WithDescription("Number of Spot Guard fallbacks to on-demand capacity"))
+	if err != nil {
+		return spotGuardInstruments{}, fmt.Errorf("failed to create Prometheus counter %q: %w", name, err)
+	}
+	fallbacks.Add(context.Background(), 0)
+
+	name = "spotguard.scaleup.time_to_inservice"
+	timeToInService, err := meter.Float64Histogram(name,
+		api.WithDescription("Time from a Spot Guard scale-up request until a new instance is InService"),
+		api.WithUnit("s"),
+		api.WithExplicitBucketBoundaries(15, 30, 60, 90, 120, 180, 300, 600, 900))
+	if err != nil {
+		return spotGuardInstruments{}, fmt.Errorf("failed to create Prometheus histogram %q: %w", name, err)
+	}
+
+	name = "spotguard.ondemand.runtime"
+	onDemandRuntime, err := meter.Float64Histogram(name,
+		api.WithDescription("How long on-demand nodes ran before Spot Guard retired them"),
+		api.WithUnit("s"),
+		api.WithExplicitBucketBoundaries(600, 1800, 3600, 7200, 14400, 28800, 57600, 86400))
+	if err != nil {
+		return spotGuardInstruments{}, fmt.Errorf("failed to create Prometheus histogram %q: %w", name, err)
+	}
+
+	name = "spotguard.scaledowns"
+	scaleDowns, err := meter.Int64Counter(name, api.WithDescription("Number of on-demand scale-down evaluations by outcome and reason"))
+	if err != nil {
+		return spotGuardInstruments{}, fmt.Errorf("failed to create Prometheus counter %q: %w", name, err)
+	}
+	scaleDowns.Add(context.Background(), 0)
+
+	name = "spotguard.prescale.levels"
+	preScaleLevels, err := meter.Int64Counter(name, api.WithDescription("Number of times each pre-scale fallback level was reached, by outcome"))
+	if err != nil {
+		return spotGuardInstruments{}, fmt.Errorf("failed to create Prometheus counter %q: %w", name, err)
+	}
+	preScaleLevels.Add(context.Background(), 0)
+
+	name = "spotguard.ca_protection"
+	caProtection, err := meter.Int64Gauge(name, api.WithDescription("Whether the spot node is protected from Cluster Autoscaler scale-down (1) or not (0)"))
+	if err != nil {
+		return spotGuardInstruments{}, fmt.Errorf("failed to create Prometheus gauge %q: %w", name, err)
+	}
+
+	name = "spotguard.ca_protection.changes"
+	caProtectionChanges, err := meter.Int64Counter(name, api.WithDescription("Number of times Cluster Autoscaler protection was applied or removed"))
+	if err != nil {
+		return spotGuardInstruments{}, fmt.Errorf("failed to create Prometheus counter %q: %w", name, err)
+	}
+	caProtectionChanges.Add(context.Background(), 0)
+
+	name = "spotguard.ondemand.node_hours"
+	onDemandNodeHours, err := meter.Float64Counter(name, api.WithDescription("On-demand node-hours incurred by Spot Guard fallbacks, counted when the node is retired"))
+	if err != nil {
+		return spotGuardInstruments{}, fmt.Errorf("failed to create Prometheus counter %q: %w", name, err)
+	}
+	onDemandNodeHours.Add(context.Background(), 0)
+
+	name = "spotguard.ondemand.cost_usd"
+	onDemandCost, err := meter.Float64Counter(name, api.WithDescription("Estimated on-demand cost in USD of the node-hours incurred by Spot Guard fallbacks"))
+	if err != nil {
+		return spotGuardInstruments{}, fmt.Errorf("failed to create Prometheus counter %q: %w", name, err)
+	}
+	onDemandCost.Add(context.Background(), 0)
+
+	name = "spotguard.ondemand.extra_cost_usd"
+	onDemandExtraCost, err := meter.Float64Counter(name, api.WithDescription("Estimated extra cost in USD of on-demand fallbacks compared with the same node-hours on spot"))
+	if err != nil {
+		return spotGuardInstruments{}, fmt.Errorf("failed to create Prometheus counter %q: %w", name, err)
+	}
+	onDemandExtraCost.Add(context.Background(), 0)
+
+	return spotGuardInstruments{
+		scaleUpAttempts:     scaleUpAttempts,
+		fallbacks:           fallbacks,
+		timeToInService:     timeToInService,
+		onDemandRuntime:     onDemandRuntime,
+		scaleDowns:          scaleDowns,
+		preScaleLevels:      preScaleLevels,
+		caProtection:        caProtection,
+		caProtectionChanges: caProtectionChanges,
+		onDemandNodeHours:   onDemandNodeHours,
+		onDemandCost:        onDemandCost,
+		onDemandExtraCost:   onDemandExtraCost,
+	}, nil
+}
@@ -0,0 +1,141 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package observability
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func TestSpotGuardScaleUpMetrics(t *testing.T) {
+	metrics := getMetrics(t)
+
+	metrics.SpotGuardScaleUpInc("spot-asg", SpotGuardCapacitySpot, "capacity")
+	metrics.SpotGuardScaleUpInc("od-asg", SpotGuardCapacityOnDemand, SpotGuardOutcomeSuccess)
+	metrics.SpotGuardTimeToInServiceRecord("od-asg", SpotGuardCapacityOnDemand, 45*time.Second)
+	metrics.SpotGuardFallbackInc("od-asg", SpotGuardOutcomeSuccess)
+
+	responseRecorder := mockMetricsRequest()
+
+	validateStatus(t, responseRecorder)
+
+	metricsMap := getMetricsMap(responseRecorder.Body.String())
+
+	validateSpotGuardMetric(t, metricsMap, "spotguard_scaleup_attempts_total",
+		`spotguard_asg="spot-asg",spotguard_capacity_type="spot",spotguard_outcome="capacity"`, "1")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_scaleup_attempts_total",
+		`spotguard_asg="od-asg",spotguard_capacity_type="on-demand",spotguard_outcome="success"`, "1")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_scaleup_time_to_inservice_seconds_count",
+		`spotguard_asg="od-asg",spotguard_capacity_type="on-demand"`, "1")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_scaleup_time_to_inservice_seconds_bucket",
+		`spotguard_asg="od-asg",spotguard_capacity_type="on-demand",le="30"`, "0")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_scaleup_time_to_inservice_seconds_bucket",
+		`spotguard_asg="od-asg",spotguard_capacity_type="on-demand",le="60"`, "1")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_fallbacks_total",
+		`spotguard_asg="od-asg",spotguard_outcome="success"`, "1")
+}
+
+func TestSpotGuardScaleDownMetrics(t *testing.T) {
+	metrics := getMetrics(t)
+
+	metrics.SpotGuardScaleDownInc(SpotGuardOutcomeBlocked, "PDBBlocked")
+	metrics.SpotGuardScaleDownInc(SpotGuardOutcomeBlocked, "PDBBlocked")
+	metrics.SpotGuardScaleDownInc(SpotGuardOutcomeSuccess, "Completed")
+	metrics.SpotGuardOnDemandRuntimeRecord("od-asg", 2*time.Hour)
+	metrics.SpotGuardPreScaleInc(2, SpotGuardOutcomeSuccess)
+
+	responseRecorder := mockMetricsRequest()
+
+	validateStatus(t, responseRecorder)
+
+	metricsMap := getMetricsMap(responseRecorder.Body.String())
+
+	validateSpotGuardMetric(t, metricsMap, "spotguard_scaledowns_total",
+		`spotguard_outcome="blocked",spotguard_reason="PDBBlocked"`, "2")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_scaledowns_total",
+		`spotguard_outcome="success",spotguard_reason="Completed"`, "1")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_ondemand_runtime_seconds_sum",
+		`spotguard_asg="od-asg"`, "7200")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_prescale_levels_total",
+		`spotguard_level="2",spotguard_outcome="success"`, "1")
+}
+
+func TestSpotGuardOnDemandCostMetrics(t *testing.T) {
+	metrics := getMetrics(t)
+
+	metrics.SpotGuardOnDemandCostAdd("od-asg", "m5.large", 2, 0.25, 0.125)
+	metrics.SpotGuardOnDemandCostAdd("od-asg", "m5.large", 1, 0.125, 0.0625)
+
+	responseRecorder := mockMetricsRequest()
+
+	validateStatus(t, responseRecorder)
+
+	metricsMap := getMetricsMap(responseRecorder.Body.String())
+
+	labels := `spotguard_asg="od-asg",spotguard_instance_type="m5.large"`
+	validateSpotGuardMetric(t, metricsMap, "spotguard_ondemand_node_hours_total", labels, "3")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_ondemand_cost_usd_total", labels, "0.375")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_ondemand_extra_cost_usd_total", labels, "0.1875")
+}
+
+func TestSpotGuardCAProtectionMetrics(t *testing.T) {
+	metrics := getMetrics(t)
+
+	metrics.SpotGuardCAProtectionRecord(mockNodeName1, true)
+	metrics.SpotGuardCAProtectionChangeInc(SpotGuardCAProtectionApplied)
+	metrics.SpotGuardCAProtectionRecord(mockNodeName1, false)
+	metrics.SpotGuardCAProtectionChangeInc(SpotGuardCAProtectionRemoved)
+
+	responseRecorder := mockMetricsRequest()
+
+	validateStatus(t, responseRecorder)
+
+	metricsMap := getMetricsMap(responseRecorder.Body.String())
+
+	caProtectionKey := fmt.Sprintf("spotguard_ca_protection{node_name=\"%v\",otel_scope_name=\"%v\",otel_scope_version=\"\"}", mockNodeName1, mockNth)
+	h.Equals(t, "0", metricsMap[caProtectionKey])
+	validateSpotGuardMetric(t, metricsMap, "spotguard_ca_protection_changes_total", `spotguard_action="applied"`, "1")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_ca_protection_changes_total", `spotguard_action="removed"`, "1")
+}
+
+func TestSpotGuardMetricsDisabled(t *testing.T) {
+	metrics := getMetrics(t)
+	metrics.enabled = false
+
+	metrics.SpotGuardScaleUpInc("spot-asg", SpotGuardCapacitySpot, SpotGuardOutcomeSuccess)
+	metrics.SpotGuardScaleDownInc(SpotGuardOutcomeBlocked, "PDBBlocked")
+
+	responseRecorder := mockMetricsRequest()
+
+	validateStatus(t, responseRecorder)
+
+	metricsMap := getMetricsMap(responseRecorder.Body.String())
+
+	validateSpotGuardMetric(t, metricsMap, "spotguard_scaleup_attempts_total",
+		`spotguard_asg="spot-asg",spotguard_capacity_type="spot",spotguard_outcome="success"`, "0")
+	validateSpotGuardMetric(t, metricsMap, "spotguard_scaledowns_total",
+		`spotguard_outcome="blocked",spotguard_reason="PDBBlocked"`, "0")
+}
+
+func validateSpotGuardMetric(t *testing.T, metricsMap map[string]string, name string, labels string, expectedValue string) {
+	key := fmt.Sprintf("%v{otel_scope_name=\"%v\",otel_scope_version=\"\",%v}", name, mockNth, labels)
+	actualValue, exists := metricsMap[key]
+	if !exists {
+		actualValue = "0"
+	}
+	h.Equals(t, expectedValue, actualValue)
+}
@@ -0,0 +1,113 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Cluster Autoscaler pod annotations honored by the drain safety check
+const (
+	// AnnotationCASafeToEvict set to "false" keeps the node of the pod; "true" lets the pod go
+	// even with local storage or without a controller
+	AnnotationCASafeToEvict = "cluster-autoscaler.kubernetes.io/safe-to-evict"
+	// AnnotationCASafeToEvictLocalVolumes lists, comma-separated, the local volumes of a pod that may be lost
+	AnnotationCASafeToEvictLocalVolumes = "cluster-autoscaler.kubernetes.io/safe-to-evict-local-volumes"
+	// annotationMirrorPod marks the API mirror of a static pod, which is never evicted
+	annotationMirrorPod = "kubernetes.io/config.mirror"
+)
+
+// evictionRules decides which pods keep their node the way Cluster Autoscaler does.
+// Local storage only logs a warning when blockLocalStorage is off, like --skip-nodes-with-local-storage=false.
+type evictionRules struct {
+	blockLocalStorage bool
+}
+
+// defaultEvictionRules are the Cluster Autoscaler defaults
+var defaultEvictionRules = evictionRules{blockLocalStorage: true}
+
+// evictionRulesFromConfig returns the eviction rules of the Spot Guard flags
+func evictionRulesFromConfig(nthConfig config.Config) evictionRules {
+	return evictionRules{blockLocalStorage: nthConfig.SpotGuardSkipNodesWithLocalStorage}
+}
+
+// check returns why the pod keeps the node. When blocked is false, a non-empty reason is a warning.
+func (r evictionRules) check(pod *corev1.Pod) (blocked bool, reason string) {
+	switch pod.Annotations[AnnotationCASafeToEvict] {
+	case "true":
+		return false, ""
+	case "false":
+		return true, fmt.Sprintf("pod is annotated %s=false", AnnotationCASafeToEvict)
+	}
+
+	if !hasController(pod) {
+		return true, "pod is not replicated: it has no controller and would not be recreated"
+	}
+
+	if volumes := localVolumes(pod); len(volumes) > 0 {
+		return r.blockLocalStorage, fmt.Sprintf("pod uses local storage (%s) that would be lost", strings.Join(volumes, ", "))
+	}
+
+	return false, ""
+}
+
+// isMirrorPod reports whether the pod is the mirror of a static pod
+func isMirrorPod(pod *corev1.Pod) bool {
+	_, mirror := pod.Annotations[annotationMirrorPod]
+	return mirror
+}
+
+// hasController reports whether the pod has a controller that recreates it elsewhere
+func hasController(pod *corev1.Pod) bool {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller != nil && *owner.Controller {
+			return true
+		}
+	}
+	return false
+}
+
+// localVolumes returns the hostPath and disk-backed emptyDir volumes of a pod
+// that are not listed in its safe-to-evict-local-volumes annotation
+func localVolumes(pod *corev1.Pod) []string {
+	allowed := make(map[string]bool)
+	for _, name := range strings.Split(pod.Annotations[AnnotationCASafeToEvictLocalVolumes], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			allowed[name] = true
+		}
+	}
+
+	volumes := make([]string, 0)
+	for _, volume := range pod.Spec.Volumes {
+		isLocal := volume.HostPath != nil || (volume.EmptyDir != nil && volume.EmptyDir.Medium != corev1.StorageMediumMemory)
+		if isLocal && !allowed[volume.Name] {
+			volumes = append(volumes, volume.Name)
+		}
+	}
+	return volumes
+}
+
+// isScaleDownDisabled reports whether the node is annotated scale-down-disabled by someone else than
+// Spot Guard's own Cluster Autoscaler protection, which is always accompanied by AnnotationCAProtectedUntil
+func isScaleDownDisabled(node *corev1.Node) bool {
+	if node.Annotations[AnnotationCAScaleDownDisabled] != "true" {
+		return false
+	}
+	_, ownProtection := node.Annotations[AnnotationCAProtectedUntil]
+	return !ownProtection
+}
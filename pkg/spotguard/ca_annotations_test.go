@@ -0,0 +1,97 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func TestEvictionRulesCheck(t *testing.T) {
+	pod := readyPod("web-0", "od-1", "web", true)
+	blocked, reason := defaultEvictionRules.check(&pod)
+	h.Assert(t, !blocked && reason == "", "a replicated pod without local storage can be evicted, got %q", reason)
+
+	pod.Annotations = map[string]string{AnnotationCASafeToEvict: "false"}
+	blocked, reason = defaultEvictionRules.check(&pod)
+	h.Assert(t, blocked, "safe-to-evict=false keeps the node")
+	h.Equals(t, ScaleDownReasonNotSafeToEvict, drainBlockReason(reason))
+
+	bare := simPod("job-0", "od-1", "100m", nil)
+	blocked, reason = defaultEvictionRules.check(&bare)
+	h.Assert(t, blocked, "a pod without a controller keeps the node")
+	h.Equals(t, ScaleDownReasonNotReplicated, drainBlockReason(reason))
+
+	bare.Annotations = map[string]string{AnnotationCASafeToEvict: "true"}
+	blocked, _ = defaultEvictionRules.check(&bare)
+	h.Assert(t, !blocked, "safe-to-evict=true overrides the controller rule")
+}
+
+func TestEvictionRulesLocalStorage(t *testing.T) {
+	pod := readyPod("web-0", "od-1", "web", true)
+	pod.Spec.Volumes = []corev1.Volume{
+		{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		{Name: "tmpfs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}}},
+		{Name: "logs", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log"}}},
+	}
+
+	blocked, reason := defaultEvictionRules.check(&pod)
+	h.Assert(t, blocked, "disk-backed local volumes keep the node")
+	h.Equals(t, ScaleDownReasonLocalStorage, drainBlockReason(reason))
+	h.Assert(t, strings.Contains(reason, "cache, logs"), reason)
+
+	blocked, reason = evictionRules{blockLocalStorage: false}.check(&pod)
+	h.Assert(t, !blocked && reason != "", "local storage is only a warning when not blocking, got %q", reason)
+
+	pod.Annotations = map[string]string{AnnotationCASafeToEvictLocalVolumes: "cache, logs"}
+	blocked, reason = defaultEvictionRules.check(&pod)
+	h.Assert(t, !blocked && reason == "", "volumes listed as safe to lose do not block, got %q", reason)
+}
+
+func TestCanSafelyDrainNodeCAAnnotations(t *testing.T) {
+	ctx := context.Background()
+	spare := simNode("spot-1", "us-east-1a", "4", "8Gi")
+
+	// A node someone excluded from Cluster Autoscaler scale-down is kept
+	disabled := simNode("od-1", "us-east-1a", "4", "8Gi")
+	disabled.Annotations = map[string]string{AnnotationCAScaleDownDisabled: "true"}
+	canDrain, reason := NewSafetyChecker(fake.NewSimpleClientset(&disabled, &spare), 100).CanSafelyDrainNode(ctx, "od-1", nil)
+	h.Assert(t, !canDrain, "a scale-down-disabled node must not be drained")
+	h.Equals(t, ScaleDownReasonScaleDownDisabled, drainBlockReason(reason))
+
+	// Spot Guard's own protection annotation does not count
+	disabled.Annotations[AnnotationCAProtectedUntil] = "2026-01-01T00:00:00Z"
+	canDrain, reason = NewSafetyChecker(fake.NewSimpleClientset(&disabled, &spare), 100).CanSafelyDrainNode(ctx, "od-1", nil)
+	h.Assert(t, canDrain, "Spot Guard's own protection must not block, got %q", reason)
+
+	// A pod marked unevictable keeps the node
+	drained := simNode("od-2", "us-east-1a", "4", "8Gi")
+	pod := readyPod("web-0", "od-2", "web", true)
+	pod.Annotations = map[string]string{AnnotationCASafeToEvict: "false"}
+	canDrain, reason = NewSafetyChecker(fake.NewSimpleClientset(&drained, &spare, &pod), 100).CanSafelyDrainNode(ctx, "od-2", nil)
+	h.Assert(t, !canDrain, "a safe-to-evict=false pod must block the drain")
+	h.Equals(t, ScaleDownReasonNotSafeToEvict, drainBlockReason(reason))
+
+	// Mirror pods are owned by the kubelet and ignored
+	mirror := simPod("kube-proxy-od-2", "od-2", "100m", nil)
+	mirror.Annotations = map[string]string{annotationMirrorPod: "abc"}
+	canDrain, reason = NewSafetyChecker(fake.NewSimpleClientset(&drained, &spare, &mirror), 100).CanSafelyDrainNode(ctx, "od-2", nil)
+	h.Assert(t, canDrain, "mirror pods must not block, got %q", reason)
+}
@@ -15,53 +15,97 @@ package spotguard
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-node-termination-handler/pkg/config"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
 	"github.com/rs/zerolog/log"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 )
 
 const (
 	// Cluster Autoscaler annotation to prevent scale-down
 	AnnotationCAScaleDownDisabled = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
 
-	// NTH annotation to track approximate protection expiry time
-	// Note: Actual removal may be up to 5 minutes later due to check interval
+	// NTH annotation marking the protection as Spot Guard's own, with the approximate end of the pending drains.
+	// Protection is released as soon as no drain is pending, whatever the time.
 	AnnotationCAProtectedUntil = "nth.aws.amazon.com/ca-protected-until-approx"
 )
 
-// CAProtector manages Cluster Autoscaler protection for spot nodes
+// CAProtector keeps Cluster Autoscaler from removing the current spot node while Spot Guard
+// still has to drain an on-demand node onto it. It watches its own node and the labeled on-demand
+// nodes, not every node of the cluster, and reacts to every change.
 type CAProtector struct {
 	clientset kubernetes.Interface
 	nodeName  string
 	config    config.Config
+	metrics   observability.Metrics
+	recorder  observability.K8sEventRecorder
+	changed   chan struct{}
+	// dryRunProtected is the protection last reported in dry-run, so it is logged once per change
+	dryRunProtected *bool
 }
 
 // NewCAProtector creates a new CA protector for the current spot node
-func NewCAProtector(clientset kubernetes.Interface, nodeName string, nthConfig config.Config) *CAProtector {
+func NewCAProtector(clientset kubernetes.Interface, nodeName string, nthConfig config.Config, metrics observability.Metrics, recorder observability.K8sEventRecorder) *CAProtector {
 	return &CAProtector{
 		clientset: clientset,
 		nodeName:  nodeName,
 		config:    nthConfig,
+		metrics:   metrics,
+		recorder:  recorder,
+		changed:   make(chan struct{}, 1),
 	}
 }
 
-// Start begins the CA protection monitoring loop
+// Start watches the spot node and the on-demand nodes and applies or removes the protection whenever
+// a drain starts or ends. It blocks until the context is cancelled.
 func (cp *CAProtector) Start(ctx context.Context) {
 	log.Info().
 		Str("nodeName", cp.nodeName).
 		Msg("Starting Cluster Autoscaler protection for spot node")
 
-	// Apply protection immediately on startup
-	cp.checkAndApplyProtection(ctx)
+	// Every spot node runs a protector, so each one only watches its own node and the few on-demand nodes
+	selfFactory := informers.NewSharedInformerFactoryWithOptions(cp.clientset, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", cp.nodeName).String()
+	}))
+	onDemandFactory := informers.NewSharedInformerFactoryWithOptions(cp.clientset, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = LabelOnDemandFallback + "=true"
+	}))
+	selfInformer := selfFactory.Core().V1().Nodes()
+	onDemandInformer := onDemandFactory.Core().V1().Nodes()
+	for _, informer := range []cache.SharedIndexInformer{selfInformer.Informer(), onDemandInformer.Informer()} {
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { cp.notify() },
+			UpdateFunc: func(_, _ interface{}) { cp.notify() },
+			DeleteFunc: func(interface{}) { cp.notify() },
+		})
+		if err != nil {
+			log.Error().Err(err).Str("nodeName", cp.nodeName).Msg("Failed to watch nodes for CA protection")
+			return
+		}
+	}
 
-	// Then check every 5 minutes
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+	selfFactory.Start(ctx.Done())
+	onDemandFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), selfInformer.Informer().HasSynced, onDemandInformer.Informer().HasSynced) {
+		log.Info().Str("nodeName", cp.nodeName).Msg("CA protection stopped")
+		return
+	}
 
+	selfLister := selfInformer.Lister()
+	onDemandLister := onDemandInformer.Lister()
 	for {
 		select {
 		case <-ctx.Done():
@@ -69,68 +113,132 @@ func (cp *CAProtector) Start(ctx context.Context) {
 				Str("nodeName", cp.nodeName).
 				Msg("CA protection stopped")
 			return
-		case <-ticker.C:
-			cp.checkAndApplyProtection(ctx)
+		case <-cp.changed:
+			nodes, err := onDemandLister.List(labels.Everything())
+			if err != nil {
+				log.Warn().Err(err).Str("nodeName", cp.nodeName).Msg("Failed to list nodes for CA protection check")
+				continue
+			}
+			self, err := selfLister.Get(cp.nodeName)
+			if err != nil && !apierrors.IsNotFound(err) {
+				log.Warn().Err(err).Str("nodeName", cp.nodeName).Msg("Failed to get spot node for CA protection check")
+				continue
+			}
+			if self != nil {
+				nodes = append(nodes, self)
+			}
+			cp.reconcile(ctx, nodes)
 		}
 	}
 }
 
-// checkAndApplyProtection checks if protection is needed and applies/removes it
-func (cp *CAProtector) checkAndApplyProtection(ctx context.Context) {
-	node, err := cp.clientset.CoreV1().Nodes().Get(ctx, cp.nodeName, metav1.GetOptions{})
-	if err != nil {
+// notify queues a reconcile, coalescing the node events that arrive while one is pending
+func (cp *CAProtector) notify() {
+	select {
+	case cp.changed <- struct{}{}:
+	default:
+	}
+}
+
+// reconcile protects the spot node while any on-demand node has a Spot Guard drain pending or in progress
+func (cp *CAProtector) reconcile(ctx context.Context, nodes []*corev1.Node) {
+	var self *corev1.Node
+	var protectedUntil time.Time
+	pending := 0
+	for _, node := range nodes {
+		if node.Name == cp.nodeName {
+			self = node
+			continue
+		}
+		if until, ok := cp.pendingDrainUntil(node); ok {
+			pending++
+			if until.After(protectedUntil) {
+				protectedUntil = until
+			}
+		}
+	}
+	if self == nil {
 		log.Warn().
-			Err(err).
 			Str("nodeName", cp.nodeName).
-			Msg("Failed to get node for CA protection check")
+			Msg("Spot node not found for CA protection check")
 		return
 	}
 
-	// Calculate protection duration
-	// Total = spotStabilityDuration + minimumWaitDuration + podMigrationBuffer
-	protectionDuration := time.Duration(
-		cp.config.SpotGuardSpotStabilityDuration+
-			cp.config.SpotGuardMinimumWaitDuration+
-			cp.config.SpotGuardPodMigrationBuffer,
-	) * time.Second
-
-	// Calculate when protection should expire (based on node creation time)
-	protectedUntil := node.CreationTimestamp.Add(protectionDuration)
-	now := time.Now()
-
-	// Check if node should be protected
-	if now.Before(protectedUntil) {
-		// Node should be protected
-		cp.applyProtection(ctx, node, protectedUntil)
+	if pending > 0 {
+		log.Debug().
+			Str("nodeName", cp.nodeName).
+			Int("pendingDrains", pending).
+			Time("protectedUntil", protectedUntil).
+			Msg("Spot Guard drains pending, spot node needs CA protection")
+		cp.applyProtection(ctx, self, protectedUntil)
 	} else {
-		// Node protection should be removed
-		cp.removeProtection(ctx, node)
+		cp.removeProtection(ctx, self)
 	}
 }
 
-// applyProtection applies CA scale-down protection to the node
-func (cp *CAProtector) applyProtection(ctx context.Context, node *corev1.Node, protectedUntil time.Time) {
-	if node.Annotations == nil {
-		node.Annotations = make(map[string]string)
+// pendingDrainUntil returns when the Spot Guard drain of an on-demand node is expected to be over.
+// It returns false when the node has no drain pending, or one for another spot ASG.
+func (cp *CAProtector) pendingDrainUntil(node *corev1.Node) (time.Time, bool) {
+	startTime, err := time.Parse(time.RFC3339, node.Annotations[AnnotationStartTime])
+	if err != nil {
+		return time.Time{}, false
+	}
+	if spotASG := node.Annotations[AnnotationSpotASG]; spotASG != "" && cp.config.SpotAsgName != "" && spotASG != cp.config.SpotAsgName {
+		return time.Time{}, false
 	}
 
-	// Check if already protected
-	existingProtection := node.Annotations[AnnotationCAScaleDownDisabled]
-	if existingProtection == "true" {
-		// Already protected, no update needed
+	podMigrationBuffer := time.Duration(cp.config.SpotGuardPodMigrationBuffer) * time.Second
+	if drainStart, err := time.Parse(time.RFC3339, node.Annotations[AnnotationScaleDownDone]); err == nil {
+		// Draining, the pods need the spot node until they are running again
+		return drainStart.Add(podMigrationBuffer), true
+	}
+
+	// Waiting for the scale-down, which comes no sooner than the minimum wait and spot stability
+	return startTime.Add(time.Duration(
+		cp.config.SpotGuardSpotStabilityDuration+cp.config.SpotGuardMinimumWaitDuration,
+	)*time.Second + podMigrationBuffer), true
+}
+
+// applyProtection applies CA scale-down protection to the node with a strategic merge patch
+func (cp *CAProtector) applyProtection(ctx context.Context, node *corev1.Node, protectedUntil time.Time) {
+	until := protectedUntil.Format(time.RFC3339)
+	current, ownProtection := node.Annotations[AnnotationCAProtectedUntil]
+	if node.Annotations[AnnotationCAScaleDownDisabled] == "true" && (!ownProtection || current == until) {
+		// Already protected, by Spot Guard or by someone else whose annotation is left alone
 		log.Debug().
 			Str("nodeName", cp.nodeName).
 			Time("protectedUntil", protectedUntil).
+			Bool("ownProtection", ownProtection).
 			Msg("CA protection already applied")
+		cp.metrics.SpotGuardCAProtectionRecord(cp.nodeName, true)
 		return
 	}
 
-	// Apply protection annotations
-	node.Annotations[AnnotationCAScaleDownDisabled] = "true"
-	node.Annotations[AnnotationCAProtectedUntil] = protectedUntil.Format(time.RFC3339)
+	if cp.config.DryRun {
+		if cp.dryRunProtected == nil || !*cp.dryRunProtected {
+			log.Info().
+				Str("nodeName", cp.nodeName).
+				Time("protectedUntil", protectedUntil).
+				Msg("CA scale-down protection would have been applied, but dry-run flag was set")
+		}
+		protected := true
+		cp.dryRunProtected = &protected
+		return
+	}
 
-	_, err := cp.clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				AnnotationCAScaleDownDisabled: "true",
+				AnnotationCAProtectedUntil:    until,
+			},
+		},
+	})
 	if err != nil {
+		log.Warn().Err(err).Str("nodeName", cp.nodeName).Msg("Failed to build CA protection patch")
+		return
+	}
+	if _, err := cp.clientset.CoreV1().Nodes().Patch(ctx, cp.nodeName, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
 		log.Warn().
 			Err(err).
 			Str("nodeName", cp.nodeName).
@@ -138,33 +246,67 @@ func (cp *CAProtector) applyProtection(ctx context.Context, node *corev1.Node, p
 		return
 	}
 
+	if ownProtection {
+		log.Debug().
+			Str("nodeName", cp.nodeName).
+			Time("protectedUntil", protectedUntil).
+			Msg("Extended CA scale-down protection of spot node")
+		return
+	}
+
 	log.Info().
 		Str("nodeName", cp.nodeName).
+		Time("protectedUntil", protectedUntil).
 		Msg("Applied CA scale-down protection to spot node")
+	cp.metrics.SpotGuardCAProtectionRecord(cp.nodeName, true)
+	cp.metrics.SpotGuardCAProtectionChangeInc(observability.SpotGuardCAProtectionApplied)
+	cp.recorder.Emit(cp.nodeName, observability.Normal, observability.SpotGuardCAProtectionAppliedReason,
+		observability.SpotGuardCAProtectionAppliedMsgFmt, until)
 }
 
-// removeProtection removes CA scale-down protection from the node
+// removeProtection removes Spot Guard's CA scale-down protection from the node with a JSON patch.
+// The patch only applies while the protection is still the one Spot Guard set.
 func (cp *CAProtector) removeProtection(ctx context.Context, node *corev1.Node) {
-	if node.Annotations == nil {
-		return
-	}
-
-	// Check if protection exists
-	_, hasProtection := node.Annotations[AnnotationCAScaleDownDisabled]
-	if !hasProtection {
-		// Not protected, no update needed
+	until, ownProtection := node.Annotations[AnnotationCAProtectedUntil]
+	if !ownProtection {
+		// Not protected by Spot Guard, an annotation set by someone else is left alone
 		log.Debug().
 			Str("nodeName", cp.nodeName).
 			Msg("CA protection not present (already removed or never applied)")
+		cp.metrics.SpotGuardCAProtectionRecord(cp.nodeName, false)
 		return
 	}
 
-	// Remove protection annotations
-	delete(node.Annotations, AnnotationCAScaleDownDisabled)
-	delete(node.Annotations, AnnotationCAProtectedUntil)
+	if cp.config.DryRun {
+		if cp.dryRunProtected == nil || *cp.dryRunProtected {
+			log.Info().
+				Str("nodeName", cp.nodeName).
+				Msg("CA scale-down protection would have been removed, but dry-run flag was set")
+		}
+		protected := false
+		cp.dryRunProtected = &protected
+		return
+	}
 
-	_, err := cp.clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+	type patchRequest struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value string `json:"value,omitempty"`
+	}
+	patchReqs := []patchRequest{
+		{Op: "test", Path: annotationPatchPath(AnnotationCAProtectedUntil), Value: until},
+		{Op: "remove", Path: annotationPatchPath(AnnotationCAProtectedUntil)},
+	}
+	if _, disabled := node.Annotations[AnnotationCAScaleDownDisabled]; disabled {
+		patchReqs = append(patchReqs, patchRequest{Op: "remove", Path: annotationPatchPath(AnnotationCAScaleDownDisabled)})
+	}
+	patch, err := json.Marshal(patchReqs)
 	if err != nil {
+		log.Warn().Err(err).Str("nodeName", cp.nodeName).Msg("Failed to build CA protection patch")
+		return
+	}
+	if _, err := cp.clientset.CoreV1().Nodes().Patch(ctx, cp.nodeName, types.JSONPatchType, patch, metav1.PatchOptions{}); err != nil {
+		// A failed test means the protection changed meanwhile; the resulting node event retries
 		log.Warn().
 			Err(err).
 			Str("nodeName", cp.nodeName).
@@ -174,5 +316,13 @@ func (cp *CAProtector) removeProtection(ctx context.Context, node *corev1.Node)
 
 	log.Info().
 		Str("nodeName", cp.nodeName).
-		Msg("✅ Removed CA scale-down protection from spot node (protection period expired)")
+		Msg("✅ Removed CA scale-down protection from spot node (no Spot Guard drain pending)")
+	cp.metrics.SpotGuardCAProtectionRecord(cp.nodeName, false)
+	cp.metrics.SpotGuardCAProtectionChangeInc(observability.SpotGuardCAProtectionRemoved)
+	cp.recorder.Emit(cp.nodeName, observability.Normal, observability.SpotGuardCAProtectionRemovedReason, observability.SpotGuardCAProtectionRemovedMsg)
+}
+
+// annotationPatchPath returns the JSON patch path of a node annotation
+func annotationPatchPath(key string) string {
+	return fmt.Sprintf("/metadata/annotations/%s", strings.NewReplacer("~", "~0", "/", "~1").Replace(key))
 }
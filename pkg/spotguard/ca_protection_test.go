@@ -0,0 +1,149 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func testCAProtector(clientset kubernetes.Interface) *CAProtector {
+	nthConfig := config.Config{
+		SpotAsgName:                    "spot",
+		SpotGuardSpotStabilityDuration: 120,
+		SpotGuardMinimumWaitDuration:   600,
+		SpotGuardPodMigrationBuffer:    180,
+	}
+	return NewCAProtector(clientset, "spot-1", nthConfig, observability.Metrics{}, observability.K8sEventRecorder{})
+}
+
+func listTestNodes(t *testing.T, clientset kubernetes.Interface) []*corev1.Node {
+	list, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	h.Ok(t, err)
+	nodes := make([]*corev1.Node, 0, len(list.Items))
+	for i := range list.Items {
+		nodes = append(nodes, &list.Items[i])
+	}
+	return nodes
+}
+
+func TestCAProtectorPendingDrainUntil(t *testing.T) {
+	cp := testCAProtector(fake.NewSimpleClientset())
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	_, pending := cp.pendingDrainUntil(testNode("od-1", "i-1", nil))
+	h.Assert(t, !pending, "an on-demand node without a start time has no drain pending")
+
+	waiting := testNode("od-1", "i-1", map[string]string{AnnotationStartTime: start.Format(time.RFC3339), AnnotationSpotASG: "spot"})
+	until, pending := cp.pendingDrainUntil(waiting)
+	h.Assert(t, pending, "a tracked on-demand node has a drain pending")
+	h.Equals(t, start.Add(15*time.Minute), until)
+
+	draining := testNode("od-1", "i-1", map[string]string{
+		AnnotationStartTime:     start.Format(time.RFC3339),
+		AnnotationScaleDownDone: start.Add(time.Hour).Format(time.RFC3339),
+	})
+	until, pending = cp.pendingDrainUntil(draining)
+	h.Assert(t, pending, "a draining on-demand node has a drain in progress")
+	h.Equals(t, start.Add(time.Hour+3*time.Minute), until)
+
+	other := testNode("od-1", "i-1", map[string]string{AnnotationStartTime: start.Format(time.RFC3339), AnnotationSpotASG: "other"})
+	_, pending = cp.pendingDrainUntil(other)
+	h.Assert(t, !pending, "drains onto another spot ASG do not protect this node")
+}
+
+func TestCAProtectorReconcile(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now().Truncate(time.Second)
+	clientset := fake.NewSimpleClientset(
+		testNode("spot-1", "i-1", nil),
+		testNode("od-1", "i-2", map[string]string{AnnotationStartTime: start.Format(time.RFC3339)}),
+	)
+	cp := testCAProtector(clientset)
+
+	cp.reconcile(ctx, listTestNodes(t, clientset))
+	node, err := clientset.CoreV1().Nodes().Get(ctx, "spot-1", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, "true", node.Annotations[AnnotationCAScaleDownDisabled])
+	h.Equals(t, start.Add(15*time.Minute).Format(time.RFC3339), node.Annotations[AnnotationCAProtectedUntil])
+
+	// Once the on-demand node is gone, the protection is released right away
+	h.Ok(t, clientset.CoreV1().Nodes().Delete(ctx, "od-1", metav1.DeleteOptions{}))
+	cp.reconcile(ctx, listTestNodes(t, clientset))
+	node, err = clientset.CoreV1().Nodes().Get(ctx, "spot-1", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, 0, len(node.Annotations))
+
+	// A scale-down-disabled annotation set by someone else is left alone
+	node.Annotations = map[string]string{AnnotationCAScaleDownDisabled: "true"}
+	_, err = clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+	h.Ok(t, err)
+	cp.reconcile(ctx, listTestNodes(t, clientset))
+	node, err = clientset.CoreV1().Nodes().Get(ctx, "spot-1", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, map[string]string{AnnotationCAScaleDownDisabled: "true"}, node.Annotations)
+}
+
+func TestCAProtectorWatchesDrains(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clientset := fake.NewSimpleClientset(testNode("spot-1", "i-1", nil))
+	go testCAProtector(clientset).Start(ctx)
+
+	onDemand := testNode("od-1", "i-2", map[string]string{AnnotationStartTime: time.Now().Format(time.RFC3339)})
+	onDemand.Labels = map[string]string{LabelOnDemandFallback: "true"}
+	_, err := clientset.CoreV1().Nodes().Create(ctx, onDemand, metav1.CreateOptions{})
+	h.Ok(t, err)
+
+	protected := func() bool {
+		node, err := clientset.CoreV1().Nodes().Get(ctx, "spot-1", metav1.GetOptions{})
+		h.Ok(t, err)
+		return node.Annotations[AnnotationCAScaleDownDisabled] == "true"
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !protected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.Assert(t, protected(), "a new on-demand drain must protect the spot node")
+
+	h.Ok(t, clientset.CoreV1().Nodes().Delete(ctx, "od-1", metav1.DeleteOptions{}))
+	deadline = time.Now().Add(5 * time.Second)
+	for protected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.Assert(t, !protected(), "the protection must be released once no drain is pending")
+
+	// Only the spot node itself and the on-demand nodes are watched, never every node of the cluster
+	for _, action := range clientset.Actions() {
+		switch action := action.(type) {
+		case k8stesting.ListAction:
+			restrictions := action.GetListRestrictions()
+			h.Assert(t, !restrictions.Fields.Empty() || !restrictions.Labels.Empty(), "unfiltered node list")
+		case k8stesting.WatchAction:
+			restrictions := action.GetWatchRestrictions()
+			h.Assert(t, !restrictions.Fields.Empty() || !restrictions.Labels.Empty(), "unfiltered node watch")
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/rs/zerolog/log"
+)
+
+// Capacity providers selectable with --spot-guard-capacity-provider
+const (
+	CapacityProviderASG       = "asg"
+	CapacityProviderKarpenter = "karpenter"
+)
+
+// Health statuses of a pool instance, the same as the ASG instance health statuses
+const (
+	InstanceHealthy   = "Healthy"
+	InstanceUnhealthy = "Unhealthy"
+)
+
+// PoolInstance is one instance of a capacity pool.
+// LifecycleState uses the ASG lifecycle states whatever the provider, e.g. Pending, InService or Terminating.
+type PoolInstance struct {
+	InstanceID     string
+	LifecycleState string
+	HealthStatus   string
+	// Lifecycle is spot or on-demand when the pool runs both, empty when the provider does not tell them apart
+	Lifecycle string
+	// Weight is the units of desired capacity the instance provides, 0 when the pool does not weight its instances
+	Weight int64
+}
+
+// capacityUnits returns the units of desired capacity the instance counts for
+func (i PoolInstance) capacityUnits() int64 {
+	if i.Weight > 0 {
+		return i.Weight
+	}
+	return 1
+}
+
+// CapacityPool is the current state of a spot or on-demand pool: an ASG, or a Karpenter NodePool
+type CapacityPool struct {
+	Name            string
+	DesiredCapacity int64
+	MinSize         int64
+	// MaxSize is 0 when the pool has no limit on its number of nodes
+	MaxSize   int64
+	Instances []PoolInstance
+}
+
+// InServiceCount returns the number of InService and healthy instances of the pool
+func (p *CapacityPool) InServiceCount() int {
+	count := 0
+	for _, instance := range p.Instances {
+		if instance.LifecycleState == autoscaling.LifecycleStateInService && instance.HealthStatus == InstanceHealthy {
+			count++
+		}
+	}
+	return count
+}
+
+// CapacityProvider adds and removes the nodes of spot and on-demand pools.
+// SpotGuard, HealthChecker and ScaleDownExecutor only talk to the pools through it.
+type CapacityProvider interface {
+	// DescribePool returns the current size and instances of a pool
+	DescribePool(ctx context.Context, pool string) (*CapacityPool, error)
+	// SetDesiredCapacity asks the pool to run the given number of nodes.
+	// Classified failures are returned as a *ScalingFailureError.
+	SetDesiredCapacity(ctx context.Context, pool string, desired int64) error
+	// ScalingFailure returns the first classified launch failure of the pool that started after since, if any
+	ScalingFailure(ctx context.Context, pool string, since time.Time) (*ScalingFailureError, error)
+	// TerminateInstance terminates one instance and shrinks the pool by one,
+	// so the pool never picks a different instance to remove
+	TerminateInstance(ctx context.Context, pool string, instanceID string) error
+}
+
+// asgCapacityProvider implements CapacityProvider with Auto Scaling groups
+type asgCapacityProvider struct {
+	asgClient autoscalingiface.AutoScalingAPI
+	// ec2Client looks up launch templates and instance types; it may be nil
+	ec2Client ec2iface.EC2API
+}
+
+// NewASGCapacityProvider returns a CapacityProvider whose pools are Auto Scaling groups.
+// The EC2 client is optional and only used to size pre-scaling by instance type.
+func NewASGCapacityProvider(asgClient autoscalingiface.AutoScalingAPI, ec2Client ec2iface.EC2API) CapacityProvider {
+	return &asgCapacityProvider{asgClient: asgClient, ec2Client: ec2Client}
+}
+
+// DescribePool describes the ASG
+func (p *asgCapacityProvider) DescribePool(ctx context.Context, pool string) (*CapacityPool, error) {
+	result, err := p.asgClient.DescribeAutoScalingGroupsWithContext(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(pool)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe ASG %s: %w", pool, err)
+	}
+	if len(result.AutoScalingGroups) == 0 {
+		return nil, fmt.Errorf("ASG %s not found", pool)
+	}
+
+	asg := result.AutoScalingGroups[0]
+	capacityPool := &CapacityPool{
+		Name:            pool,
+		DesiredCapacity: aws.Int64Value(asg.DesiredCapacity),
+		MinSize:         aws.Int64Value(asg.MinSize),
+		MaxSize:         aws.Int64Value(asg.MaxSize),
+		Instances:       make([]PoolInstance, 0, len(asg.Instances)),
+	}
+	for _, instance := range asg.Instances {
+		capacityPool.Instances = append(capacityPool.Instances, PoolInstance{
+			InstanceID:     aws.StringValue(instance.InstanceId),
+			LifecycleState: aws.StringValue(instance.LifecycleState),
+			HealthStatus:   aws.StringValue(instance.HealthStatus),
+			Weight:         capacityWeight(instance.WeightedCapacity),
+		})
+	}
+	return capacityPool, nil
+}
+
+// describeGroup describes the raw ASG, including its MixedInstancesPolicy and tags
+func (p *asgCapacityProvider) describeGroup(ctx context.Context, pool string) (*autoscaling.Group, error) {
+	result, err := p.asgClient.DescribeAutoScalingGroupsWithContext(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(pool)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe ASG %s: %w", pool, err)
+	}
+	if len(result.AutoScalingGroups) == 0 {
+		return nil, fmt.Errorf("ASG %s not found", pool)
+	}
+	return result.AutoScalingGroups[0], nil
+}
+
+// SetDesiredCapacity sets the desired capacity of the ASG, skipping its cooldown
+func (p *asgCapacityProvider) SetDesiredCapacity(ctx context.Context, pool string, desired int64) error {
+	_, err := p.asgClient.SetDesiredCapacityWithContext(ctx, &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: aws.String(pool),
+		DesiredCapacity:      aws.Int64(desired),
+		HonorCooldown:        aws.Bool(false),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set desired capacity for ASG %s: %w", pool, classifyAPIError(pool, err))
+	}
+	return nil
+}
+
+// ScalingFailure checks the recent scaling activities of the ASG for classified failures.
+// Only activities that started after since are checked to avoid false positives.
+func (p *asgCapacityProvider) ScalingFailure(ctx context.Context, pool string, since time.Time) (*ScalingFailureError, error) {
+	output, err := p.asgClient.DescribeScalingActivitiesWithContext(ctx, &autoscaling.DescribeScalingActivitiesInput{
+		AutoScalingGroupName: aws.String(pool),
+		MaxRecords:           aws.Int64(50), // Increased from 10 to catch more activities
+	})
+	if err != nil {
+		// Check if it's a throttling error
+		if contains(err.Error(), "Throttling") || contains(err.Error(), "Rate exceeded") {
+			log.Warn().
+				Err(err).
+				Str("asgName", pool).
+				Msg("⚠️ AWS API throttled (DescribeScalingActivities), will retry on next check cycle")
+			return nil, nil // Don't fail, just skip this check
+		}
+		return nil, fmt.Errorf("failed to describe scaling activities for ASG %s: %w", pool, err)
+	}
+
+	// Add 5-second buffer to account for clock skew and API recording delays
+	cutoffTime := since.Add(-5 * time.Second)
+
+	log.Debug().Msgf("Spot Guard: Checking scaling activities for %s (cutoff: %s, total activities: %d)",
+		pool, cutoffTime.Format(time.RFC3339), len(output.Activities))
+
+	relevantActivitiesChecked := 0
+
+	for _, activity := range output.Activities {
+		// Only check activities that started after we initiated scale-up
+		activityTime := aws.TimeValue(activity.StartTime)
+		if activityTime.Before(cutoffTime) {
+			continue
+		}
+
+		relevantActivitiesChecked++
+
+		statusCode := aws.StringValue(activity.StatusCode)
+		description := aws.StringValue(activity.Description)
+		statusMessage := aws.StringValue(activity.StatusMessage)
+		cause := aws.StringValue(activity.Cause)
+
+		if statusCode != autoscaling.ScalingActivityStatusCodeFailed && statusCode != autoscaling.ScalingActivityStatusCodeCancelled {
+			continue
+		}
+
+		log.Debug().Msgf("Spot Guard: Found failed/cancelled activity - Status: %s, Time: %s, Description: %s",
+			statusCode, activityTime.Format(time.RFC3339), description)
+
+		class, found := classifyScalingMessage(statusMessage, description, cause)
+		if !found {
+			// Unknown failures keep waiting; the timeout still applies
+			continue
+		}
+
+		log.Warn().Msgf("🚨 Spot Guard: Detected %s failure in recent activity at %s: %s",
+			class, activityTime.Format(time.RFC3339), description)
+		message := statusMessage
+		if message == "" {
+			message = description
+		}
+		return &ScalingFailureError{Class: class, ASGName: pool, Message: message}, nil
+	}
+
+	log.Debug().Msgf("Spot Guard: No classified failures detected (checked %d relevant activities out of %d total)",
+		relevantActivitiesChecked, len(output.Activities))
+
+	return nil, nil
+}
+
+// TerminateInstance terminates the instance and decrements the ASG desired capacity in one call
+func (p *asgCapacityProvider) TerminateInstance(ctx context.Context, pool string, instanceID string) error {
+	_, err := p.asgClient.TerminateInstanceInAutoScalingGroupWithContext(ctx, &autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     aws.String(instanceID),
+		ShouldDecrementDesiredCapacity: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to terminate instance %s in ASG %s: %w", instanceID, pool, err)
+	}
+	return nil
+}
@@ -55,6 +55,17 @@ type Config struct {
 	// MaxEventAge is the maximum age of events to keep in tracking
 	// Default: 24 hours
 	MaxEventAge time.Duration
+
+	// StateConfigMapName is the ConfigMap fallback events are persisted in, so they survive restarts
+	// Default: "" (events are only kept in memory)
+	StateConfigMapName string
+
+	// StateConfigMapNamespace is the namespace of StateConfigMapName
+	StateConfigMapNamespace string
+
+	// DryRun logs and emits the scale-ups and scale-downs that would have been made without changing ASGs or nodes
+	// Default: false
+	DryRun bool
 }
 
 // DefaultConfig returns the recommended default configuration
@@ -119,5 +130,9 @@ func (c *Config) Validate() error {
 		return ErrInvalidMaxUtilization
 	}
 
+	if c.StateConfigMapName != "" && c.StateConfigMapNamespace == "" {
+		return ErrStateNamespaceRequired
+	}
+
 	return nil
 }
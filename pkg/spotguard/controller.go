@@ -0,0 +1,587 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	"github.com/aws/aws-node-termination-handler/pkg/node"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// onDemandCandidate is an on-demand node the controller may retire
+type onDemandCandidate struct {
+	nodeName   string
+	instanceID string
+	startTime  time.Time
+}
+
+// Controller evaluates all on-demand nodes of the ASG pair from a single leader-elected replica.
+// It replaces the per-node SelfMonitor so only one replica decides which nodes to retire.
+type Controller struct {
+	config            config.Config
+	provider          CapacityProvider
+	clientset         kubernetes.Interface
+	healthChecker     *HealthChecker
+	safetyChecker     *SafetyChecker
+	scaleDownExecutor *ScaleDownExecutor
+	preScaler         *preScaler
+	schedule          *ScaleDownSchedule
+	drains            *drainSemaphore
+	metrics           observability.Metrics
+	recorder          observability.K8sEventRecorder
+	healthySince      *time.Time
+	identity          string
+	spotASGName       string
+	onDemandASGName   string
+	onDecision        func(ControllerDecision)
+	status            *Status
+	conditions        map[string]*scaleDownCondition // SpotGuardScaleDownReady writers of the on-demand nodes, by node name
+}
+
+// Decisions reported by the controller after each cycle
+const (
+	DecisionIdle          = "Idle"
+	DecisionWaiting       = "Waiting"
+	DecisionSpotNotReady  = "SpotNotReady"
+	DecisionBlocked       = "ScaleDownBlocked"
+	DecisionOutsideWindow = "OutsideScaleDownWindow"
+	DecisionScaledDown    = "ScaledDown"
+	DecisionError         = "Error"
+	DecisionInvalid       = "Invalid"
+)
+
+// ControllerDecision is the outcome of one controller cycle
+type ControllerDecision struct {
+	Decision string
+	Message  string
+	Time     time.Time
+}
+
+// newDecision creates a decision stamped with the current time
+func newDecision(decision string, message string) ControllerDecision {
+	return ControllerDecision{Decision: decision, Message: message, Time: time.Now()}
+}
+
+// NewController creates a new cluster-wide Spot Guard controller
+func NewController(
+	provider CapacityProvider,
+	clientset kubernetes.Interface,
+	nodeHandler node.Node,
+	nthConfig config.Config,
+	metrics observability.Metrics,
+	recorder observability.K8sEventRecorder,
+	ledger *CostLedger,
+) *Controller {
+	healthChecker := NewHealthChecker(provider, clientset, nthConfig.DryRun)
+	healthChecker.interruptionWindow = interruptionWindowFromConfig(nthConfig)
+	safetyChecker := NewSafetyChecker(clientset, float64(nthConfig.SpotGuardMaxClusterUtilization))
+	safetyChecker.evictionRules = evictionRulesFromConfig(nthConfig)
+	safetyChecker.drainTimeout = time.Duration(nthConfig.NodeTerminationGracePeriod) * time.Second
+	scaleDownExecutor := NewScaleDownExecutor(
+		provider,
+		clientset,
+		nodeHandler,
+		time.Duration(nthConfig.SpotGuardPodEvictionTimeout)*time.Second,
+		recorder,
+		nthConfig.DryRun,
+		ledger,
+	)
+
+	return &Controller{
+		config:            nthConfig,
+		provider:          provider,
+		clientset:         clientset,
+		healthChecker:     healthChecker,
+		safetyChecker:     safetyChecker,
+		scaleDownExecutor: scaleDownExecutor,
+		preScaler:         newPreScaler(nthConfig, healthChecker, safetyChecker, metrics, recorder),
+		schedule:          scaleDownScheduleFromConfig(nthConfig),
+		drains:            newDrainSemaphore(clientset, provider, nthConfig),
+		metrics:           metrics,
+		recorder:          recorder,
+		identity:          nthConfig.PodName,
+		spotASGName:       nthConfig.SpotAsgName,
+		onDemandASGName:   nthConfig.OnDemandAsgName,
+	}
+}
+
+// TrackFallbacks makes the controller close the fallback events of the nodes it retires
+func (c *Controller) TrackFallbacks(tracker *FallbackTracker) {
+	c.scaleDownExecutor.tracker = tracker
+}
+
+// ReportStatus makes the controller record its state in status
+func (c *Controller) ReportStatus(status *Status) {
+	c.status = status
+	c.preScaler.status = status
+}
+
+// Start campaigns for the Spot Guard lease and runs the control loop while this replica is the leader.
+// It blocks until the context is cancelled.
+func (c *Controller) Start(ctx context.Context) {
+	runLeaderElected(ctx, c.clientset, c.config, c.run)
+}
+
+// runLeaderElected campaigns for the Spot Guard lease and calls run while this replica holds it.
+// It blocks until the context is cancelled.
+func runLeaderElected(ctx context.Context, clientset kubernetes.Interface, nthConfig config.Config, run func(ctx context.Context)) {
+	// Replicas scheduled on the same node must still be told apart
+	identity := nthConfig.PodName
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      nthConfig.SpotGuardLeaseName,
+			Namespace: nthConfig.PodNamespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	log.Info().
+		Str("lease", nthConfig.SpotGuardLeaseName).
+		Str("namespace", nthConfig.PodNamespace).
+		Str("identity", identity).
+		Msg("Starting Spot Guard leader election")
+
+	// RunOrDie returns when leadership is lost, so keep campaigning until shutdown
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			Name:            nthConfig.SpotGuardLeaseName,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: run,
+				OnStoppedLeading: func() {
+					log.Info().Str("identity", identity).Msg("Spot Guard lost leadership")
+				},
+				OnNewLeader: func(leader string) {
+					if leader != identity {
+						log.Info().Str("leader", leader).Msg("Spot Guard leader is running on another replica")
+					}
+				},
+			},
+		})
+	}
+}
+
+// run is the control loop executed by the leader
+func (c *Controller) run(ctx context.Context) {
+	checkInterval := time.Duration(c.config.SpotGuardCheckInterval) * time.Second
+
+	log.Info().
+		Str("identity", c.identity).
+		Str("spotASG", c.spotASGName).
+		Str("onDemandASG", c.onDemandASGName).
+		Dur("checkInterval", checkInterval).
+		Int("maxScaleDownsPerCycle", c.config.SpotGuardMaxScaleDownsPerCycle).
+		Msg("Evaluating on-demand nodes cluster-wide")
+
+	// Forget stability observed by a previous term, it may be stale
+	c.healthySince = nil
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Spot Guard controller stopped")
+			return
+		case <-ticker.C:
+			decision := c.reconcile(ctx)
+			if c.onDecision != nil {
+				c.onDecision(decision)
+			}
+		}
+	}
+}
+
+// reconcile evaluates every on-demand node once and retires up to SpotGuardMaxScaleDownsPerCycle of them
+func (c *Controller) reconcile(ctx context.Context) ControllerDecision {
+	candidates, err := c.listOnDemandCandidates(ctx)
+	if err != nil {
+		log.Warn().Err(err).Str("onDemandASG", c.onDemandASGName).Msg("Failed to list on-demand nodes")
+		return newDecision(DecisionError, err.Error())
+	}
+	if len(candidates) == 0 {
+		log.Debug().Str("onDemandASG", c.onDemandASGName).Msg("No on-demand nodes to evaluate")
+		return newDecision(DecisionIdle, "no on-demand nodes")
+	}
+
+	// Step 1: Only nodes past the minimum wait time are eligible
+	minimumWaitDuration := time.Duration(c.config.SpotGuardMinimumWaitDuration) * time.Second
+	eligible := make([]onDemandCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if time.Since(candidate.startTime) >= minimumWaitDuration {
+			eligible = append(eligible, candidate)
+			continue
+		}
+		c.condition(candidate.nodeName).keep(ctx, ScaleDownReasonMinimumWaitNotMet, fmt.Sprintf("on-demand node is kept for the minimum wait of %v, until %s",
+			minimumWaitDuration, candidate.startTime.Add(minimumWaitDuration).UTC().Format(time.RFC3339)))
+	}
+	if len(eligible) == 0 {
+		log.Debug().
+			Int("onDemandNodes", len(candidates)).
+			Msg("Minimum wait time not met yet for any on-demand node")
+		c.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, ScaleDownReasonMinimumWaitNotMet)
+		return newDecision(DecisionWaiting, fmt.Sprintf("minimum wait time not met for %d on-demand node(s)", len(candidates)))
+	}
+
+	// Step 2: One spot ASG check for the whole cluster
+	stabilityDuration := time.Duration(c.config.SpotGuardSpotStabilityDuration) * time.Second
+	status, err := c.healthChecker.CheckSpotASGComprehensive(ctx, c.spotASGName, stabilityDuration, c.healthySince)
+	c.status.setSpotHealth(c.spotASGName, status, stabilityDuration, err)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("spotASG", c.spotASGName).
+			Msg("Failed to perform comprehensive spot ASG check")
+		c.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, ScaleDownReasonCheckFailed)
+		c.keepAll(ctx, eligible, ScaleDownReasonCheckFailed, fmt.Sprintf("spot ASG check failed: %v", err))
+		return newDecision(DecisionError, err.Error())
+	}
+	c.healthySince = status.HealthySince
+	if !status.IsHealthy || !status.NodesReady || !status.IsStable {
+		log.Debug().
+			Str("spotASG", c.spotASGName).
+			Bool("healthy", status.IsHealthy).
+			Bool("nodesReady", status.NodesReady).
+			Bool("stable", status.IsStable).
+			Strs("interruptions", status.Interruptions).
+			Msg("Spot capacity not yet restored")
+		c.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, spotBlockReason(status))
+		c.keepAll(ctx, eligible, spotBlockReason(status), spotNotReadyMessage(c.spotASGName, status, stabilityDuration))
+		return newDecision(DecisionSpotNotReady, fmt.Sprintf("spot ASG %s healthy=%t nodesReady=%t stable=%t interruptions=%d",
+			c.spotASGName, status.IsHealthy, status.NodesReady, status.IsStable, len(status.Interruptions)))
+	}
+
+	// Step 3: Only drain inside the scale-down windows, stability keeps being tracked above meanwhile
+	if allowed, blockReason, message := c.schedule.Allows(time.Now()); !allowed {
+		log.Debug().
+			Int("eligibleNodes", len(eligible)).
+			Str("reason", message).
+			Msg("Scale-down not allowed by schedule")
+		c.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, blockReason)
+		c.keepAll(ctx, eligible, blockReason, message)
+		return newDecision(DecisionOutsideWindow, message)
+	}
+
+	// Step 4: Retire the longest-running on-demand nodes first, pods with zonal volumes need a spot node in their zone
+	spotInstances := status.spotInstances()
+	sort.Slice(eligible, func(i, j int) bool {
+		return eligible[i].startTime.Before(eligible[j].startTime)
+	})
+
+	retired := 0
+	lastBlock := ""
+	for _, candidate := range eligible {
+		if retired >= c.config.SpotGuardMaxScaleDownsPerCycle {
+			log.Info().
+				Int("retired", retired).
+				Int("remaining", len(eligible)-retired).
+				Msg("Reached maximum scale-downs for this cycle, continuing next cycle")
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Safety is re-evaluated before every node since each drain changes cluster utilization
+		maxUtilization := c.preScaler.drainThreshold(candidate.nodeName)
+		canDrain, reason := c.safetyChecker.CanSafelyDrainNodeWithThreshold(ctx, candidate.nodeName, spotInstances, maxUtilization)
+		c.status.setDrainCheck(candidate.nodeName, canDrain, reason, maxUtilization)
+		if !canDrain {
+			lastBlock = fmt.Sprintf("%s: %s", candidate.nodeName, reason)
+			c.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, drainBlockReason(reason))
+			c.condition(candidate.nodeName).keep(ctx, drainBlockReason(reason), reason)
+			c.recorder.Emit(candidate.nodeName, observability.Normal, observability.SpotGuardScaleDownBlockedReason,
+				observability.SpotGuardScaleDownBlockedMsgFmt, drainBlockReason(reason), reason)
+			if reason == ReasonClusterUtilizationTooHigh {
+				if c.config.EnablePreScale {
+					log.Info().
+						Str("nodeName", candidate.nodeName).
+						Msg("Cluster utilization too high, attempting smart pre-scale")
+					if c.preScaler.attemptPreScaleWithFallback(ctx, candidate.nodeName) {
+						log.Info().Msg("Pre-scale successful, will retry drain on next check cycle")
+					}
+				}
+				// Retiring any other node would hit the same limit
+				break
+			}
+
+			log.Debug().
+				Str("nodeName", candidate.nodeName).
+				Str("reason", reason).
+				Msg("Cannot safely drain on-demand node yet")
+			continue
+		}
+
+		// The drain slot also bounds drains started by a previous leader that are still running
+		release, err := c.drains.acquire(ctx, candidate.nodeName)
+		if err != nil {
+			lastBlock = fmt.Sprintf("%s: %v", candidate.nodeName, err)
+			c.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, semaphoreBlockReason(err))
+			c.condition(candidate.nodeName).keep(ctx, semaphoreBlockReason(err), err.Error())
+			log.Info().
+				Err(err).
+				Str("nodeName", candidate.nodeName).
+				Msg("No Spot Guard drain slot available, continuing next cycle")
+			break
+		}
+		scaledDown := c.scaleDown(ctx, candidate)
+		release()
+		if scaledDown {
+			retired++
+		} else {
+			lastBlock = fmt.Sprintf("%s: scale-down failed", candidate.nodeName)
+		}
+	}
+
+	if retired > 0 {
+		return newDecision(DecisionScaledDown, fmt.Sprintf("retired %d of %d eligible on-demand node(s)", retired, len(eligible)))
+	}
+	return newDecision(DecisionBlocked, lastBlock)
+}
+
+// scaleDown retires a single on-demand node, returning true if it is now terminating
+func (c *Controller) scaleDown(ctx context.Context, candidate onDemandCandidate) bool {
+	log.Info().
+		Str("nodeName", candidate.nodeName).
+		Str("instanceID", candidate.instanceID).
+		Str("spotASG", c.spotASGName).
+		Str("onDemandASG", c.onDemandASGName).
+		Dur("onDemandRuntime", time.Since(candidate.startTime)).
+		Msg("All conditions met, initiating scale-down of on-demand node")
+
+	event := &FallbackEvent{
+		EventID:              fmt.Sprintf("controller-%s-%d", candidate.nodeName, time.Now().Unix()),
+		Timestamp:            candidate.startTime,
+		SpotASGName:          c.spotASGName,
+		OnDemandASGName:      c.onDemandASGName,
+		OnDemandNodeName:     candidate.nodeName,
+		OnDemandInstanceID:   candidate.instanceID,
+		ScaleDownInitiated:   true,
+		SpotCapacityRestored: true,
+	}
+
+	// Mark scale-down as initiated so a new leader does not pick the same node again
+	if !c.config.DryRun {
+		annotations := map[string]string{AnnotationScaleDownDone: time.Now().Format(time.RFC3339)}
+		if err := setNodeAnnotations(ctx, c.clientset, candidate.nodeName, annotations); err != nil {
+			log.Warn().Err(err).Str("nodeName", candidate.nodeName).Msg("Failed to mark scale-down as initiated, continuing anyway")
+		}
+	}
+
+	c.condition(candidate.nodeName).set(ctx, corev1.ConditionTrue, ScaleDownReasonDraining, "spot capacity is restored, draining and terminating this on-demand node")
+
+	err := c.scaleDownExecutor.ScaleDownOnDemandNode(ctx, event)
+	c.scaleDownExecutor.recordScaleDown(c.metrics, event, err)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("nodeName", candidate.nodeName).
+			Str("eventID", event.EventID).
+			Msg("Failed to scale down on-demand node")
+		c.condition(candidate.nodeName).keep(ctx, ScaleDownReasonExecutionFailed, fmt.Sprintf("scale-down failed: %v", err))
+
+		// The executor restored the node, clear the marker so a later cycle can retry.
+		// The drain may have failed because leadership was lost, so the drain context is not used.
+		// After a termination timeout the node is not restored and the marker stays until it is stale.
+		if c.config.DryRun || errors.Is(err, ErrTerminationTimeout) {
+			return false
+		}
+		clearCtx, cancel := context.WithTimeout(context.Background(), restoreNodeTimeout)
+		defer cancel()
+		if err := removeNodeAnnotation(clearCtx, c.clientset, candidate.nodeName, AnnotationScaleDownDone); err != nil {
+			log.Error().Err(err).Str("nodeName", candidate.nodeName).Msg("Failed to clear scale-down marker, it expires on its own")
+		}
+		return false
+	}
+
+	log.Info().
+		Str("nodeName", candidate.nodeName).
+		Str("eventID", event.EventID).
+		Dur("totalRuntime", time.Since(candidate.startTime)).
+		Msg("Successfully scaled down on-demand node")
+	return true
+}
+
+// listOnDemandCandidates returns the InService on-demand instances that are registered as nodes
+// and not already being scaled down. One DescribePool call covers all of them.
+// For a mixed instances ASG only as many as its on-demand surplus are returned.
+func (c *Controller) listOnDemandCandidates(ctx context.Context) ([]onDemandCandidate, error) {
+	pool, err := c.provider.DescribePool(ctx, c.onDemandASGName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe on-demand ASG: %w", err)
+	}
+
+	inService := make(map[string]bool)
+	for _, instance := range pool.Instances {
+		// A mixed instances ASG also runs the spot instances
+		if instance.LifecycleState == autoscaling.LifecycleStateInService && instance.Lifecycle != InstanceLifecycleSpot {
+			inService[instance.InstanceID] = true
+		}
+	}
+	if len(inService) == 0 {
+		return nil, nil
+	}
+
+	nodes, err := c.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	candidates := make([]onDemandCandidate, 0, len(inService))
+	onDemandNodes := make(map[string]bool, len(inService))
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		instanceID := extractInstanceIDFromProviderID(node.Spec.ProviderID)
+		if !inService[instanceID] {
+			continue
+		}
+		onDemandNodes[node.Name] = true
+		if marked, inProgress := node.Annotations[AnnotationScaleDownDone]; inProgress {
+			if !c.scaleDownExecutor.staleScaleDownMarker(marked) {
+				c.condition(node.Name).set(ctx, corev1.ConditionTrue, ScaleDownReasonDraining, "scale-down of this on-demand node already started")
+				continue
+			}
+			// The instance is still InService long after its drain started, whoever drained it never cleaned up
+			log.Warn().
+				Str("nodeName", node.Name).
+				Str("markedAt", marked).
+				Msg("Scale-down marker outlived the drain timeout, recovering on-demand node")
+			c.scaleDownExecutor.restoreNode(node.Name)
+			if err := removeNodeAnnotation(ctx, c.clientset, node.Name, AnnotationScaleDownDone); err != nil {
+				log.Warn().Err(err).Str("nodeName", node.Name).Msg("Failed to clear stale scale-down marker")
+				continue
+			}
+		}
+
+		candidates = append(candidates, onDemandCandidate{
+			nodeName:   node.Name,
+			instanceID: instanceID,
+			startTime:  c.getOrCreateStartTime(ctx, node),
+		})
+	}
+	// Forget the condition writers of nodes that were retired
+	for nodeName := range c.conditions {
+		if !onDemandNodes[nodeName] {
+			delete(c.conditions, nodeName)
+		}
+	}
+
+	shifter, ok := c.provider.(onDemandShifter)
+	if !ok {
+		return candidates, nil
+	}
+
+	// Only the on-demand instances added by fallbacks are retired, the newest ones are assumed to be them
+	surplus, err := shifter.OnDemandSurplus(ctx, c.onDemandASGName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read on-demand surplus: %w", err)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].startTime.After(candidates[j].startTime)
+	})
+	if len(candidates) > surplus {
+		candidates = candidates[:surplus]
+	}
+	return candidates, nil
+}
+
+// condition returns the SpotGuardScaleDownReady condition writer of an on-demand node
+func (c *Controller) condition(nodeName string) *scaleDownCondition {
+	if c.conditions == nil {
+		c.conditions = make(map[string]*scaleDownCondition)
+	}
+	condition, ok := c.conditions[nodeName]
+	if !ok {
+		condition = newScaleDownCondition(c.clientset, nodeName, c.config.DryRun)
+		c.conditions[nodeName] = condition
+	}
+	return condition
+}
+
+// keepAll sets the condition of every candidate to the reason they are all kept
+func (c *Controller) keepAll(ctx context.Context, candidates []onDemandCandidate, reason string, message string) {
+	for _, candidate := range candidates {
+		c.condition(candidate.nodeName).keep(ctx, reason, message)
+	}
+}
+
+// getOrCreateStartTime reads the on-demand start time annotation, recording it on first sight
+func (c *Controller) getOrCreateStartTime(ctx context.Context, node *corev1.Node) time.Time {
+	if startTimeStr, exists := node.Annotations[AnnotationStartTime]; exists {
+		if startTime, err := time.Parse(time.RFC3339, startTimeStr); err == nil {
+			if !c.config.DryRun {
+				// Nodes tracked before the label existed get it now
+				if err := labelOnDemandFallback(ctx, c.clientset, node); err != nil {
+					log.Warn().Err(err).Str("nodeName", node.Name).Msg("Failed to label on-demand node, will retry")
+				}
+			}
+			return startTime
+		}
+		log.Warn().
+			Str("nodeName", node.Name).
+			Str("startTimeStr", startTimeStr).
+			Msg("Failed to parse start time annotation, creating new one")
+	}
+
+	if c.config.DryRun {
+		// The annotation is never written in dry-run, so count from when the node joined
+		return node.CreationTimestamp.Time
+	}
+
+	startTime := time.Now()
+	annotations := map[string]string{
+		AnnotationStartTime:   startTime.Format(time.RFC3339),
+		AnnotationSpotASG:     c.spotASGName,
+		AnnotationOnDemandASG: c.onDemandASGName,
+	}
+	if err := setNodeAnnotations(ctx, c.clientset, node.Name, annotations); err != nil {
+		log.Warn().Err(err).Str("nodeName", node.Name).Msg("Failed to set start time annotation, will retry")
+	} else if err := labelOnDemandFallback(ctx, c.clientset, node); err != nil {
+		log.Warn().Err(err).Str("nodeName", node.Name).Msg("Failed to label on-demand node, will retry")
+	} else {
+		log.Info().
+			Time("startTime", startTime).
+			Str("nodeName", node.Name).
+			Msg("Created start time annotation on on-demand node")
+	}
+
+	return startTime
+}
@@ -0,0 +1,117 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+type mockedDescribeASG struct {
+	autoscalingiface.AutoScalingAPI
+	group *autoscaling.Group
+}
+
+func (m mockedDescribeASG) DescribeAutoScalingGroupsWithContext(_ aws.Context, _ *autoscaling.DescribeAutoScalingGroupsInput, _ ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: []*autoscaling.Group{m.group}}, nil
+}
+
+func asgInstance(instanceID string, lifecycleState string) *autoscaling.Instance {
+	return &autoscaling.Instance{InstanceId: aws.String(instanceID), LifecycleState: aws.String(lifecycleState)}
+}
+
+func testNode(name string, instanceID string, annotations map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+		Spec:       corev1.NodeSpec{ProviderID: "aws:///us-east-1a/" + instanceID},
+	}
+}
+
+// drainedNode returns a node left cordoned and tainted by a scale-down
+func drainedNode(name string, instanceID string, annotations map[string]string) *corev1.Node {
+	drained := testNode(name, instanceID, annotations)
+	drained.Spec.Unschedulable = true
+	drained.Spec.Taints = []corev1.Taint{{Key: ScaleDownPendingTaint, Value: "true", Effect: corev1.TaintEffectNoSchedule}}
+	return drained
+}
+
+func TestListOnDemandCandidates(t *testing.T) {
+	startTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	clientset := fake.NewSimpleClientset(
+		testNode("od-1", "i-1", map[string]string{AnnotationStartTime: startTime.Format(time.RFC3339)}),
+		testNode("od-2", "i-2", nil),
+		testNode("od-3", "i-3", map[string]string{AnnotationScaleDownDone: time.Now().Format(time.RFC3339)}),
+		testNode("od-4", "i-4", nil),
+		testNode("spot-1", "i-5", nil),
+		drainedNode("od-6", "i-6", map[string]string{AnnotationStartTime: startTime.Add(time.Minute).Format(time.RFC3339), AnnotationScaleDownDone: startTime.Format(time.RFC3339)}),
+	)
+	asgClient := mockedDescribeASG{group: &autoscaling.Group{
+		Instances: []*autoscaling.Instance{
+			asgInstance("i-1", autoscaling.LifecycleStateInService),
+			asgInstance("i-2", autoscaling.LifecycleStateInService),
+			asgInstance("i-3", autoscaling.LifecycleStateInService),
+			asgInstance("i-4", autoscaling.LifecycleStateTerminating),
+			asgInstance("i-6", autoscaling.LifecycleStateInService),
+		},
+	}}
+	provider := NewASGCapacityProvider(asgClient, nil)
+	c := &Controller{provider: provider, clientset: clientset, onDemandASGName: "od-asg", spotASGName: "spot-asg"}
+	c.scaleDownExecutor = NewScaleDownExecutor(provider, clientset, testNodeHandler(clientset), 300*time.Second, observability.K8sEventRecorder{}, false, nil)
+
+	candidates, err := c.listOnDemandCandidates(context.Background())
+	h.Ok(t, err)
+	h.Equals(t, 3, len(candidates))
+	h.Equals(t, "od-1", candidates[0].nodeName)
+	h.Equals(t, "i-1", candidates[0].instanceID)
+	h.Assert(t, candidates[0].startTime.Equal(startTime), "Expected start time to be loaded from annotation")
+	h.Equals(t, "od-2", candidates[1].nodeName)
+
+	// The start time of a node seen for the first time is recorded on the node
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "od-2", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, "od-asg", node.Annotations[AnnotationOnDemandASG])
+	_, exists := node.Annotations[AnnotationStartTime]
+	h.Assert(t, exists, "Expected start time annotation on od-2")
+	h.Equals(t, "true", node.Labels[LabelOnDemandFallback])
+
+	// Nodes tracked before the label existed get it too
+	node, err = clientset.CoreV1().Nodes().Get(context.Background(), "od-1", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, "true", node.Labels[LabelOnDemandFallback])
+
+	// A marker older than the drain timeout was left behind by a failed drain and is cleared
+	h.Equals(t, "od-6", candidates[2].nodeName)
+	node, err = clientset.CoreV1().Nodes().Get(context.Background(), "od-6", metav1.GetOptions{})
+	h.Ok(t, err)
+	_, exists = node.Annotations[AnnotationScaleDownDone]
+	h.Assert(t, !exists, "Expected the stale scale-down marker to be cleared from od-6")
+	h.Assert(t, !node.Spec.Unschedulable && len(node.Spec.Taints) == 0, "Expected od-6 to be restored: %+v", node.Spec)
+
+	// A node whose scale-down is running reports it on its condition
+	draining := nodeConditions(t, clientset, "od-3")[NodeConditionScaleDownReady]
+	h.Equals(t, corev1.ConditionTrue, draining.Status)
+	h.Equals(t, ScaleDownReasonDraining, draining.Reason)
+}
@@ -0,0 +1,366 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// CostLedgerEndpoint is the path the cost ledger is served on, next to the metrics and probes
+const CostLedgerEndpoint = "/spotguard/cost"
+
+const (
+	// costLedgerDataKey is the ConfigMap data key holding the ledger
+	costLedgerDataKey = "ledger"
+	// maxCostLedgerEntries bounds the recent entries kept, so the ConfigMap stays small
+	maxCostLedgerEntries = 100
+)
+
+// InstancePrice is the hourly price of an instance type in USD
+type InstancePrice struct {
+	OnDemand float64 `json:"onDemand"`
+	Spot     float64 `json:"spot"`
+}
+
+// PriceTable maps instance types to their hourly prices
+type PriceTable map[string]InstancePrice
+
+// ParsePriceTable parses a comma-separated list of "<instance-type>=<on-demand>:<spot>" hourly prices in USD,
+// e.g. "m5.large=0.096:0.035,c5.xlarge=0.17:0.068"
+func ParsePriceTable(priceTableStr string) (PriceTable, error) {
+	prices := PriceTable{}
+	for _, entry := range strings.Split(priceTableStr, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		instanceType, pricesStr, found := strings.Cut(entry, "=")
+		instanceType = strings.TrimSpace(instanceType)
+		if !found || instanceType == "" {
+			return nil, fmt.Errorf("invalid price %q: expected <instance-type>=<on-demand>:<spot>", entry)
+		}
+		onDemandStr, spotStr, found := strings.Cut(pricesStr, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid price %q: expected <instance-type>=<on-demand>:<spot>", entry)
+		}
+		onDemand, err := strconv.ParseFloat(strings.TrimSpace(onDemandStr), 64)
+		if err != nil || onDemand < 0 {
+			return nil, fmt.Errorf("invalid on-demand price in %q", entry)
+		}
+		spot, err := strconv.ParseFloat(strings.TrimSpace(spotStr), 64)
+		if err != nil || spot < 0 {
+			return nil, fmt.Errorf("invalid spot price in %q", entry)
+		}
+		prices[instanceType] = InstancePrice{OnDemand: onDemand, Spot: spot}
+	}
+	return prices, nil
+}
+
+// CostEntry is one retired on-demand node in the cost ledger
+type CostEntry struct {
+	NodeName        string    `json:"nodeName"`
+	InstanceID      string    `json:"instanceID,omitempty"`
+	InstanceType    string    `json:"instanceType,omitempty"`
+	SpotASGName     string    `json:"spotASGName"`
+	OnDemandASGName string    `json:"onDemandASGName"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	NodeHours       float64   `json:"nodeHours"`
+	// Priced is false when the instance type is not in the price table, the costs are zero then
+	Priced       bool    `json:"priced"`
+	OnDemandCost float64 `json:"onDemandCost"`
+	SpotCost     float64 `json:"spotCost"`
+	ExtraCost    float64 `json:"extraCost"`
+}
+
+// CostTotals sums the cost ledger entries
+type CostTotals struct {
+	ScaleDowns         int     `json:"scaleDowns"`
+	UnpricedScaleDowns int     `json:"unpricedScaleDowns"`
+	NodeHours          float64 `json:"nodeHours"`
+	OnDemandCost       float64 `json:"onDemandCost"`
+	SpotCost           float64 `json:"spotCost"`
+	ExtraCost          float64 `json:"extraCost"`
+}
+
+// add counts an entry in the totals
+func (t *CostTotals) add(entry CostEntry) {
+	t.ScaleDowns++
+	if !entry.Priced {
+		t.UnpricedScaleDowns++
+	}
+	t.NodeHours += entry.NodeHours
+	t.OnDemandCost += entry.OnDemandCost
+	t.SpotCost += entry.SpotCost
+	t.ExtraCost += entry.ExtraCost
+}
+
+// CostLedgerSummary is the cost ledger as served on CostLedgerEndpoint
+type CostLedgerSummary struct {
+	CostTotals
+	ByInstanceType map[string]CostTotals `json:"byInstanceType"`
+	// RecentEntries holds the latest entries, oldest first
+	RecentEntries []CostEntry `json:"recentEntries"`
+}
+
+// add counts an entry in the summary, dropping the oldest recent entry when full
+func (s *CostLedgerSummary) add(entry CostEntry) {
+	s.CostTotals.add(entry)
+
+	if s.ByInstanceType == nil {
+		s.ByInstanceType = make(map[string]CostTotals)
+	}
+	byType := s.ByInstanceType[entry.InstanceType]
+	byType.add(entry)
+	s.ByInstanceType[entry.InstanceType] = byType
+
+	s.RecentEntries = append(s.RecentEntries, entry)
+	if len(s.RecentEntries) > maxCostLedgerEntries {
+		s.RecentEntries = s.RecentEntries[len(s.RecentEntries)-maxCostLedgerEntries:]
+	}
+}
+
+// copy returns a summary that does not share maps or slices with s
+func (s CostLedgerSummary) copy() CostLedgerSummary {
+	summary := CostLedgerSummary{
+		CostTotals:     s.CostTotals,
+		ByInstanceType: make(map[string]CostTotals, len(s.ByInstanceType)),
+		RecentEntries:  append([]CostEntry{}, s.RecentEntries...),
+	}
+	for instanceType, totals := range s.ByInstanceType {
+		summary.ByInstanceType[instanceType] = totals
+	}
+	return summary
+}
+
+// CostLedger keeps the on-demand node-hours incurred by fallbacks and their estimated extra cost over spot.
+// A nil *CostLedger records nothing.
+type CostLedger struct {
+	prices  PriceTable
+	metrics observability.Metrics
+	summary CostLedgerSummary
+	mutex   sync.Mutex
+	store   *configMapLedgerStore // nil when the ledger is only kept in memory
+}
+
+// NewCostLedger creates an in-memory cost ledger
+func NewCostLedger(prices PriceTable, metrics observability.Metrics) *CostLedger {
+	return &CostLedger{
+		prices:  prices,
+		metrics: metrics,
+	}
+}
+
+// NewPersistentCostLedger creates a cost ledger persisted in a ConfigMap, so totals survive restarts
+// and every replica records into, and serves, the same ledger
+func NewPersistentCostLedger(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	namespace string,
+	name string,
+	prices PriceTable,
+	metrics observability.Metrics,
+) (*CostLedger, error) {
+	ledger := NewCostLedger(prices, metrics)
+	ledger.store = &configMapLedgerStore{clientset: clientset, namespace: namespace, name: name}
+
+	summary, err := ledger.store.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ledger.summary = summary
+
+	log.Info().
+		Str("namespace", namespace).
+		Str("configMap", name).
+		Int("scaleDowns", summary.ScaleDowns).
+		Float64("extraCost", summary.ExtraCost).
+		Msg("Loaded persisted Spot Guard cost ledger")
+	return ledger, nil
+}
+
+// Record adds a retired on-demand node to the ledger.
+// The node ran from the fallback event timestamp, which is the start time annotation in self-monitor and controller mode, until end.
+func (l *CostLedger) Record(ctx context.Context, event *FallbackEvent, end time.Time) {
+	if l == nil {
+		return
+	}
+
+	entry := l.price(event, end)
+	l.metrics.SpotGuardOnDemandCostAdd(entry.OnDemandASGName, entry.InstanceType, entry.NodeHours, entry.OnDemandCost, entry.ExtraCost)
+
+	log.Info().
+		Str("node", entry.NodeName).
+		Str("instanceType", entry.InstanceType).
+		Float64("nodeHours", entry.NodeHours).
+		Bool("priced", entry.Priced).
+		Float64("onDemandCost", entry.OnDemandCost).
+		Float64("extraCost", entry.ExtraCost).
+		Msg("Recorded on-demand node in cost ledger")
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.store != nil {
+		summary, err := l.store.update(ctx, func(summary *CostLedgerSummary) {
+			summary.add(entry)
+		})
+		if err == nil {
+			l.summary = summary
+			return
+		}
+		log.Warn().Err(err).Str("node", entry.NodeName).Msg("Failed to persist cost ledger entry, keeping it in memory")
+	}
+	l.summary.add(entry)
+}
+
+// Summary returns the ledger totals and recent entries, reloading them when the ledger is persisted
+func (l *CostLedger) Summary(ctx context.Context) CostLedgerSummary {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.store != nil {
+		summary, err := l.store.load(ctx)
+		if err == nil {
+			l.summary = summary
+		} else {
+			log.Warn().Err(err).Msg("Failed to reload cost ledger, serving the last known totals")
+		}
+	}
+	return l.summary.copy()
+}
+
+// ServeHTTP serves the ledger summary as JSON
+func (l *CostLedger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(l.Summary(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if _, err := w.Write(body); err != nil {
+		log.Warn().Err(err).Msg("Unable to write cost ledger response")
+	}
+}
+
+// price builds the ledger entry of a retired on-demand node
+func (l *CostLedger) price(event *FallbackEvent, end time.Time) CostEntry {
+	entry := CostEntry{
+		NodeName:        event.OnDemandNodeName,
+		InstanceID:      event.OnDemandInstanceID,
+		InstanceType:    event.OnDemandInstanceType,
+		SpotASGName:     event.SpotASGName,
+		OnDemandASGName: event.OnDemandASGName,
+		Start:           event.Timestamp,
+		End:             end,
+	}
+	if !event.Timestamp.IsZero() && end.After(event.Timestamp) {
+		entry.NodeHours = end.Sub(event.Timestamp).Hours()
+	}
+
+	price, found := l.prices[entry.InstanceType]
+	if !found {
+		return entry
+	}
+	entry.Priced = true
+	entry.OnDemandCost = entry.NodeHours * price.OnDemand
+	entry.SpotCost = entry.NodeHours * price.Spot
+	entry.ExtraCost = entry.OnDemandCost - entry.SpotCost
+	return entry
+}
+
+// configMapLedgerStore persists the cost ledger summary in a single ConfigMap data key
+type configMapLedgerStore struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// load returns the persisted summary, creating the ConfigMap if it does not exist yet
+func (s *configMapLedgerStore) load(ctx context.Context) (CostLedgerSummary, error) {
+	configMap, err := s.getOrCreate(ctx)
+	if err != nil {
+		return CostLedgerSummary{}, err
+	}
+	return s.decode(configMap), nil
+}
+
+// update applies a mutation to the persisted summary and returns the summary as written
+func (s *configMapLedgerStore) update(ctx context.Context, mutate func(summary *CostLedgerSummary)) (CostLedgerSummary, error) {
+	var written CostLedgerSummary
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := s.getOrCreate(ctx)
+		if err != nil {
+			return err
+		}
+
+		summary := s.decode(configMap)
+		mutate(&summary)
+
+		raw, err := json.Marshal(summary)
+		if err != nil {
+			return fmt.Errorf("failed to encode cost ledger: %w", err)
+		}
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data[costLedgerDataKey] = string(raw)
+
+		if _, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		written = summary
+		return nil
+	})
+	if err != nil {
+		return CostLedgerSummary{}, fmt.Errorf("failed to persist cost ledger to ConfigMap %s/%s: %w", s.namespace, s.name, err)
+	}
+
+	return written, nil
+}
+
+// decode parses the persisted summary, starting over when it cannot be parsed
+func (s *configMapLedgerStore) decode(configMap *corev1.ConfigMap) CostLedgerSummary {
+	summary := CostLedgerSummary{}
+	raw, exists := configMap.Data[costLedgerDataKey]
+	if !exists {
+		return summary
+	}
+	if err := json.Unmarshal([]byte(raw), &summary); err != nil {
+		log.Warn().Err(err).Str("configMap", s.name).Msg("Skipping unreadable persisted cost ledger")
+		return CostLedgerSummary{}
+	}
+	return summary
+}
+
+// getOrCreate reads the ConfigMap, creating an empty one on first use
+func (s *configMapLedgerStore) getOrCreate(ctx context.Context) (*corev1.ConfigMap, error) {
+	return getOrCreateConfigMap(ctx, s.clientset, s.namespace, s.name, "the Spot Guard cost ledger")
+}
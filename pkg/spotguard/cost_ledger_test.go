@@ -0,0 +1,114 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func TestParsePriceTable(t *testing.T) {
+	prices, err := ParsePriceTable("m5.large=0.096:0.035, c5.xlarge = 0.17:0.068,,")
+	h.Ok(t, err)
+	h.Equals(t, PriceTable{
+		"m5.large":  {OnDemand: 0.096, Spot: 0.035},
+		"c5.xlarge": {OnDemand: 0.17, Spot: 0.068},
+	}, prices)
+
+	prices, err = ParsePriceTable("")
+	h.Ok(t, err)
+	h.Equals(t, 0, len(prices))
+
+	for _, invalid := range []string{"m5.large", "m5.large=0.096", "=0.096:0.035", "m5.large=abc:0.035", "m5.large=0.096:-1"} {
+		_, err = ParsePriceTable(invalid)
+		h.Assert(t, err != nil, "expected an error for %q", invalid)
+	}
+}
+
+func TestCostLedgerRecord(t *testing.T) {
+	ledger := NewCostLedger(PriceTable{"m5.large": {OnDemand: 0.5, Spot: 0.125}}, observability.Metrics{})
+	end := time.Now()
+
+	ledger.Record(context.Background(), &FallbackEvent{
+		Timestamp:            end.Add(-2 * time.Hour),
+		OnDemandASGName:      "od-asg",
+		OnDemandNodeName:     "od-1",
+		OnDemandInstanceType: "m5.large",
+	}, end)
+	ledger.Record(context.Background(), &FallbackEvent{
+		Timestamp:            end.Add(-time.Hour),
+		OnDemandASGName:      "od-asg",
+		OnDemandNodeName:     "od-2",
+		OnDemandInstanceType: "r5.large",
+	}, end)
+
+	summary := ledger.Summary(context.Background())
+	h.Equals(t, 2, summary.ScaleDowns)
+	h.Equals(t, 1, summary.UnpricedScaleDowns)
+	h.Equals(t, 3.0, summary.NodeHours)
+	h.Equals(t, 1.0, summary.OnDemandCost)
+	h.Equals(t, 0.25, summary.SpotCost)
+	h.Equals(t, 0.75, summary.ExtraCost)
+	h.Equals(t, 2.0, summary.ByInstanceType["m5.large"].NodeHours)
+	h.Equals(t, 1, summary.ByInstanceType["r5.large"].UnpricedScaleDowns)
+	h.Equals(t, 2, len(summary.RecentEntries))
+	h.Equals(t, "od-1", summary.RecentEntries[0].NodeName)
+	h.Assert(t, !summary.RecentEntries[1].Priced, "r5.large is not in the price table")
+}
+
+func TestPersistentCostLedgerSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	prices := PriceTable{"m5.large": {OnDemand: 0.5, Spot: 0.125}}
+
+	ledger, err := NewPersistentCostLedger(ctx, clientset, "kube-system", "spot-guard-cost", prices, observability.Metrics{})
+	h.Ok(t, err)
+	end := time.Now()
+	ledger.Record(ctx, &FallbackEvent{
+		Timestamp:            end.Add(-time.Hour),
+		OnDemandASGName:      "od-asg",
+		OnDemandNodeName:     "od-1",
+		OnDemandInstanceType: "m5.large",
+	}, end)
+
+	// Another replica sees the entry
+	restarted, err := NewPersistentCostLedger(ctx, clientset, "kube-system", "spot-guard-cost", prices, observability.Metrics{})
+	h.Ok(t, err)
+	summary := restarted.Summary(ctx)
+	h.Equals(t, 1, summary.ScaleDowns)
+	h.Equals(t, 0.5, summary.OnDemandCost)
+
+	recorder := httptest.NewRecorder()
+	restarted.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, CostLedgerEndpoint, nil))
+	h.Equals(t, http.StatusOK, recorder.Code)
+
+	served := CostLedgerSummary{}
+	h.Ok(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+	h.Equals(t, 1, served.ScaleDowns)
+	h.Equals(t, "od-1", served.RecentEntries[0].NodeName)
+}
+
+func TestNilCostLedgerRecordsNothing(t *testing.T) {
+	var ledger *CostLedger
+	ledger.Record(context.Background(), &FallbackEvent{OnDemandNodeName: "od-1"}, time.Now())
+}
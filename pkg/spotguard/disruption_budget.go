@@ -0,0 +1,162 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// budgetState is one PodDisruptionBudget and the pods of the drained node it covers
+type budgetState struct {
+	pdb      *policyv1.PodDisruptionBudget
+	selector labels.Selector
+	// evicted is how many pods of the node the drain evicts against the budget
+	evicted int
+	// startup is the longest a pod of the budget took to become ready, how long a replacement is expected to take
+	startup time.Duration
+}
+
+// disruptionBudgets replays the eviction API's PodDisruptionBudget checks for the pods of a drained node.
+// Every eviction is charged against the budget of its PDB. Once the budget is used up, the drain retries
+// the refused evictions until its timeout, and each retry only succeeds once the replacement of an earlier
+// eviction is ready. A PDB whose pods need more of these waits than the drain timeout allows blocks, as
+// does a PDB allowing no disruption at all, since nothing on the node can start the drain.
+type disruptionBudgets struct {
+	budgets      []*budgetState
+	drainTimeout time.Duration
+}
+
+// newDisruptionBudgets snapshots the PodDisruptionBudgets of the cluster. A drainTimeout of 0 lets
+// evictions wait for replacements indefinitely.
+func newDisruptionBudgets(pdbs []policyv1.PodDisruptionBudget, drainTimeout time.Duration) *disruptionBudgets {
+	db := &disruptionBudgets{drainTimeout: drainTimeout}
+	for i := range pdbs {
+		selector, err := metav1.LabelSelectorAsSelector(pdbs[i].Spec.Selector)
+		if err != nil {
+			// The eviction API skips PDBs with an invalid selector as well
+			continue
+		}
+		db.budgets = append(db.budgets, &budgetState{
+			pdb:      &pdbs[i],
+			selector: selector,
+		})
+	}
+	return db
+}
+
+// evict reports whether the drain gets the pod evicted, now or once earlier evictions of its PDB were
+// replaced within the drain timeout. When it would not, the second value explains which PDB refuses it.
+// The pods of the node are expected in the order the drain evicts them.
+func (db *disruptionBudgets) evict(pod *corev1.Pod) (bool, string) {
+	// Pods that are not running or already terminating are deleted without consulting PDBs
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed ||
+		pod.Status.Phase == corev1.PodPending || pod.DeletionTimestamp != nil {
+		return true, ""
+	}
+
+	matching := make([]*budgetState, 0, 1)
+	for _, budget := range db.budgets {
+		if budget.pdb.Namespace == pod.Namespace && budget.selector.Matches(labels.Set(pod.Labels)) {
+			matching = append(matching, budget)
+		}
+	}
+	if len(matching) == 0 {
+		return true, ""
+	}
+	if len(matching) > 1 {
+		names := make([]string, 0, len(matching))
+		for _, budget := range matching {
+			names = append(names, budget.pdb.Name)
+		}
+		return false, fmt.Sprintf("pod is covered by several PDBs (%s), which the eviction API refuses", strings.Join(names, ", "))
+	}
+
+	budget := matching[0]
+	pdb := budget.pdb
+	if pdb.Status.ObservedGeneration < pdb.Generation {
+		return false, fmt.Sprintf("PDB %s has not been processed by the disruption controller yet", pdb.Name)
+	}
+
+	healthy := isPodReady(pod)
+	if !healthy {
+		// Unhealthy pods do not count towards the budget and may be evicted without using it
+		if pdb.Spec.UnhealthyPodEvictionPolicy != nil && *pdb.Spec.UnhealthyPodEvictionPolicy == policyv1.AlwaysAllow {
+			return true, ""
+		}
+		if pdb.Status.CurrentHealthy >= pdb.Status.DesiredHealthy {
+			return true, ""
+		}
+	}
+
+	if pdb.Status.DisruptionsAllowed <= 0 {
+		return false, fmt.Sprintf("PDB %s would be violated: it allows no disruption", pdb.Name)
+	}
+
+	budget.evicted++
+	if startup := podStartupDuration(pod); startup > budget.startup {
+		budget.startup = startup
+	}
+	allowed := int(pdb.Status.DisruptionsAllowed)
+	// Evictions beyond the budget wait for one replacement per round of allowed disruptions
+	waits := (budget.evicted - 1) / allowed
+	if waits == 0 {
+		return true, ""
+	}
+	wait := time.Duration(waits) * budget.startup
+	if db.drainTimeout > 0 && wait > db.drainTimeout {
+		return false, fmt.Sprintf("PDB %s allows %d disruptions, evicting %d pods waits about %v for replacements, longer than the drain timeout of %v",
+			pdb.Name, allowed, budget.evicted, wait, db.drainTimeout)
+	}
+	log.Debug().
+		Str("pdb", pdb.Name).
+		Str("pod", pod.Namespace+"/"+pod.Name).
+		Int("disruptionsAllowed", allowed).
+		Int("evicted", budget.evicted).
+		Dur("wait", wait).
+		Msg("Pod is evicted once earlier evictions of its PDB were replaced")
+	return true, ""
+}
+
+// podStartupDuration returns how long the pod took from starting to becoming ready, 0 when it is not known
+func podStartupDuration(pod *corev1.Pod) time.Duration {
+	if pod.Status.StartTime == nil {
+		return 0
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			if startup := condition.LastTransitionTime.Sub(pod.Status.StartTime.Time); startup > 0 {
+				return startup
+			}
+		}
+	}
+	return 0
+}
+
+// isPodReady checks if a pod has the Ready condition
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
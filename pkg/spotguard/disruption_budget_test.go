@@ -0,0 +1,197 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func testPDB(name string, appLabel string, disruptionsAllowed int32, currentHealthy int32, desiredHealthy int32) policyv1.PodDisruptionBudget {
+	return policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": appLabel}},
+		},
+		Status: policyv1.PodDisruptionBudgetStatus{
+			DisruptionsAllowed: disruptionsAllowed,
+			CurrentHealthy:     currentHealthy,
+			DesiredHealthy:     desiredHealthy,
+		},
+	}
+}
+
+func readyPod(name string, nodeName string, appLabel string, ready bool) corev1.Pod {
+	pod := simPod(name, nodeName, "100m", map[string]string{"app": appLabel})
+	pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: appLabel, Controller: aws.Bool(true)}}
+	status := corev1.ConditionTrue
+	if !ready {
+		status = corev1.ConditionFalse
+	}
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+	return pod
+}
+
+func TestDisruptionBudgetsSequential(t *testing.T) {
+	budgets := newDisruptionBudgets([]policyv1.PodDisruptionBudget{
+		testPDB("web", "web", 1, 3, 2),
+		testPDB("db", "db", 0, 3, 3),
+	}, 0)
+
+	first := readyPod("web-0", "od-1", "web", true)
+	second := readyPod("web-1", "od-1", "web", true)
+	allowed, _ := budgets.evict(&first)
+	h.Assert(t, allowed, "the first pod fits the budget")
+	allowed, reason := budgets.evict(&second)
+	h.Assert(t, allowed, "the second pod is evicted once the first one was replaced: %s", reason)
+
+	db := readyPod("db-0", "od-1", "db", true)
+	allowed, reason = budgets.evict(&db)
+	h.Assert(t, !allowed, "a PDB allowing no disruption blocks")
+	h.Assert(t, strings.Contains(reason, "allows no disruption"), reason)
+}
+
+// startedPod returns a ready pod that took startup to become ready
+func startedPod(name string, appLabel string, startup time.Duration) corev1.Pod {
+	pod := readyPod(name, "od-1", appLabel, true)
+	started := metav1.NewTime(time.Now().Add(-time.Hour))
+	pod.Status.StartTime = &started
+	pod.Status.Conditions[0].LastTransitionTime = metav1.NewTime(started.Add(startup))
+	return pod
+}
+
+func TestDisruptionBudgetsDrainTimeout(t *testing.T) {
+	budgets := newDisruptionBudgets([]policyv1.PodDisruptionBudget{
+		testPDB("web", "web", 1, 4, 3),
+		testPDB("api", "api", 2, 4, 2),
+	}, 2*time.Minute)
+
+	// One disruption at a time: the second pod waits for one replacement, the third for two
+	for i, expected := range []bool{true, true, false} {
+		pod := startedPod(fmt.Sprintf("web-%d", i), "web", 90*time.Second)
+		allowed, reason := budgets.evict(&pod)
+		h.Equals(t, expected, allowed)
+		if !allowed {
+			h.Assert(t, strings.Contains(reason, "longer than the drain timeout"), reason)
+		}
+	}
+
+	// Two at a time: the third pod only waits for one replacement
+	for i := 0; i < 3; i++ {
+		pod := startedPod(fmt.Sprintf("api-%d", i), "api", 90*time.Second)
+		allowed, reason := budgets.evict(&pod)
+		h.Assert(t, allowed, "unexpected refusal: %s", reason)
+	}
+}
+
+func TestDisruptionBudgetsSeveralPDBs(t *testing.T) {
+	budgets := newDisruptionBudgets([]policyv1.PodDisruptionBudget{
+		testPDB("web", "web", 5, 5, 0),
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "everything", Namespace: "default"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{}},
+			Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 5},
+		},
+	}, 0)
+
+	pod := readyPod("web-0", "od-1", "web", true)
+	allowed, reason := budgets.evict(&pod)
+	h.Assert(t, !allowed, "the eviction API refuses pods covered by more than one PDB")
+	h.Assert(t, strings.Contains(reason, "several PDBs"), reason)
+}
+
+func TestDisruptionBudgetsUnhealthyPodEvictionPolicy(t *testing.T) {
+	// Budget exhausted and not enough healthy pods, only AlwaysAllow lets the unready pod go
+	ifHealthy := testPDB("web", "web", 0, 1, 2)
+	alwaysAllow := testPDB("api", "api", 0, 1, 2)
+	policy := policyv1.AlwaysAllow
+	alwaysAllow.Spec.UnhealthyPodEvictionPolicy = &policy
+	budgets := newDisruptionBudgets([]policyv1.PodDisruptionBudget{ifHealthy, alwaysAllow}, 0)
+
+	unreadyWeb := readyPod("web-0", "od-1", "web", false)
+	allowed, _ := budgets.evict(&unreadyWeb)
+	h.Assert(t, !allowed, "IfHealthyBudget keeps the unready pod while the PDB is unhealthy")
+
+	unreadyAPI := readyPod("api-0", "od-1", "api", false)
+	allowed, _ = budgets.evict(&unreadyAPI)
+	h.Assert(t, allowed, "AlwaysAllow evicts unready pods regardless of the budget")
+
+	readyAPI := readyPod("api-1", "od-1", "api", true)
+	allowed, _ = budgets.evict(&readyAPI)
+	h.Assert(t, !allowed, "ready pods still need budget under AlwaysAllow")
+
+	// A healthy PDB lets unready pods go without using its budget
+	budgets = newDisruptionBudgets([]policyv1.PodDisruptionBudget{testPDB("web", "web", 0, 3, 2)}, 0)
+	allowed, _ = budgets.evict(&unreadyWeb)
+	h.Assert(t, allowed, "IfHealthyBudget evicts unready pods while enough pods are healthy")
+
+	// A PDB requiring no healthy pod is always healthy enough
+	budgets = newDisruptionBudgets([]policyv1.PodDisruptionBudget{testPDB("web", "web", 0, 0, 0)}, 0)
+	allowed, _ = budgets.evict(&unreadyWeb)
+	h.Assert(t, allowed, "IfHealthyBudget evicts unready pods of a PDB without a desired healthy count")
+}
+
+func TestDisruptionBudgetsIgnoredPods(t *testing.T) {
+	budgets := newDisruptionBudgets([]policyv1.PodDisruptionBudget{testPDB("web", "web", 0, 2, 2)}, 0)
+
+	pending := readyPod("web-0", "od-1", "web", false)
+	pending.Status.Phase = corev1.PodPending
+	allowed, _ := budgets.evict(&pending)
+	h.Assert(t, allowed, "pending pods are deleted without consulting PDBs")
+
+	other := readyPod("db-0", "od-1", "db", true)
+	allowed, _ = budgets.evict(&other)
+	h.Assert(t, allowed, "pods without a PDB can always be evicted")
+
+	stale := testPDB("web", "web", 1, 2, 1)
+	stale.Generation = 2
+	stale.Status.ObservedGeneration = 1
+	budgets = newDisruptionBudgets([]policyv1.PodDisruptionBudget{stale}, 0)
+	pod := readyPod("web-1", "od-1", "web", true)
+	allowed, _ = budgets.evict(&pod)
+	h.Assert(t, !allowed, "a PDB the disruption controller has not observed yet refuses evictions")
+}
+
+func TestCanSafelyDrainNodeEvictsPDBPodsSequentially(t *testing.T) {
+	drained := simNode("od-1", "us-east-1a", "4", "8Gi")
+	spare := simNode("spot-1", "us-east-1a", "4", "8Gi")
+	first := readyPod("web-0", "od-1", "web", true)
+	second := readyPod("web-1", "od-1", "web", true)
+	pdb := testPDB("web", "web", 1, 4, 3)
+
+	clientset := fake.NewSimpleClientset(&drained, &spare, &first, &second, &pdb)
+	checker := NewSafetyChecker(clientset, 100)
+
+	canDrain, reason := checker.CanSafelyDrainNode(context.Background(), "od-1", nil)
+	h.Assert(t, canDrain, "two reschedulable pods of a PDB allowing one disruption are drained one after the other: %s", reason)
+
+	// Without a disruption allowed the drain cannot even start
+	pdb.Status.DisruptionsAllowed = 0
+	_, err := clientset.PolicyV1().PodDisruptionBudgets("default").UpdateStatus(context.Background(), &pdb, metav1.UpdateOptions{})
+	h.Ok(t, err)
+	canDrain, reason = checker.CanSafelyDrainNode(context.Background(), "od-1", nil)
+	h.Assert(t, !canDrain, "a PDB allowing no disruption must block the drain")
+	h.Equals(t, ScaleDownReasonPDBBlocked, drainBlockReason(reason))
+}
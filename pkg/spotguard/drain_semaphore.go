@@ -0,0 +1,402 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/rs/zerolog/log"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// AnnotationDrainHolders lists the nodes currently holding a drain slot on the drain semaphore Lease
+const AnnotationDrainHolders = "spot-guard.aws.amazon.com/drain-holders"
+
+const (
+	// drainHolderTTL is how long a drain slot is kept without renewal, so a crashed replica frees it
+	drainHolderTTL = 60 * time.Second
+	// drainRenewInterval is how often a held drain slot is renewed
+	drainRenewInterval = drainHolderTTL / 3
+)
+
+// drainHolder is one drain in progress
+type drainHolder struct {
+	Acquired time.Time `json:"acquired"`
+	Renewed  time.Time `json:"renewed"`
+}
+
+// drainSemaphore caps concurrent Spot Guard drains across all replicas and spaces them out.
+// The holders live in an annotation of a single coordination.k8s.io Lease, so the limit and the
+// spacing are checked and taken in one optimistic update. A nil *drainSemaphore never blocks.
+type drainSemaphore struct {
+	clientset        kubernetes.Interface
+	provider         CapacityProvider
+	namespace        string
+	name             string
+	onDemandASGName  string
+	maxDrains        int
+	maxDrainsPercent int
+	minSpacing       time.Duration
+}
+
+// newDrainSemaphore returns the drain semaphore configured by the Spot Guard flags, or nil when drains are not limited
+func newDrainSemaphore(clientset kubernetes.Interface, provider CapacityProvider, nthConfig config.Config) *drainSemaphore {
+	if nthConfig.SpotGuardMaxConcurrentDrains <= 0 && nthConfig.SpotGuardMaxConcurrentDrainsPercent <= 0 && nthConfig.SpotGuardMinDrainSpacing <= 0 {
+		return nil
+	}
+	return &drainSemaphore{
+		clientset:        clientset,
+		provider:         provider,
+		namespace:        nthConfig.PodNamespace,
+		name:             nthConfig.SpotGuardDrainLeaseName,
+		onDemandASGName:  nthConfig.OnDemandAsgName,
+		maxDrains:        nthConfig.SpotGuardMaxConcurrentDrains,
+		maxDrainsPercent: nthConfig.SpotGuardMaxConcurrentDrainsPercent,
+		minSpacing:       time.Duration(nthConfig.SpotGuardMinDrainSpacing) * time.Second,
+	}
+}
+
+// semaphoreBlockReason maps an acquire error to the ScaleDownReason reported for it
+func semaphoreBlockReason(err error) string {
+	switch {
+	case errors.Is(err, ErrDrainConcurrencyLimit):
+		return ScaleDownReasonConcurrencyLimit
+	case errors.Is(err, ErrDrainSpacing):
+		return ScaleDownReasonDrainSpacing
+	}
+	return ScaleDownReasonCheckFailed
+}
+
+// acquire takes a drain slot for holder. The returned release stops renewing the slot and frees it,
+// it must be called once the drain finished. When no slot is free the error wraps
+// ErrDrainConcurrencyLimit or ErrDrainSpacing.
+func (s *drainSemaphore) acquire(ctx context.Context, holder string) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	limit, err := s.limit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.update(ctx, func(lease *coordinationv1.Lease, holders map[string]drainHolder, now time.Time) error {
+		if _, held := holders[holder]; held {
+			// Taken before a restart, keep it
+			holders[holder] = drainHolder{Acquired: holders[holder].Acquired, Renewed: now}
+			return nil
+		}
+		if len(holders) >= limit {
+			return fmt.Errorf("%w: %d of %d drain slot(s) in use by %v", ErrDrainConcurrencyLimit, len(holders), limit, holderNames(holders))
+		}
+		if s.minSpacing > 0 && lease.Spec.AcquireTime != nil {
+			if since := now.Sub(lease.Spec.AcquireTime.Time); since < s.minSpacing {
+				return fmt.Errorf("%w: last drain started %v ago, minimum spacing is %v", ErrDrainSpacing, since.Round(time.Second), s.minSpacing)
+			}
+		}
+
+		holders[holder] = drainHolder{Acquired: now, Renewed: now}
+		lease.Spec.HolderIdentity = aws.String(holder)
+		lease.Spec.AcquireTime = &metav1.MicroTime{Time: now}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("holder", holder).
+		Str("lease", s.name).
+		Int("limit", limit).
+		Msg("Acquired Spot Guard drain slot")
+
+	renewCtx, stopRenewing := context.WithCancel(ctx)
+	go s.renew(renewCtx, holder)
+
+	return func() {
+		stopRenewing()
+		// Release even when the drain context was cancelled, otherwise the slot stays taken until it expires
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.update(releaseCtx, func(_ *coordinationv1.Lease, holders map[string]drainHolder, _ time.Time) error {
+			delete(holders, holder)
+			return nil
+		}); err != nil {
+			log.Warn().Err(err).Str("holder", holder).Msg("Failed to release Spot Guard drain slot, it expires on its own")
+			return
+		}
+		log.Info().Str("holder", holder).Str("lease", s.name).Msg("Released Spot Guard drain slot")
+	}, nil
+}
+
+// renew keeps the drain slot of holder alive until the context is cancelled
+func (s *drainSemaphore) renew(ctx context.Context, holder string) {
+	ticker := time.NewTicker(drainRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.update(ctx, func(_ *coordinationv1.Lease, holders map[string]drainHolder, now time.Time) error {
+				if current, held := holders[holder]; held {
+					holders[holder] = drainHolder{Acquired: current.Acquired, Renewed: now}
+				}
+				return nil
+			})
+			if err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Str("holder", holder).Msg("Failed to renew Spot Guard drain slot")
+			}
+		}
+	}
+}
+
+// limit returns how many drains may run at once: maxDrains, maxDrainsPercent of the on-demand ASG,
+// or the lower of both when both are set. A percentage is at least 1 so a small ASG still makes progress.
+func (s *drainSemaphore) limit(ctx context.Context) (int, error) {
+	limit := math.MaxInt
+	if s.maxDrains > 0 {
+		limit = s.maxDrains
+	}
+	if s.maxDrainsPercent > 0 {
+		pool, err := s.provider.DescribePool(ctx, s.onDemandASGName)
+		if err != nil {
+			return 0, fmt.Errorf("failed to describe on-demand ASG: %w", err)
+		}
+		percentLimit := max(1, int(pool.DesiredCapacity)*s.maxDrainsPercent/100)
+		limit = min(limit, percentLimit)
+	}
+	return limit, nil
+}
+
+// update applies a mutation to the drain holders of the Lease, dropping expired holders first.
+// An error returned by mutate is returned as is and nothing is written.
+func (s *drainSemaphore) update(ctx context.Context, mutate func(lease *coordinationv1.Lease, holders map[string]drainHolder, now time.Time) error) error {
+	var mutateErr error
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mutateErr = nil
+		lease, err := s.getOrCreate(ctx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		holders := decodeDrainHolders(lease.Annotations[AnnotationDrainHolders])
+		for name, holder := range holders {
+			if now.Sub(holder.Renewed) > drainHolderTTL {
+				log.Warn().Str("holder", name).Msg("Spot Guard drain slot expired, its holder stopped renewing it")
+				delete(holders, name)
+			}
+		}
+
+		if mutateErr = mutate(lease, holders, now); mutateErr != nil {
+			return nil
+		}
+
+		raw, err := json.Marshal(holders)
+		if err != nil {
+			return fmt.Errorf("failed to encode drain holders: %w", err)
+		}
+		if lease.Annotations == nil {
+			lease.Annotations = make(map[string]string)
+		}
+		lease.Annotations[AnnotationDrainHolders] = string(raw)
+		lease.Spec.RenewTime = &metav1.MicroTime{Time: now}
+		lease.Spec.LeaseDurationSeconds = aws.Int32(int32(drainHolderTTL.Seconds()))
+
+		_, err = s.clientset.CoordinationV1().Leases(s.namespace).Update(ctx, lease, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update drain semaphore Lease %s/%s: %w", s.namespace, s.name, err)
+	}
+	return mutateErr
+}
+
+// getOrCreate reads the Lease, creating an empty one on first use
+func (s *drainSemaphore) getOrCreate(ctx context.Context) (*coordinationv1.Lease, error) {
+	leases := s.clientset.CoordinationV1().Leases(s.namespace)
+	lease, _, err := getOrCreate(ctx, "Lease", s.namespace, s.name, leases.Get, leases.Create, &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.name,
+			Namespace: s.namespace,
+		},
+	})
+	return lease, err
+}
+
+// decodeDrainHolders parses the holders annotation, treating an unreadable one as empty
+func decodeDrainHolders(raw string) map[string]drainHolder {
+	holders := make(map[string]drainHolder)
+	if raw == "" {
+		return holders
+	}
+	if err := json.Unmarshal([]byte(raw), &holders); err != nil {
+		log.Warn().Err(err).Msg("Skipping unreadable Spot Guard drain holders")
+		return make(map[string]drainHolder)
+	}
+	return holders
+}
+
+// holderNames returns the sorted names of the holders
+func holderNames(holders map[string]drainHolder) []string {
+	names := make([]string, 0, len(holders))
+	for name := range holders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AnnotationShiftHolder holds the lock serializing on-demand shifts of a mixed instances ASG
+const AnnotationShiftHolder = "spot-guard.aws.amazon.com/on-demand-shift-holder"
+
+const (
+	// shiftLockTTL is how long the shift lock is kept, a shift is a handful of ASG API calls
+	shiftLockTTL = 60 * time.Second
+	// shiftLockWait is how long a shift waits for another replica to finish its own
+	shiftLockWait = 2 * time.Minute
+	// shiftLockRetryInterval is how often a busy shift lock is tried again
+	shiftLockRetryInterval = time.Second
+)
+
+// shiftHolder is the replica shifting the ASG
+type shiftHolder struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// ShiftLock serializes the read-modify-write of a mixed instances ASG's on-demand shift across replicas.
+// It lives in an annotation of the drain semaphore Lease, so taking it is one optimistic update.
+// A nil *ShiftLock does not lock.
+type ShiftLock struct {
+	lease  *drainSemaphore
+	holder string
+}
+
+// NewShiftLock returns the shift lock of this replica on the drain semaphore Lease
+func NewShiftLock(clientset kubernetes.Interface, nthConfig config.Config) *ShiftLock {
+	return &ShiftLock{
+		lease:  &drainSemaphore{clientset: clientset, namespace: nthConfig.PodNamespace, name: nthConfig.SpotGuardDrainLeaseName},
+		holder: nthConfig.PodName,
+	}
+}
+
+// withLock runs fn while holding the shift lock, waiting up to shiftLockWait for another holder
+func (l *ShiftLock) withLock(ctx context.Context, fn func() error) error {
+	if l == nil {
+		return fn()
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, shiftLockWait)
+	defer cancel()
+	for {
+		holder, err := l.tryAcquire(waitCtx)
+		if err != nil {
+			return err
+		}
+		if holder == "" {
+			break
+		}
+		select {
+		case <-waitCtx.Done():
+			return fmt.Errorf("timed out waiting for the on-demand shift lock held by %s: %w", holder, waitCtx.Err())
+		case <-time.After(shiftLockRetryInterval):
+		}
+	}
+	defer l.release()
+	return fn()
+}
+
+// tryAcquire takes the lock unless another replica holds it, whose name is returned then
+func (l *ShiftLock) tryAcquire(ctx context.Context) (string, error) {
+	busy := ""
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		busy = ""
+		lease, err := l.lease.getOrCreate(ctx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if current := decodeShiftHolder(lease.Annotations[AnnotationShiftHolder]); current != nil &&
+			current.Holder != l.holder && now.Before(current.Expires) {
+			busy = current.Holder
+			return nil
+		}
+
+		raw, err := json.Marshal(shiftHolder{Holder: l.holder, Expires: now.Add(shiftLockTTL)})
+		if err != nil {
+			return fmt.Errorf("failed to encode shift holder: %w", err)
+		}
+		if lease.Annotations == nil {
+			lease.Annotations = make(map[string]string)
+		}
+		lease.Annotations[AnnotationShiftHolder] = string(raw)
+		_, err = l.lease.clientset.CoordinationV1().Leases(l.lease.namespace).Update(ctx, lease, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to take the on-demand shift lock on Lease %s/%s: %w", l.lease.namespace, l.lease.name, err)
+	}
+	return busy, nil
+}
+
+// release frees the lock if this replica still holds it, even when the shift context was cancelled
+func (l *ShiftLock) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		lease, err := l.lease.getOrCreate(ctx)
+		if err != nil {
+			return err
+		}
+		if current := decodeShiftHolder(lease.Annotations[AnnotationShiftHolder]); current == nil || current.Holder != l.holder {
+			return nil
+		}
+		delete(lease.Annotations, AnnotationShiftHolder)
+		_, err = l.lease.clientset.CoordinationV1().Leases(l.lease.namespace).Update(ctx, lease, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("holder", l.holder).Msg("Failed to release the on-demand shift lock, it expires on its own")
+	}
+}
+
+// decodeShiftHolder parses the shift holder annotation, nil when unset or unreadable
+func decodeShiftHolder(raw string) *shiftHolder {
+	if raw == "" {
+		return nil
+	}
+	holder := &shiftHolder{}
+	if err := json.Unmarshal([]byte(raw), holder); err != nil {
+		log.Warn().Err(err).Msg("Skipping unreadable Spot Guard on-demand shift holder")
+		return nil
+	}
+	return holder
+}
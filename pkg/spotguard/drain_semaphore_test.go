@@ -0,0 +1,121 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func testDrainSemaphore(nthConfig config.Config, desiredCapacity int64) *drainSemaphore {
+	nthConfig.PodNamespace = "kube-system"
+	nthConfig.SpotGuardDrainLeaseName = "spot-guard-drains"
+	nthConfig.OnDemandAsgName = "od-asg"
+	asgClient := mockedDescribeASG{group: &autoscaling.Group{DesiredCapacity: aws.Int64(desiredCapacity)}}
+	return newDrainSemaphore(fake.NewSimpleClientset(), NewASGCapacityProvider(asgClient, nil), nthConfig)
+}
+
+func TestDrainSemaphoreDisabled(t *testing.T) {
+	semaphore := newDrainSemaphore(fake.NewSimpleClientset(), nil, config.Config{})
+	h.Assert(t, semaphore == nil, "no limit configured should disable the semaphore")
+
+	release, err := semaphore.acquire(context.Background(), "od-1")
+	h.Ok(t, err)
+	release()
+}
+
+func TestDrainSemaphoreConcurrencyLimit(t *testing.T) {
+	ctx := context.Background()
+	semaphore := testDrainSemaphore(config.Config{SpotGuardMaxConcurrentDrains: 2}, 10)
+
+	releaseFirst, err := semaphore.acquire(ctx, "od-1")
+	h.Ok(t, err)
+	releaseSecond, err := semaphore.acquire(ctx, "od-2")
+	h.Ok(t, err)
+	defer releaseSecond()
+
+	_, err = semaphore.acquire(ctx, "od-3")
+	h.Assert(t, errors.Is(err, ErrDrainConcurrencyLimit), "expected the concurrency limit, got %v", err)
+	h.Equals(t, ScaleDownReasonConcurrencyLimit, semaphoreBlockReason(err))
+
+	releaseFirst()
+	releaseThird, err := semaphore.acquire(ctx, "od-3")
+	h.Ok(t, err)
+	releaseThird()
+}
+
+func TestDrainSemaphorePercentLimit(t *testing.T) {
+	ctx := context.Background()
+
+	// 25% of 10 nodes rounds down to 2, the lower of both limits wins
+	semaphore := testDrainSemaphore(config.Config{SpotGuardMaxConcurrentDrains: 5, SpotGuardMaxConcurrentDrainsPercent: 25}, 10)
+	limit, err := semaphore.limit(ctx)
+	h.Ok(t, err)
+	h.Equals(t, 2, limit)
+
+	// A small ASG still drains one node at a time
+	semaphore = testDrainSemaphore(config.Config{SpotGuardMaxConcurrentDrainsPercent: 10}, 3)
+	limit, err = semaphore.limit(ctx)
+	h.Ok(t, err)
+	h.Equals(t, 1, limit)
+}
+
+func TestDrainSemaphoreSpacing(t *testing.T) {
+	ctx := context.Background()
+	semaphore := testDrainSemaphore(config.Config{SpotGuardMinDrainSpacing: 300}, 10)
+
+	release, err := semaphore.acquire(ctx, "od-1")
+	h.Ok(t, err)
+	release()
+
+	// The first drain is done but started too recently
+	_, err = semaphore.acquire(ctx, "od-2")
+	h.Assert(t, errors.Is(err, ErrDrainSpacing), "expected the spacing to block, got %v", err)
+	h.Equals(t, ScaleDownReasonDrainSpacing, semaphoreBlockReason(err))
+}
+
+func TestDrainSemaphoreExpiredHolder(t *testing.T) {
+	ctx := context.Background()
+	semaphore := testDrainSemaphore(config.Config{SpotGuardMaxConcurrentDrains: 1}, 10)
+
+	// A replica that crashed while draining stopped renewing its slot
+	stale := time.Now().Add(-2 * drainHolderTTL)
+	raw, err := json.Marshal(map[string]drainHolder{"od-crashed": {Acquired: stale, Renewed: stale}})
+	h.Ok(t, err)
+	lease, err := semaphore.getOrCreate(ctx)
+	h.Ok(t, err)
+	lease.Annotations = map[string]string{AnnotationDrainHolders: string(raw)}
+	_, err = semaphore.clientset.CoordinationV1().Leases("kube-system").Update(ctx, lease, metav1.UpdateOptions{})
+	h.Ok(t, err)
+
+	release, err := semaphore.acquire(ctx, "od-1")
+	h.Ok(t, err)
+	defer release()
+
+	lease, err = semaphore.clientset.CoordinationV1().Leases("kube-system").Get(ctx, "spot-guard-drains", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, []string{"od-1"}, holderNames(decodeDrainHolders(lease.Annotations[AnnotationDrainHolders])))
+	h.Equals(t, "od-1", aws.StringValue(lease.Spec.HolderIdentity))
+}
@@ -30,4 +30,43 @@ var (
 
 	// ErrInvalidMaxUtilization is returned when max utilization is invalid
 	ErrInvalidMaxUtilization = errors.New("max cluster utilization must be between 0 and 100")
+
+	// ErrStateNamespaceRequired is returned when a state ConfigMap is configured without a namespace
+	ErrStateNamespaceRequired = errors.New("state ConfigMap namespace is required when a state ConfigMap name is set")
+
+	// ErrUnknownPolicyPreset is returned when a SpotGuardPolicy names a preset that does not exist
+	ErrUnknownPolicyPreset = errors.New("unknown policy preset, must be one of default, conservative or aggressive")
+
+	// ErrCapacityUnavailable is returned when EC2 has no capacity for the requested instance types
+	ErrCapacityUnavailable = errors.New("insufficient instance capacity")
+
+	// ErrQuotaExceeded is returned when the account vCPU or instance quota is reached
+	ErrQuotaExceeded = errors.New("instance quota exceeded")
+
+	// ErrInvalidLaunchTemplate is returned when the launch template or AMI cannot be launched
+	ErrInvalidLaunchTemplate = errors.New("invalid launch template or AMI")
+
+	// ErrPermissionDenied is returned when an IAM permission or instance profile is missing
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrMaxSizeReached is returned when the ASG is already at its maximum size
+	ErrMaxSizeReached = errors.New("ASG is at its maximum size")
+
+	// ErrScaleUpTimeout is returned when no new instance reached InService in time
+	ErrScaleUpTimeout = errors.New("timeout waiting for new instance to reach InService")
+
+	// ErrInstanceNotInASG is returned when the instance to scale down is not a member of the ASG
+	ErrInstanceNotInASG = errors.New("instance is not a member of the ASG")
+
+	// ErrWrongInstanceTerminated is returned when a different instance than the drained one was terminated
+	ErrWrongInstanceTerminated = errors.New("a different instance than the drained one was terminated")
+
+	// ErrTerminationTimeout is returned when the drained instance was not seen terminating in time
+	ErrTerminationTimeout = errors.New("timeout waiting for the drained instance to start terminating")
+
+	// ErrDrainConcurrencyLimit is returned when the cluster-wide number of concurrent drains is reached
+	ErrDrainConcurrencyLimit = errors.New("concurrent drain limit reached")
+
+	// ErrDrainSpacing is returned when the previous drain started less than the minimum spacing ago
+	ErrDrainSpacing = errors.New("minimum spacing between drains not met")
 )
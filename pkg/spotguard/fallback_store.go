@@ -0,0 +1,148 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// configMapEventStore persists fallback events in a ConfigMap, one data key per event ID.
+// Writes carry the resourceVersion that was read, so concurrent writers conflict and retry
+// instead of overwriting each other.
+type configMapEventStore struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// newConfigMapEventStore creates a store for the given ConfigMap
+func newConfigMapEventStore(clientset kubernetes.Interface, namespace string, name string) *configMapEventStore {
+	return &configMapEventStore{
+		clientset: clientset,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// load returns the persisted events and the resourceVersion they were read at,
+// creating the ConfigMap if it does not exist yet
+func (s *configMapEventStore) load(ctx context.Context) (map[string]*FallbackEvent, string, error) {
+	configMap, err := s.getOrCreate(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return decodeFallbackEvents(configMap.Data), configMap.ResourceVersion, nil
+}
+
+// update applies a mutation to the persisted events and returns the events as written with their resourceVersion
+func (s *configMapEventStore) update(ctx context.Context, mutate func(events map[string]*FallbackEvent)) (map[string]*FallbackEvent, string, error) {
+	var written map[string]*FallbackEvent
+	var resourceVersion string
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		written, resourceVersion, err = s.write(ctx, "", mutate)
+		if apierrors.IsConflict(err) {
+			log.Debug().
+				Str("namespace", s.namespace).
+				Str("configMap", s.name).
+				Msg("Fallback events were modified concurrently, retrying")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to persist fallback events to ConfigMap %s/%s: %w", s.namespace, s.name, err)
+	}
+
+	return written, resourceVersion, nil
+}
+
+// updateAt applies a mutation only if the persisted events are still at resourceVersion.
+// It does not retry, a conflict means another process changed the events since they were read.
+func (s *configMapEventStore) updateAt(ctx context.Context, resourceVersion string, mutate func(events map[string]*FallbackEvent)) (map[string]*FallbackEvent, string, error) {
+	return s.write(ctx, resourceVersion, mutate)
+}
+
+// write applies one read-modify-write to the ConfigMap. A non-empty expectedVersion must match the one read;
+// the write carries the resourceVersion it read either way, so the API server rejects concurrent changes.
+func (s *configMapEventStore) write(ctx context.Context, expectedVersion string, mutate func(events map[string]*FallbackEvent)) (map[string]*FallbackEvent, string, error) {
+	configMap, err := s.getOrCreate(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if expectedVersion != "" && configMap.ResourceVersion != expectedVersion {
+		return nil, "", apierrors.NewConflict(corev1.Resource("configmaps"), s.name,
+			fmt.Errorf("fallback events changed since resourceVersion %s", expectedVersion))
+	}
+
+	events := decodeFallbackEvents(configMap.Data)
+	mutate(events)
+
+	data, err := encodeFallbackEvents(events)
+	if err != nil {
+		return nil, "", err
+	}
+	if reflect.DeepEqual(data, configMap.Data) || (len(data) == 0 && len(configMap.Data) == 0) {
+		return events, configMap.ResourceVersion, nil
+	}
+
+	configMap.Data = data
+	updated, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	return events, updated.ResourceVersion, nil
+}
+
+// getOrCreate reads the ConfigMap, creating an empty one on first use
+func (s *configMapEventStore) getOrCreate(ctx context.Context) (*corev1.ConfigMap, error) {
+	return getOrCreateConfigMap(ctx, s.clientset, s.namespace, s.name, "fallback events")
+}
+
+// decodeFallbackEvents parses ConfigMap data into events, skipping entries that cannot be parsed
+func decodeFallbackEvents(data map[string]string) map[string]*FallbackEvent {
+	events := make(map[string]*FallbackEvent, len(data))
+	for eventID, raw := range data {
+		event := &FallbackEvent{}
+		if err := json.Unmarshal([]byte(raw), event); err != nil {
+			log.Warn().Err(err).Str("eventID", eventID).Msg("Skipping unreadable persisted fallback event")
+			continue
+		}
+		events[eventID] = event
+	}
+	return events
+}
+
+// encodeFallbackEvents serializes events into ConfigMap data
+func encodeFallbackEvents(events map[string]*FallbackEvent) (map[string]string, error) {
+	data := make(map[string]string, len(events))
+	for eventID, event := range events {
+		raw, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode fallback event %s: %w", eventID, err)
+		}
+		data[eventID] = string(raw)
+	}
+	return data, nil
+}
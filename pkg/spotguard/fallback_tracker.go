@@ -14,51 +14,121 @@
 package spotguard
 
 import (
+	"context"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
 )
 
 // FallbackEvent represents a fallback to on-demand instance when spot capacity is unavailable
 type FallbackEvent struct {
-	EventID              string
-	Timestamp            time.Time
-	SpotASGName          string
-	OnDemandASGName      string
-	OnDemandInstanceID   string
-	OnDemandNodeName     string
-	SpotCapacityRestored bool
-	ScaleDownInitiated   bool
-	MinimumWaitDuration  time.Duration
-	SpotHealthySince     *time.Time // When spot became healthy
+	EventID              string        `json:"eventID"`
+	Timestamp            time.Time     `json:"timestamp"`
+	SpotASGName          string        `json:"spotASGName"`
+	OnDemandASGName      string        `json:"onDemandASGName"`
+	OnDemandInstanceID   string        `json:"onDemandInstanceID,omitempty"`
+	OnDemandNodeName     string        `json:"onDemandNodeName,omitempty"`
+	OnDemandInstanceType string        `json:"onDemandInstanceType,omitempty"`
+	SpotCapacityRestored bool          `json:"spotCapacityRestored"`
+	ScaleDownInitiated   bool          `json:"scaleDownInitiated"`
+	MinimumWaitDuration  time.Duration `json:"minimumWaitDuration"`
+	SpotHealthySince     *time.Time    `json:"spotHealthySince,omitempty"` // When spot became healthy
 }
 
 // FallbackTracker manages multiple fallback events
 type FallbackTracker struct {
 	events map[string]*FallbackEvent
 	mutex  sync.RWMutex
+	store  *configMapEventStore // nil when events are only kept in memory
+	// resourceVersion is the version of the persisted events the in-memory copy was read or written at
+	resourceVersion string
 }
 
-// NewFallbackTracker creates a new fallback tracker
+// NewFallbackTracker creates a new in-memory fallback tracker
 func NewFallbackTracker() *FallbackTracker {
 	return &FallbackTracker{
 		events: make(map[string]*FallbackEvent),
 	}
 }
 
+// NewPersistentFallbackTracker creates a fallback tracker backed by a ConfigMap
+// and reloads the events persisted by a previous run
+func NewPersistentFallbackTracker(ctx context.Context, clientset kubernetes.Interface, namespace string, name string) (*FallbackTracker, error) {
+	ft := &FallbackTracker{
+		events: make(map[string]*FallbackEvent),
+		store:  newConfigMapEventStore(clientset, namespace, name),
+	}
+
+	if err := ft.Reload(ctx); err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("namespace", namespace).
+		Str("configMap", name).
+		Int("events", len(ft.events)).
+		Msg("Loaded persisted fallback events")
+	return ft, nil
+}
+
+// Reload replaces the in-memory events with the persisted copy, picking up changes made by other processes
+func (ft *FallbackTracker) Reload(ctx context.Context) error {
+	if ft.store == nil {
+		return nil
+	}
+
+	events, resourceVersion, err := ft.store.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+	ft.events = events
+	ft.resourceVersion = resourceVersion
+	return nil
+}
+
+// apply runs a mutation against the persisted copy when a store is configured, retrying on conflicts,
+// and against the in-memory map otherwise. The in-memory copy is only changed once the write succeeded,
+// so it never holds state other processes cannot see. The caller must hold the write lock.
+func (ft *FallbackTracker) apply(mutate func(events map[string]*FallbackEvent)) error {
+	if ft.store == nil {
+		mutate(ft.events)
+		return nil
+	}
+
+	events, resourceVersion, err := ft.store.update(context.Background(), mutate)
+	if err != nil {
+		return err
+	}
+	ft.events = events
+	ft.resourceVersion = resourceVersion
+	return nil
+}
+
 // AddEvent adds a new fallback event to track
-func (ft *FallbackTracker) AddEvent(event *FallbackEvent) {
+func (ft *FallbackTracker) AddEvent(event *FallbackEvent) error {
 	ft.mutex.Lock()
 	defer ft.mutex.Unlock()
 
-	ft.events[event.EventID] = event
+	err := ft.apply(func(events map[string]*FallbackEvent) {
+		events[event.EventID] = event
+	})
+	if err != nil {
+		return err
+	}
 	log.Info().
 		Str("eventID", event.EventID).
 		Str("onDemandASG", event.OnDemandASGName).
 		Str("spotASG", event.SpotASGName).
 		Str("onDemandNode", event.OnDemandNodeName).
 		Msg("Tracking new fallback event")
+	return nil
 }
 
 // GetEvent retrieves a specific fallback event
@@ -85,36 +155,94 @@ func (ft *FallbackTracker) GetActiveEvents() []*FallbackEvent {
 }
 
 // UpdateEvent updates an existing event
-func (ft *FallbackTracker) UpdateEvent(eventID string, updateFunc func(*FallbackEvent)) {
+func (ft *FallbackTracker) UpdateEvent(eventID string, updateFunc func(*FallbackEvent)) error {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+
+	return ft.apply(func(events map[string]*FallbackEvent) {
+		if event, exists := events[eventID]; exists {
+			updateFunc(event)
+		}
+	})
+}
+
+// ClaimScaleDown marks an event as scale-down initiated if it is still active. With a store the write only
+// succeeds if no other process changed the events since this tracker last read or wrote them, so two
+// monitors never both scale down the same node. It returns false when the event was claimed elsewhere.
+func (ft *FallbackTracker) ClaimScaleDown(eventID string) (bool, error) {
 	ft.mutex.Lock()
 	defer ft.mutex.Unlock()
 
-	if event, exists := ft.events[eventID]; exists {
-		updateFunc(event)
+	claimed := false
+	claim := func(events map[string]*FallbackEvent) {
+		if event, exists := events[eventID]; exists && !event.ScaleDownInitiated {
+			event.ScaleDownInitiated = true
+			event.SpotCapacityRestored = true
+			claimed = true
+		}
+	}
+	if ft.store == nil {
+		claim(ft.events)
+		return claimed, nil
+	}
+
+	events, resourceVersion, err := ft.store.updateAt(context.Background(), ft.resourceVersion, claim)
+	if apierrors.IsConflict(err) {
+		log.Info().Str("eventID", eventID).Msg("Fallback events changed concurrently, not claiming the scale-down")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
+	ft.events = events
+	ft.resourceVersion = resourceVersion
+	return claimed, nil
 }
 
 // RemoveEvent removes a fallback event from tracking
-func (ft *FallbackTracker) RemoveEvent(eventID string) {
+func (ft *FallbackTracker) RemoveEvent(eventID string) error {
 	ft.mutex.Lock()
 	defer ft.mutex.Unlock()
 
-	delete(ft.events, eventID)
+	err := ft.apply(func(events map[string]*FallbackEvent) {
+		delete(events, eventID)
+	})
+	if err != nil {
+		return err
+	}
 	log.Info().Str("eventID", eventID).Msg("Removed fallback event from tracking")
+	return nil
 }
 
 // CleanupOldEvents removes events older than the specified duration
-func (ft *FallbackTracker) CleanupOldEvents(maxAge time.Duration) {
+func (ft *FallbackTracker) CleanupOldEvents(maxAge time.Duration) error {
 	ft.mutex.Lock()
 	defer ft.mutex.Unlock()
 
 	now := time.Now()
-	for eventID, event := range ft.events {
-		if event.ScaleDownInitiated && now.Sub(event.Timestamp) > maxAge {
-			delete(ft.events, eventID)
-			log.Debug().Str("eventID", eventID).Msg("Cleaned up old fallback event")
+	return ft.apply(func(events map[string]*FallbackEvent) {
+		for eventID, event := range events {
+			if event.ScaleDownInitiated && now.Sub(event.Timestamp) > maxAge {
+				delete(events, eventID)
+				log.Debug().Str("eventID", eventID).Msg("Cleaned up old fallback event")
+			}
 		}
+	})
+}
+
+// snapshot returns a copy of all tracked events, sorted by timestamp
+func (ft *FallbackTracker) snapshot() []FallbackEvent {
+	ft.mutex.RLock()
+	defer ft.mutex.RUnlock()
+
+	events := make([]FallbackEvent, 0, len(ft.events))
+	for _, event := range ft.events {
+		events = append(events, *event)
 	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	return events
 }
 
 // GetEventCount returns the number of tracked events
@@ -123,3 +251,55 @@ func (ft *FallbackTracker) GetEventCount() int {
 	defer ft.mutex.RUnlock()
 	return len(ft.events)
 }
+
+// completeFallback closes the fallback event a scale-down retired: the event itself when it is tracked,
+// the active event that launched the retired instance otherwise, and the oldest active event of its
+// on-demand ASG without a known instance as a last resort, since the controller and the self-monitor do not
+// know which fallback launched the node. Events older than the default maximum age are dropped, active
+// ones as well: without a Monitor nothing else closes the fallbacks whose instance was retired elsewhere.
+// A nil tracker records nothing.
+func (ft *FallbackTracker) completeFallback(retired *FallbackEvent) {
+	if ft == nil {
+		return
+	}
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+
+	maxAge := DefaultConfig().MaxEventAge
+	now := time.Now()
+	err := ft.apply(func(events map[string]*FallbackEvent) {
+		completed, tracked := events[retired.EventID]
+		if !tracked {
+			for _, event := range events {
+				if !event.ScaleDownInitiated && event.OnDemandInstanceID != "" && event.OnDemandInstanceID == retired.OnDemandInstanceID {
+					completed = event
+				}
+			}
+		}
+		if completed == nil {
+			for _, event := range events {
+				if event.ScaleDownInitiated || event.OnDemandASGName != retired.OnDemandASGName || event.OnDemandInstanceID != "" {
+					continue
+				}
+				if completed == nil || event.Timestamp.Before(completed.Timestamp) {
+					completed = event
+				}
+			}
+		}
+		if completed != nil {
+			completed.ScaleDownInitiated = true
+			completed.SpotCapacityRestored = true
+			completed.OnDemandNodeName = retired.OnDemandNodeName
+			completed.OnDemandInstanceID = retired.OnDemandInstanceID
+			completed.OnDemandInstanceType = retired.OnDemandInstanceType
+		}
+		for eventID, event := range events {
+			if now.Sub(event.Timestamp) > maxAge {
+				delete(events, eventID)
+			}
+		}
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("node", retired.OnDemandNodeName).Msg("Failed to close the fallback event of a retired on-demand node")
+	}
+}
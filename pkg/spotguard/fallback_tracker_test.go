@@ -0,0 +1,151 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func TestPersistentFallbackTrackerSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+
+	tracker, err := NewPersistentFallbackTracker(ctx, clientset, "kube-system", "spot-guard-state")
+	h.Ok(t, err)
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{
+		EventID:             "fallback-i-1",
+		Timestamp:           time.Now().Truncate(time.Second),
+		SpotASGName:         "spot-asg",
+		OnDemandASGName:     "od-asg",
+		OnDemandNodeName:    "od-1",
+		MinimumWaitDuration: 10 * time.Minute,
+	}))
+	h.Ok(t, tracker.UpdateEvent("fallback-i-1", func(e *FallbackEvent) {
+		e.SpotCapacityRestored = true
+	}))
+
+	configMap, err := clientset.CoreV1().ConfigMaps("kube-system").Get(ctx, "spot-guard-state", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, 1, len(configMap.Data))
+
+	restarted, err := NewPersistentFallbackTracker(ctx, clientset, "kube-system", "spot-guard-state")
+	h.Ok(t, err)
+	event, exists := restarted.GetEvent("fallback-i-1")
+	h.Assert(t, exists, "Expected event to be reloaded after restart")
+	h.Equals(t, "od-1", event.OnDemandNodeName)
+	h.Equals(t, 10*time.Minute, event.MinimumWaitDuration)
+	h.Assert(t, event.SpotCapacityRestored, "Expected update to be persisted")
+}
+
+func TestPersistentFallbackTrackerCleanup(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+
+	tracker, err := NewPersistentFallbackTracker(ctx, clientset, "kube-system", "spot-guard-state")
+	h.Ok(t, err)
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{EventID: "old", Timestamp: time.Now().Add(-48 * time.Hour), ScaleDownInitiated: true}))
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{EventID: "pending", Timestamp: time.Now().Add(-48 * time.Hour)}))
+
+	// A second process sees the same events and cleans them up on the persisted copy
+	other, err := NewPersistentFallbackTracker(ctx, clientset, "kube-system", "spot-guard-state")
+	h.Ok(t, err)
+	h.Ok(t, other.CleanupOldEvents(24*time.Hour))
+
+	h.Ok(t, tracker.Reload(ctx))
+	h.Equals(t, 1, tracker.GetEventCount())
+	_, exists := tracker.GetEvent("pending")
+	h.Assert(t, exists, "Expected pending event to be kept")
+}
+
+func TestPersistentFallbackTrackerKeepsMemoryOnWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	tracker, err := NewPersistentFallbackTracker(ctx, clientset, "kube-system", "spot-guard-state")
+	h.Ok(t, err)
+
+	clientset.PrependReactor("update", "configmaps", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("etcdserver: request timed out")
+	})
+	err = tracker.AddEvent(&FallbackEvent{EventID: "fallback-i-1", Timestamp: time.Now()})
+	h.Assert(t, err != nil, "expected the persist error")
+	h.Equals(t, 0, tracker.GetEventCount())
+}
+
+func TestPersistentFallbackTrackerClaimScaleDown(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	tracker, err := NewPersistentFallbackTracker(ctx, clientset, "kube-system", "spot-guard-state")
+	h.Ok(t, err)
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{EventID: "fallback-i-1", Timestamp: time.Now()}))
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{EventID: "fallback-i-2", Timestamp: time.Now()}))
+
+	claimed, err := tracker.ClaimScaleDown("fallback-i-1")
+	h.Ok(t, err)
+	h.Assert(t, claimed, "expected the active event to be claimed")
+	claimed, err = tracker.ClaimScaleDown("fallback-i-1")
+	h.Ok(t, err)
+	h.Assert(t, !claimed, "an event is only claimed once")
+
+	// Another monitor changed the events since they were read, the claim loses instead of retrying
+	clientset.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		configMap := action.(k8stesting.UpdateAction).GetObject().(*corev1.ConfigMap)
+		return true, nil, apierrors.NewConflict(corev1.Resource("configmaps"), configMap.Name, errors.New("object was modified"))
+	})
+	claimed, err = tracker.ClaimScaleDown("fallback-i-2")
+	h.Ok(t, err)
+	h.Assert(t, !claimed, "a conflicting claim must fail")
+	h.Equals(t, 1, len(tracker.GetActiveEvents()))
+}
+
+func TestCompleteFallbackClosesOldestEventOfASG(t *testing.T) {
+	tracker := NewFallbackTracker()
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{EventID: "newer", Timestamp: time.Now(), OnDemandASGName: "od-asg"}))
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{EventID: "older", Timestamp: time.Now().Add(-time.Hour), OnDemandASGName: "od-asg"}))
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{EventID: "other", Timestamp: time.Now().Add(-2 * time.Hour), OnDemandASGName: "other-asg"}))
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{EventID: "launched", Timestamp: time.Now().Add(-3 * time.Hour), OnDemandASGName: "od-asg", OnDemandInstanceID: "i-3"}))
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{EventID: "abandoned", Timestamp: time.Now().Add(-48 * time.Hour), OnDemandASGName: "other-asg"}))
+
+	// The controller retires a node without knowing which fallback launched it
+	tracker.completeFallback(&FallbackEvent{EventID: "od-1", OnDemandASGName: "od-asg", OnDemandNodeName: "od-1", OnDemandInstanceID: "i-1"})
+	older, _ := tracker.GetEvent("older")
+	h.Assert(t, older.ScaleDownInitiated, "expected the oldest event of the ASG to be closed")
+	h.Equals(t, "i-1", older.OnDemandInstanceID)
+	// Events past the maximum age are dropped even though they were never closed
+	_, exists := tracker.GetEvent("abandoned")
+	h.Assert(t, !exists, "expected the abandoned event to be dropped")
+	h.Equals(t, 3, len(tracker.GetActiveEvents()))
+
+	// The event that launched the retired instance is closed, whatever its age
+	tracker.completeFallback(&FallbackEvent{EventID: "od-3", OnDemandASGName: "od-asg", OnDemandNodeName: "od-3", OnDemandInstanceID: "i-3"})
+	launched, _ := tracker.GetEvent("launched")
+	h.Assert(t, launched.ScaleDownInitiated, "expected the event of instance i-3 to be closed")
+	h.Equals(t, "od-3", launched.OnDemandNodeName)
+	h.Equals(t, 2, len(tracker.GetActiveEvents()))
+
+	// A nil tracker records nothing
+	var untracked *FallbackTracker
+	untracked.completeFallback(&FallbackEvent{EventID: "od-2", OnDemandASGName: "od-asg"})
+}
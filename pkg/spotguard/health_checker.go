@@ -16,12 +16,11 @@ package spotguard
 import (
 	"context"
 	"fmt"
+	"math"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/autoscaling"
-	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/rs/zerolog/log"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -30,8 +29,10 @@ import (
 
 // HealthChecker performs health checks on spot capacity and cluster state
 type HealthChecker struct {
-	asgClient autoscalingiface.AutoScalingAPI
-	k8sClient kubernetes.Interface
+	provider           CapacityProvider
+	k8sClient          kubernetes.Interface
+	dryRun             bool
+	interruptionWindow time.Duration
 }
 
 // SpotASGHealthStatus contains comprehensive health check results
@@ -45,18 +46,35 @@ type SpotASGHealthStatus struct {
 	// ASG has been healthy and stable for required duration
 	IsStable bool
 
-	// Instance IDs in the ASG (for node matching)
+	// Instance IDs of the InService and healthy instances, the spot nodes evicted pods can move to
 	InstanceIDs []string
 
+	// Units of desired capacity provided by the InService and healthy instances, their summed weights
+	InServiceCapacity int64
+
 	// When the ASG first became healthy (for stability tracking)
 	HealthySince *time.Time
+
+	// Interruption signals of the spot nodes, spot capacity being reclaimed is never stable
+	Interruptions []string
+}
+
+// spotInstances returns the InService spot instances as a set, pods with zonal volumes only move to these
+func (s *SpotASGHealthStatus) spotInstances() map[string]bool {
+	instances := make(map[string]bool, len(s.InstanceIDs))
+	for _, instanceID := range s.InstanceIDs {
+		instances[instanceID] = true
+	}
+	return instances
 }
 
 // NewHealthChecker creates a new health checker
-func NewHealthChecker(asgClient autoscalingiface.AutoScalingAPI, k8sClient kubernetes.Interface) *HealthChecker {
+func NewHealthChecker(provider CapacityProvider, k8sClient kubernetes.Interface, dryRun bool) *HealthChecker {
 	return &HealthChecker{
-		asgClient: asgClient,
-		k8sClient: k8sClient,
+		provider:           provider,
+		k8sClient:          k8sClient,
+		dryRun:             dryRun,
+		interruptionWindow: defaultInterruptionWindow,
 	}
 }
 
@@ -64,43 +82,35 @@ func NewHealthChecker(asgClient autoscalingiface.AutoScalingAPI, k8sClient kuber
 func (hc *HealthChecker) IsSpotASGHealthy(ctx context.Context, asgName string) (bool, error) {
 	log.Debug().Str("asg", asgName).Msg("Checking spot ASG health")
 
-	input := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{aws.String(asgName)},
-	}
-
-	result, err := hc.asgClient.DescribeAutoScalingGroupsWithContext(ctx, input)
+	asg, err := hc.provider.DescribePool(ctx, asgName)
 	if err != nil {
 		log.Error().
 			Err(err).
 			Str("asg", asgName).
 			Msg("Failed to describe ASG")
-		return false, fmt.Errorf("failed to describe ASG %s: %w", asgName, err)
-	}
-
-	if len(result.AutoScalingGroups) == 0 {
-		log.Error().Str("asg", asgName).Msg("ASG not found")
-		return false, fmt.Errorf("ASG %s not found", asgName)
+		return false, err
 	}
 
-	asg := result.AutoScalingGroups[0]
-	desiredCapacity := aws.Int64Value(asg.DesiredCapacity)
-	minSize := aws.Int64Value(asg.MinSize)
-	maxSize := aws.Int64Value(asg.MaxSize)
+	desiredCapacity := asg.DesiredCapacity
+	minSize := asg.MinSize
+	maxSize := asg.MaxSize
 
 	// Count instances by state and health
 	inServiceCount := int64(0)
+	inServiceCapacity := int64(0)
 	unhealthyCount := int64(0)
 	pendingCount := int64(0)
 	terminatingCount := int64(0)
 
 	instanceDetails := make([]string, 0)
 	for _, instance := range asg.Instances {
-		instanceID := aws.StringValue(instance.InstanceId)
-		lifecycleState := aws.StringValue(instance.LifecycleState)
-		healthStatus := aws.StringValue(instance.HealthStatus)
+		instanceID := instance.InstanceID
+		lifecycleState := instance.LifecycleState
+		healthStatus := instance.HealthStatus
 
 		if lifecycleState == "InService" && healthStatus == "Healthy" {
 			inServiceCount++
+			inServiceCapacity += instance.capacityUnits()
 			instanceDetails = append(instanceDetails, fmt.Sprintf("%s:InService:Healthy", instanceID))
 		} else if lifecycleState == "Pending" {
 			pendingCount++
@@ -116,7 +126,7 @@ func (hc *HealthChecker) IsSpotASGHealthy(ctx context.Context, asgName string) (
 		}
 	}
 
-	isHealthy := inServiceCount >= desiredCapacity
+	isHealthy := inServiceCapacity >= desiredCapacity
 
 	log.Debug().
 		Str("asg", asgName).
@@ -146,22 +156,15 @@ func (hc *HealthChecker) AreSpotNodesReady(ctx context.Context, asgName string)
 	log.Debug().Str("asg", asgName).Msg("Checking if spot nodes are ready in Kubernetes")
 
 	// First, get instance IDs from the ASG
-	asgInput := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{aws.String(asgName)},
-	}
-	asgOutput, err := hc.asgClient.DescribeAutoScalingGroups(asgInput)
+	asg, err := hc.provider.DescribePool(ctx, asgName)
 	if err != nil {
-		return false, fmt.Errorf("failed to describe ASG: %w", err)
-	}
-	if len(asgOutput.AutoScalingGroups) == 0 {
-		return false, fmt.Errorf("ASG not found: %s", asgName)
+		return false, err
 	}
 
-	asg := asgOutput.AutoScalingGroups[0]
 	asgInstanceIDs := make(map[string]bool)
 	for _, instance := range asg.Instances {
-		if instance.InstanceId != nil {
-			asgInstanceIDs[*instance.InstanceId] = true
+		if instance.InstanceID != "" {
+			asgInstanceIDs[instance.InstanceID] = true
 		}
 	}
 
@@ -354,11 +357,12 @@ func (hc *HealthChecker) IsSpotCapacityStable(ctx context.Context, asgName strin
 
 // IsSpotCapacityRestored performs comprehensive check if spot capacity is restored
 func (hc *HealthChecker) IsSpotCapacityRestored(ctx context.Context, asgName string, stabilityDuration time.Duration, healthySince *time.Time) (bool, *time.Time, error) {
-	// Run stability check which includes ASG and K8s node checks
-	isStable, newHealthySince, err := hc.IsSpotCapacityStable(ctx, asgName, stabilityDuration, healthySince)
+	// Run stability check which includes ASG, K8s node and interruption checks
+	status, err := hc.CheckSpotASGComprehensive(ctx, asgName, stabilityDuration, healthySince)
 	if err != nil {
 		return false, healthySince, err
 	}
+	isStable, newHealthySince := status.IsStable, status.HealthySince
 
 	if isStable {
 		log.Info().
@@ -408,49 +412,49 @@ func (hc *HealthChecker) CheckSpotASGComprehensive(
 	// ═══════════════════════════════════════════════════════════
 	// ONE AWS API CALL - Get all ASG data
 	// ═══════════════════════════════════════════════════════════
-	input := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{aws.String(asgName)},
-	}
-	result, err := hc.asgClient.DescribeAutoScalingGroupsWithContext(ctx, input)
+	asg, err := hc.provider.DescribePool(ctx, asgName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to describe ASG %s: %w", asgName, err)
-	}
-	if len(result.AutoScalingGroups) == 0 {
-		return nil, fmt.Errorf("ASG %s not found", asgName)
+		return nil, err
 	}
 
-	asg := result.AutoScalingGroups[0]
 	status := &SpotASGHealthStatus{}
 
 	// ═══════════════════════════════════════════════════════════
 	// CHECK 1: ASG Health (inService >= desired?)
 	// ═══════════════════════════════════════════════════════════
-	desiredCapacity := aws.Int64Value(asg.DesiredCapacity)
+	desiredCapacity := asg.DesiredCapacity
 	inServiceCount := int64(0)
+	inServiceCapacity := int64(0)
 	instanceIDs := make([]string, 0)
+	inServiceIDs := make([]string, 0)
 
 	instanceDetails := make([]string, 0)
 	for _, instance := range asg.Instances {
-		instanceID := aws.StringValue(instance.InstanceId)
+		instanceID := instance.InstanceID
 		instanceIDs = append(instanceIDs, instanceID)
 
-		lifecycleState := aws.StringValue(instance.LifecycleState)
-		healthStatus := aws.StringValue(instance.HealthStatus)
+		lifecycleState := instance.LifecycleState
+		healthStatus := instance.HealthStatus
 
 		if lifecycleState == "InService" && healthStatus == "Healthy" {
 			inServiceCount++
+			inServiceCapacity += instance.capacityUnits()
+			inServiceIDs = append(inServiceIDs, instanceID)
 			instanceDetails = append(instanceDetails, fmt.Sprintf("%s:InService:Healthy", instanceID))
 		} else {
 			instanceDetails = append(instanceDetails, fmt.Sprintf("%s:%s:%s", instanceID, lifecycleState, healthStatus))
 		}
 	}
 
-	status.IsHealthy = (inServiceCount >= desiredCapacity)
-	status.InstanceIDs = instanceIDs
+	// Desired capacity is in units, so a weighted ASG is healthy once the weights of its instances add up
+	status.IsHealthy = (inServiceCapacity >= desiredCapacity)
+	status.InstanceIDs = inServiceIDs
+	status.InServiceCapacity = inServiceCapacity
 
 	log.Debug().
 		Str("asg", asgName).
 		Int64("inService", inServiceCount).
+		Int64("inServiceCapacity", inServiceCapacity).
 		Int64("desired", desiredCapacity).
 		Int("totalInstances", len(instanceIDs)).
 		Strs("instances", instanceDetails).
@@ -540,7 +544,26 @@ func (hc *HealthChecker) CheckSpotASGComprehensive(
 		Msg("Comprehensive check: K8s node readiness")
 
 	// ═══════════════════════════════════════════════════════════
-	// CHECK 3: Stability (healthy for required duration?)
+	// CHECK 3: Interruptions (is the spot pool being reclaimed?)
+	// ═══════════════════════════════════════════════════════════
+	if status.IsHealthy && status.NodesReady {
+		status.Interruptions, err = hc.spotInterruptions(ctx, spotNodes)
+		if err != nil {
+			return nil, err
+		}
+		if len(status.Interruptions) > 0 {
+			status.IsStable = false
+			status.HealthySince = nil
+			log.Info().
+				Str("asg", asgName).
+				Strs("interruptions", status.Interruptions).
+				Msg("Comprehensive check: Spot capacity is being reclaimed, resetting stability tracking")
+			return status, nil
+		}
+	}
+
+	// ═══════════════════════════════════════════════════════════
+	// CHECK 4: Stability (healthy for required duration?)
 	// ═══════════════════════════════════════════════════════════
 	now := time.Now()
 
@@ -591,7 +614,8 @@ func (hc *HealthChecker) CheckSpotASGComprehensive(
 	return status, nil
 }
 
-// PreScaleCalculation contains pre-scale calculation results
+// PreScaleCalculation contains pre-scale calculation results.
+// Node counts are in units of desired capacity, which differ from instances when the ASG uses weights.
 type PreScaleCalculation struct {
 	CurrentNodes         int
 	CurrentSpotNodes     int
@@ -602,14 +626,24 @@ type PreScaleCalculation struct {
 	AdditionalSpotNodes  int
 	ExpectedUtilization  float64
 	SafetyBuffer         float64
+	// DisplacedCPU (millicores) and DisplacedMemory (bytes) are the requests of the pods the drain moves
+	DisplacedCPU    int64
+	DisplacedMemory int64
+	// UnitCPU (millicores) and UnitMemory (bytes) are the allocatable of one unit of spot capacity
+	UnitCPU    int64
+	UnitMemory int64
+	// InstanceTypes are the instance types the spot pool may launch, when the capacity provider knows them
+	InstanceTypes []string
 }
 
-// CalculatePreScaleNodes determines how many spot nodes to add
-// to achieve target utilization after draining on-demand
+// CalculatePreScaleNodes determines how many spot nodes to add so that the pods displaced by draining
+// the on-demand node nodeName fit on the spot pool at the target utilization. It works on the CPU and
+// memory requests of the pods and the allocatable of the spot instance types.
 func (hc *HealthChecker) CalculatePreScaleNodes(
 	ctx context.Context,
 	spotASGName string,
 	onDemandASGName string,
+	nodeName string,
 	currentUtilization float64,
 	targetUtilization float64,
 	safetyBufferPercent int,
@@ -620,97 +654,129 @@ func (hc *HealthChecker) CalculatePreScaleNodes(
 		TargetUtilization:  targetUtilization,
 		SafetyBuffer:       float64(safetyBufferPercent) / 100.0,
 	}
+	if targetUtilization <= 0 {
+		return nil, fmt.Errorf("invalid target utilization %v", targetUtilization)
+	}
 
 	log.Debug().
 		Str("spotASG", spotASGName).
 		Str("onDemandASG", onDemandASGName).
+		Str("node", nodeName).
 		Float64("currentUtilization", currentUtilization).
 		Float64("targetUtilization", targetUtilization).
 		Float64("safetyBuffer", calc.SafetyBuffer*100).
 		Msg("Starting pre-scale calculation")
 
 	// Get current spot ASG size
-	spotInput := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{aws.String(spotASGName)},
-	}
-	spotResult, err := hc.asgClient.DescribeAutoScalingGroupsWithContext(ctx, spotInput)
-	if err != nil || len(spotResult.AutoScalingGroups) == 0 {
+	spotPool, err := hc.provider.DescribePool(ctx, spotASGName)
+	if err != nil {
 		return nil, fmt.Errorf("failed to get spot ASG data: %w", err)
 	}
-	calc.CurrentSpotNodes = int(aws.Int64Value(spotResult.AutoScalingGroups[0].DesiredCapacity))
+	calc.CurrentSpotNodes = int(spotPool.DesiredCapacity)
 
 	// Get current on-demand ASG size
-	onDemandInput := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{aws.String(onDemandASGName)},
-	}
-	onDemandResult, err := hc.asgClient.DescribeAutoScalingGroupsWithContext(ctx, onDemandInput)
-	if err != nil || len(onDemandResult.AutoScalingGroups) == 0 {
+	onDemandPool, err := hc.provider.DescribePool(ctx, onDemandASGName)
+	if err != nil {
 		return nil, fmt.Errorf("failed to get on-demand ASG data: %w", err)
 	}
-	calc.CurrentOnDemandNodes = int(aws.Int64Value(onDemandResult.AutoScalingGroups[0].DesiredCapacity))
+	calc.CurrentOnDemandNodes = int(onDemandPool.DesiredCapacity)
 
 	calc.CurrentNodes = calc.CurrentSpotNodes + calc.CurrentOnDemandNodes
 
-	log.Debug().
-		Int("currentNodes", calc.CurrentNodes).
-		Int("spotNodes", calc.CurrentSpotNodes).
-		Int("onDemandNodes", calc.CurrentOnDemandNodes).
-		Msg("Retrieved current cluster node counts")
-
-	// Check if already below target
-	if currentUtilization <= targetUtilization {
-		calc.NodesNeeded = calc.CurrentSpotNodes
-		calc.AdditionalSpotNodes = 0
-		calc.ExpectedUtilization = currentUtilization
-
-		log.Info().
-			Float64("currentUtilization", currentUtilization).
-			Float64("targetUtilization", targetUtilization).
-			Msg("No pre-scale needed - utilization already below target")
-
-		return calc, nil
+	nodes, err := hc.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	pods, err := hc.k8sClient.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
 	}
 
-	// Calculate total workload (in abstract capacity units)
-	// Formula: Total workload = Current nodes × Current utilization%
-	totalWorkload := float64(calc.CurrentNodes) * (currentUtilization / 100.0)
-
-	log.Debug().
-		Float64("totalWorkload", totalWorkload).
-		Int("currentNodes", calc.CurrentNodes).
-		Float64("currentUtilization", currentUtilization).
-		Msg("Calculated total cluster workload")
-
-	// After draining on-demand, all workload goes to spot nodes
-	// Required spot nodes = Total workload / Target utilization%
-	// Formula: nodes = workload / (target% / 100)
-	requiredCapacity := totalWorkload / (targetUtilization / 100.0)
+	// Ready spot nodes and what their pods already request
+	spotInstances := make(map[string]bool, len(spotPool.Instances))
+	for _, instance := range spotPool.Instances {
+		spotInstances[instance.InstanceID] = true
+	}
+	spotNodes := make([]*corev1.Node, 0)
+	spotNodeNames := make(map[string]bool)
+	var spotAllocatable, spotUsed, displaced capacityUnit
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if !spotInstances[extractInstanceIDFromProviderID(node.Spec.ProviderID)] || !isNodeReady(node) || node.Spec.Unschedulable {
+			continue
+		}
+		spotNodes = append(spotNodes, node)
+		spotNodeNames[node.Name] = true
+		spotAllocatable.add(capacityUnitOf(node.Status.Allocatable))
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		requests := podRequests(pod)
+		usage := capacityUnit{cpu: requests[corev1.ResourceCPU], memory: requests[corev1.ResourceMemory]}
+		switch {
+		case pod.Spec.NodeName == nodeName:
+			// DaemonSet and static pods stay with their node, terminating pods are gone soon
+			if !isDaemonSetPod(pod) && !isMirrorPod(pod) && pod.DeletionTimestamp == nil {
+				displaced.add(usage)
+			}
+		case spotNodeNames[pod.Spec.NodeName]:
+			spotUsed.add(usage)
+		}
+	}
+	calc.DisplacedCPU = displaced.cpu
+	calc.DisplacedMemory = displaced.memory
 
-	// Round up to ensure we have enough capacity
-	calc.NodesNeeded = int(requiredCapacity)
-	if requiredCapacity > float64(calc.NodesNeeded) {
-		calc.NodesNeeded++
+	unit, weights, err := hc.spotCapacityUnit(ctx, spotASGName, nodes.Items, spotNodes)
+	if err != nil {
+		return nil, err
+	}
+	calc.UnitCPU = unit.cpu
+	calc.UnitMemory = unit.memory
+	for instanceType := range weights {
+		calc.InstanceTypes = append(calc.InstanceTypes, instanceType)
 	}
+	sort.Strings(calc.InstanceTypes)
 
-	// How many additional spot nodes needed
-	calc.AdditionalSpotNodes = calc.NodesNeeded - calc.CurrentSpotNodes
+	// Capacity that is launching but has not joined the cluster yet counts as whole units
+	joinedUnits := int64(0)
+	for _, node := range spotNodes {
+		joinedUnits += nodeWeight(node, weights)
+	}
+	if pendingUnits := spotPool.DesiredCapacity - joinedUnits; pendingUnits > 0 {
+		spotAllocatable.add(capacityUnit{cpu: pendingUnits * unit.cpu, memory: pendingUnits * unit.memory})
+	}
 
 	log.Debug().
-		Float64("requiredCapacity", requiredCapacity).
-		Int("nodesNeeded", calc.NodesNeeded).
-		Int("currentSpotNodes", calc.CurrentSpotNodes).
-		Int("additionalSpotNodes", calc.AdditionalSpotNodes).
-		Msg("Base pre-scale calculation complete")
+		Int("spotNodes", len(spotNodes)).
+		Int64("spotAllocatableCPU", spotAllocatable.cpu).
+		Int64("spotAllocatableMemory", spotAllocatable.memory).
+		Int64("spotRequestedCPU", spotUsed.cpu).
+		Int64("spotRequestedMemory", spotUsed.memory).
+		Int64("displacedCPU", displaced.cpu).
+		Int64("displacedMemory", displaced.memory).
+		Int64("unitCPU", unit.cpu).
+		Int64("unitMemory", unit.memory).
+		Strs("instanceTypes", calc.InstanceTypes).
+		Msg("Calculated displaced requests and spot capacity")
+
+	// Units needed so that the spot requests plus the displaced ones stay at the target utilization
+	required := capacityUnit{cpu: spotUsed.cpu + displaced.cpu, memory: spotUsed.memory + displaced.memory}
+	target := targetUtilization / 100.0
+	missingCPU := float64(required.cpu)/target - float64(spotAllocatable.cpu)
+	missingMemory := float64(required.memory)/target - float64(spotAllocatable.memory)
+	additional := math.Max(math.Ceil(missingCPU/float64(unit.cpu)), math.Ceil(missingMemory/float64(unit.memory)))
+	if additional > 0 {
+		calc.AdditionalSpotNodes = int(additional)
+	}
 
 	// Apply safety buffer (e.g., +10% more nodes)
 	if calc.SafetyBuffer > 0 && calc.AdditionalSpotNodes > 0 {
 		bufferedNodes := float64(calc.AdditionalSpotNodes) * (1.0 + calc.SafetyBuffer)
 		originalAdditional := calc.AdditionalSpotNodes
-		calc.AdditionalSpotNodes = int(bufferedNodes)
-		if bufferedNodes > float64(calc.AdditionalSpotNodes) {
-			calc.AdditionalSpotNodes++
-		}
-		calc.NodesNeeded = calc.CurrentSpotNodes + calc.AdditionalSpotNodes
+		calc.AdditionalSpotNodes = int(math.Ceil(bufferedNodes))
 
 		log.Debug().
 			Int("originalAdditional", originalAdditional).
@@ -718,19 +784,35 @@ func (hc *HealthChecker) CalculatePreScaleNodes(
 			Float64("safetyBufferPercent", calc.SafetyBuffer*100).
 			Msg("Applied safety buffer to pre-scale calculation")
 	}
+	calc.NodesNeeded = calc.CurrentSpotNodes + calc.AdditionalSpotNodes
+
+	// The ASG rejects a desired capacity above its maximum size, scale as far as it goes
+	if maxSize := int(spotPool.MaxSize); maxSize > 0 && calc.NodesNeeded > maxSize {
+		log.Warn().
+			Int("requiredSpotNodes", calc.NodesNeeded).
+			Int("maxSize", maxSize).
+			Msg("Pre-scale is capped at the maximum size of the spot ASG")
+		calc.NodesNeeded = maxSize
+		calc.AdditionalSpotNodes = maxSize - calc.CurrentSpotNodes
+		if calc.AdditionalSpotNodes < 0 {
+			calc.AdditionalSpotNodes = 0
+		}
+	}
 
-	// Ensure we add at least 1 node if utilization is high
-	if calc.AdditionalSpotNodes < 1 && currentUtilization > targetUtilization {
-		log.Debug().
-			Int("calculated", calc.AdditionalSpotNodes).
-			Msg("Calculated less than 1 node, setting minimum to 1")
-		calc.AdditionalSpotNodes = 1
-		calc.NodesNeeded = calc.CurrentSpotNodes + 1
+	// Calculate expected spot utilization after the drain
+	additionalUnits := int64(calc.AdditionalSpotNodes)
+	capacity := capacityUnit{
+		cpu:    spotAllocatable.cpu + additionalUnits*unit.cpu,
+		memory: spotAllocatable.memory + additionalUnits*unit.memory,
 	}
+	calc.ExpectedUtilization = required.utilization(capacity)
 
-	// Calculate expected utilization after pre-scale
-	if calc.NodesNeeded > 0 {
-		calc.ExpectedUtilization = (totalWorkload / float64(calc.NodesNeeded)) * 100.0
+	if calc.AdditionalSpotNodes == 0 {
+		log.Info().
+			Float64("expectedUtilization", calc.ExpectedUtilization).
+			Float64("targetUtilization", targetUtilization).
+			Msg("No pre-scale needed - displaced pods fit on the spot capacity below target utilization")
+		return calc, nil
 	}
 
 	log.Info().
@@ -754,14 +836,15 @@ func (hc *HealthChecker) ScaleSpotASG(ctx context.Context, asgName string, desir
 		Int("desiredCapacity", desiredCapacity).
 		Msg("Scaling spot ASG")
 
-	input := &autoscaling.SetDesiredCapacityInput{
-		AutoScalingGroupName: aws.String(asgName),
-		DesiredCapacity:      aws.Int64(int64(desiredCapacity)),
-		HonorCooldown:        aws.Bool(false), // Skip cooldown for immediate scaling
+	if hc.dryRun {
+		log.Info().
+			Str("asg", asgName).
+			Int("desiredCapacity", desiredCapacity).
+			Msg("Spot ASG would have been scaled, but dry-run flag was set")
+		return nil
 	}
 
-	_, err := hc.asgClient.SetDesiredCapacity(input)
-	if err != nil {
+	if err := hc.provider.SetDesiredCapacity(ctx, asgName, int64(desiredCapacity)); err != nil {
 		return fmt.Errorf("failed to scale ASG: %w", err)
 	}
 
@@ -0,0 +1,222 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// instanceTypeSource is implemented by capacity providers that know which instance types a pool launches
+type instanceTypeSource interface {
+	// InstanceTypes returns the instance types the pool may launch,
+	// with the units of desired capacity each one counts for
+	InstanceTypes(ctx context.Context, pool string) (map[string]int64, error)
+}
+
+// allocatableEstimator is implemented by capacity providers that can estimate the allocatable
+// resources of instance types no node of the cluster runs yet
+type allocatableEstimator interface {
+	EstimateAllocatable(ctx context.Context, instanceTypes []string) (map[string]corev1.ResourceList, error)
+}
+
+// InstanceTypes returns the instance types of the ASG: the MixedInstancesPolicy overrides, or the
+// launch template or launch configuration instance type, and the types of the running instances
+func (p *asgCapacityProvider) InstanceTypes(ctx context.Context, pool string) (map[string]int64, error) {
+	group, err := p.describeGroup(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	weights := make(map[string]int64)
+	for _, instance := range group.Instances {
+		if instanceType := aws.StringValue(instance.InstanceType); instanceType != "" {
+			weights[instanceType] = capacityWeight(instance.WeightedCapacity)
+		}
+	}
+
+	if policy := group.MixedInstancesPolicy; policy != nil && policy.LaunchTemplate != nil {
+		for _, override := range policy.LaunchTemplate.Overrides {
+			// Overrides with instance requirements only show up through the running instances
+			if instanceType := aws.StringValue(override.InstanceType); instanceType != "" {
+				weights[instanceType] = capacityWeight(override.WeightedCapacity)
+			}
+		}
+		if len(policy.LaunchTemplate.Overrides) > 0 {
+			return weights, nil
+		}
+		instanceType, err := p.launchTemplateInstanceType(ctx, policy.LaunchTemplate.LaunchTemplateSpecification)
+		if err != nil {
+			return nil, err
+		}
+		if instanceType != "" {
+			weights[instanceType] = 1
+		}
+		return weights, nil
+	}
+
+	if group.LaunchTemplate != nil {
+		instanceType, err := p.launchTemplateInstanceType(ctx, group.LaunchTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if instanceType != "" {
+			weights[instanceType] = 1
+		}
+		return weights, nil
+	}
+
+	if name := aws.StringValue(group.LaunchConfigurationName); name != "" {
+		result, err := p.asgClient.DescribeLaunchConfigurationsWithContext(ctx, &autoscaling.DescribeLaunchConfigurationsInput{
+			LaunchConfigurationNames: []*string{aws.String(name)},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe launch configuration %s: %w", name, err)
+		}
+		for _, launchConfiguration := range result.LaunchConfigurations {
+			weights[aws.StringValue(launchConfiguration.InstanceType)] = 1
+		}
+	}
+	return weights, nil
+}
+
+// launchTemplateInstanceType returns the instance type of a launch template version,
+// or an empty string when it has none or no EC2 client is configured
+func (p *asgCapacityProvider) launchTemplateInstanceType(ctx context.Context, spec *autoscaling.LaunchTemplateSpecification) (string, error) {
+	if p.ec2Client == nil || spec == nil {
+		return "", nil
+	}
+	version := aws.StringValue(spec.Version)
+	if version == "" {
+		version = "$Default"
+	}
+	input := &ec2.DescribeLaunchTemplateVersionsInput{Versions: []*string{aws.String(version)}}
+	if spec.LaunchTemplateId != nil {
+		input.LaunchTemplateId = spec.LaunchTemplateId
+	} else {
+		input.LaunchTemplateName = spec.LaunchTemplateName
+	}
+
+	result, err := p.ec2Client.DescribeLaunchTemplateVersionsWithContext(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to describe launch template version %s: %w", version, err)
+	}
+	for _, templateVersion := range result.LaunchTemplateVersions {
+		if data := templateVersion.LaunchTemplateData; data != nil {
+			return aws.StringValue(data.InstanceType), nil
+		}
+	}
+	return "", nil
+}
+
+// EstimateAllocatable estimates the allocatable CPU and memory of instance types from their EC2 capacity,
+// minus the kube-reserved CPU and memory and the eviction threshold the EKS optimized AMI configures
+func (p *asgCapacityProvider) EstimateAllocatable(ctx context.Context, instanceTypes []string) (map[string]corev1.ResourceList, error) {
+	allocatable := make(map[string]corev1.ResourceList)
+	if p.ec2Client == nil || len(instanceTypes) == 0 {
+		return allocatable, nil
+	}
+
+	input := &ec2.DescribeInstanceTypesInput{InstanceTypes: aws.StringSlice(instanceTypes)}
+	err := p.ec2Client.DescribeInstanceTypesPagesWithContext(ctx, input, func(page *ec2.DescribeInstanceTypesOutput, _ bool) bool {
+		for _, info := range page.InstanceTypes {
+			if info.VCpuInfo == nil || info.MemoryInfo == nil {
+				continue
+			}
+			maxPods := int64(110)
+			if network := info.NetworkInfo; network != nil {
+				maxPods = aws.Int64Value(network.MaximumNetworkInterfaces)*(aws.Int64Value(network.Ipv4AddressesPerInterface)-1) + 2
+			}
+			vcpus := aws.Int64Value(info.VCpuInfo.DefaultVCpus)
+			memoryMiB := aws.Int64Value(info.MemoryInfo.SizeInMiB) - eksReservedMemoryMiB(maxPods)
+			allocatable[aws.StringValue(info.InstanceType)] = corev1.ResourceList{
+				corev1.ResourceCPU:    *resource.NewMilliQuantity(vcpus*1000-eksReservedCPUMilli(vcpus), resource.DecimalSI),
+				corev1.ResourceMemory: *resource.NewQuantity(memoryMiB*1024*1024, resource.BinarySI),
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instance types: %w", err)
+	}
+	return allocatable, nil
+}
+
+// eksReservedCPUMilli is the kube-reserved CPU of the EKS optimized AMI: 6% of the first core,
+// 1% of the second, 0.5% of the third and fourth and 0.25% of every further core
+func eksReservedCPUMilli(vcpus int64) int64 {
+	reserved := 0.0
+	for core := int64(1); core <= vcpus; core++ {
+		switch {
+		case core == 1:
+			reserved += 60
+		case core == 2:
+			reserved += 10
+		case core <= 4:
+			reserved += 5
+		default:
+			reserved += 2.5
+		}
+	}
+	return int64(reserved + 0.5)
+}
+
+// eksReservedMemoryMiB is the kube-reserved memory of the EKS optimized AMI plus its 100Mi hard eviction threshold
+func eksReservedMemoryMiB(maxPods int64) int64 {
+	return 11*maxPods + 255 + 100
+}
+
+// capacityWeight parses the weighted capacity of an ASG instance type, 1 when unset
+func capacityWeight(weightedCapacity *string) int64 {
+	weight, err := strconv.ParseInt(aws.StringValue(weightedCapacity), 10, 64)
+	if err != nil || weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+// InstanceTypes returns the instance types the NodePool requires with the In operator.
+// A NodePool that lets Karpenter pick any instance type returns none.
+func (p *karpenterCapacityProvider) InstanceTypes(ctx context.Context, pool string) (map[string]int64, error) {
+	nodePool, err := p.dynamicClient.Resource(NodePoolGVR).Get(ctx, pool, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get NodePool %s: %w", pool, err)
+	}
+	requirements, _, err := unstructured.NestedSlice(nodePool.Object, "spec", "template", "spec", "requirements")
+	if err != nil {
+		return nil, fmt.Errorf("invalid requirements in NodePool %s: %w", pool, err)
+	}
+
+	weights := make(map[string]int64)
+	for _, item := range requirements {
+		requirement, ok := item.(map[string]interface{})
+		if !ok || requirement["key"] != corev1.LabelInstanceTypeStable || requirement["operator"] != "In" {
+			continue
+		}
+		values, _, _ := unstructured.NestedStringSlice(requirement, "values")
+		for _, instanceType := range values {
+			weights[instanceType] = 1
+		}
+	}
+	return weights, nil
+}
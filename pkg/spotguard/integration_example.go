@@ -19,7 +19,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-node-termination-handler/pkg/node"
-	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
 	"github.com/rs/zerolog/log"
 	"k8s.io/client-go/kubernetes"
 )
@@ -31,9 +31,12 @@ import (
 func InitializeSpotGuard(
 	ctx context.Context,
 	config Config,
-	asgClient *autoscaling.AutoScaling,
+	provider CapacityProvider,
 	k8sClient kubernetes.Interface,
 	nodeHandler node.Node,
+	metrics observability.Metrics,
+	recorder observability.K8sEventRecorder,
+	ledger *CostLedger,
 ) (*Monitor, *FallbackTracker, error) {
 	// Validate configuration
 	if err := config.Validate(); err != nil {
@@ -52,21 +55,31 @@ func InitializeSpotGuard(
 		Dur("checkInterval", config.CheckInterval).
 		Msg("Initializing spot guard")
 
-	// Create fallback tracker
+	// Create fallback tracker, reloading persisted events when a state ConfigMap is configured
 	tracker := NewFallbackTracker()
+	if config.StateConfigMapName != "" {
+		persistentTracker, err := NewPersistentFallbackTracker(ctx, k8sClient, config.StateConfigMapNamespace, config.StateConfigMapName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load persisted fallback events: %w", err)
+		}
+		tracker = persistentTracker
+	}
 
 	// Create health checker
-	healthChecker := NewHealthChecker(asgClient, k8sClient)
+	healthChecker := NewHealthChecker(provider, k8sClient, config.DryRun)
 
 	// Create safety checker
 	safetyChecker := NewSafetyChecker(k8sClient, config.MaxClusterUtilization)
 
 	// Create scale-down executor
 	scaleDownExecutor := NewScaleDownExecutor(
-		asgClient,
+		provider,
 		k8sClient,
 		nodeHandler,
 		config.PodEvictionTimeout,
+		recorder,
+		config.DryRun,
+		ledger,
 	)
 
 	// Create monitor configuration
@@ -86,6 +99,8 @@ func InitializeSpotGuard(
 		healthChecker,
 		safetyChecker,
 		scaleDownExecutor,
+		metrics,
+		recorder,
 	)
 
 	// Start monitoring in background
@@ -118,7 +133,10 @@ func RecordFallbackEvent(
 		MinimumWaitDuration: config.MinimumWaitDuration,
 	}
 
-	tracker.AddEvent(event)
+	if err := tracker.AddEvent(event); err != nil {
+		log.Error().Err(err).Str("eventID", event.EventID).Msg("Failed to record fallback to on-demand event")
+		return
+	}
 
 	log.Info().
 		Str("eventID", event.EventID).
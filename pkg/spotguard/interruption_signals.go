@@ -0,0 +1,121 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	"github.com/aws/aws-node-termination-handler/pkg/monitor"
+	"github.com/aws/aws-node-termination-handler/pkg/node"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// defaultInterruptionWindow is how long a rebalance recommendation or spot ITN event keeps spot capacity unstable
+const defaultInterruptionWindow = 10 * time.Minute
+
+// interruptionTaints are the taints NTH puts on a spot node that is being reclaimed
+var interruptionTaints = map[string]bool{
+	node.SpotInterruptionTaint:        true,
+	node.RebalanceRecommendationTaint: true,
+}
+
+// interruptionEventKinds are the interruption kinds whose Kubernetes Events mark a spot node as being reclaimed
+var interruptionEventKinds = []string{monitor.SpotITNKind, monitor.RebalanceRecommendationKind}
+
+// interruptionWindowFromConfig returns the interruption window of the Spot Guard flags
+func interruptionWindowFromConfig(nthConfig config.Config) time.Duration {
+	return time.Duration(nthConfig.SpotGuardInterruptionWindow) * time.Second
+}
+
+// spotInterruptions returns the interruption signals of spot nodes: NTH interruption taints, and the
+// rebalance recommendation and spot ITN Events emitted by NTH within the interruption window.
+// Events of nodes that no longer exist count as well, a reclaimed spot node is often gone before the window ends.
+// It returns none when the interruption window is zero.
+func (hc *HealthChecker) spotInterruptions(ctx context.Context, spotNodes []corev1.Node) ([]string, error) {
+	signals := make([]string, 0)
+	if hc.interruptionWindow <= 0 {
+		return signals, nil
+	}
+
+	// countedNodes tells whose Events count: the spot nodes, and other nodes once they were found deleted
+	countedNodes := make(map[string]bool, len(spotNodes))
+	for _, spotNode := range spotNodes {
+		countedNodes[spotNode.Name] = true
+		for _, taint := range spotNode.Spec.Taints {
+			if interruptionTaints[taint.Key] {
+				signals = append(signals, fmt.Sprintf("%s has the %s taint", spotNode.Name, taint.Key))
+			}
+		}
+	}
+
+	since := time.Now().Add(-hc.interruptionWindow)
+	for _, kind := range interruptionEventKinds {
+		reason := observability.GetReasonForKind(kind, "")
+		selector := fields.Set{"involvedObject.kind": "Node", "reason": reason}.AsSelector().String()
+		events, err := hc.k8sClient.CoreV1().Events(metav1.NamespaceAll).List(ctx, metav1.ListOptions{FieldSelector: selector})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s events: %w", reason, err)
+		}
+		for _, event := range events.Items {
+			at := eventTime(event)
+			if !at.After(since) {
+				continue
+			}
+			nodeName := event.InvolvedObject.Name
+			if _, known := countedNodes[nodeName]; !known {
+				// Remembered so each other node is only looked up once
+				countedNodes[nodeName], err = hc.nodeDeleted(ctx, nodeName)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if countedNodes[nodeName] {
+				signals = append(signals, fmt.Sprintf("%s received a %s event at %s", nodeName, reason, at.UTC().Format(time.RFC3339)))
+			}
+		}
+	}
+	return signals, nil
+}
+
+// nodeDeleted returns true when the node no longer exists
+func (hc *HealthChecker) nodeDeleted(ctx context.Context, nodeName string) (bool, error) {
+	_, err := hc.k8sClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return true, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to get node %s: %w", nodeName, err)
+	}
+	return false, nil
+}
+
+// eventTime returns when an Event was last seen
+func eventTime(event corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	case !event.FirstTimestamp.IsZero():
+		return event.FirstTimestamp.Time
+	}
+	return event.CreationTimestamp.Time
+}
@@ -0,0 +1,132 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/aws/aws-node-termination-handler/pkg/node"
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func readySpotNode(name string, instanceID string) *corev1.Node {
+	spotNode := testNode(name, instanceID, nil)
+	spotNode.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	return spotNode
+}
+
+func nodeEvent(name string, nodeName string, reason string, lastSeen time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault},
+		InvolvedObject: corev1.ObjectReference{Kind: "Node", Name: nodeName},
+		Reason:         reason,
+		LastTimestamp:  metav1.NewTime(lastSeen),
+	}
+}
+
+// filterEventsByFields makes the fake clientset apply Event field selectors like the API server does
+func filterEventsByFields(clientset *fake.Clientset) {
+	clientset.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, err := clientset.Tracker().List(corev1.SchemeGroupVersion.WithResource("events"), corev1.SchemeGroupVersion.WithKind("Event"), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		selector := action.(k8stesting.ListAction).GetListRestrictions().Fields
+		filtered := &corev1.EventList{}
+		for _, event := range obj.(*corev1.EventList).Items {
+			if selector.Matches(fields.Set{"involvedObject.kind": event.InvolvedObject.Kind, "reason": event.Reason}) {
+				filtered.Items = append(filtered.Items, event)
+			}
+		}
+		return true, filtered, nil
+	})
+}
+
+func TestSpotInterruptions(t *testing.T) {
+	ctx := context.Background()
+	tainted := readySpotNode("spot-1", "i-1")
+	tainted.Spec.Taints = []corev1.Taint{{Key: node.RebalanceRecommendationTaint, Effect: corev1.TaintEffectNoSchedule}}
+	warned := readySpotNode("spot-2", "i-2")
+	quiet := readySpotNode("spot-3", "i-3")
+	clientset := fake.NewSimpleClientset(
+		tainted, warned, quiet, testNode("od-2", "i-4", nil),
+		nodeEvent("itn", "spot-2", "SpotInterruption", time.Now().Add(-time.Minute)),
+		nodeEvent("old", "spot-3", "RebalanceRecommendation", time.Now().Add(-time.Hour)),
+		nodeEvent("reclaimed-node", "spot-0", "RebalanceRecommendation", time.Now()),
+		nodeEvent("deleted-node", "od-1", "SpotInterruption", time.Now()),
+		nodeEvent("other-node", "od-2", "SpotInterruption", time.Now()),
+		nodeEvent("other-reason", "spot-3", "CordonAndDrain", time.Now()),
+	)
+	filterEventsByFields(clientset)
+	healthChecker := NewHealthChecker(nil, clientset, false)
+	spotNodes := []corev1.Node{*tainted, *warned, *quiet}
+
+	signals, err := healthChecker.spotInterruptions(ctx, spotNodes)
+	h.Ok(t, err)
+	h.Equals(t, 4, len(signals))
+	h.Equals(t, "spot-1 has the "+node.RebalanceRecommendationTaint+" taint", signals[0])
+	// Events of nodes deleted within the window still count, those of other live nodes do not
+	h.Assert(t, strings.HasPrefix(signals[1], "od-1 received a SpotInterruption event at "), "unexpected signal %q", signals[1])
+	h.Assert(t, strings.HasPrefix(signals[2], "spot-2 received a SpotInterruption event at "), "unexpected signal %q", signals[2])
+	h.Assert(t, strings.HasPrefix(signals[3], "spot-0 received a RebalanceRecommendation event at "), "unexpected signal %q", signals[3])
+
+	// A zero window turns the check off
+	healthChecker.interruptionWindow = 0
+	signals, err = healthChecker.spotInterruptions(ctx, spotNodes)
+	h.Ok(t, err)
+	h.Equals(t, 0, len(signals))
+}
+
+func TestCheckSpotASGComprehensiveResetsStabilityWhileInterrupted(t *testing.T) {
+	ctx := context.Background()
+	group := fullASG("spot")
+	group.DesiredCapacity = aws.Int64(1)
+	group.Instances = []*autoscaling.Instance{
+		{InstanceId: aws.String("i-1"), LifecycleState: aws.String("InService"), HealthStatus: aws.String("Healthy")},
+		{InstanceId: aws.String("i-2"), LifecycleState: aws.String("Pending"), HealthStatus: aws.String("Healthy")},
+	}
+	provider := NewASGCapacityProvider(mockedScalingASGs{groups: map[string]*autoscaling.Group{"spot": group}}, nil)
+
+	clientset := fake.NewSimpleClientset(readySpotNode("spot-1", "i-1"))
+	filterEventsByFields(clientset)
+	healthChecker := NewHealthChecker(provider, clientset, false)
+	healthySince := time.Now().Add(-time.Hour)
+
+	status, err := healthChecker.CheckSpotASGComprehensive(ctx, "spot", time.Minute, &healthySince)
+	h.Ok(t, err)
+	h.Assert(t, status.IsStable, "expected stable spot capacity: %+v", status)
+	// Launching instances are not nodes evicted pods can move to yet
+	h.Equals(t, []string{"i-1"}, status.InstanceIDs)
+
+	_, err = clientset.CoreV1().Events(metav1.NamespaceDefault).Create(ctx, nodeEvent("rebalance", "spot-1", "RebalanceRecommendation", time.Now()), metav1.CreateOptions{})
+	h.Ok(t, err)
+	status, err = healthChecker.CheckSpotASGComprehensive(ctx, "spot", time.Minute, &healthySince)
+	h.Ok(t, err)
+	h.Assert(t, status.IsHealthy && status.NodesReady && !status.IsStable, "expected unstable spot capacity: %+v", status)
+	h.Assert(t, status.HealthySince == nil, "stability tracking not reset: %v", status.HealthySince)
+	h.Equals(t, 1, len(status.Interruptions))
+	h.Equals(t, ScaleDownReasonSpotInterrupted, spotBlockReason(status))
+}
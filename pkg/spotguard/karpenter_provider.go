@@ -0,0 +1,458 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/rs/zerolog/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// Karpenter labels and annotations Spot Guard reads or sets on NodeClaims
+const (
+	// LabelKarpenterNodePool names the NodePool of a NodeClaim and of its node
+	LabelKarpenterNodePool = "karpenter.sh/nodepool"
+	// annotationKarpenterNodePoolHash and annotationKarpenterNodePoolHashVersion are copied from the
+	// NodePool so Karpenter does not consider the NodeClaims Spot Guard creates as drifted
+	annotationKarpenterNodePoolHash        = "karpenter.sh/nodepool-hash"
+	annotationKarpenterNodePoolHashVersion = "karpenter.sh/nodepool-hash-version"
+	// annotationKarpenterDoNotDisrupt keeps Karpenter from consolidating a NodeClaim and its node
+	annotationKarpenterDoNotDisrupt = "karpenter.sh/do-not-disrupt"
+	// AnnotationDrainProtected marks the NodeClaims whose do-not-disrupt annotation was set by Spot Guard,
+	// it is removed with it once the drain the capacity was added for completed
+	AnnotationDrainProtected = "spot-guard.aws.amazon.com/drain-protected"
+)
+
+// nodeGVR is the core Node resource, patched through the dynamic client like the NodeClaims
+var nodeGVR = schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+
+// NodePoolGVR is the Karpenter NodePool resource
+var NodePoolGVR = schema.GroupVersionResource{
+	Group:    "karpenter.sh",
+	Version:  "v1",
+	Resource: "nodepools",
+}
+
+// NodeClaimGVR is the Karpenter NodeClaim resource
+var NodeClaimGVR = schema.GroupVersionResource{
+	Group:    "karpenter.sh",
+	Version:  "v1",
+	Resource: "nodeclaims",
+}
+
+// drainProtector is implemented by capacity providers that keep the capacity Spot Guard adds from being
+// disrupted while pods still have to move to it. ReleaseDrainProtection ends that for a pool once the drain completed.
+type drainProtector interface {
+	ReleaseDrainProtection(ctx context.Context, pool string) error
+}
+
+// karpenterCapacityProvider implements CapacityProvider with Karpenter: a pool is a NodePool and
+// its instances are the NodeClaims labelled with it. Capacity is added by creating NodeClaims from
+// the NodePool template and removed by deleting them, Karpenter launches and terminates the instances.
+type karpenterCapacityProvider struct {
+	dynamicClient dynamic.Interface
+
+	// launching holds the NodeClaims created by SetDesiredCapacity that have not launched yet, by pool and name.
+	// Karpenter deletes a NodeClaim it cannot launch, so one that disappears from this set failed.
+	launching   map[string]map[string]time.Time
+	launchingMu sync.Mutex
+}
+
+// NewKarpenterCapacityProvider returns a CapacityProvider whose pools are Karpenter NodePools
+func NewKarpenterCapacityProvider(dynamicClient dynamic.Interface) CapacityProvider {
+	return &karpenterCapacityProvider{
+		dynamicClient: dynamicClient,
+		launching:     make(map[string]map[string]time.Time),
+	}
+}
+
+// DescribePool returns the NodePool limits and its NodeClaims.
+// The desired capacity is the number of NodeClaims that are not being deleted.
+func (p *karpenterCapacityProvider) DescribePool(ctx context.Context, pool string) (*CapacityPool, error) {
+	nodePool, err := p.dynamicClient.Resource(NodePoolGVR).Get(ctx, pool, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get NodePool %s: %w", pool, err)
+	}
+	nodeClaims, err := p.listNodeClaims(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	capacityPool := &CapacityPool{
+		Name:      pool,
+		MaxSize:   nodePoolNodeLimit(nodePool),
+		Instances: make([]PoolInstance, 0, len(nodeClaims)),
+	}
+	for i := range nodeClaims {
+		instance := nodeClaimInstance(&nodeClaims[i])
+		if instance.LifecycleState != autoscaling.LifecycleStateTerminating {
+			capacityPool.DesiredCapacity++
+		}
+		capacityPool.Instances = append(capacityPool.Instances, instance)
+	}
+	return capacityPool, nil
+}
+
+// SetDesiredCapacity creates NodeClaims from the NodePool template until the pool has desired of them,
+// or deletes the NodeClaims least likely to be serving pods, those not Ready yet and then the newest.
+func (p *karpenterCapacityProvider) SetDesiredCapacity(ctx context.Context, pool string, desired int64) error {
+	nodePool, err := p.dynamicClient.Resource(NodePoolGVR).Get(ctx, pool, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get NodePool %s: %w", pool, err)
+	}
+	nodeClaims, err := p.listNodeClaims(ctx, pool)
+	if err != nil {
+		return err
+	}
+
+	active := make([]unstructured.Unstructured, 0, len(nodeClaims))
+	for _, nodeClaim := range nodeClaims {
+		if nodeClaim.GetDeletionTimestamp() == nil {
+			active = append(active, nodeClaim)
+		}
+	}
+
+	current := int64(len(active))
+	if limit := nodePoolNodeLimit(nodePool); limit > 0 && desired > limit {
+		return &ScalingFailureError{
+			Class:   FailureClassMaxSize,
+			ASGName: pool,
+			Message: fmt.Sprintf("NodePool %s is limited to %d nodes, %d requested", pool, limit, desired),
+		}
+	}
+
+	for ; current < desired; current++ {
+		nodeClaim, err := newNodeClaim(nodePool)
+		if err != nil {
+			return err
+		}
+		created, err := p.dynamicClient.Resource(NodeClaimGVR).Create(ctx, nodeClaim, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to create NodeClaim for NodePool %s: %w", pool, err)
+		}
+		p.trackLaunch(pool, created.GetName(), time.Now())
+		log.Info().
+			Str("nodePool", pool).
+			Str("nodeClaim", created.GetName()).
+			Msg("Spot Guard created Karpenter NodeClaim")
+	}
+
+	if current > desired {
+		sort.SliceStable(active, func(i, j int) bool {
+			readyI, readyJ := nodeClaimCondition(&active[i], "Ready") == "True", nodeClaimCondition(&active[j], "Ready") == "True"
+			if readyI != readyJ {
+				return !readyI
+			}
+			return active[i].GetCreationTimestamp().After(active[j].GetCreationTimestamp().Time)
+		})
+		for _, nodeClaim := range active[:current-desired] {
+			if err := p.deleteNodeClaim(ctx, nodeClaim.GetName()); err != nil {
+				return err
+			}
+			p.untrackLaunch(pool, nodeClaim.GetName())
+			log.Info().
+				Str("nodePool", pool).
+				Str("nodeClaim", nodeClaim.GetName()).
+				Msg("Spot Guard deleted Karpenter NodeClaim")
+		}
+	}
+	return nil
+}
+
+// ScalingFailure checks the NodeClaims created after since for a classified launch failure.
+// Karpenter reports them on the Launched condition, e.g. InsufficientCapacityError, and deletes
+// the NodeClaims it gives up on, so a created NodeClaim that is gone before it launched is a capacity failure.
+func (p *karpenterCapacityProvider) ScalingFailure(ctx context.Context, pool string, since time.Time) (*ScalingFailureError, error) {
+	nodeClaims, err := p.listNodeClaims(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	// Same clock skew buffer as for scaling activities
+	cutoffTime := since.Add(-5 * time.Second)
+
+	if vanished := p.vanishedLaunch(pool, nodeClaims, cutoffTime); vanished != "" {
+		log.Warn().Msgf("🚨 Spot Guard: NodeClaim %s of NodePool %s was deleted before it launched", vanished, pool)
+		return &ScalingFailureError{
+			Class:   FailureClassCapacity,
+			ASGName: pool,
+			Message: fmt.Sprintf("NodeClaim %s was deleted before it launched", vanished),
+		}, nil
+	}
+
+	for i := range nodeClaims {
+		nodeClaim := &nodeClaims[i]
+		if nodeClaim.GetCreationTimestamp().Time.Before(cutoffTime) {
+			continue
+		}
+		condition := findNodeClaimCondition(nodeClaim, "Launched")
+		if condition == nil || condition["status"] != "False" {
+			continue
+		}
+
+		reason, _ := condition["reason"].(string)
+		message, _ := condition["message"].(string)
+		class, found := classifyScalingMessage(reason, message)
+		if !found {
+			// Unknown failures keep waiting; the timeout still applies
+			continue
+		}
+
+		log.Warn().Msgf("🚨 Spot Guard: Detected %s failure launching NodeClaim %s: %s", class, nodeClaim.GetName(), message)
+		if message == "" {
+			message = reason
+		}
+		return &ScalingFailureError{Class: class, ASGName: pool, Message: message}, nil
+	}
+	return nil, nil
+}
+
+// TerminateInstance deletes the NodeClaim of the instance. Karpenter drains and terminates it,
+// and the pool shrinks by one since no NodeClaim replaces it.
+func (p *karpenterCapacityProvider) TerminateInstance(ctx context.Context, pool string, instanceID string) error {
+	nodeClaims, err := p.listNodeClaims(ctx, pool)
+	if err != nil {
+		return err
+	}
+	for i := range nodeClaims {
+		nodeClaim := &nodeClaims[i]
+		if nodeClaimInstanceID(nodeClaim) != instanceID {
+			continue
+		}
+		if nodeClaim.GetDeletionTimestamp() != nil {
+			return nil
+		}
+		return p.deleteNodeClaim(ctx, nodeClaim.GetName())
+	}
+	return fmt.Errorf("failed to terminate instance %s in NodePool %s: %w", instanceID, pool, ErrInstanceNotInASG)
+}
+
+// ReleaseDrainProtection removes the do-not-disrupt annotation Spot Guard set on the NodeClaims of a pool,
+// and from their nodes, which got it from the NodeClaim when they registered
+func (p *karpenterCapacityProvider) ReleaseDrainProtection(ctx context.Context, pool string) error {
+	nodeClaims, err := p.listNodeClaims(ctx, pool)
+	if err != nil {
+		return err
+	}
+	patch := []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:null,%q:null}}}`, annotationKarpenterDoNotDisrupt, AnnotationDrainProtected))
+	for i := range nodeClaims {
+		nodeClaim := &nodeClaims[i]
+		if _, ok := nodeClaim.GetAnnotations()[AnnotationDrainProtected]; !ok {
+			continue
+		}
+		if nodeName, _, _ := unstructured.NestedString(nodeClaim.Object, "status", "nodeName"); nodeName != "" {
+			_, err := p.dynamicClient.Resource(nodeGVR).Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to release drain protection of node %s: %w", nodeName, err)
+			}
+		}
+		_, err := p.dynamicClient.Resource(NodeClaimGVR).Patch(ctx, nodeClaim.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to release drain protection of NodeClaim %s: %w", nodeClaim.GetName(), err)
+		}
+		log.Info().
+			Str("nodePool", pool).
+			Str("nodeClaim", nodeClaim.GetName()).
+			Msg("Spot Guard released Karpenter NodeClaim for disruption")
+	}
+	return nil
+}
+
+// trackLaunch remembers a created NodeClaim until it launched
+func (p *karpenterCapacityProvider) trackLaunch(pool string, name string, created time.Time) {
+	p.launchingMu.Lock()
+	defer p.launchingMu.Unlock()
+	if p.launching[pool] == nil {
+		p.launching[pool] = make(map[string]time.Time)
+	}
+	p.launching[pool][name] = created
+}
+
+// vanishedLaunch returns a NodeClaim created after cutoffTime that is missing from nodeClaims
+// without ever reporting Launched=True. NodeClaims that launched, or were created before cutoffTime, stop being tracked.
+func (p *karpenterCapacityProvider) vanishedLaunch(pool string, nodeClaims []unstructured.Unstructured, cutoffTime time.Time) string {
+	p.launchingMu.Lock()
+	defer p.launchingMu.Unlock()
+
+	current := make(map[string]*unstructured.Unstructured, len(nodeClaims))
+	for i := range nodeClaims {
+		current[nodeClaims[i].GetName()] = &nodeClaims[i]
+	}
+	vanished := ""
+	for name, created := range p.launching[pool] {
+		nodeClaim, ok := current[name]
+		switch {
+		case created.Before(cutoffTime), ok && nodeClaimCondition(nodeClaim, "Launched") == "True":
+			delete(p.launching[pool], name)
+		case !ok:
+			delete(p.launching[pool], name)
+			vanished = name
+		}
+	}
+	return vanished
+}
+
+// untrackLaunch forgets a NodeClaim Spot Guard deletes itself
+func (p *karpenterCapacityProvider) untrackLaunch(pool string, name string) {
+	p.launchingMu.Lock()
+	defer p.launchingMu.Unlock()
+	delete(p.launching[pool], name)
+}
+
+// listNodeClaims returns the NodeClaims of a NodePool
+func (p *karpenterCapacityProvider) listNodeClaims(ctx context.Context, pool string) ([]unstructured.Unstructured, error) {
+	list, err := p.dynamicClient.Resource(NodeClaimGVR).List(ctx, metav1.ListOptions{
+		LabelSelector: LabelKarpenterNodePool + "=" + pool,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NodeClaims of NodePool %s: %w", pool, err)
+	}
+	return list.Items, nil
+}
+
+// deleteNodeClaim deletes a NodeClaim, Karpenter's finalizer terminates its instance
+func (p *karpenterCapacityProvider) deleteNodeClaim(ctx context.Context, name string) error {
+	if err := p.dynamicClient.Resource(NodeClaimGVR).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete NodeClaim %s: %w", name, err)
+	}
+	return nil
+}
+
+// newNodeClaim builds a NodeClaim from the template of a NodePool, the way Karpenter's provisioner does
+func newNodeClaim(nodePool *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	pool := nodePool.GetName()
+
+	templateSpec, found, err := unstructured.NestedMap(nodePool.Object, "spec", "template", "spec")
+	if err != nil || !found {
+		return nil, fmt.Errorf("NodePool %s has no spec.template.spec", pool)
+	}
+	labels, _, _ := unstructured.NestedStringMap(nodePool.Object, "spec", "template", "metadata", "labels")
+	annotations, _, _ := unstructured.NestedStringMap(nodePool.Object, "spec", "template", "metadata", "annotations")
+
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[LabelKarpenterNodePool] = pool
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	for _, key := range []string{annotationKarpenterNodePoolHash, annotationKarpenterNodePoolHashVersion} {
+		if value, ok := nodePool.GetAnnotations()[key]; ok {
+			annotations[key] = value
+		}
+	}
+	// The node stays empty until the drain moves pods to it, consolidation must not remove it before.
+	// A do-not-disrupt annotation from the NodePool template is the user's and is left in place.
+	if _, ok := annotations[annotationKarpenterDoNotDisrupt]; !ok {
+		annotations[annotationKarpenterDoNotDisrupt] = "true"
+		annotations[AnnotationDrainProtected] = "true"
+	}
+
+	nodeClaim := &unstructured.Unstructured{Object: map[string]interface{}{"spec": templateSpec}}
+	nodeClaim.SetAPIVersion(NodeClaimGVR.GroupVersion().String())
+	nodeClaim.SetKind("NodeClaim")
+	nodeClaim.SetGenerateName(pool + "-")
+	nodeClaim.SetLabels(labels)
+	nodeClaim.SetAnnotations(annotations)
+	nodeClaim.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion:         nodePool.GetAPIVersion(),
+		Kind:               nodePool.GetKind(),
+		Name:               pool,
+		UID:                nodePool.GetUID(),
+		BlockOwnerDeletion: aws.Bool(true),
+	}})
+	return nodeClaim, nil
+}
+
+// nodeClaimInstance maps a NodeClaim to a pool instance using the ASG lifecycle vocabulary
+func nodeClaimInstance(nodeClaim *unstructured.Unstructured) PoolInstance {
+	instance := PoolInstance{
+		InstanceID:     nodeClaimInstanceID(nodeClaim),
+		LifecycleState: autoscaling.LifecycleStatePending,
+		HealthStatus:   InstanceHealthy,
+	}
+	switch {
+	case nodeClaim.GetDeletionTimestamp() != nil:
+		instance.LifecycleState = autoscaling.LifecycleStateTerminating
+	case nodeClaimCondition(nodeClaim, "Ready") == "True":
+		instance.LifecycleState = autoscaling.LifecycleStateInService
+	case nodeClaimCondition(nodeClaim, "Initialized") == "True" && nodeClaimCondition(nodeClaim, "Ready") == "False":
+		// The node came up once and then stopped being ready
+		instance.HealthStatus = InstanceUnhealthy
+	}
+	return instance
+}
+
+// nodeClaimInstanceID returns the EC2 instance ID of a NodeClaim, or its name while it has not launched yet
+func nodeClaimInstanceID(nodeClaim *unstructured.Unstructured) string {
+	providerID, _, _ := unstructured.NestedString(nodeClaim.Object, "status", "providerID")
+	if instanceID := extractInstanceIDFromProviderID(providerID); instanceID != "" {
+		return instanceID
+	}
+	return nodeClaim.GetName()
+}
+
+// nodeClaimCondition returns the status of a NodeClaim condition, or "" when it is not set
+func nodeClaimCondition(nodeClaim *unstructured.Unstructured, conditionType string) string {
+	condition := findNodeClaimCondition(nodeClaim, conditionType)
+	if condition == nil {
+		return ""
+	}
+	status, _ := condition["status"].(string)
+	return status
+}
+
+// findNodeClaimCondition returns a NodeClaim condition by type
+func findNodeClaimCondition(nodeClaim *unstructured.Unstructured, conditionType string) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(nodeClaim.Object, "status", "conditions")
+	for _, raw := range conditions {
+		condition, ok := raw.(map[string]interface{})
+		if ok && condition["type"] == conditionType {
+			return condition
+		}
+	}
+	return nil
+}
+
+// nodePoolNodeLimit returns the spec.limits.nodes of a NodePool, 0 when it is not limited
+func nodePoolNodeLimit(nodePool *unstructured.Unstructured) int64 {
+	raw, found, _ := unstructured.NestedFieldNoCopy(nodePool.Object, "spec", "limits", "nodes")
+	if !found {
+		return 0
+	}
+	switch value := raw.(type) {
+	case int64:
+		return value
+	case float64:
+		return int64(value)
+	case string:
+		if quantity, err := resource.ParseQuantity(value); err == nil {
+			return quantity.Value()
+		}
+	}
+	return 0
+}
@@ -0,0 +1,266 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func testNodePool(name string, nodeLimit string) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"template": map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": map[string]interface{}{"team": "web"},
+			},
+			"spec": map[string]interface{}{
+				"nodeClassRef": map[string]interface{}{"group": "karpenter.k8s.aws", "kind": "EC2NodeClass", "name": "default"},
+				"requirements": []interface{}{map[string]interface{}{
+					"key": "karpenter.sh/capacity-type", "operator": "In", "values": []interface{}{"spot"},
+				}},
+			},
+		},
+	}
+	if nodeLimit != "" {
+		spec["limits"] = map[string]interface{}{"nodes": nodeLimit}
+	}
+	nodePool := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	nodePool.SetAPIVersion("karpenter.sh/v1")
+	nodePool.SetKind("NodePool")
+	nodePool.SetName(name)
+	nodePool.SetAnnotations(map[string]string{annotationKarpenterNodePoolHash: "1234", annotationKarpenterNodePoolHashVersion: "v3"})
+	return nodePool
+}
+
+func testNodeClaim(name string, pool string, instanceID string, created time.Time, conditions map[string]string) *unstructured.Unstructured {
+	status := map[string]interface{}{}
+	if instanceID != "" {
+		status["providerID"] = "aws:///us-east-1a/" + instanceID
+	}
+	list := make([]interface{}, 0, len(conditions))
+	for conditionType, conditionStatus := range conditions {
+		list = append(list, map[string]interface{}{"type": conditionType, "status": conditionStatus})
+	}
+	status["conditions"] = list
+
+	nodeClaim := &unstructured.Unstructured{Object: map[string]interface{}{"status": status}}
+	nodeClaim.SetAPIVersion("karpenter.sh/v1")
+	nodeClaim.SetKind("NodeClaim")
+	nodeClaim.SetName(name)
+	nodeClaim.SetLabels(map[string]string{LabelKarpenterNodePool: pool})
+	nodeClaim.SetCreationTimestamp(metav1.NewTime(created))
+	return nodeClaim
+}
+
+// testKarpenterClient returns a fake dynamic client that names NodeClaims created with generateName
+func testKarpenterClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		NodePoolGVR:  "NodePoolList",
+		NodeClaimGVR: "NodeClaimList",
+	}, objects...)
+	generated := 0
+	client.PrependReactor("create", "nodeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if obj.GetName() == "" {
+			generated++
+			obj.SetName(fmt.Sprintf("%s%d", obj.GetGenerateName(), generated))
+		}
+		return false, nil, nil
+	})
+	return client
+}
+
+func TestKarpenterDescribePool(t *testing.T) {
+	now := time.Now()
+	deleting := testNodeClaim("spot-c", "spot", "i-3", now, map[string]string{"Ready": "True"})
+	deleting.SetDeletionTimestamp(&metav1.Time{Time: now})
+	client := testKarpenterClient(
+		testNodePool("spot", "5"),
+		testNodeClaim("spot-a", "spot", "i-1", now, map[string]string{"Ready": "True"}),
+		testNodeClaim("spot-b", "spot", "", now, map[string]string{"Launched": "Unknown"}),
+		deleting,
+		testNodeClaim("broken", "spot", "i-4", now, map[string]string{"Initialized": "True", "Ready": "False"}),
+		testNodeClaim("od-a", "od", "i-9", now, map[string]string{"Ready": "True"}),
+	)
+
+	pool, err := NewKarpenterCapacityProvider(client).DescribePool(context.Background(), "spot")
+	h.Ok(t, err)
+	h.Equals(t, int64(3), pool.DesiredCapacity)
+	h.Equals(t, int64(5), pool.MaxSize)
+	h.Equals(t, 1, pool.InServiceCount())
+	h.Equals(t, []PoolInstance{
+		{InstanceID: "i-4", LifecycleState: "Pending", HealthStatus: InstanceUnhealthy},
+		{InstanceID: "i-1", LifecycleState: "InService", HealthStatus: InstanceHealthy},
+		{InstanceID: "spot-b", LifecycleState: "Pending", HealthStatus: InstanceHealthy},
+		{InstanceID: "i-3", LifecycleState: "Terminating", HealthStatus: InstanceHealthy},
+	}, pool.Instances)
+}
+
+func TestKarpenterSetDesiredCapacity(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	client := testKarpenterClient(
+		testNodePool("spot", "3"),
+		testNodeClaim("spot-old", "spot", "i-1", now.Add(-time.Hour), map[string]string{"Ready": "True"}),
+	)
+	provider := NewKarpenterCapacityProvider(client)
+
+	h.Ok(t, provider.SetDesiredCapacity(ctx, "spot", 3))
+	list, err := client.Resource(NodeClaimGVR).List(ctx, metav1.ListOptions{})
+	h.Ok(t, err)
+	h.Equals(t, 3, len(list.Items))
+
+	created, err := client.Resource(NodeClaimGVR).Get(ctx, "spot-1", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, map[string]string{"team": "web", LabelKarpenterNodePool: "spot"}, created.GetLabels())
+	h.Equals(t, "1234", created.GetAnnotations()[annotationKarpenterNodePoolHash])
+	h.Equals(t, "true", created.GetAnnotations()[annotationKarpenterDoNotDisrupt])
+	h.Equals(t, "true", created.GetAnnotations()[AnnotationDrainProtected])
+	h.Equals(t, "spot", created.GetOwnerReferences()[0].Name)
+	requirements, _, _ := unstructured.NestedSlice(created.Object, "spec", "requirements")
+	h.Equals(t, 1, len(requirements))
+
+	// The NodePool node limit is reported as a max-size failure
+	err = provider.SetDesiredCapacity(ctx, "spot", 4)
+	var failure *ScalingFailureError
+	h.Assert(t, errors.As(err, &failure), "expected a *ScalingFailureError, got %v", err)
+	h.Equals(t, FailureClassMaxSize, failure.Class)
+
+	// Shrinking removes NodeClaims that are not Ready before the one serving pods
+	h.Ok(t, provider.SetDesiredCapacity(ctx, "spot", 1))
+	list, err = client.Resource(NodeClaimGVR).List(ctx, metav1.ListOptions{})
+	h.Ok(t, err)
+	h.Equals(t, 1, len(list.Items))
+	h.Equals(t, "spot-old", list.Items[0].GetName())
+}
+
+func TestKarpenterScalingFailure(t *testing.T) {
+	since := time.Now()
+	failed := testNodeClaim("spot-new", "spot", "", since, nil)
+	h.Ok(t, unstructured.SetNestedSlice(failed.Object, []interface{}{map[string]interface{}{
+		"type":    "Launched",
+		"status":  "False",
+		"reason":  "InsufficientCapacityError",
+		"message": "all requested instance types were unavailable during launch",
+	}}, "status", "conditions"))
+	stale := testNodeClaim("spot-stale", "spot", "", since.Add(-time.Hour), nil)
+	h.Ok(t, unstructured.SetNestedSlice(stale.Object, []interface{}{map[string]interface{}{
+		"type": "Launched", "status": "False", "reason": "InsufficientCapacityError",
+	}}, "status", "conditions"))
+
+	provider := NewKarpenterCapacityProvider(testKarpenterClient(testNodePool("spot", ""), stale))
+	failure, err := provider.ScalingFailure(context.Background(), "spot", since)
+	h.Ok(t, err)
+	h.Assert(t, failure == nil, "a failure from before the scale-up must be ignored, got %v", failure)
+
+	provider = NewKarpenterCapacityProvider(testKarpenterClient(testNodePool("spot", ""), stale, failed))
+	failure, err = provider.ScalingFailure(context.Background(), "spot", since)
+	h.Ok(t, err)
+	h.Assert(t, failure != nil, "expected a classified launch failure")
+	h.Equals(t, FailureClassCapacity, failure.Class)
+	h.Equals(t, "spot", failure.ASGName)
+}
+
+func TestKarpenterScalingFailureVanishedNodeClaim(t *testing.T) {
+	ctx := context.Background()
+	client := testKarpenterClient(testNodePool("spot", ""))
+	provider := NewKarpenterCapacityProvider(client)
+	since := time.Now()
+
+	h.Ok(t, provider.SetDesiredCapacity(ctx, "spot", 1))
+	failure, err := provider.ScalingFailure(ctx, "spot", since)
+	h.Ok(t, err)
+	h.Assert(t, failure == nil, "a NodeClaim still launching is no failure, got %v", failure)
+
+	// Karpenter deletes the NodeClaims it cannot launch
+	h.Ok(t, client.Resource(NodeClaimGVR).Delete(ctx, "spot-1", metav1.DeleteOptions{}))
+	failure, err = provider.ScalingFailure(ctx, "spot", since)
+	h.Ok(t, err)
+	h.Assert(t, failure != nil, "expected a failure for the deleted NodeClaim")
+	h.Equals(t, FailureClassCapacity, failure.Class)
+
+	// Reported once
+	failure, err = provider.ScalingFailure(ctx, "spot", since)
+	h.Ok(t, err)
+	h.Assert(t, failure == nil, "the deleted NodeClaim must be reported once, got %v", failure)
+
+	// NodeClaims Spot Guard deletes itself are no failure
+	h.Ok(t, provider.SetDesiredCapacity(ctx, "spot", 1))
+	h.Ok(t, provider.SetDesiredCapacity(ctx, "spot", 0))
+	failure, err = provider.ScalingFailure(ctx, "spot", since)
+	h.Ok(t, err)
+	h.Assert(t, failure == nil, "a NodeClaim removed by a scale-in is no failure, got %v", failure)
+}
+
+func TestKarpenterReleaseDrainProtection(t *testing.T) {
+	ctx := context.Background()
+	owned := testNodeClaim("spot-own", "spot", "i-1", time.Now(), map[string]string{"Ready": "True"})
+	owned.SetAnnotations(map[string]string{annotationKarpenterDoNotDisrupt: "true", AnnotationDrainProtected: "true"})
+	h.Ok(t, unstructured.SetNestedField(owned.Object, "spot-node-1", "status", "nodeName"))
+	pinned := testNodeClaim("spot-user", "spot", "i-2", time.Now(), map[string]string{"Ready": "True"})
+	pinned.SetAnnotations(map[string]string{annotationKarpenterDoNotDisrupt: "true"})
+	registered := &unstructured.Unstructured{}
+	registered.SetAPIVersion("v1")
+	registered.SetKind("Node")
+	registered.SetName("spot-node-1")
+	registered.SetAnnotations(map[string]string{annotationKarpenterDoNotDisrupt: "true", AnnotationDrainProtected: "true"})
+
+	client := testKarpenterClient(testNodePool("spot", ""), owned, pinned, registered)
+	provider := NewKarpenterCapacityProvider(client)
+	protector, ok := provider.(drainProtector)
+	h.Assert(t, ok, "the Karpenter provider must protect new capacity")
+	h.Ok(t, protector.ReleaseDrainProtection(ctx, "spot"))
+
+	released, err := client.Resource(NodeClaimGVR).Get(ctx, "spot-own", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, 0, len(released.GetAnnotations()))
+	node, err := client.Resource(nodeGVR).Get(ctx, "spot-node-1", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, 0, len(node.GetAnnotations()))
+	// The do-not-disrupt annotation set by the user stays
+	kept, err := client.Resource(NodeClaimGVR).Get(ctx, "spot-user", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, "true", kept.GetAnnotations()[annotationKarpenterDoNotDisrupt])
+}
+
+func TestKarpenterTerminateInstance(t *testing.T) {
+	ctx := context.Background()
+	client := testKarpenterClient(
+		testNodePool("od", ""),
+		testNodeClaim("od-a", "od", "i-1", time.Now(), map[string]string{"Ready": "True"}),
+		testNodeClaim("od-b", "od", "i-2", time.Now(), map[string]string{"Ready": "True"}),
+	)
+	provider := NewKarpenterCapacityProvider(client)
+
+	h.Ok(t, provider.TerminateInstance(ctx, "od", "i-2"))
+	list, err := client.Resource(NodeClaimGVR).List(ctx, metav1.ListOptions{})
+	h.Ok(t, err)
+	h.Equals(t, 1, len(list.Items))
+	h.Equals(t, "od-a", list.Items[0].GetName())
+
+	err = provider.TerminateInstance(ctx, "od", "i-7")
+	h.Assert(t, errors.Is(err, ErrInstanceNotInASG), "expected ErrInstanceNotInASG, got %v", err)
+}
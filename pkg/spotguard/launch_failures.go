@@ -0,0 +1,89 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"sync"
+	"time"
+)
+
+// LaunchFailure is an "EC2 Instance Launch Unsuccessful" Auto Scaling event
+type LaunchFailure struct {
+	ASGName       string
+	ActivityID    string
+	StatusMessage string
+	Time          time.Time
+}
+
+// launchFailureNotifier keeps the latest launch failure per ASG and wakes up waiters
+// when a new one arrives. The zero value is ready to use.
+type launchFailureNotifier struct {
+	mutex   sync.Mutex
+	latest  map[string]LaunchFailure
+	changed chan struct{}
+}
+
+// notify records a launch failure and wakes up everyone waiting on the current channel
+func (n *launchFailureNotifier) notify(failure LaunchFailure) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.latest == nil {
+		n.latest = make(map[string]LaunchFailure)
+	}
+	if previous, found := n.latest[failure.ASGName]; found && previous.Time.After(failure.Time) {
+		return
+	}
+	n.latest[failure.ASGName] = failure
+
+	if n.changed != nil {
+		close(n.changed)
+		n.changed = nil
+	}
+}
+
+// wait returns a channel that is closed on the next launch failure.
+// Take it before checking since() so a failure in between is not missed.
+func (n *launchFailureNotifier) wait() <-chan struct{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.changed == nil {
+		n.changed = make(chan struct{})
+	}
+	return n.changed
+}
+
+// since returns the latest launch failure of an ASG if it happened at or after cutoff
+func (n *launchFailureNotifier) since(asgName string, cutoff time.Time) (LaunchFailure, bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	failure, found := n.latest[asgName]
+	if !found || failure.Time.Before(cutoff) {
+		return LaunchFailure{}, false
+	}
+	return failure, true
+}
+
+// NotifyLaunchFailure reports an unsuccessful instance launch in an ASG.
+// A scale-up waiting on that ASG gives up immediately instead of waiting for its timeout.
+func (sg *SpotGuard) NotifyLaunchFailure(failure LaunchFailure) {
+	sg.launchFailures.notify(failure)
+}
+
+// LatestLaunchFailure returns the most recent launch failure reported for an ASG
+func (sg *SpotGuard) LatestLaunchFailure(asgName string) (LaunchFailure, bool) {
+	return sg.launchFailures.since(asgName, time.Time{})
+}
@@ -0,0 +1,369 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/rs/zerolog/log"
+)
+
+// How a MixedInstancesPolicy ASG is shifted to on-demand, selected with --spot-guard-mixed-instances-shift
+const (
+	// MixedInstancesShiftBaseCapacity raises OnDemandBaseCapacity by one per fallback
+	MixedInstancesShiftBaseCapacity = "base-capacity"
+	// MixedInstancesShiftPercentage raises OnDemandPercentageAboveBaseCapacity to 100 while any fallback is active
+	MixedInstancesShiftPercentage = "percentage"
+)
+
+// Lifecycles of a pool instance, reported by providers that run spot and on-demand in one pool
+const (
+	InstanceLifecycleSpot     = "spot"
+	InstanceLifecycleOnDemand = "on-demand"
+)
+
+// TagOnDemandShift records the original instances distribution of a shifted ASG and how many
+// on-demand instances were added on top of it, so recovery works across restarts and replicas
+const TagOnDemandShift = "spot-guard.aws.amazon.com/on-demand-shift"
+
+// onDemandShift is the value of TagOnDemandShift
+type onDemandShift struct {
+	BaseCapacity        int64 `json:"onDemandBaseCapacity"`
+	PercentageAboveBase int64 `json:"onDemandPercentageAboveBaseCapacity"`
+	Surplus             int   `json:"surplus"`
+}
+
+// onDemandShifter is implemented by capacity providers whose spot and on-demand capacity share one pool.
+// Falling back shifts the pool to on-demand instead of scaling a separate on-demand pool.
+type onDemandShifter interface {
+	// ShiftToOnDemand makes the pool launch its next instance as on-demand
+	ShiftToOnDemand(ctx context.Context, pool string) error
+	// OnDemandSurplus returns how many on-demand instances were added by fallbacks and not retired yet
+	OnDemandSurplus(ctx context.Context, pool string) (int, error)
+}
+
+// mixedInstancesCapacityProvider implements CapacityProvider for a single ASG with a MixedInstancesPolicy.
+// Falling back raises the on-demand share of the ASG, and each retired on-demand instance gives it back,
+// until the original distribution is restored.
+type mixedInstancesCapacityProvider struct {
+	asgCapacityProvider
+	shift string
+	lock  *ShiftLock
+}
+
+// NewMixedInstancesCapacityProvider returns a CapacityProvider that falls back by shifting the
+// instances distribution of a MixedInstancesPolicy ASG to on-demand. Every replica may shift the ASG,
+// the lock serializes their updates of the distribution and the surplus; it may be nil with a single replica.
+func NewMixedInstancesCapacityProvider(asgClient autoscalingiface.AutoScalingAPI, ec2Client ec2iface.EC2API, shift string, lock *ShiftLock) CapacityProvider {
+	return &mixedInstancesCapacityProvider{
+		asgCapacityProvider: asgCapacityProvider{asgClient: asgClient, ec2Client: ec2Client},
+		shift:               shift,
+		lock:                lock,
+	}
+}
+
+// DescribePool describes the ASG and tells its spot and on-demand instances apart
+func (p *mixedInstancesCapacityProvider) DescribePool(ctx context.Context, pool string) (*CapacityPool, error) {
+	capacityPool, err := p.asgCapacityProvider.DescribePool(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceIDs := make([]*string, 0, len(capacityPool.Instances))
+	for _, instance := range capacityPool.Instances {
+		instanceIDs = append(instanceIDs, aws.String(instance.InstanceID))
+	}
+	if len(instanceIDs) == 0 {
+		return capacityPool, nil
+	}
+
+	lifecycles := make(map[string]string, len(instanceIDs))
+	err = p.ec2Client.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: instanceIDs},
+		func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					lifecycle := InstanceLifecycleOnDemand
+					if aws.StringValue(instance.InstanceLifecycle) == ec2.InstanceLifecycleSpot {
+						lifecycle = InstanceLifecycleSpot
+					}
+					lifecycles[aws.StringValue(instance.InstanceId)] = lifecycle
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instances of ASG %s: %w", pool, err)
+	}
+
+	for i := range capacityPool.Instances {
+		capacityPool.Instances[i].Lifecycle = lifecycles[capacityPool.Instances[i].InstanceID]
+	}
+	return capacityPool, nil
+}
+
+// ShiftToOnDemand raises the on-demand share of the ASG so the instance that spot could not provide
+// launches as on-demand. The desired capacity is only raised when no launch is pending already.
+func (p *mixedInstancesCapacityProvider) ShiftToOnDemand(ctx context.Context, pool string) error {
+	return p.lock.withLock(ctx, func() error {
+		return p.shiftToOnDemand(ctx, pool)
+	})
+}
+
+// shiftToOnDemand raises the on-demand share, the caller holds the shift lock
+func (p *mixedInstancesCapacityProvider) shiftToOnDemand(ctx context.Context, pool string) error {
+	group, err := p.describeGroup(ctx, pool)
+	if err != nil {
+		return err
+	}
+	distribution, err := instancesDistribution(group)
+	if err != nil {
+		return err
+	}
+
+	base := aws.Int64Value(distribution.OnDemandBaseCapacity)
+	percentage := aws.Int64Value(distribution.OnDemandPercentageAboveBaseCapacity)
+	previous := readOnDemandShift(group)
+	state := onDemandShift{BaseCapacity: base, PercentageAboveBase: percentage}
+	if previous != nil {
+		state = *previous
+	}
+	state.Surplus++
+
+	input := &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(pool),
+		MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{InstancesDistribution: &autoscaling.InstancesDistribution{}},
+	}
+	if p.shift == MixedInstancesShiftPercentage {
+		input.MixedInstancesPolicy.InstancesDistribution.OnDemandPercentageAboveBaseCapacity = aws.Int64(100)
+	} else {
+		input.MixedInstancesPolicy.InstancesDistribution.OnDemandBaseCapacity = aws.Int64(base + 1)
+	}
+
+	desired := aws.Int64Value(group.DesiredCapacity)
+	if int64(len(activeGroupInstances(group))) >= desired {
+		// Spot never got a pending slot, e.g. the ASG was at its max size, so one is added for on-demand
+		if maxSize := aws.Int64Value(group.MaxSize); desired+1 > maxSize {
+			return &ScalingFailureError{
+				Class:   FailureClassMaxSize,
+				ASGName: pool,
+				Message: fmt.Sprintf("scaling to %d would exceed max size (%d)", desired+1, maxSize),
+			}
+		}
+		input.DesiredCapacity = aws.Int64(desired + 1)
+	}
+
+	// The originals are recorded first so they are never lost if the update fails halfway
+	if err := p.writeOnDemandShift(ctx, pool, &state); err != nil {
+		return err
+	}
+	if _, err := p.asgClient.UpdateAutoScalingGroupWithContext(ctx, input); err != nil {
+		if restoreErr := p.writeOnDemandShift(ctx, pool, previous); restoreErr != nil {
+			log.Error().Err(restoreErr).Str("asgName", pool).Msg("Failed to roll back Spot Guard on-demand shift tag")
+		}
+		return fmt.Errorf("failed to shift ASG %s to on-demand: %w", pool, classifyAPIError(pool, err))
+	}
+
+	log.Info().
+		Str("asgName", pool).
+		Str("shift", p.shift).
+		Int64("originalBaseCapacity", state.BaseCapacity).
+		Int64("originalPercentageAboveBase", state.PercentageAboveBase).
+		Int("onDemandSurplus", state.Surplus).
+		Msg("Spot Guard shifted mixed instances ASG to on-demand")
+	return nil
+}
+
+// OnDemandSurplus returns the number of fallback on-demand instances recorded on the ASG
+func (p *mixedInstancesCapacityProvider) OnDemandSurplus(ctx context.Context, pool string) (int, error) {
+	group, err := p.describeGroup(ctx, pool)
+	if err != nil {
+		return 0, err
+	}
+	state := readOnDemandShift(group)
+	if state == nil {
+		return 0, nil
+	}
+	return state.Surplus, nil
+}
+
+// TerminateInstance gives back one step of the on-demand shift, then terminates the instance and
+// decrements the ASG. The distribution is lowered first so the ASG never replaces the retired
+// instance with another on-demand one, and put back when the termination fails. Once the last
+// surplus instance is retired the original distribution is restored and the tag removed.
+func (p *mixedInstancesCapacityProvider) TerminateInstance(ctx context.Context, pool string, instanceID string) error {
+	return p.lock.withLock(ctx, func() error {
+		group, err := p.describeGroup(ctx, pool)
+		if err != nil {
+			return err
+		}
+
+		state := readOnDemandShift(group)
+		if state == nil || state.Surplus <= 0 {
+			return p.asgCapacityProvider.TerminateInstance(ctx, pool, instanceID)
+		}
+
+		distribution, err := instancesDistribution(group)
+		if err != nil {
+			return err
+		}
+		before := &autoscaling.InstancesDistribution{
+			OnDemandBaseCapacity:                distribution.OnDemandBaseCapacity,
+			OnDemandPercentageAboveBaseCapacity: distribution.OnDemandPercentageAboveBaseCapacity,
+		}
+		previous := *state
+		if err := p.unshift(ctx, group, state); err != nil {
+			return err
+		}
+
+		if err := p.asgCapacityProvider.TerminateInstance(ctx, pool, instanceID); err != nil {
+			p.reshift(ctx, pool, before, &previous)
+			return err
+		}
+		return nil
+	})
+}
+
+// reshift puts back the distribution and the surplus of an unshift whose instance was not terminated
+func (p *mixedInstancesCapacityProvider) reshift(ctx context.Context, pool string, distribution *autoscaling.InstancesDistribution, state *onDemandShift) {
+	_, err := p.asgClient.UpdateAutoScalingGroupWithContext(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(pool),
+		MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{InstancesDistribution: distribution},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("asgName", pool).Msg("Failed to roll back the instances distribution after a failed termination")
+		return
+	}
+	if err := p.writeOnDemandShift(ctx, pool, state); err != nil {
+		log.Error().Err(err).Str("asgName", pool).Msg("Failed to roll back Spot Guard on-demand shift tag after a failed termination")
+		return
+	}
+	log.Info().
+		Str("asgName", pool).
+		Int("onDemandSurplus", state.Surplus).
+		Msg("Spot Guard put back the on-demand shift of an instance that was not terminated")
+}
+
+// unshift lowers the on-demand share of the ASG by one retired surplus instance
+func (p *mixedInstancesCapacityProvider) unshift(ctx context.Context, group *autoscaling.Group, state *onDemandShift) error {
+	pool := aws.StringValue(group.AutoScalingGroupName)
+	distribution, err := instancesDistribution(group)
+	if err != nil {
+		return err
+	}
+
+	state.Surplus--
+	restored := &autoscaling.InstancesDistribution{
+		// Spot is back, new launches above the base use the original percentage again
+		OnDemandPercentageAboveBaseCapacity: aws.Int64(state.PercentageAboveBase),
+		OnDemandBaseCapacity:                aws.Int64(max(state.BaseCapacity, aws.Int64Value(distribution.OnDemandBaseCapacity)-1)),
+	}
+	if state.Surplus <= 0 {
+		restored.OnDemandBaseCapacity = aws.Int64(state.BaseCapacity)
+	}
+
+	_, err = p.asgClient.UpdateAutoScalingGroupWithContext(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(pool),
+		MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{InstancesDistribution: restored},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore instances distribution of ASG %s: %w", pool, err)
+	}
+
+	if state.Surplus <= 0 {
+		state = nil
+	}
+	if err := p.writeOnDemandShift(ctx, pool, state); err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("asgName", pool).
+		Int64("onDemandBaseCapacity", aws.Int64Value(restored.OnDemandBaseCapacity)).
+		Int64("onDemandPercentageAboveBase", aws.Int64Value(restored.OnDemandPercentageAboveBaseCapacity)).
+		Bool("fullyRestored", state == nil).
+		Msg("Spot Guard restored mixed instances ASG distribution")
+	return nil
+}
+
+// writeOnDemandShift stores the shift state on the ASG, deleting the tag when state is nil
+func (p *mixedInstancesCapacityProvider) writeOnDemandShift(ctx context.Context, pool string, state *onDemandShift) error {
+	tag := &autoscaling.Tag{
+		Key:          aws.String(TagOnDemandShift),
+		ResourceId:   aws.String(pool),
+		ResourceType: aws.String("auto-scaling-group"),
+	}
+
+	if state == nil {
+		if _, err := p.asgClient.DeleteTagsWithContext(ctx, &autoscaling.DeleteTagsInput{Tags: []*autoscaling.Tag{tag}}); err != nil {
+			return fmt.Errorf("failed to delete %s tag of ASG %s: %w", TagOnDemandShift, pool, err)
+		}
+		return nil
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode on-demand shift: %w", err)
+	}
+	tag.Value = aws.String(string(raw))
+	tag.PropagateAtLaunch = aws.Bool(false)
+	if _, err := p.asgClient.CreateOrUpdateTagsWithContext(ctx, &autoscaling.CreateOrUpdateTagsInput{Tags: []*autoscaling.Tag{tag}}); err != nil {
+		return fmt.Errorf("failed to write %s tag of ASG %s: %w", TagOnDemandShift, pool, err)
+	}
+	return nil
+}
+
+// readOnDemandShift returns the shift state recorded on the ASG, or nil when it is not shifted
+func readOnDemandShift(group *autoscaling.Group) *onDemandShift {
+	for _, tag := range group.Tags {
+		if aws.StringValue(tag.Key) != TagOnDemandShift {
+			continue
+		}
+		state := &onDemandShift{}
+		if err := json.Unmarshal([]byte(aws.StringValue(tag.Value)), state); err != nil {
+			log.Warn().
+				Err(err).
+				Str("asgName", aws.StringValue(group.AutoScalingGroupName)).
+				Msg("Ignoring unreadable Spot Guard on-demand shift tag")
+			return nil
+		}
+		return state
+	}
+	return nil
+}
+
+// instancesDistribution returns the instances distribution of a MixedInstancesPolicy ASG
+func instancesDistribution(group *autoscaling.Group) (*autoscaling.InstancesDistribution, error) {
+	if group.MixedInstancesPolicy == nil || group.MixedInstancesPolicy.InstancesDistribution == nil {
+		return nil, fmt.Errorf("ASG %s has no MixedInstancesPolicy", aws.StringValue(group.AutoScalingGroupName))
+	}
+	return group.MixedInstancesPolicy.InstancesDistribution, nil
+}
+
+// activeGroupInstances returns the instances of the ASG that are neither terminating nor terminated
+func activeGroupInstances(group *autoscaling.Group) []*autoscaling.Instance {
+	active := make([]*autoscaling.Instance, 0, len(group.Instances))
+	for _, instance := range group.Instances {
+		if !isTerminatingState(aws.StringValue(instance.LifecycleState)) {
+			active = append(active, instance)
+		}
+	}
+	return active
+}
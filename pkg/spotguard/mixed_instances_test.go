@@ -0,0 +1,271 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+// mockedMixedASG keeps the state of one mixed instances ASG across calls
+type mockedMixedASG struct {
+	autoscalingiface.AutoScalingAPI
+	group        *autoscaling.Group
+	terminated   []string
+	terminateErr error
+}
+
+func (m *mockedMixedASG) DescribeAutoScalingGroupsWithContext(_ aws.Context, _ *autoscaling.DescribeAutoScalingGroupsInput, _ ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: []*autoscaling.Group{m.group}}, nil
+}
+
+func (m *mockedMixedASG) UpdateAutoScalingGroupWithContext(_ aws.Context, input *autoscaling.UpdateAutoScalingGroupInput, _ ...request.Option) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	if input.DesiredCapacity != nil {
+		m.group.DesiredCapacity = input.DesiredCapacity
+	}
+	distribution := m.group.MixedInstancesPolicy.InstancesDistribution
+	if update := input.MixedInstancesPolicy.InstancesDistribution; update != nil {
+		if update.OnDemandBaseCapacity != nil {
+			distribution.OnDemandBaseCapacity = update.OnDemandBaseCapacity
+		}
+		if update.OnDemandPercentageAboveBaseCapacity != nil {
+			distribution.OnDemandPercentageAboveBaseCapacity = update.OnDemandPercentageAboveBaseCapacity
+		}
+	}
+	return &autoscaling.UpdateAutoScalingGroupOutput{}, nil
+}
+
+func (m *mockedMixedASG) CreateOrUpdateTagsWithContext(_ aws.Context, input *autoscaling.CreateOrUpdateTagsInput, _ ...request.Option) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+	for _, tag := range input.Tags {
+		m.deleteTag(aws.StringValue(tag.Key))
+		m.group.Tags = append(m.group.Tags, &autoscaling.TagDescription{Key: tag.Key, Value: tag.Value})
+	}
+	return &autoscaling.CreateOrUpdateTagsOutput{}, nil
+}
+
+func (m *mockedMixedASG) DeleteTagsWithContext(_ aws.Context, input *autoscaling.DeleteTagsInput, _ ...request.Option) (*autoscaling.DeleteTagsOutput, error) {
+	for _, tag := range input.Tags {
+		m.deleteTag(aws.StringValue(tag.Key))
+	}
+	return &autoscaling.DeleteTagsOutput{}, nil
+}
+
+func (m *mockedMixedASG) TerminateInstanceInAutoScalingGroupWithContext(_ aws.Context, input *autoscaling.TerminateInstanceInAutoScalingGroupInput, _ ...request.Option) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	if m.terminateErr != nil {
+		return nil, m.terminateErr
+	}
+	m.terminated = append(m.terminated, aws.StringValue(input.InstanceId))
+	m.group.DesiredCapacity = aws.Int64(aws.Int64Value(m.group.DesiredCapacity) - 1)
+	return &autoscaling.TerminateInstanceInAutoScalingGroupOutput{}, nil
+}
+
+func (m *mockedMixedASG) deleteTag(key string) {
+	tags := m.group.Tags[:0]
+	for _, tag := range m.group.Tags {
+		if aws.StringValue(tag.Key) != key {
+			tags = append(tags, tag)
+		}
+	}
+	m.group.Tags = tags
+}
+
+// mockedInstanceLifecycles reports the listed instances as spot, all others as on-demand
+type mockedInstanceLifecycles struct {
+	ec2iface.EC2API
+	spot map[string]bool
+}
+
+func (m mockedInstanceLifecycles) DescribeInstancesPagesWithContext(_ aws.Context, input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool, _ ...request.Option) error {
+	reservation := &ec2.Reservation{}
+	for _, instanceID := range input.InstanceIds {
+		instance := &ec2.Instance{InstanceId: instanceID}
+		if m.spot[aws.StringValue(instanceID)] {
+			instance.InstanceLifecycle = aws.String(ec2.InstanceLifecycleSpot)
+		}
+		reservation.Instances = append(reservation.Instances, instance)
+	}
+	fn(&ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{reservation}}, true)
+	return nil
+}
+
+func testMixedASG(desired int64, instances ...*autoscaling.Instance) *mockedMixedASG {
+	return &mockedMixedASG{group: &autoscaling.Group{
+		AutoScalingGroupName: aws.String("mixed"),
+		DesiredCapacity:      aws.Int64(desired),
+		MaxSize:              aws.Int64(10),
+		Instances:            instances,
+		MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{InstancesDistribution: &autoscaling.InstancesDistribution{
+			OnDemandBaseCapacity:                aws.Int64(1),
+			OnDemandPercentageAboveBaseCapacity: aws.Int64(0),
+		}},
+	}}
+}
+
+func TestMixedInstancesBaseCapacityShift(t *testing.T) {
+	ctx := context.Background()
+	// The failed spot scale-up left one slot pending
+	asg := testMixedASG(3, asgInstance("i-1", autoscaling.LifecycleStateInService), asgInstance("i-2", autoscaling.LifecycleStateInService))
+	provider := NewMixedInstancesCapacityProvider(asg, mockedInstanceLifecycles{}, MixedInstancesShiftBaseCapacity, nil)
+	shifter := provider.(onDemandShifter)
+
+	h.Ok(t, shifter.ShiftToOnDemand(ctx, "mixed"))
+	h.Equals(t, int64(2), aws.Int64Value(asg.group.MixedInstancesPolicy.InstancesDistribution.OnDemandBaseCapacity))
+	h.Equals(t, int64(3), aws.Int64Value(asg.group.DesiredCapacity))
+
+	// No slot is pending anymore, the second fallback adds one
+	asg.group.Instances = append(asg.group.Instances, asgInstance("i-3", autoscaling.LifecycleStateInService))
+	h.Ok(t, shifter.ShiftToOnDemand(ctx, "mixed"))
+	h.Equals(t, int64(3), aws.Int64Value(asg.group.MixedInstancesPolicy.InstancesDistribution.OnDemandBaseCapacity))
+	h.Equals(t, int64(4), aws.Int64Value(asg.group.DesiredCapacity))
+
+	surplus, err := shifter.OnDemandSurplus(ctx, "mixed")
+	h.Ok(t, err)
+	h.Equals(t, 2, surplus)
+
+	// Each retirement gives back one step, the last one restores the original and removes the tag
+	h.Ok(t, provider.TerminateInstance(ctx, "mixed", "i-3"))
+	h.Equals(t, int64(2), aws.Int64Value(asg.group.MixedInstancesPolicy.InstancesDistribution.OnDemandBaseCapacity))
+	h.Ok(t, provider.TerminateInstance(ctx, "mixed", "i-2"))
+	h.Equals(t, int64(1), aws.Int64Value(asg.group.MixedInstancesPolicy.InstancesDistribution.OnDemandBaseCapacity))
+	h.Equals(t, []string{"i-3", "i-2"}, asg.terminated)
+	h.Equals(t, 0, len(asg.group.Tags))
+
+	surplus, err = shifter.OnDemandSurplus(ctx, "mixed")
+	h.Ok(t, err)
+	h.Equals(t, 0, surplus)
+}
+
+func TestMixedInstancesPercentageShift(t *testing.T) {
+	ctx := context.Background()
+	asg := testMixedASG(2, asgInstance("i-1", autoscaling.LifecycleStateInService))
+	provider := NewMixedInstancesCapacityProvider(asg, mockedInstanceLifecycles{}, MixedInstancesShiftPercentage, nil)
+
+	h.Ok(t, provider.(onDemandShifter).ShiftToOnDemand(ctx, "mixed"))
+	distribution := asg.group.MixedInstancesPolicy.InstancesDistribution
+	h.Equals(t, int64(100), aws.Int64Value(distribution.OnDemandPercentageAboveBaseCapacity))
+	h.Equals(t, int64(1), aws.Int64Value(distribution.OnDemandBaseCapacity))
+
+	h.Ok(t, provider.TerminateInstance(ctx, "mixed", "i-2"))
+	h.Equals(t, int64(0), aws.Int64Value(distribution.OnDemandPercentageAboveBaseCapacity))
+	h.Equals(t, 0, len(asg.group.Tags))
+}
+
+func TestMixedInstancesTerminateFailureKeepsShift(t *testing.T) {
+	ctx := context.Background()
+	asg := testMixedASG(2, asgInstance("i-1", autoscaling.LifecycleStateInService))
+	provider := NewMixedInstancesCapacityProvider(asg, mockedInstanceLifecycles{}, MixedInstancesShiftBaseCapacity, nil)
+	shifter := provider.(onDemandShifter)
+	h.Ok(t, shifter.ShiftToOnDemand(ctx, "mixed"))
+
+	asg.terminateErr = errors.New("ScalingActivityInProgress")
+	h.Assert(t, provider.TerminateInstance(ctx, "mixed", "i-2") != nil, "expected the termination error")
+	// The instance still runs, its on-demand step is kept
+	h.Equals(t, int64(2), aws.Int64Value(asg.group.MixedInstancesPolicy.InstancesDistribution.OnDemandBaseCapacity))
+	surplus, err := shifter.OnDemandSurplus(ctx, "mixed")
+	h.Ok(t, err)
+	h.Equals(t, 1, surplus)
+
+	asg.terminateErr = nil
+	h.Ok(t, provider.TerminateInstance(ctx, "mixed", "i-2"))
+	h.Equals(t, int64(1), aws.Int64Value(asg.group.MixedInstancesPolicy.InstancesDistribution.OnDemandBaseCapacity))
+	h.Equals(t, 0, len(asg.group.Tags))
+}
+
+func TestShiftLockWaitsForOtherReplica(t *testing.T) {
+	ctx := context.Background()
+	raw, err := json.Marshal(shiftHolder{Holder: "nth-b", Expires: time.Now().Add(time.Hour)})
+	h.Ok(t, err)
+	clientset := fake.NewSimpleClientset(&coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{
+		Name:        "drains",
+		Namespace:   "kube-system",
+		Annotations: map[string]string{AnnotationShiftHolder: string(raw)},
+	}})
+	lock := NewShiftLock(clientset, config.Config{PodName: "nth-a", PodNamespace: "kube-system", SpotGuardDrainLeaseName: "drains"})
+
+	lockCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	ran := false
+	err = lock.withLock(lockCtx, func() error {
+		ran = true
+		return nil
+	})
+	h.Assert(t, err != nil && !ran, "shift ran while another replica held the lock")
+
+	// Once the other replica's hold expires the lock is taken and given back
+	raw, err = json.Marshal(shiftHolder{Holder: "nth-b", Expires: time.Now().Add(-time.Second)})
+	h.Ok(t, err)
+	lease, err := clientset.CoordinationV1().Leases("kube-system").Get(ctx, "drains", metav1.GetOptions{})
+	h.Ok(t, err)
+	lease.Annotations[AnnotationShiftHolder] = string(raw)
+	_, err = clientset.CoordinationV1().Leases("kube-system").Update(ctx, lease, metav1.UpdateOptions{})
+	h.Ok(t, err)
+
+	h.Ok(t, lock.withLock(ctx, func() error {
+		ran = true
+		return nil
+	}))
+	h.Assert(t, ran, "shift did not run")
+	lease, err = clientset.CoordinationV1().Leases("kube-system").Get(ctx, "drains", metav1.GetOptions{})
+	h.Ok(t, err)
+	_, held := lease.Annotations[AnnotationShiftHolder]
+	h.Assert(t, !held, "shift lock not released")
+}
+
+func TestListOnDemandCandidatesMixedInstances(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	clientset := fake.NewSimpleClientset(
+		testNode("base", "i-1", map[string]string{AnnotationStartTime: now.Add(-24 * time.Hour).Format(time.RFC3339)}),
+		testNode("fallback", "i-2", map[string]string{AnnotationStartTime: now.Add(-time.Hour).Format(time.RFC3339)}),
+		testNode("spot", "i-3", map[string]string{AnnotationStartTime: now.Format(time.RFC3339)}),
+	)
+	asg := testMixedASG(3,
+		asgInstance("i-1", autoscaling.LifecycleStateInService),
+		asgInstance("i-2", autoscaling.LifecycleStateInService),
+		asgInstance("i-3", autoscaling.LifecycleStateInService),
+	)
+	provider := NewMixedInstancesCapacityProvider(asg, mockedInstanceLifecycles{spot: map[string]bool{"i-3": true}}, MixedInstancesShiftBaseCapacity, nil)
+	c := &Controller{provider: provider, clientset: clientset, onDemandASGName: "mixed", spotASGName: "mixed"}
+
+	// Nothing was shifted, the on-demand base is not retired
+	candidates, err := c.listOnDemandCandidates(ctx)
+	h.Ok(t, err)
+	h.Equals(t, 0, len(candidates))
+
+	h.Ok(t, provider.(onDemandShifter).ShiftToOnDemand(ctx, "mixed"))
+	candidates, err = c.listOnDemandCandidates(ctx)
+	h.Ok(t, err)
+	h.Equals(t, 1, len(candidates))
+	h.Equals(t, "fallback", candidates[0].nodeName)
+
+	node, err := clientset.CoreV1().Nodes().Get(ctx, "spot", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Equals(t, "", node.Annotations[AnnotationOnDemandASG])
+}
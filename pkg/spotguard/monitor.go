@@ -15,8 +15,10 @@ package spotguard
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
 	"github.com/rs/zerolog/log"
 )
 
@@ -49,6 +51,9 @@ type Monitor struct {
 	healthChecker     *HealthChecker
 	safetyChecker     *SafetyChecker
 	scaleDownExecutor *ScaleDownExecutor
+	metrics           observability.Metrics
+	recorder          observability.K8sEventRecorder
+	status            *Status
 }
 
 // NewMonitor creates a new scale-down monitor
@@ -58,6 +63,8 @@ func NewMonitor(
 	healthChecker *HealthChecker,
 	safetyChecker *SafetyChecker,
 	scaleDownExecutor *ScaleDownExecutor,
+	metrics observability.Metrics,
+	recorder observability.K8sEventRecorder,
 ) *Monitor {
 	return &Monitor{
 		config:            config,
@@ -65,9 +72,17 @@ func NewMonitor(
 		healthChecker:     healthChecker,
 		safetyChecker:     safetyChecker,
 		scaleDownExecutor: scaleDownExecutor,
+		metrics:           metrics,
+		recorder:          recorder,
 	}
 }
 
+// ReportStatus makes the monitor record its drain checks and tracked fallback events in status
+func (m *Monitor) ReportStatus(status *Status) {
+	m.status = status
+	status.TrackFallbacks(m.tracker)
+}
+
 // Start begins monitoring for scale-down opportunities
 func (m *Monitor) Start(ctx context.Context) {
 	log.Info().
@@ -99,6 +114,11 @@ func (m *Monitor) Start(ctx context.Context) {
 
 // checkAndScaleDown checks all active fallback events and scales down if conditions are met
 func (m *Monitor) checkAndScaleDown(ctx context.Context) {
+	// Pick up events recorded or updated by other processes since the last cycle
+	if err := m.tracker.Reload(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to reload persisted fallback events, using cached copy")
+	}
+
 	events := m.tracker.GetActiveEvents()
 	if len(events) == 0 {
 		return
@@ -112,8 +132,13 @@ func (m *Monitor) checkAndScaleDown(ctx context.Context) {
 			continue
 		}
 
+		// Fallbacks are recorded before the node of the new instance registers, it is looked up once it did
+		if event.OnDemandNodeName == "" && !m.resolveOnDemandNode(ctx, event) {
+			continue
+		}
+
 		// Check all conditions
-		if shouldScale, reason := m.shouldScaleDownEvent(ctx, event); shouldScale {
+		if shouldScale, reason, blockReason := m.shouldScaleDownEvent(ctx, event); shouldScale {
 			// All conditions met - scale down!
 			if err := m.executeScaleDown(ctx, event); err != nil {
 				log.Error().
@@ -127,15 +152,48 @@ func (m *Monitor) checkAndScaleDown(ctx context.Context) {
 				Str("eventID", event.EventID).
 				Str("reason", reason).
 				Msg("Cannot scale down yet")
+			m.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, blockReason)
 		}
 	}
 }
 
-// shouldScaleDownEvent checks if all conditions are met for scaling down
-func (m *Monitor) shouldScaleDownEvent(ctx context.Context, event *FallbackEvent) (bool, string) {
+// resolveOnDemandNode finds the node of the event's on-demand instance and records it on the event.
+// It returns false while the event cannot be scaled down: the instance is unknown or has no node yet.
+func (m *Monitor) resolveOnDemandNode(ctx context.Context, event *FallbackEvent) bool {
+	if event.OnDemandInstanceID == "" {
+		log.Debug().Str("eventID", event.EventID).Msg("Fallback event has no on-demand instance, it cannot be scaled down")
+		return false
+	}
+	nodeName, err := m.scaleDownExecutor.nodeForInstance(ctx, event.OnDemandInstanceID)
+	if err != nil {
+		log.Warn().Err(err).Str("eventID", event.EventID).Msg("Failed to find the node of the on-demand instance")
+		return false
+	}
+	if nodeName == "" {
+		log.Debug().
+			Str("eventID", event.EventID).
+			Str("instanceID", event.OnDemandInstanceID).
+			Msg("On-demand instance has not registered as a node yet")
+		return false
+	}
+
+	err = m.tracker.UpdateEvent(event.EventID, func(e *FallbackEvent) {
+		e.OnDemandNodeName = nodeName
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("eventID", event.EventID).Msg("Failed to persist the node of the on-demand instance")
+		return false
+	}
+	event.OnDemandNodeName = nodeName
+	return true
+}
+
+// shouldScaleDownEvent checks if all conditions are met for scaling down.
+// When they are not, it also returns the ScaleDownReason constant of the first unmet condition.
+func (m *Monitor) shouldScaleDownEvent(ctx context.Context, event *FallbackEvent) (bool, string, string) {
 	// 1. Check minimum wait time
 	if canScale, reason := m.safetyChecker.CanScaleDownOnDemand(event); !canScale {
-		return false, reason
+		return false, reason, ScaleDownReasonMinimumWaitNotMet
 	}
 
 	// 2. Check spot capacity health and stability
@@ -147,26 +205,35 @@ func (m *Monitor) shouldScaleDownEvent(ctx context.Context, event *FallbackEvent
 	)
 	if err != nil {
 		log.Warn().Err(err).Str("eventID", event.EventID).Msg("Failed to check spot health")
-		return false, "spot health check failed"
+		return false, "spot health check failed", ScaleDownReasonCheckFailed
 	}
 
 	// Update spot healthy timestamp
 	if newHealthySince != event.SpotHealthySince {
-		m.tracker.UpdateEvent(event.EventID, func(e *FallbackEvent) {
+		err := m.tracker.UpdateEvent(event.EventID, func(e *FallbackEvent) {
 			e.SpotHealthySince = newHealthySince
 		})
+		if err != nil {
+			log.Warn().Err(err).Str("eventID", event.EventID).Msg("Failed to persist spot healthy time")
+			return false, "fallback event could not be persisted", ScaleDownReasonCheckFailed
+		}
 	}
 
 	if !isRestored {
-		return false, "spot capacity not yet stable"
+		return false, "spot capacity not yet stable", ScaleDownReasonSpotNotStable
 	}
 
-	// 3. Check if on-demand node can be safely drained
-	if canDrain, reason := m.safetyChecker.CanSafelyDrainNode(ctx, event.OnDemandNodeName); !canDrain {
-		return false, reason
+	// 3. Check if on-demand node can be safely drained, the spot instances are not known here so pods with
+	// zonal volumes may move to any node of their zone
+	canDrain, reason := m.safetyChecker.CanSafelyDrainNode(ctx, event.OnDemandNodeName, nil)
+	m.status.setDrainCheck(event.OnDemandNodeName, canDrain, reason, m.safetyChecker.maxUtilization)
+	if !canDrain {
+		m.recorder.Emit(event.OnDemandNodeName, observability.Normal, observability.SpotGuardScaleDownBlockedReason,
+			observability.SpotGuardScaleDownBlockedMsgFmt, drainBlockReason(reason), reason)
+		return false, reason, drainBlockReason(reason)
 	}
 
-	return true, ""
+	return true, "", ""
 }
 
 // executeScaleDown performs the scale-down operation
@@ -195,18 +262,26 @@ func (m *Monitor) executeScaleDown(ctx context.Context, event *FallbackEvent) er
 		return ctx.Err()
 	}
 
-	// Mark as scale-down initiated (prevents duplicate processing)
-	m.tracker.UpdateEvent(event.EventID, func(e *FallbackEvent) {
-		e.ScaleDownInitiated = true
-		e.SpotCapacityRestored = true
-	})
+	// Claim the scale-down, a monitor that changed the events first wins and this one skips the node
+	claimed, err := m.tracker.ClaimScaleDown(event.EventID)
+	if err != nil {
+		return fmt.Errorf("failed to claim scale-down of fallback event %s: %w", event.EventID, err)
+	}
+	if !claimed {
+		log.Info().Str("eventID", event.EventID).Msg("Scale-down was claimed by another monitor, skipping")
+		return nil
+	}
 
 	// Execute the scale-down
 	if err := m.scaleDownExecutor.ScaleDownOnDemandNode(ctx, event); err != nil {
+		m.scaleDownExecutor.recordScaleDown(m.metrics, event, err)
 		// Revert flag on failure
-		m.tracker.UpdateEvent(event.EventID, func(e *FallbackEvent) {
+		revertErr := m.tracker.UpdateEvent(event.EventID, func(e *FallbackEvent) {
 			e.ScaleDownInitiated = false
 		})
+		if revertErr != nil {
+			log.Error().Err(revertErr).Str("eventID", event.EventID).Msg("Failed to release the scale-down claim, the node is not retried")
+		}
 		return err
 	}
 
@@ -216,7 +291,6 @@ func (m *Monitor) executeScaleDown(ctx context.Context, event *FallbackEvent) er
 		Dur("totalDuration", time.Since(event.Timestamp)).
 		Msg("Successfully completed on-demand scale-down")
 
-	// Emit metrics (placeholder for your metrics implementation)
 	m.emitMetrics(event)
 
 	return nil
@@ -224,11 +298,14 @@ func (m *Monitor) executeScaleDown(ctx context.Context, event *FallbackEvent) er
 
 // cleanup removes old processed events
 func (m *Monitor) cleanup() {
-	m.tracker.CleanupOldEvents(m.config.MaxEventAge)
+	if err := m.tracker.CleanupOldEvents(m.config.MaxEventAge); err != nil {
+		log.Warn().Err(err).Msg("Failed to clean up old fallback events")
+		return
+	}
 	log.Debug().Int("trackedEvents", m.tracker.GetEventCount()).Msg("Cleaned up old events")
 }
 
-// emitMetrics emits metrics for monitoring (implement based on your metrics system)
+// emitMetrics records the successful scale-down and the on-demand runtime
 func (m *Monitor) emitMetrics(event *FallbackEvent) {
 	duration := time.Since(event.Timestamp)
 
@@ -239,10 +316,5 @@ func (m *Monitor) emitMetrics(event *FallbackEvent) {
 		Str("onDemandASG", event.OnDemandASGName).
 		Msg("On-demand instance runtime metric")
 
-	// TODO: Integrate with your Prometheus metrics
-	// Example:
-	// metrics.OnDemandRuntimeSeconds.WithLabelValues(
-	//     event.SpotASGName,
-	//     event.OnDemandASGName,
-	// ).Observe(duration.Seconds())
+	m.scaleDownExecutor.recordScaleDown(m.metrics, event, nil)
 }
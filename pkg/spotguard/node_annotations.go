@@ -0,0 +1,79 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// setNodeAnnotations adds or overwrites the given annotations on a node
+func setNodeAnnotations(ctx context.Context, clientset kubernetes.Interface, nodeName string, annotations map[string]string) error {
+	return setNodeMetadata(ctx, clientset, nodeName, nil, annotations)
+}
+
+// setNodeMetadata adds or overwrites the given labels and annotations on a node with a merge patch,
+// so the other fields of the node, written concurrently by the kubelet or other controllers, are left alone
+func setNodeMetadata(ctx context.Context, clientset kubernetes.Interface, nodeName string, labels map[string]string, annotations map[string]string) error {
+	metadata := map[string]interface{}{}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return fmt.Errorf("failed to build node patch: %w", err)
+	}
+	if _, err := clientset.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to update node annotation: %w", err)
+	}
+	return nil
+}
+
+// labelOnDemandFallback adds the on-demand fallback label to a node that does not have it yet
+func labelOnDemandFallback(ctx context.Context, clientset kubernetes.Interface, node *corev1.Node) error {
+	if node.Labels[LabelOnDemandFallback] == "true" {
+		return nil
+	}
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:"true"}}}`, LabelOnDemandFallback))
+	if _, err := clientset.CoreV1().Nodes().Patch(ctx, node.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to label node: %w", err)
+	}
+	return nil
+}
+
+// removeNodeAnnotation removes an annotation from a node if present.
+// The merge patch sets it to null, which is a no-op when the annotation is already gone.
+func removeNodeAnnotation(ctx context.Context, clientset kubernetes.Interface, nodeName string, key string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{key: nil},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build node patch: %w", err)
+	}
+	if _, err := clientset.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to clear node annotation: %w", err)
+	}
+	return nil
+}
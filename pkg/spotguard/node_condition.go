@@ -0,0 +1,146 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NodeConditionScaleDownReady is the condition the self-monitor keeps on its on-demand node.
+// It is False with one of the ScaleDownReason constants while the node is kept, True with
+// ScaleDownReasonDraining once the scale-down started, and Unknown when a check failed.
+const NodeConditionScaleDownReady corev1.NodeConditionType = "SpotGuardScaleDownReady"
+
+// scaleDownCondition writes the SpotGuardScaleDownReady condition of a node
+type scaleDownCondition struct {
+	clientset kubernetes.Interface
+	nodeName  string
+	dryRun    bool
+	last      *corev1.NodeCondition // last condition written, nil until it was read from or written to the node
+}
+
+// newScaleDownCondition creates the SpotGuardScaleDownReady condition writer of nodeName.
+// In dry-run the condition is only logged.
+func newScaleDownCondition(clientset kubernetes.Interface, nodeName string, dryRun bool) *scaleDownCondition {
+	return &scaleDownCondition{clientset: clientset, nodeName: nodeName, dryRun: dryRun}
+}
+
+// set patches the condition when its status, reason or message changed.
+// lastTransitionTime only moves when the status changes.
+func (c *scaleDownCondition) set(ctx context.Context, status corev1.ConditionStatus, reason string, message string) {
+	if c.last == nil {
+		c.last = c.current(ctx)
+	}
+	if c.last != nil && c.last.Status == status && c.last.Reason == reason && c.last.Message == message {
+		return
+	}
+
+	now := metav1.Now()
+	condition := corev1.NodeCondition{
+		Type:               NodeConditionScaleDownReady,
+		Status:             status,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            message,
+	}
+	if c.last != nil && c.last.Status == status {
+		condition.LastTransitionTime = c.last.LastTransitionTime
+	}
+
+	if c.dryRun {
+		log.Info().
+			Str("nodeName", c.nodeName).
+			Str("status", string(status)).
+			Str("reason", reason).
+			Str("message", message).
+			Msg("Scale-down condition of the node would have been updated, but dry-run flag was set")
+		c.last = &condition
+		return
+	}
+
+	// Node conditions are merged by type, the kubelet's own conditions are left alone
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"conditions": []corev1.NodeCondition{condition}},
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to build the scale-down condition patch")
+		return
+	}
+	if _, err := c.clientset.CoreV1().Nodes().PatchStatus(ctx, c.nodeName, patch); err != nil {
+		log.Warn().
+			Err(err).
+			Str("nodeName", c.nodeName).
+			Str("reason", reason).
+			Msg("Failed to update the scale-down condition of the node")
+		return
+	}
+
+	log.Debug().
+		Str("nodeName", c.nodeName).
+		Str("status", string(status)).
+		Str("reason", reason).
+		Str("message", message).
+		Msg("Updated the scale-down condition of the node")
+	c.last = &condition
+}
+
+// keep sets the condition to False with the reason the node is kept, or to Unknown when a check failed
+func (c *scaleDownCondition) keep(ctx context.Context, reason string, message string) {
+	if reason == ScaleDownReasonCheckFailed {
+		c.set(ctx, corev1.ConditionUnknown, reason, message)
+		return
+	}
+	c.set(ctx, corev1.ConditionFalse, reason, message)
+}
+
+// current returns the condition already on the node, e.g. written before a restart
+func (c *scaleDownCondition) current(ctx context.Context) *corev1.NodeCondition {
+	node, err := c.clientset.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == NodeConditionScaleDownReady {
+			return condition.DeepCopy()
+		}
+	}
+	return nil
+}
+
+// spotNotReadyMessage describes spot capacity that is not yet restored, without counters so the condition stays put
+func spotNotReadyMessage(spotASGName string, status *SpotASGHealthStatus, stabilityDuration time.Duration) string {
+	switch {
+	case !status.IsHealthy:
+		return fmt.Sprintf("spot ASG %s has fewer InService instances than desired", spotASGName)
+	case !status.NodesReady:
+		return fmt.Sprintf("spot nodes of %s are not all Ready in Kubernetes", spotASGName)
+	case len(status.Interruptions) > 0:
+		return fmt.Sprintf("spot ASG %s is being reclaimed: %s", spotASGName, strings.Join(status.Interruptions, "; "))
+	case status.HealthySince != nil:
+		return fmt.Sprintf("spot ASG %s is healthy since %s, stable at %s", spotASGName,
+			status.HealthySince.UTC().Format(time.RFC3339), status.HealthySince.Add(stabilityDuration).UTC().Format(time.RFC3339))
+	default:
+		return fmt.Sprintf("spot ASG %s has not been healthy for %v yet", spotASGName, stabilityDuration)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+// nodeConditions returns the conditions of the node by type
+func nodeConditions(t *testing.T, clientset *fake.Clientset, nodeName string) map[corev1.NodeConditionType]corev1.NodeCondition {
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
+	h.Ok(t, err)
+	conditions := make(map[corev1.NodeConditionType]corev1.NodeCondition)
+	for _, condition := range node.Status.Conditions {
+		conditions[condition.Type] = condition
+	}
+	return conditions
+}
+
+// countPatches returns the number of patch actions recorded by the fake clientset
+func countPatches(clientset *fake.Clientset) int {
+	patches := 0
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "patch" {
+			patches++
+		}
+	}
+	return patches
+}
+
+func TestScaleDownCondition(t *testing.T) {
+	ctx := context.Background()
+	node := testNode("od-1", "i-1", nil)
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	clientset := fake.NewSimpleClientset(node)
+	condition := newScaleDownCondition(clientset, "od-1", false)
+
+	condition.keep(ctx, ScaleDownReasonMinimumWaitNotMet, "on-demand node is kept for the minimum wait of 10m0s")
+	conditions := nodeConditions(t, clientset, "od-1")
+	h.Equals(t, corev1.ConditionTrue, conditions[corev1.NodeReady].Status)
+	ready := conditions[NodeConditionScaleDownReady]
+	h.Equals(t, corev1.ConditionFalse, ready.Status)
+	h.Equals(t, ScaleDownReasonMinimumWaitNotMet, ready.Reason)
+
+	// An unchanged condition is not written again
+	condition.keep(ctx, ScaleDownReasonMinimumWaitNotMet, "on-demand node is kept for the minimum wait of 10m0s")
+	h.Equals(t, 1, countPatches(clientset))
+
+	// A new reason keeps the transition time as long as the status is the same
+	condition.last.LastTransitionTime = metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	firstTransition := condition.last.LastTransitionTime
+	condition.keep(ctx, ScaleDownReasonPDBBlocked, "pod web-1 would violate PDB web")
+	ready = nodeConditions(t, clientset, "od-1")[NodeConditionScaleDownReady]
+	h.Equals(t, ScaleDownReasonPDBBlocked, ready.Reason)
+	h.Assert(t, ready.LastTransitionTime.Equal(&firstTransition), "transition time moved to %v", ready.LastTransitionTime)
+
+	condition.keep(ctx, ScaleDownReasonCheckFailed, "spot ASG check failed: throttled")
+	h.Equals(t, corev1.ConditionUnknown, nodeConditions(t, clientset, "od-1")[NodeConditionScaleDownReady].Status)
+
+	condition.set(ctx, corev1.ConditionTrue, ScaleDownReasonDraining, "draining")
+	ready = nodeConditions(t, clientset, "od-1")[NodeConditionScaleDownReady]
+	h.Equals(t, corev1.ConditionTrue, ready.Status)
+	h.Assert(t, ready.LastTransitionTime.After(firstTransition.Time), "transition time not updated: %v", ready.LastTransitionTime)
+
+	// After a restart the condition already on the node is picked up
+	patches := countPatches(clientset)
+	restarted := newScaleDownCondition(clientset, "od-1", false)
+	restarted.set(ctx, corev1.ConditionTrue, ScaleDownReasonDraining, "draining")
+	h.Equals(t, patches, countPatches(clientset))
+}
+
+func TestScaleDownConditionDryRun(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset(testNode("od-1", "i-1", nil))
+	condition := newScaleDownCondition(clientset, "od-1", true)
+
+	condition.keep(ctx, ScaleDownReasonMinimumWaitNotMet, "on-demand node is kept for the minimum wait of 10m0s")
+	condition.set(ctx, corev1.ConditionTrue, ScaleDownReasonDraining, "draining")
+	h.Equals(t, 0, countPatches(clientset))
+	_, found := nodeConditions(t, clientset, "od-1")[NodeConditionScaleDownReady]
+	h.Assert(t, !found, "dry-run must not write the condition")
+}
+
+func TestSpotNotReadyMessage(t *testing.T) {
+	healthySince := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	h.Equals(t, "spot ASG spot has fewer InService instances than desired",
+		spotNotReadyMessage("spot", &SpotASGHealthStatus{}, time.Minute))
+	h.Equals(t, "spot ASG spot is being reclaimed: spot-1 has the aws-node-termination-handler/spot-itn taint",
+		spotNotReadyMessage("spot", &SpotASGHealthStatus{IsHealthy: true, NodesReady: true, Interruptions: []string{"spot-1 has the aws-node-termination-handler/spot-itn taint"}}, time.Minute))
+	h.Equals(t, "spot ASG spot is healthy since 2024-05-01T10:00:00Z, stable at 2024-05-01T10:02:00Z",
+		spotNotReadyMessage("spot", &SpotASGHealthStatus{IsHealthy: true, NodesReady: true, HealthySince: &healthySince}, 2*time.Minute))
+}
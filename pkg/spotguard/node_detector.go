@@ -25,8 +25,13 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
-// NodeDetector detects whether the current node is an on-demand or spot node
+// NodeDetector detects whether the current node is an on-demand or spot node.
+// Without an ASG client, as with the Karpenter capacity provider, membership is read from the node's NodePool label.
 type NodeDetector struct {
+	// MixedInstances is set when spot and on-demand instances share the on-demand ASG,
+	// membership then only tells the node is in the ASG and the instance lifecycle decides
+	MixedInstances bool
+
 	imds      *ec2metadata.Service
 	asgClient autoscalingiface.AutoScalingAPI
 	clientset kubernetes.Interface
@@ -51,12 +56,18 @@ func (nd *NodeDetector) IsOnDemandNode(onDemandASGName string) (bool, error) {
 		Str("onDemandASG", onDemandASGName).
 		Msg("Detecting node type")
 
-	// Method 1: Check via ASG membership (most reliable)
-	isOnDemand, err := nd.detectViaASG(onDemandASGName)
+	// Method 1: Check via ASG or NodePool membership (most reliable)
+	var isOnDemand bool
+	var err error
+	if nd.asgClient != nil {
+		isOnDemand, err = nd.detectViaASG(onDemandASGName)
+	} else {
+		isOnDemand, err = nd.detectViaNodePool(onDemandASGName)
+	}
 	if err == nil {
 		return isOnDemand, nil
 	}
-	log.Debug().Err(err).Msg("ASG detection failed, trying node labels")
+	log.Debug().Err(err).Msg("Pool membership detection failed, trying node labels")
 
 	// Method 2: Check via node labels (EKS sets these)
 	isOnDemand, err = nd.detectViaNodeLabels()
@@ -102,6 +113,14 @@ func (nd *NodeDetector) detectViaASG(onDemandASGName string) (bool, error) {
 	asgName := *result.AutoScalingInstances[0].AutoScalingGroupName
 	isOnDemand := asgName == onDemandASGName
 
+	if isOnDemand && nd.MixedInstances {
+		lifecycle, err := nd.imds.GetMetadataInfo("/latest/meta-data/instance-life-cycle", false)
+		if err != nil {
+			return false, fmt.Errorf("failed to get instance lifecycle from IMDS: %w", err)
+		}
+		isOnDemand = lifecycle != "spot"
+	}
+
 	log.Info().
 		Str("instanceID", instanceID).
 		Str("currentASG", asgName).
@@ -112,6 +131,29 @@ func (nd *NodeDetector) detectViaASG(onDemandASGName string) (bool, error) {
 	return isOnDemand, nil
 }
 
+// detectViaNodePool checks if this node belongs to the on-demand Karpenter NodePool
+func (nd *NodeDetector) detectViaNodePool(onDemandNodePool string) (bool, error) {
+	node, err := nd.clientset.CoreV1().Nodes().Get(context.Background(), nd.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get node: %w", err)
+	}
+
+	nodePool, exists := node.Labels[LabelKarpenterNodePool]
+	if !exists {
+		return false, fmt.Errorf("node has no %s label", LabelKarpenterNodePool)
+	}
+	isOnDemand := nodePool == onDemandNodePool
+
+	log.Info().
+		Str("nodeName", nd.nodeName).
+		Str("currentNodePool", nodePool).
+		Str("onDemandNodePool", onDemandNodePool).
+		Bool("isOnDemand", isOnDemand).
+		Msg("Detected node type via Karpenter NodePool membership")
+
+	return isOnDemand, nil
+}
+
 // detectViaNodeLabels checks node labels for capacity type
 func (nd *NodeDetector) detectViaNodeLabels() (bool, error) {
 	node, err := nd.clientset.CoreV1().Nodes().Get(context.Background(), nd.nodeName, metav1.GetOptions{})
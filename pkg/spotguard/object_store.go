@@ -0,0 +1,81 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// getOrCreate reads a namespaced object, creating empty on first use. It returns true when this call created it.
+// The state ConfigMaps and the drain semaphore Lease are shared by every replica, so one of them may create it first.
+func getOrCreate[T any](
+	ctx context.Context,
+	kind string,
+	namespace string,
+	name string,
+	get func(context.Context, string, metav1.GetOptions) (T, error),
+	create func(context.Context, T, metav1.CreateOptions) (T, error),
+	empty T,
+) (T, bool, error) {
+	object, err := get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return object, false, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return object, false, fmt.Errorf("failed to get %s %s/%s: %w", kind, namespace, name, err)
+	}
+
+	object, err = create(ctx, empty, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// Another replica created it first
+		object, err = get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return object, false, fmt.Errorf("failed to get %s %s/%s: %w", kind, namespace, name, err)
+		}
+		return object, false, nil
+	}
+	if err != nil {
+		return object, false, fmt.Errorf("failed to create %s %s/%s: %w", kind, namespace, name, err)
+	}
+	return object, true, nil
+}
+
+// getOrCreateConfigMap reads a state ConfigMap, creating an empty one on first use.
+// The purpose describes what the ConfigMap holds in the creation log.
+func getOrCreateConfigMap(ctx context.Context, clientset kubernetes.Interface, namespace string, name string, purpose string) (*corev1.ConfigMap, error) {
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	configMap, created, err := getOrCreate(ctx, "ConfigMap", namespace, name, configMaps.Get, configMaps.Create, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		log.Info().
+			Str("namespace", namespace).
+			Str("configMap", name).
+			Msgf("Created ConfigMap for %s", purpose)
+	}
+	return configMap, nil
+}
@@ -0,0 +1,611 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Reasons a node is rejected by the placement simulation, worded like the scheduler's FailedScheduling events
+const (
+	reasonNodeNotReady        = "node(s) were not ready"
+	reasonNodeUnschedulable   = "node(s) were unschedulable"
+	reasonNodeSelector        = "node(s) didn't match Pod's node affinity/selector"
+	reasonTooManyPods         = "Too many pods"
+	reasonHostPortConflict    = "node(s) didn't have free ports for the requested pod ports"
+	reasonPodAffinity         = "node(s) didn't match pod affinity rules"
+	reasonPodAntiAffinity     = "node(s) didn't match pod anti-affinity rules"
+	reasonExistingAntiAffin   = "node(s) didn't satisfy existing pods anti-affinity rules"
+	reasonTopologySpread      = "node(s) didn't match pod topology spread constraints"
+	reasonTopologySpreadLabel = "node(s) didn't match pod topology spread constraints (missing required label)"
+	reasonVolumeNodeAffinity  = "node(s) had volume node affinity conflict"
+	reasonNotSpotNode         = "node(s) were not spot nodes"
+)
+
+// nodeState is the scheduling view of one node during a placement simulation
+type nodeState struct {
+	node      *corev1.Node
+	pods      []*corev1.Pod
+	requested map[corev1.ResourceName]int64
+	usedPorts map[string]bool
+}
+
+// placementSimulator replays the scheduler's filter predicates against a snapshot of the cluster
+// so pods evicted from a drained node can be placed one by one on the remaining nodes
+type placementSimulator struct {
+	nodes []*nodeState
+	// volumes pins pods with zonal PersistentVolumes to their zone, nil skips the volume check
+	volumes *volumeTopology
+	// spotInstances are the instance IDs of the spot pool, pods with pinned volumes only move to these.
+	// nil lets them move to any node matching their volumes.
+	spotInstances map[string]bool
+}
+
+// newPlacementSimulator builds a snapshot of every node except the one being drained.
+// Pods of the drained node are left out since they are the ones being moved.
+func newPlacementSimulator(nodes []corev1.Node, pods []corev1.Pod, drainingNode string) *placementSimulator {
+	ps := &placementSimulator{}
+	byName := make(map[string]*nodeState, len(nodes))
+
+	for i := range nodes {
+		if nodes[i].Name == drainingNode {
+			continue
+		}
+		state := &nodeState{
+			node:      &nodes[i],
+			requested: make(map[corev1.ResourceName]int64),
+			usedPorts: make(map[string]bool),
+		}
+		ps.nodes = append(ps.nodes, state)
+		byName[nodes[i].Name] = state
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if state, ok := byName[pod.Spec.NodeName]; ok {
+			state.addPod(pod)
+		}
+	}
+
+	return ps
+}
+
+// place finds a node for the pod and reserves it there.
+// Returns the chosen node, or an empty string and the aggregated reasons every node was rejected.
+func (ps *placementSimulator) place(pod *corev1.Pod) (string, string) {
+	rejections := make(map[string]int)
+	var best *nodeState
+	bestScore := -1.0
+
+	for _, state := range ps.nodes {
+		if reason := ps.filter(pod, state); reason != "" {
+			rejections[reason]++
+			continue
+		}
+		if score := state.freeFraction(pod); score > bestScore {
+			best = state
+			bestScore = score
+		}
+	}
+
+	if best == nil {
+		return "", formatRejections(len(ps.nodes), rejections)
+	}
+
+	best.addPod(pod)
+	return best.node.Name, ""
+}
+
+// filter runs the scheduler predicates for one node and returns the first failing reason
+func (ps *placementSimulator) filter(pod *corev1.Pod, state *nodeState) string {
+	node := state.node
+
+	if !isNodeReady(node) {
+		return reasonNodeNotReady
+	}
+	if node.Spec.Unschedulable && !toleratesUnschedulable(pod) {
+		return reasonNodeUnschedulable
+	}
+	if taint := untoleratedTaint(pod, node); taint != nil {
+		return fmt.Sprintf("node(s) had untolerated taint {%s: %s}", taint.Key, taint.Value)
+	}
+	if !podMatchesNodeSelectorAndAffinity(pod, node) {
+		return reasonNodeSelector
+	}
+	if reason := ps.checkVolumes(pod, node); reason != "" {
+		return reason
+	}
+	if reason := state.fitsResources(pod); reason != "" {
+		return reason
+	}
+	if state.hasPortConflict(pod) {
+		return reasonHostPortConflict
+	}
+	if reason := ps.checkInterPodAffinity(pod, state); reason != "" {
+		return reason
+	}
+	return ps.checkTopologySpread(pod, state)
+}
+
+// checkVolumes keeps pods next to their bound volumes, on a spot node when the spot pool is known.
+// Retiring an on-demand node is only safe once spot capacity exists in the zone of its zonal volumes.
+func (ps *placementSimulator) checkVolumes(pod *corev1.Pod, node *corev1.Node) string {
+	pinned := ps.volumes.pinnedVolumes(pod)
+	if len(pinned) == 0 {
+		return ""
+	}
+	for _, pv := range pinned {
+		if !volumeNodeAffinityMatches(pv, node) {
+			return reasonVolumeNodeAffinity
+		}
+	}
+	if ps.spotInstances != nil && !ps.spotInstances[extractInstanceIDFromProviderID(node.Spec.ProviderID)] {
+		return reasonNotSpotNode
+	}
+	return ""
+}
+
+// addPod reserves the pod's requests and host ports on the node
+func (state *nodeState) addPod(pod *corev1.Pod) {
+	state.pods = append(state.pods, pod)
+	for name, value := range podRequests(pod) {
+		state.requested[name] += value
+	}
+	for _, port := range podHostPorts(pod) {
+		state.usedPorts[port] = true
+	}
+}
+
+// fitsResources checks requested resources and pod count against the node's allocatable
+func (state *nodeState) fitsResources(pod *corev1.Pod) string {
+	allocatable := state.node.Status.Allocatable
+
+	if maxPods, ok := allocatable[corev1.ResourcePods]; ok && int64(len(state.pods)+1) > maxPods.Value() {
+		return reasonTooManyPods
+	}
+
+	requests := podRequests(pod)
+	names := make([]string, 0, len(requests))
+	for name := range requests {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		resourceName := corev1.ResourceName(name)
+		request := requests[resourceName]
+		if request == 0 {
+			continue
+		}
+		quantity, ok := allocatable[resourceName]
+		if !ok || state.requested[resourceName]+request > resourceValue(resourceName, quantity) {
+			return fmt.Sprintf("Insufficient %s", name)
+		}
+	}
+	return ""
+}
+
+// freeFraction scores a node like the default LeastAllocated strategy: the more room left, the higher
+func (state *nodeState) freeFraction(pod *corev1.Pod) float64 {
+	requests := podRequests(pod)
+	score := 0.0
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		allocatable := resourceValue(name, state.node.Status.Allocatable[name])
+		if allocatable <= 0 {
+			continue
+		}
+		score += float64(allocatable-state.requested[name]-requests[name]) / float64(allocatable)
+	}
+	return score / 2
+}
+
+// hasPortConflict checks whether any host port of the pod is already taken on the node
+func (state *nodeState) hasPortConflict(pod *corev1.Pod) bool {
+	for _, port := range podHostPorts(pod) {
+		if state.usedPorts[port] {
+			return true
+		}
+	}
+	return false
+}
+
+// checkInterPodAffinity evaluates required pod affinity and anti-affinity in both directions
+func (ps *placementSimulator) checkInterPodAffinity(pod *corev1.Pod, state *nodeState) string {
+	// Existing pods whose anti-affinity rejects the incoming pod
+	for _, other := range ps.nodes {
+		for _, existing := range other.pods {
+			if existing.Spec.Affinity == nil || existing.Spec.Affinity.PodAntiAffinity == nil {
+				continue
+			}
+			for _, term := range existing.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+				if sameTopologyDomain(state.node, other.node, term.TopologyKey) && podMatchesAffinityTerm(pod, existing, term) {
+					return reasonExistingAntiAffin
+				}
+			}
+		}
+	}
+
+	if pod.Spec.Affinity == nil {
+		return ""
+	}
+
+	if antiAffinity := pod.Spec.Affinity.PodAntiAffinity; antiAffinity != nil {
+		for _, term := range antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+			if ps.domainHasMatchingPod(pod, state.node, term) {
+				return reasonPodAntiAffinity
+			}
+		}
+	}
+
+	if affinity := pod.Spec.Affinity.PodAffinity; affinity != nil {
+		for _, term := range affinity.RequiredDuringSchedulingIgnoredDuringExecution {
+			if ps.domainHasMatchingPod(pod, state.node, term) {
+				continue
+			}
+			// Like the scheduler, the first pod of a group that matches its own affinity may go anywhere
+			if !ps.anyPodMatches(pod, term) && podMatchesAffinityTerm(pod, pod, term) {
+				continue
+			}
+			return reasonPodAffinity
+		}
+	}
+
+	return ""
+}
+
+// domainHasMatchingPod checks whether a pod matching the term runs in the node's topology domain
+func (ps *placementSimulator) domainHasMatchingPod(pod *corev1.Pod, node *corev1.Node, term corev1.PodAffinityTerm) bool {
+	for _, other := range ps.nodes {
+		if !sameTopologyDomain(node, other.node, term.TopologyKey) {
+			continue
+		}
+		for _, existing := range other.pods {
+			if podMatchesAffinityTerm(existing, pod, term) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyPodMatches checks whether a pod matching the term runs anywhere in the snapshot
+func (ps *placementSimulator) anyPodMatches(pod *corev1.Pod, term corev1.PodAffinityTerm) bool {
+	for _, other := range ps.nodes {
+		for _, existing := range other.pods {
+			if podMatchesAffinityTerm(existing, pod, term) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkTopologySpread evaluates DoNotSchedule topology spread constraints for the node
+func (ps *placementSimulator) checkTopologySpread(pod *corev1.Pod, state *nodeState) string {
+	for _, constraint := range pod.Spec.TopologySpreadConstraints {
+		if constraint.WhenUnsatisfiable != corev1.DoNotSchedule {
+			continue
+		}
+
+		domain, ok := state.node.Labels[constraint.TopologyKey]
+		if !ok {
+			return reasonTopologySpreadLabel
+		}
+
+		selector := spreadSelector(pod, constraint)
+		counts := make(map[string]int)
+		for _, other := range ps.nodes {
+			otherDomain, ok := other.node.Labels[constraint.TopologyKey]
+			if !ok || !spreadNodeEligible(pod, other.node, constraint) {
+				continue
+			}
+			if _, seen := counts[otherDomain]; !seen {
+				counts[otherDomain] = 0
+			}
+			for _, existing := range other.pods {
+				if existing.Namespace == pod.Namespace && existing.DeletionTimestamp == nil && selector.Matches(labels.Set(existing.Labels)) {
+					counts[otherDomain]++
+				}
+			}
+		}
+
+		minCount := -1
+		for _, count := range counts {
+			if minCount == -1 || count < minCount {
+				minCount = count
+			}
+		}
+		if minCount == -1 || (constraint.MinDomains != nil && int32(len(counts)) < *constraint.MinDomains) {
+			minCount = 0
+		}
+
+		selfMatch := 0
+		if selector.Matches(labels.Set(pod.Labels)) {
+			selfMatch = 1
+		}
+		if int32(counts[domain]+selfMatch-minCount) > constraint.MaxSkew {
+			return reasonTopologySpread
+		}
+	}
+	return ""
+}
+
+// spreadSelector combines the constraint's label selector with its matchLabelKeys
+func spreadSelector(pod *corev1.Pod, constraint corev1.TopologySpreadConstraint) labels.Selector {
+	selector, err := metav1.LabelSelectorAsSelector(constraint.LabelSelector)
+	if err != nil {
+		return labels.Nothing()
+	}
+	for _, key := range constraint.MatchLabelKeys {
+		value, ok := pod.Labels[key]
+		if !ok {
+			continue
+		}
+		if requirement, err := labels.NewRequirement(key, "=", []string{value}); err == nil {
+			selector = selector.Add(*requirement)
+		}
+	}
+	return selector
+}
+
+// spreadNodeEligible applies the constraint's node inclusion policies
+func spreadNodeEligible(pod *corev1.Pod, node *corev1.Node, constraint corev1.TopologySpreadConstraint) bool {
+	if constraint.NodeAffinityPolicy == nil || *constraint.NodeAffinityPolicy == corev1.NodeInclusionPolicyHonor {
+		if !podMatchesNodeSelectorAndAffinity(pod, node) {
+			return false
+		}
+	}
+	if constraint.NodeTaintsPolicy != nil && *constraint.NodeTaintsPolicy == corev1.NodeInclusionPolicyHonor {
+		if untoleratedTaint(pod, node) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// podRequests returns the effective requests of a pod the way the scheduler accounts for them:
+// app containers and restartable init containers add up, regular init containers only raise the peak
+func podRequests(pod *corev1.Pod) map[corev1.ResourceName]int64 {
+	requests := make(map[corev1.ResourceName]int64)
+	for _, container := range pod.Spec.Containers {
+		addResources(requests, container.Resources.Requests)
+	}
+
+	sidecars := make(map[corev1.ResourceName]int64)
+	for _, container := range pod.Spec.InitContainers {
+		if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			addResources(requests, container.Resources.Requests)
+			addResources(sidecars, container.Resources.Requests)
+			continue
+		}
+		// A regular init container runs next to the sidecars started before it
+		initPeak := make(map[corev1.ResourceName]int64)
+		addResources(initPeak, container.Resources.Requests)
+		for name, value := range sidecars {
+			initPeak[name] += value
+		}
+		for name, value := range initPeak {
+			if value > requests[name] {
+				requests[name] = value
+			}
+		}
+	}
+
+	addResources(requests, pod.Spec.Overhead)
+	return requests
+}
+
+// addResources adds a resource list to the accumulator
+func addResources(accumulator map[corev1.ResourceName]int64, resources corev1.ResourceList) {
+	for name, quantity := range resources {
+		accumulator[name] += resourceValue(name, quantity)
+	}
+}
+
+// resourceValue returns CPU in millicores and every other resource in its base unit
+func resourceValue(name corev1.ResourceName, quantity resource.Quantity) int64 {
+	if name == corev1.ResourceCPU {
+		return quantity.MilliValue()
+	}
+	return quantity.Value()
+}
+
+// podHostPorts returns the host ports a pod binds as protocol/port keys
+func podHostPorts(pod *corev1.Pod) []string {
+	var ports []string
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.HostPort <= 0 {
+				continue
+			}
+			protocol := port.Protocol
+			if protocol == "" {
+				protocol = corev1.ProtocolTCP
+			}
+			ports = append(ports, fmt.Sprintf("%s/%d", protocol, port.HostPort))
+		}
+	}
+	return ports
+}
+
+// toleratesUnschedulable checks whether the pod tolerates a cordoned node
+func toleratesUnschedulable(pod *corev1.Pod) bool {
+	taint := &corev1.Taint{Key: corev1.TaintNodeUnschedulable, Effect: corev1.TaintEffectNoSchedule}
+	for i := range pod.Spec.Tolerations {
+		if pod.Spec.Tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// untoleratedTaint returns the first NoSchedule or NoExecute taint of the node the pod does not tolerate
+func untoleratedTaint(pod *corev1.Pod, node *corev1.Node) *corev1.Taint {
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect != corev1.TaintEffectNoSchedule && taint.Effect != corev1.TaintEffectNoExecute {
+			continue
+		}
+		tolerated := false
+		for j := range pod.Spec.Tolerations {
+			if pod.Spec.Tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return taint
+		}
+	}
+	return nil
+}
+
+// podMatchesNodeSelectorAndAffinity checks spec.nodeSelector and required node affinity
+func podMatchesNodeSelectorAndAffinity(pod *corev1.Pod, node *corev1.Node) bool {
+	if len(pod.Spec.NodeSelector) > 0 && !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+		return true
+	}
+	required := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil {
+		return true
+	}
+
+	// Terms are ORed
+	for _, term := range required.NodeSelectorTerms {
+		if nodeSelectorTermMatches(term, node) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeSelectorTermMatches checks one node selector term, whose requirements are ANDed
+func nodeSelectorTermMatches(term corev1.NodeSelectorTerm, node *corev1.Node) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+	for _, requirement := range term.MatchExpressions {
+		if !nodeSelectorRequirementMatches(requirement, node.Labels) {
+			return false
+		}
+	}
+	for _, requirement := range term.MatchFields {
+		// metadata.name is the only field supported by the scheduler
+		if requirement.Key != "metadata.name" {
+			return false
+		}
+		if !nodeSelectorRequirementMatches(requirement, map[string]string{requirement.Key: node.Name}) {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeSelectorRequirementMatches evaluates a single node selector requirement against a label set
+func nodeSelectorRequirementMatches(requirement corev1.NodeSelectorRequirement, nodeLabels map[string]string) bool {
+	value, exists := nodeLabels[requirement.Key]
+
+	switch requirement.Operator {
+	case corev1.NodeSelectorOpIn:
+		return exists && containsString(requirement.Values, value)
+	case corev1.NodeSelectorOpNotIn:
+		return !exists || !containsString(requirement.Values, value)
+	case corev1.NodeSelectorOpExists:
+		return exists
+	case corev1.NodeSelectorOpDoesNotExist:
+		return !exists
+	case corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+		if !exists || len(requirement.Values) != 1 {
+			return false
+		}
+		nodeValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+		requiredValue, err := strconv.ParseInt(requirement.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+		if requirement.Operator == corev1.NodeSelectorOpGt {
+			return nodeValue > requiredValue
+		}
+		return nodeValue < requiredValue
+	}
+	return false
+}
+
+// podMatchesAffinityTerm checks whether target is selected by a pod affinity term owned by owner.
+// Namespace selectors are treated as matching every namespace, which is conservative for anti-affinity.
+func podMatchesAffinityTerm(target *corev1.Pod, owner *corev1.Pod, term corev1.PodAffinityTerm) bool {
+	if term.NamespaceSelector == nil {
+		namespaces := term.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = []string{owner.Namespace}
+		}
+		if !containsString(namespaces, target.Namespace) {
+			return false
+		}
+	}
+
+	if term.LabelSelector == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(target.Labels))
+}
+
+// sameTopologyDomain checks whether two nodes share a value for the topology key
+func sameTopologyDomain(a *corev1.Node, b *corev1.Node, topologyKey string) bool {
+	valueA, okA := a.Labels[topologyKey]
+	valueB, okB := b.Labels[topologyKey]
+	return okA && okB && valueA == valueB
+}
+
+// formatRejections renders per-node rejection reasons like "0/3 nodes are available: 2 Insufficient cpu, ..."
+func formatRejections(totalNodes int, rejections map[string]int) string {
+	reasons := make([]string, 0, len(rejections))
+	for reason, count := range rejections {
+		reasons = append(reasons, fmt.Sprintf("%d %s", count, reason))
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("0/%d nodes are available: %s", totalNodes, strings.Join(reasons, ", "))
+}
+
+// containsString checks whether a slice contains a string
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
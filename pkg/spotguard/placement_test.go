@@ -0,0 +1,165 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+const zoneLabel = "topology.kubernetes.io/zone"
+
+func simNode(name string, zone string, cpu string, memory string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{zoneLabel: zone}},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+				corev1.ResourcePods:   resource.MustParse("110"),
+			},
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func simPod(name string, nodeName string, cpu string, podLabels map[string]string) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: podLabels},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Containers: []corev1.Container{{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(cpu),
+					corev1.ResourceMemory: resource.MustParse("128Mi"),
+				}},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestPlacementAccountsForExistingUsage(t *testing.T) {
+	nodes := []corev1.Node{simNode("draining", "a", "4", "8Gi"), simNode("target", "a", "2", "8Gi")}
+	pods := []corev1.Pod{simPod("existing", "target", "1500m", nil)}
+	sim := newPlacementSimulator(nodes, pods, "draining")
+
+	pod := simPod("evicted", "draining", "1", nil)
+	target, reason := sim.place(&pod)
+	h.Equals(t, "", target)
+	h.Equals(t, "0/1 nodes are available: 1 Insufficient cpu", reason)
+}
+
+func TestPlacementReservesEarlierPlacements(t *testing.T) {
+	nodes := []corev1.Node{simNode("draining", "a", "4", "8Gi"), simNode("target", "a", "2", "8Gi")}
+	sim := newPlacementSimulator(nodes, nil, "draining")
+
+	first := simPod("first", "draining", "1500m", nil)
+	second := simPod("second", "draining", "1", nil)
+	target, _ := sim.place(&first)
+	h.Equals(t, "target", target)
+	target, _ = sim.place(&second)
+	h.Equals(t, "", target)
+}
+
+func TestPlacementTaintsAndSelectors(t *testing.T) {
+	tainted := simNode("tainted", "a", "4", "8Gi")
+	tainted.Spec.Taints = []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}}
+	labelled := simNode("labelled", "b", "4", "8Gi")
+	labelled.Labels["pool"] = "batch"
+	sim := newPlacementSimulator([]corev1.Node{tainted, labelled}, nil, "")
+
+	pod := simPod("selector", "", "1", nil)
+	pod.Spec.NodeSelector = map[string]string{"pool": "web"}
+	_, reason := sim.place(&pod)
+	h.Assert(t, strings.Contains(reason, "1 node(s) had untolerated taint {dedicated: gpu}"), reason)
+	h.Assert(t, strings.Contains(reason, "1 "+reasonNodeSelector), reason)
+
+	tolerating := simPod("tolerating", "", "1", nil)
+	tolerating.Spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "gpu", Effect: corev1.TaintEffectNoSchedule}}
+	tolerating.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+			MatchExpressions: []corev1.NodeSelectorRequirement{{Key: zoneLabel, Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}}},
+		}}},
+	}}
+	target, _ := sim.place(&tolerating)
+	h.Equals(t, "tainted", target)
+}
+
+func TestPlacementPodAntiAffinity(t *testing.T) {
+	nodes := []corev1.Node{simNode("node-a", "a", "4", "8Gi"), simNode("node-b", "b", "4", "8Gi")}
+	appLabels := map[string]string{"app": "web"}
+	pods := []corev1.Pod{simPod("web-1", "node-a", "100m", appLabels)}
+	sim := newPlacementSimulator(nodes, pods, "")
+
+	pod := simPod("web-2", "", "100m", appLabels)
+	pod.Spec.Affinity = &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: appLabels},
+			TopologyKey:   zoneLabel,
+		}},
+	}}
+	target, _ := sim.place(&pod)
+	h.Equals(t, "node-b", target)
+
+	third := pod
+	third.Name = "web-3"
+	target, reason := sim.place(&third)
+	h.Equals(t, "", target)
+	h.Assert(t, strings.Contains(reason, "1 "+reasonPodAntiAffinity), reason)
+	h.Assert(t, strings.Contains(reason, "1 "+reasonExistingAntiAffin), reason)
+}
+
+func TestPlacementTopologySpread(t *testing.T) {
+	tainted := simNode("node-a", "a", "4", "8Gi")
+	tainted.Spec.Taints = []corev1.Taint{{Key: "maintenance", Effect: corev1.TaintEffectNoSchedule}}
+	nodes := []corev1.Node{tainted, simNode("node-b", "b", "4", "8Gi")}
+	appLabels := map[string]string{"app": "api"}
+	pods := []corev1.Pod{simPod("api-1", "node-a", "100m", appLabels), simPod("api-2", "node-b", "100m", appLabels)}
+	sim := newPlacementSimulator(nodes, pods, "")
+
+	spread := []corev1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       zoneLabel,
+		WhenUnsatisfiable: corev1.DoNotSchedule,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: appLabels},
+	}}
+
+	// Zone a is tainted but still counts as a domain, so zone b may hold at most one more pod than zone a
+	pod := simPod("api-3", "", "100m", appLabels)
+	pod.Spec.TopologySpreadConstraints = spread
+	target, _ := sim.place(&pod)
+	h.Equals(t, "node-b", target)
+
+	fourth := pod
+	fourth.Name = "api-4"
+	target, reason := sim.place(&fourth)
+	h.Equals(t, "", target)
+	h.Assert(t, strings.Contains(reason, reasonTopologySpread), reason)
+}
+
+func TestDrainBlockReason(t *testing.T) {
+	h.Equals(t, ScaleDownReasonUtilizationTooHigh, drainBlockReason(ReasonClusterUtilizationTooHigh))
+	h.Equals(t, ScaleDownReasonPDBBlocked, drainBlockReason("pod default/web-0 would violate PDB: PDB web would be violated"))
+	h.Equals(t, ScaleDownReasonCheckFailed, drainBlockReason("failed to list pods: timeout"))
+	h.Equals(t, ScaleDownReasonPodsUnschedulable, drainBlockReason("pod default/web-0 cannot be rescheduled: insufficient cpu"))
+	h.Equals(t, ScaleDownReasonZonalVolume, drainBlockReason("pod default/db-0 cannot be rescheduled next to its volume pv-1 in us-east-1a: 0/2 nodes are available"))
+}
@@ -0,0 +1,179 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Policy presets mapping onto DefaultConfig, ConservativeConfig and AggressiveConfig
+const (
+	PolicyPresetDefault      = "default"
+	PolicyPresetConservative = "conservative"
+	PolicyPresetAggressive   = "aggressive"
+)
+
+// SpotGuardPolicyGVR identifies the cluster-scoped SpotGuardPolicy custom resource
+var SpotGuardPolicyGVR = schema.GroupVersionResource{
+	Group:    "spot-guard.aws.amazon.com",
+	Version:  "v1alpha1",
+	Resource: "spotguardpolicies",
+}
+
+// SpotGuardPolicy configures Spot Guard for one spot/on-demand ASG pair
+type SpotGuardPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SpotGuardPolicySpec   `json:"spec"`
+	Status SpotGuardPolicyStatus `json:"status,omitempty"`
+}
+
+// SpotGuardPolicySpec names an ASG pair and the thresholds and timers used for it.
+// Unset fields fall back to the selected preset.
+type SpotGuardPolicySpec struct {
+	SpotASGName           string                   `json:"spotASGName"`
+	OnDemandASGName       string                   `json:"onDemandASGName"`
+	Preset                string                   `json:"preset,omitempty"`
+	MinimumWaitDuration   *metav1.Duration         `json:"minimumWaitDuration,omitempty"`
+	CheckInterval         *metav1.Duration         `json:"checkInterval,omitempty"`
+	SpotStabilityDuration *metav1.Duration         `json:"spotStabilityDuration,omitempty"`
+	MaxClusterUtilization *int                     `json:"maxClusterUtilization,omitempty"`
+	PodEvictionTimeout    *metav1.Duration         `json:"podEvictionTimeout,omitempty"`
+	MaxScaleDownsPerCycle *int                     `json:"maxScaleDownsPerCycle,omitempty"`
+	PreScale              *SpotGuardPolicyPreScale `json:"preScale,omitempty"`
+}
+
+// SpotGuardPolicyPreScale overrides the pre-scale settings for the ASG pair, unset fields keep the process-wide value
+type SpotGuardPolicyPreScale struct {
+	Enabled             *bool            `json:"enabled,omitempty"`
+	Timeout             *metav1.Duration `json:"timeout,omitempty"`
+	TargetUtilization   *int             `json:"targetUtilization,omitempty"`
+	SafetyBufferPercent *int             `json:"safetyBufferPercent,omitempty"`
+	FailureFallback     string           `json:"failureFallback,omitempty"`
+	FallbackThreshold   *int             `json:"fallbackThreshold,omitempty"`
+	RetryBackoff        *metav1.Duration `json:"retryBackoff,omitempty"`
+}
+
+// SpotGuardPolicyStatus reports the latest decision taken for the ASG pair
+type SpotGuardPolicyStatus struct {
+	ObservedGeneration int64        `json:"observedGeneration,omitempty"`
+	Decision           string       `json:"decision,omitempty"`
+	Message            string       `json:"message,omitempty"`
+	LastEvaluationTime *metav1.Time `json:"lastEvaluationTime,omitempty"`
+}
+
+// policyFromUnstructured converts an object from the dynamic client into a SpotGuardPolicy
+func policyFromUnstructured(obj *unstructured.Unstructured) (*SpotGuardPolicy, error) {
+	policy := &SpotGuardPolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, policy); err != nil {
+		return nil, fmt.Errorf("failed to decode SpotGuardPolicy %s: %w", obj.GetName(), err)
+	}
+	return policy, nil
+}
+
+// ToConfig returns the preset named by the policy with the policy's overrides applied
+func (p *SpotGuardPolicy) ToConfig() (Config, error) {
+	var cfg Config
+	switch p.Spec.Preset {
+	case "", PolicyPresetDefault:
+		cfg = DefaultConfig()
+	case PolicyPresetConservative:
+		cfg = ConservativeConfig()
+	case PolicyPresetAggressive:
+		cfg = AggressiveConfig()
+	default:
+		return Config{}, fmt.Errorf("%w: %q", ErrUnknownPolicyPreset, p.Spec.Preset)
+	}
+
+	cfg.Enabled = true
+	cfg.SpotASGName = p.Spec.SpotASGName
+	cfg.OnDemandASGName = p.Spec.OnDemandASGName
+	if p.Spec.MinimumWaitDuration != nil {
+		cfg.MinimumWaitDuration = p.Spec.MinimumWaitDuration.Duration
+	}
+	if p.Spec.CheckInterval != nil {
+		cfg.CheckInterval = p.Spec.CheckInterval.Duration
+	}
+	if p.Spec.SpotStabilityDuration != nil {
+		cfg.SpotStabilityDuration = p.Spec.SpotStabilityDuration.Duration
+	}
+	if p.Spec.MaxClusterUtilization != nil {
+		cfg.MaxClusterUtilization = float64(*p.Spec.MaxClusterUtilization)
+	}
+	if p.Spec.PodEvictionTimeout != nil {
+		cfg.PodEvictionTimeout = p.Spec.PodEvictionTimeout.Duration
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// ApplyTo returns a copy of the process-wide configuration scoped to the policy's ASG pair
+func (p *SpotGuardPolicy) ApplyTo(nthConfig config.Config) (config.Config, error) {
+	cfg, err := p.ToConfig()
+	if err != nil {
+		return config.Config{}, err
+	}
+
+	scoped := nthConfig
+	scoped.SpotAsgName = cfg.SpotASGName
+	scoped.OnDemandAsgName = cfg.OnDemandASGName
+	scoped.SpotGuardMinimumWaitDuration = int(cfg.MinimumWaitDuration.Seconds())
+	scoped.SpotGuardCheckInterval = int(cfg.CheckInterval.Seconds())
+	scoped.SpotGuardSpotStabilityDuration = int(cfg.SpotStabilityDuration.Seconds())
+	scoped.SpotGuardMaxClusterUtilization = int(cfg.MaxClusterUtilization)
+	scoped.SpotGuardPodEvictionTimeout = int(cfg.PodEvictionTimeout.Seconds())
+
+	if p.Spec.MaxScaleDownsPerCycle != nil {
+		if *p.Spec.MaxScaleDownsPerCycle < 1 {
+			return config.Config{}, fmt.Errorf("maxScaleDownsPerCycle must be at least 1, got %d", *p.Spec.MaxScaleDownsPerCycle)
+		}
+		scoped.SpotGuardMaxScaleDownsPerCycle = *p.Spec.MaxScaleDownsPerCycle
+	}
+
+	if preScale := p.Spec.PreScale; preScale != nil {
+		if preScale.Enabled != nil {
+			scoped.EnablePreScale = *preScale.Enabled
+		}
+		if preScale.Timeout != nil {
+			scoped.PreScaleTimeoutSeconds = int(preScale.Timeout.Seconds())
+		}
+		if preScale.TargetUtilization != nil {
+			scoped.PreScaleTargetUtilization = *preScale.TargetUtilization
+		}
+		if preScale.SafetyBufferPercent != nil {
+			scoped.PreScaleSafetyBufferPercent = *preScale.SafetyBufferPercent
+		}
+		if preScale.FailureFallback != "" {
+			scoped.PreScaleFailureFallback = preScale.FailureFallback
+		}
+		if preScale.FallbackThreshold != nil {
+			scoped.PreScaleFallbackThreshold = *preScale.FallbackThreshold
+		}
+		if preScale.RetryBackoff != nil {
+			scoped.PreScaleRetryBackoffSeconds = int(preScale.RetryBackoff.Seconds())
+		}
+	}
+
+	return scoped, nil
+}
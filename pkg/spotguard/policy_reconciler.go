@@ -0,0 +1,225 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	"github.com/aws/aws-node-termination-handler/pkg/node"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// runningPolicy is a controller started for one generation of a policy
+type runningPolicy struct {
+	generation int64
+	cancel     context.CancelFunc
+}
+
+// PolicyReconciler watches SpotGuardPolicy resources and runs one Controller per ASG pair.
+// A changed spec restarts the pair's controller with the new configuration.
+type PolicyReconciler struct {
+	config        config.Config
+	dynamicClient dynamic.Interface
+	provider      CapacityProvider
+	clientset     kubernetes.Interface
+	nodeHandler   node.Node
+	metrics       observability.Metrics
+	recorder      observability.K8sEventRecorder
+	ledger        *CostLedger
+	tracker       *FallbackTracker
+	running       map[string]*runningPolicy
+	mutex         sync.Mutex
+	status        *Status
+}
+
+// NewPolicyReconciler creates a new SpotGuardPolicy reconciler
+func NewPolicyReconciler(
+	dynamicClient dynamic.Interface,
+	provider CapacityProvider,
+	clientset kubernetes.Interface,
+	nodeHandler node.Node,
+	nthConfig config.Config,
+	metrics observability.Metrics,
+	recorder observability.K8sEventRecorder,
+	ledger *CostLedger,
+) *PolicyReconciler {
+	return &PolicyReconciler{
+		config:        nthConfig,
+		dynamicClient: dynamicClient,
+		provider:      provider,
+		clientset:     clientset,
+		nodeHandler:   nodeHandler,
+		metrics:       metrics,
+		recorder:      recorder,
+		ledger:        ledger,
+		running:       make(map[string]*runningPolicy),
+	}
+}
+
+// TrackFallbacks makes the controllers of all policies close the fallback events of the nodes they retire
+func (r *PolicyReconciler) TrackFallbacks(tracker *FallbackTracker) {
+	r.tracker = tracker
+}
+
+// ReportStatus makes the controllers of all policies record their state in status
+func (r *PolicyReconciler) ReportStatus(status *Status) {
+	r.status = status
+}
+
+// Start watches policies while this replica holds the Spot Guard lease.
+// It blocks until the context is cancelled.
+func (r *PolicyReconciler) Start(ctx context.Context) {
+	runLeaderElected(ctx, r.clientset, r.config, r.run)
+}
+
+// run watches policies until leadership is lost
+func (r *PolicyReconciler) run(ctx context.Context) {
+	log.Info().Str("resource", SpotGuardPolicyGVR.String()).Msg("Watching SpotGuardPolicy resources")
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(r.dynamicClient, 0)
+	informer := factory.ForResource(SpotGuardPolicyGVR).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			r.sync(ctx, obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			r.sync(ctx, obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			r.remove(obj)
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to watch SpotGuardPolicy resources")
+		return
+	}
+
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for name, running := range r.running {
+		running.cancel()
+		delete(r.running, name)
+	}
+	log.Info().Msg("Stopped watching SpotGuardPolicy resources")
+}
+
+// sync starts or restarts the controller of a policy when its spec changed
+func (r *PolicyReconciler) sync(ctx context.Context, obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	name := u.GetName()
+	generation := u.GetGeneration()
+	if running, exists := r.running[name]; exists {
+		if running.generation == generation {
+			// Status updates and resyncs do not change the spec
+			return
+		}
+		running.cancel()
+		delete(r.running, name)
+	}
+
+	policyCtx, cancel := context.WithCancel(ctx)
+	r.running[name] = &runningPolicy{generation: generation, cancel: cancel}
+
+	policy, err := policyFromUnstructured(u)
+	var scoped config.Config
+	if err == nil {
+		scoped, err = policy.ApplyTo(r.config)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("policy", name).Msg("Invalid SpotGuardPolicy, not reconciling it")
+		r.updateStatus(policyCtx, name, generation, newDecision(DecisionInvalid, err.Error()))
+		return
+	}
+
+	log.Info().
+		Str("policy", name).
+		Int64("generation", generation).
+		Str("spotASG", scoped.SpotAsgName).
+		Str("onDemandASG", scoped.OnDemandAsgName).
+		Int("minimumWaitSeconds", scoped.SpotGuardMinimumWaitDuration).
+		Int("maxClusterUtilization", scoped.SpotGuardMaxClusterUtilization).
+		Msg("Reconciling SpotGuardPolicy")
+
+	controller := NewController(r.provider, r.clientset, r.nodeHandler, scoped, r.metrics, r.recorder, r.ledger)
+	controller.ReportStatus(r.status)
+	controller.TrackFallbacks(r.tracker)
+	controller.onDecision = func(decision ControllerDecision) {
+		r.updateStatus(policyCtx, name, generation, decision)
+	}
+	go controller.run(policyCtx)
+}
+
+// remove stops the controller of a deleted policy
+func (r *PolicyReconciler) remove(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if running, exists := r.running[u.GetName()]; exists {
+		running.cancel()
+		delete(r.running, u.GetName())
+		log.Info().Str("policy", u.GetName()).Msg("SpotGuardPolicy deleted, stopped reconciling it")
+	}
+}
+
+// updateStatus records a decision in the policy's status subresource
+func (r *PolicyReconciler) updateStatus(ctx context.Context, name string, generation int64, decision ControllerDecision) {
+	patch := map[string]interface{}{
+		"status": SpotGuardPolicyStatus{
+			ObservedGeneration: generation,
+			Decision:           decision.Decision,
+			Message:            decision.Message,
+			LastEvaluationTime: &metav1.Time{Time: decision.Time.Truncate(time.Second)},
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		log.Error().Err(err).Str("policy", name).Msg("Failed to encode SpotGuardPolicy status")
+		return
+	}
+
+	_, err = r.dynamicClient.Resource(SpotGuardPolicyGVR).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{}, "status")
+	if err != nil {
+		log.Warn().Err(err).Str("policy", name).Msg("Failed to update SpotGuardPolicy status")
+	}
+}
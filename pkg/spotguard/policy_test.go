@@ -0,0 +1,153 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	"github.com/aws/aws-node-termination-handler/pkg/node"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func testPolicy(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "spot-guard.aws.amazon.com/v1alpha1",
+		"kind":       "SpotGuardPolicy",
+		"metadata":   map[string]interface{}{"name": name, "generation": int64(2)},
+		"spec":       spec,
+	}}
+}
+
+func TestPolicyToConfigPresets(t *testing.T) {
+	for preset, expected := range map[string]Config{
+		"":             DefaultConfig(),
+		"default":      DefaultConfig(),
+		"conservative": ConservativeConfig(),
+		"aggressive":   AggressiveConfig(),
+	} {
+		policy := &SpotGuardPolicy{Spec: SpotGuardPolicySpec{SpotASGName: "spot", OnDemandASGName: "od", Preset: preset}}
+		cfg, err := policy.ToConfig()
+		h.Ok(t, err)
+		h.Equals(t, expected.MinimumWaitDuration, cfg.MinimumWaitDuration)
+		h.Equals(t, expected.MaxClusterUtilization, cfg.MaxClusterUtilization)
+		h.Equals(t, "spot", cfg.SpotASGName)
+		h.Equals(t, "od", cfg.OnDemandASGName)
+		h.Assert(t, cfg.Enabled, "policy config should be enabled")
+	}
+}
+
+func TestPolicyToConfigRejectsInvalidSpec(t *testing.T) {
+	policy := &SpotGuardPolicy{Spec: SpotGuardPolicySpec{SpotASGName: "spot", OnDemandASGName: "od", Preset: "reckless"}}
+	_, err := policy.ToConfig()
+	h.Assert(t, errors.Is(err, ErrUnknownPolicyPreset), "expected ErrUnknownPolicyPreset, got %v", err)
+
+	policy = &SpotGuardPolicy{Spec: SpotGuardPolicySpec{OnDemandASGName: "od"}}
+	_, err = policy.ToConfig()
+	h.Assert(t, errors.Is(err, ErrSpotASGNameRequired), "expected ErrSpotASGNameRequired, got %v", err)
+}
+
+func TestPolicyApplyToOverridesPreset(t *testing.T) {
+	obj := testPolicy("workers", map[string]interface{}{
+		"spotASGName":           "workers-spot",
+		"onDemandASGName":       "workers-od",
+		"preset":                "conservative",
+		"minimumWaitDuration":   "20m",
+		"maxClusterUtilization": int64(60),
+		"maxScaleDownsPerCycle": int64(3),
+		"preScale": map[string]interface{}{
+			"enabled":           true,
+			"targetUtilization": int64(55),
+			"retryBackoff":      "90s",
+		},
+	})
+	policy, err := policyFromUnstructured(obj)
+	h.Ok(t, err)
+
+	base := config.Config{SpotGuardMaxScaleDownsPerCycle: 1, PreScaleTargetUtilization: 65, PreScaleSafetyBufferPercent: 10}
+	scoped, err := policy.ApplyTo(base)
+	h.Ok(t, err)
+
+	h.Equals(t, "workers-spot", scoped.SpotAsgName)
+	h.Equals(t, "workers-od", scoped.OnDemandAsgName)
+	h.Equals(t, 1200, scoped.SpotGuardMinimumWaitDuration)
+	h.Equals(t, int(ConservativeConfig().SpotStabilityDuration.Seconds()), scoped.SpotGuardSpotStabilityDuration)
+	h.Equals(t, 60, scoped.SpotGuardMaxClusterUtilization)
+	h.Equals(t, 3, scoped.SpotGuardMaxScaleDownsPerCycle)
+	h.Assert(t, scoped.EnablePreScale, "pre-scale should be enabled by the policy")
+	h.Equals(t, 55, scoped.PreScaleTargetUtilization)
+	h.Equals(t, 10, scoped.PreScaleSafetyBufferPercent)
+	h.Equals(t, 90, scoped.PreScaleRetryBackoffSeconds)
+	h.Equals(t, 1, base.SpotGuardMaxScaleDownsPerCycle)
+}
+
+func TestPolicyApplyToKeepsPreScaleEnabledWhenUnset(t *testing.T) {
+	obj := testPolicy("workers", map[string]interface{}{
+		"spotASGName":     "workers-spot",
+		"onDemandASGName": "workers-od",
+		"preScale": map[string]interface{}{
+			"targetUtilization": int64(55),
+		},
+	})
+	policy, err := policyFromUnstructured(obj)
+	h.Ok(t, err)
+
+	scoped, err := policy.ApplyTo(config.Config{EnablePreScale: true, SpotGuardMaxScaleDownsPerCycle: 1})
+	h.Ok(t, err)
+	h.Assert(t, scoped.EnablePreScale, "pre-scale enabled by the flags must stay enabled")
+	h.Equals(t, 55, scoped.PreScaleTargetUtilization)
+
+	disabled := false
+	policy.Spec.PreScale.Enabled = &disabled
+	scoped, err = policy.ApplyTo(config.Config{EnablePreScale: true, SpotGuardMaxScaleDownsPerCycle: 1})
+	h.Ok(t, err)
+	h.Assert(t, !scoped.EnablePreScale, "policy must be able to turn pre-scale off")
+}
+
+func TestPolicyReconcilerReportsInvalidPolicy(t *testing.T) {
+	obj := testPolicy("broken", map[string]interface{}{
+		"spotASGName":     "spot",
+		"onDemandASGName": "od",
+		"preset":          "reckless",
+	})
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), obj)
+	reconciler := NewPolicyReconciler(dynamicClient, nil, nil, node.Node{}, config.Config{}, observability.Metrics{}, observability.K8sEventRecorder{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reconciler.sync(ctx, obj)
+
+	updated, err := dynamicClient.Resource(SpotGuardPolicyGVR).Get(ctx, "broken", metav1.GetOptions{})
+	h.Ok(t, err)
+	policy, err := policyFromUnstructured(updated)
+	h.Ok(t, err)
+	h.Equals(t, DecisionInvalid, policy.Status.Decision)
+	h.Equals(t, int64(2), policy.Status.ObservedGeneration)
+	h.Assert(t, policy.Status.LastEvaluationTime != nil && time.Since(policy.Status.LastEvaluationTime.Time) < time.Minute,
+		"expected a recent evaluation time, got %v", policy.Status.LastEvaluationTime)
+
+	// The same generation is not reconciled twice
+	h.Equals(t, 1, len(reconciler.running))
+	reconciler.remove(obj)
+	h.Equals(t, 0, len(reconciler.running))
+}
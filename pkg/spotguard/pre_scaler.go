@@ -0,0 +1,373 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	"github.com/rs/zerolog/log"
+)
+
+// Strategies applied when pre-scaling fails, set by --pre-scale-failure-fallback
+const (
+	// PreScaleFallbackIncreaseThreshold lets the next drain attempt reach --pre-scale-fallback-threshold
+	PreScaleFallbackIncreaseThreshold = "increase_threshold"
+	// PreScaleFallbackWait keeps the node and retries pre-scaling once the retry backoff expired
+	PreScaleFallbackWait = "wait"
+	// PreScaleFallbackKeepOnDemand keeps the node without pre-scaling for it again; it is only
+	// drained once cluster utilization drops below the configured maximum on its own
+	PreScaleFallbackKeepOnDemand = "keep_ondemand"
+)
+
+// raisedThreshold is the fallback threshold granted to the next drain attempt of a node
+type raisedThreshold struct {
+	nodeName  string
+	threshold float64
+	grantedAt time.Time
+}
+
+// preScaler adds spot capacity ahead of a drain when cluster utilization is too high
+type preScaler struct {
+	config          config.Config
+	healthChecker   *HealthChecker
+	safetyChecker   *SafetyChecker
+	metrics         observability.Metrics
+	recorder        observability.K8sEventRecorder
+	spotASGName     string
+	onDemandASGName string
+
+	lastFailure time.Time        // when pre-scaling last failed, starts the retry backoff
+	raised      *raisedThreshold // granted by the increase_threshold fallback, consumed by one drain attempt
+	keptNodes   map[string]bool  // nodes the keep_ondemand fallback stopped pre-scaling for
+	status      *Status
+}
+
+// newPreScaler creates a pre-scaler for the given spot and on-demand ASG pair
+func newPreScaler(nthConfig config.Config, healthChecker *HealthChecker, safetyChecker *SafetyChecker, metrics observability.Metrics, recorder observability.K8sEventRecorder) *preScaler {
+	return &preScaler{
+		config:          nthConfig,
+		healthChecker:   healthChecker,
+		safetyChecker:   safetyChecker,
+		metrics:         metrics,
+		recorder:        recorder,
+		spotASGName:     nthConfig.SpotAsgName,
+		onDemandASGName: nthConfig.OnDemandAsgName,
+		keptNodes:       make(map[string]bool),
+	}
+}
+
+// drainThreshold returns the cluster utilization the next drain attempt of nodeName may reach.
+// A threshold raised by the increase_threshold fallback only applies to that one attempt.
+func (ps *preScaler) drainThreshold(nodeName string) float64 {
+	raised := ps.raised
+	if raised == nil || raised.nodeName != nodeName {
+		return ps.safetyChecker.maxUtilization
+	}
+	ps.raised = nil
+
+	// A grant the node did not use within two check cycles was made for a cluster that has changed since
+	if age := time.Since(raised.grantedAt); age > 2*time.Duration(ps.config.SpotGuardCheckInterval)*time.Second {
+		log.Info().
+			Str("nodeName", nodeName).
+			Dur("age", age).
+			Msg("Fallback threshold expired before the drain was attempted, using the configured maximum")
+		return ps.safetyChecker.maxUtilization
+	}
+
+	log.Info().
+		Str("nodeName", nodeName).
+		Float64("originalThreshold", ps.safetyChecker.maxUtilization).
+		Float64("fallbackThreshold", raised.threshold).
+		Msg("Applying the pre-scale fallback threshold to this drain attempt")
+	return raised.threshold
+}
+
+// backoffRemaining returns how long pre-scaling is skipped after the last failure
+func (ps *preScaler) backoffRemaining() time.Duration {
+	if ps.lastFailure.IsZero() {
+		return 0
+	}
+	backoff := time.Duration(ps.config.PreScaleRetryBackoffSeconds) * time.Second
+	if remaining := backoff - time.Since(ps.lastFailure); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// recordLevel records the outcome of a pre-scale level as a metric and as an event on the node being drained
+func (ps *preScaler) recordLevel(nodeName string, level int, outcome string, message string) {
+	ps.metrics.SpotGuardPreScaleInc(level, outcome)
+	ps.status.addPreScale(nodeName, level, outcome, message)
+	if outcome == observability.SpotGuardOutcomeSuccess {
+		ps.recorder.Emit(nodeName, observability.Normal, observability.SpotGuardPreScaleReason, observability.SpotGuardPreScaleMsgFmt, level, message)
+		return
+	}
+	ps.recorder.Emit(nodeName, observability.Warning, observability.SpotGuardPreScaleErrReason, observability.SpotGuardPreScaleMsgFmt, level, message)
+}
+
+// attemptPreScaleWithFallback implements the 3-level safety net for pre-scaling ahead of draining nodeName.
+// It returns true when the next drain attempt of nodeName is expected to pass the safety check.
+func (ps *preScaler) attemptPreScaleWithFallback(ctx context.Context, nodeName string) bool {
+	if ps.keptNodes[nodeName] {
+		log.Debug().
+			Str("nodeName", nodeName).
+			Msg("Keeping on-demand node after a failed pre-scale, waiting for utilization to drop")
+		return false
+	}
+
+	// Get current cluster utilization
+	currentUtilization := ps.safetyChecker.GetClusterUtilization(ctx)
+
+	if remaining := ps.backoffRemaining(); remaining > 0 {
+		log.Info().
+			Dur("remaining", remaining).
+			Str("fallback", ps.config.PreScaleFailureFallback).
+			Msg("Pre-scale failed recently, skipping it until the retry backoff expires")
+		return ps.applyFailureFallback(ctx, nodeName, currentUtilization)
+	}
+
+	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	log.Info().Msg("LEVEL 1: Attempting Smart Pre-Scale")
+	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	log.Info().
+		Float64("currentUtilization", currentUtilization).
+		Float64("targetUtilization", float64(ps.config.PreScaleTargetUtilization)).
+		Msg("Current cluster state")
+
+	// Level 1: Attempt pre-scale
+	calc, err := ps.healthChecker.CalculatePreScaleNodes(
+		ctx,
+		ps.spotASGName,
+		ps.onDemandASGName,
+		nodeName,
+		currentUtilization,
+		float64(ps.config.PreScaleTargetUtilization),
+		ps.config.PreScaleSafetyBufferPercent,
+	)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Pre-scale calculation failed")
+		ps.recordLevel(nodeName, 1, observability.SpotGuardOutcomeFailure, fmt.Sprintf("pre-scale calculation failed: %v", err))
+		return ps.preScaleFailed(ctx, nodeName, currentUtilization)
+	}
+
+	if calc.AdditionalSpotNodes == 0 {
+		log.Info().Msg("No additional nodes needed (displaced pods fit below target)")
+		ps.recordLevel(nodeName, 1, observability.SpotGuardOutcomeSuccess, "no additional spot nodes needed")
+		return true
+	}
+
+	log.Info().
+		Int("additionalNodes", calc.AdditionalSpotNodes).
+		Int("newDesiredCapacity", calc.NodesNeeded).
+		Float64("expectedUtilization", calc.ExpectedUtilization).
+		Msg("Pre-scale plan calculated")
+
+	// Scale up spot ASG
+	if err := ps.healthChecker.ScaleSpotASG(ctx, ps.spotASGName, calc.NodesNeeded); err != nil {
+		log.Error().
+			Err(err).
+			Str("spotASG", ps.spotASGName).
+			Int("desiredCapacity", calc.NodesNeeded).
+			Msg("Failed to scale spot ASG")
+		ps.recordLevel(nodeName, 1, observability.SpotGuardOutcomeFailure, fmt.Sprintf("failed to scale spot ASG %s: %v", ps.spotASGName, err))
+		return ps.preScaleFailed(ctx, nodeName, currentUtilization)
+	}
+
+	if ps.config.DryRun {
+		// No spot nodes are launching, so there is nothing to wait for
+		message := fmt.Sprintf("added %d spot node(s) to %s", calc.AdditionalSpotNodes, ps.spotASGName)
+		ps.metrics.SpotGuardPreScaleInc(1, observability.SpotGuardOutcomeDryRun)
+		ps.status.addPreScale(nodeName, 1, observability.SpotGuardOutcomeDryRun, message)
+		ps.recorder.Emit(nodeName, observability.Normal, observability.SpotGuardDryRunReason, observability.SpotGuardDryRunMsgFmt, message)
+		return true
+	}
+
+	log.Info().
+		Int("additionalNodes", calc.AdditionalSpotNodes).
+		Int("timeoutSeconds", ps.config.PreScaleTimeoutSeconds).
+		Msg("Waiting for new spot nodes to become ready...")
+
+	// Wait for new nodes to become ready
+	timeout := time.Duration(ps.config.PreScaleTimeoutSeconds) * time.Second
+	success := ps.waitForSpotNodesReady(ctx, calc.NodesNeeded, timeout)
+
+	if success {
+		log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		log.Info().Msg("LEVEL 1 SUCCESS: Pre-scale completed successfully!")
+		log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		ps.recordLevel(nodeName, 1, observability.SpotGuardOutcomeSuccess,
+			fmt.Sprintf("added %d spot node(s) to %s", calc.AdditionalSpotNodes, ps.spotASGName))
+		return true
+	}
+
+	log.Warn().
+		Dur("timeout", timeout).
+		Msg("LEVEL 1 FAILED: Spot nodes not ready within timeout")
+	ps.recordLevel(nodeName, 1, observability.SpotGuardOutcomeFailure, fmt.Sprintf("spot nodes not ready within %v", timeout))
+
+	// Spot capacity might not be available - try fallback
+	return ps.preScaleFailed(ctx, nodeName, currentUtilization)
+}
+
+// preScaleFailed starts the retry backoff and applies the configured failure strategy
+func (ps *preScaler) preScaleFailed(ctx context.Context, nodeName string, currentUtilization float64) bool {
+	ps.lastFailure = time.Now()
+	return ps.applyFailureFallback(ctx, nodeName, currentUtilization)
+}
+
+// applyFailureFallback applies the --pre-scale-failure-fallback strategy while pre-scaling is failing
+func (ps *preScaler) applyFailureFallback(ctx context.Context, nodeName string, currentUtilization float64) bool {
+	switch ps.config.PreScaleFailureFallback {
+	case PreScaleFallbackWait:
+		return ps.attemptFallbackLevel3(ctx, nodeName, fmt.Sprintf("will retry pre-scale in %v", ps.backoffRemaining().Round(time.Second)))
+	case PreScaleFallbackKeepOnDemand:
+		ps.keptNodes[nodeName] = true
+		return ps.attemptFallbackLevel3(ctx, nodeName, "until cluster utilization drops on its own")
+	default:
+		return ps.attemptFallbackLevel2(ctx, nodeName, currentUtilization)
+	}
+}
+
+// attemptFallbackLevel2 tries to drain with increased threshold
+func (ps *preScaler) attemptFallbackLevel2(ctx context.Context, nodeName string, currentUtilization float64) bool {
+	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	log.Info().Msg(" LEVEL 2: Fallback to Increased Threshold")
+	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	fallbackThreshold := float64(ps.config.PreScaleFallbackThreshold)
+
+	log.Info().
+		Float64("currentUtilization", currentUtilization).
+		Float64("originalThreshold", float64(ps.config.SpotGuardMaxClusterUtilization)).
+		Float64("fallbackThreshold", fallbackThreshold).
+		Msg("Comparing utilization against increased threshold")
+
+	if currentUtilization <= fallbackThreshold {
+		log.Info().
+			Float64("currentUtilization", currentUtilization).
+			Float64("fallbackThreshold", fallbackThreshold).
+			Msg("LEVEL 2 SUCCESS: Current utilization is below fallback threshold")
+		log.Info().Msg("Will proceed with drain on next check cycle")
+		log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+		// The increased threshold only applies to the next drain attempt of this node
+		ps.raised = &raisedThreshold{nodeName: nodeName, threshold: fallbackThreshold, grantedAt: time.Now()}
+		ps.recordLevel(nodeName, 2, observability.SpotGuardOutcomeSuccess,
+			fmt.Sprintf("utilization %.1f%% is within the fallback threshold of %.1f%%", currentUtilization, fallbackThreshold))
+		return true
+	}
+
+	log.Warn().
+		Float64("currentUtilization", currentUtilization).
+		Float64("fallbackThreshold", fallbackThreshold).
+		Msg(" LEVEL 2 FAILED: Still too high even with increased threshold")
+	ps.recordLevel(nodeName, 2, observability.SpotGuardOutcomeFailure,
+		fmt.Sprintf("utilization %.1f%% is above the fallback threshold of %.1f%%", currentUtilization, fallbackThreshold))
+
+	// Still too high - go to Level 3
+	checkInterval := time.Duration(ps.config.SpotGuardCheckInterval) * time.Second
+	return ps.attemptFallbackLevel3(ctx, nodeName, fmt.Sprintf("will retry in %v", checkInterval))
+}
+
+// attemptFallbackLevel3 keeps the on-demand node running, retry tells until when
+func (ps *preScaler) attemptFallbackLevel3(ctx context.Context, nodeName string, retry string) bool {
+	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	log.Info().Msg("  LEVEL 3: Keep On-Demand Node (Safety First)")
+	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	log.Warn().Msg("  Cannot safely drain on-demand node:")
+	log.Warn().Msg("   • Spot capacity unavailable or unhealthy")
+	log.Warn().Msg("   • Cluster utilization too high")
+	log.Warn().Msg("   • Draining now would risk workload disruption")
+	log.Warn().Msg("")
+	log.Warn().Msg("  Safety First: Keeping on-demand node running")
+	log.Warn().Msg(" Note: This costs more, but ensures reliability")
+	log.Warn().
+		Str("fallback", ps.config.PreScaleFailureFallback).
+		Msgf("Keeping on-demand node running, %s", retry)
+	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	// Level 3 always keeps the node, so the drain counts as blocked
+	ps.recordLevel(nodeName, 3, observability.SpotGuardOutcomeBlocked, "keeping the on-demand node running, "+retry)
+	return false
+}
+
+// waitForSpotNodesReady waits for the InService spot instances to provide the desired capacity and their nodes
+// to be ready. The desired capacity is in units, so instances count by their weight.
+func (ps *preScaler) waitForSpotNodesReady(ctx context.Context, desiredCount int, timeout time.Duration) bool {
+	startTime := time.Now()
+	checkInterval := 10 * time.Second
+
+	for {
+		elapsed := time.Since(startTime)
+		if elapsed >= timeout {
+			log.Warn().
+				Dur("elapsed", elapsed).
+				Dur("timeout", timeout).
+				Msg("Timeout waiting for spot nodes to be ready")
+			return false
+		}
+
+		// Check spot ASG health
+		status, err := ps.healthChecker.CheckSpotASGComprehensive(
+			ctx,
+			ps.spotASGName,
+			30*time.Second, // Short stability check for pre-scale
+			nil,
+		)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Msg("Failed to check spot ASG status during pre-scale wait")
+			time.Sleep(checkInterval)
+			continue
+		}
+
+		// Check if we have enough healthy nodes
+		if status.IsHealthy && status.NodesReady {
+			// Only InService instances count, weighted by their capacity
+			readyCount := int(status.InServiceCapacity)
+
+			log.Debug().
+				Int("readyCount", readyCount).
+				Int("desiredCount", desiredCount).
+				Dur("elapsed", elapsed).
+				Msg("Checking spot node readiness")
+
+			if readyCount >= desiredCount {
+				log.Info().
+					Int("readyCount", readyCount).
+					Int("desiredCount", desiredCount).
+					Dur("elapsed", elapsed).
+					Msg("All spot nodes are ready!")
+				return true
+			}
+		}
+
+		remaining := timeout - elapsed
+		log.Debug().
+			Dur("elapsed", elapsed).
+			Dur("remaining", remaining).
+			Msg("Waiting for spot nodes...")
+
+		time.Sleep(checkInterval)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+// testPreScaler returns a pre-scaler whose last pre-scale failed just now, on a cluster 80% utilized.
+// Without a health checker, any attempt to pre-scale again during the backoff panics.
+func testPreScaler(fallback string) *preScaler {
+	nodeA, nodeB := simNode("od", "a", "2", "8Gi"), simNode("spot", "a", "2", "8Gi")
+	podA, podB := simPod("web-1", "od", "1600m", nil), simPod("web-2", "spot", "1600m", nil)
+	clientset := fake.NewSimpleClientset(&nodeA, &nodeB, &podA, &podB)
+
+	nthConfig := config.Config{
+		SpotGuardCheckInterval:      30,
+		PreScaleFailureFallback:     fallback,
+		PreScaleFallbackThreshold:   95,
+		PreScaleRetryBackoffSeconds: 600,
+	}
+	ps := newPreScaler(nthConfig, nil, NewSafetyChecker(clientset, 70), observability.Metrics{}, observability.K8sEventRecorder{})
+	ps.lastFailure = time.Now()
+	return ps
+}
+
+func TestPreScaleFallbackRaisesThresholdForOneAttempt(t *testing.T) {
+	ps := testPreScaler(PreScaleFallbackIncreaseThreshold)
+
+	h.Assert(t, ps.attemptPreScaleWithFallback(context.Background(), "od"), "80%% utilization is within the fallback threshold")
+	h.Equals(t, 70.0, ps.safetyChecker.maxUtilization)
+
+	// Only the next drain attempt of the node may reach the fallback threshold
+	h.Equals(t, 70.0, ps.drainThreshold("other"))
+	h.Equals(t, 95.0, ps.drainThreshold("od"))
+	h.Equals(t, 70.0, ps.drainThreshold("od"))
+
+	// A grant left unused for longer than two check cycles expires
+	h.Assert(t, ps.attemptPreScaleWithFallback(context.Background(), "od"), "80%% utilization is within the fallback threshold")
+	ps.raised.grantedAt = time.Now().Add(-2 * time.Minute)
+	h.Equals(t, 70.0, ps.drainThreshold("od"))
+
+	// Above the fallback threshold the node is kept
+	ps.config.PreScaleFallbackThreshold = 75
+	h.Assert(t, !ps.attemptPreScaleWithFallback(context.Background(), "od"), "80%% utilization is above the fallback threshold")
+	h.Equals(t, 70.0, ps.drainThreshold("od"))
+}
+
+func TestPreScaleFallbackWait(t *testing.T) {
+	ps := testPreScaler(PreScaleFallbackWait)
+
+	h.Assert(t, !ps.attemptPreScaleWithFallback(context.Background(), "od"), "the wait fallback keeps the node during the backoff")
+	h.Equals(t, 70.0, ps.drainThreshold("od"))
+	h.Assert(t, ps.backoffRemaining() > 9*time.Minute, "expected the backoff to run, %v remaining", ps.backoffRemaining())
+
+	ps.lastFailure = time.Now().Add(-11 * time.Minute)
+	h.Equals(t, time.Duration(0), ps.backoffRemaining())
+}
+
+func TestPreScaleFallbackKeepOnDemand(t *testing.T) {
+	ps := testPreScaler(PreScaleFallbackKeepOnDemand)
+
+	h.Assert(t, !ps.attemptPreScaleWithFallback(context.Background(), "od"), "the keep_ondemand fallback keeps the node")
+	h.Assert(t, ps.keptNodes["od"], "expected the node to be kept on-demand")
+
+	// The kept node is not pre-scaled for again, even once the backoff expired
+	ps.lastFailure = time.Now().Add(-time.Hour)
+	h.Assert(t, !ps.attemptPreScaleWithFallback(context.Background(), "od"), "the kept node is not pre-scaled for")
+	h.Equals(t, 70.0, ps.drainThreshold("od"))
+}
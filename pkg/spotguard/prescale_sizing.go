@@ -0,0 +1,139 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// capacityUnit is an amount of CPU in millicores and memory in bytes
+type capacityUnit struct {
+	cpu    int64
+	memory int64
+}
+
+// capacityUnitOf returns the CPU and memory of a resource list
+func capacityUnitOf(resources corev1.ResourceList) capacityUnit {
+	return capacityUnit{
+		cpu:    resourceValue(corev1.ResourceCPU, resources[corev1.ResourceCPU]),
+		memory: resourceValue(corev1.ResourceMemory, resources[corev1.ResourceMemory]),
+	}
+}
+
+// add adds another amount
+func (u *capacityUnit) add(other capacityUnit) {
+	u.cpu += other.cpu
+	u.memory += other.memory
+}
+
+// utilization returns the percentage of capacity used by u, the higher of CPU and memory
+func (u capacityUnit) utilization(capacity capacityUnit) float64 {
+	utilization := 0.0
+	if capacity.cpu > 0 {
+		utilization = float64(u.cpu) / float64(capacity.cpu)
+	}
+	if capacity.memory > 0 {
+		utilization = math.Max(utilization, float64(u.memory)/float64(capacity.memory))
+	}
+	return utilization * 100.0
+}
+
+// nodeWeight returns the units of desired capacity a node counts for
+func nodeWeight(node *corev1.Node, weights map[string]int64) int64 {
+	if weight, ok := weights[node.Labels[corev1.LabelInstanceTypeStable]]; ok {
+		return weight
+	}
+	return 1
+}
+
+// spotCapacityUnit returns the allocatable CPU and memory of one unit of the spot pool's desired capacity,
+// together with the instance types of the pool and their weights.
+//
+// Every instance type the pool may launch is considered and the smallest one per resource is used, so the
+// pre-scale is enough whichever type the pool picks. An instance type is sized by the allocatable of the
+// nodes already running it, or estimated from EC2 by the capacity provider otherwise. When the pool does
+// not tell its instance types, the current spot nodes are used.
+func (hc *HealthChecker) spotCapacityUnit(ctx context.Context, spotASGName string, nodes []corev1.Node, spotNodes []*corev1.Node) (capacityUnit, map[string]int64, error) {
+	weights := make(map[string]int64)
+	if source, ok := hc.provider.(instanceTypeSource); ok {
+		types, err := source.InstanceTypes(ctx, spotASGName)
+		if err != nil {
+			return capacityUnit{}, nil, fmt.Errorf("failed to get instance types of spot ASG %s: %w", spotASGName, err)
+		}
+		weights = types
+	}
+
+	allocatable := make(map[string]corev1.ResourceList)
+	for i := range nodes {
+		instanceType := nodes[i].Labels[corev1.LabelInstanceTypeStable]
+		if _, wanted := weights[instanceType]; wanted {
+			allocatable[instanceType] = nodes[i].Status.Allocatable
+		}
+	}
+	missing := make([]string, 0)
+	for instanceType := range weights {
+		if _, known := allocatable[instanceType]; !known {
+			missing = append(missing, instanceType)
+		}
+	}
+	if estimator, ok := hc.provider.(allocatableEstimator); ok && len(missing) > 0 {
+		estimated, err := estimator.EstimateAllocatable(ctx, missing)
+		if err != nil {
+			return capacityUnit{}, nil, fmt.Errorf("failed to estimate allocatable of instance types %v: %w", missing, err)
+		}
+		for instanceType, resources := range estimated {
+			allocatable[instanceType] = resources
+		}
+	}
+
+	var unit capacityUnit
+	consider := func(resources corev1.ResourceList, weight int64) {
+		candidate := capacityUnitOf(resources)
+		if candidate.cpu <= 0 || candidate.memory <= 0 {
+			return
+		}
+		candidate = capacityUnit{cpu: candidate.cpu / weight, memory: candidate.memory / weight}
+		if unit.cpu == 0 || candidate.cpu < unit.cpu {
+			unit.cpu = candidate.cpu
+		}
+		if unit.memory == 0 || candidate.memory < unit.memory {
+			unit.memory = candidate.memory
+		}
+	}
+	for instanceType, weight := range weights {
+		resources, known := allocatable[instanceType]
+		if !known {
+			log.Warn().
+				Str("spotASG", spotASGName).
+				Str("instanceType", instanceType).
+				Msg("Allocatable of spot instance type unknown, leaving it out of pre-scale sizing")
+			continue
+		}
+		consider(resources, weight)
+	}
+	if unit.cpu == 0 {
+		for _, node := range spotNodes {
+			consider(node.Status.Allocatable, nodeWeight(node, weights))
+		}
+	}
+	if unit.cpu == 0 {
+		return capacityUnit{}, nil, fmt.Errorf("cannot size spot ASG %s: no instance type with a known allocatable and no ready spot node", spotASGName)
+	}
+	return unit, weights, nil
+}
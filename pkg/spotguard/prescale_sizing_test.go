@@ -0,0 +1,126 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+// mockedInstanceTypes describes m5.large and m5.xlarge
+type mockedInstanceTypes struct {
+	ec2iface.EC2API
+}
+
+func (m mockedInstanceTypes) DescribeInstanceTypesPagesWithContext(_ aws.Context, input *ec2.DescribeInstanceTypesInput, fn func(*ec2.DescribeInstanceTypesOutput, bool) bool, _ ...request.Option) error {
+	catalog := map[string]*ec2.InstanceTypeInfo{
+		"m5.large":  {VCpuInfo: &ec2.VCpuInfo{DefaultVCpus: aws.Int64(2)}, MemoryInfo: &ec2.MemoryInfo{SizeInMiB: aws.Int64(8192)}},
+		"m5.xlarge": {VCpuInfo: &ec2.VCpuInfo{DefaultVCpus: aws.Int64(4)}, MemoryInfo: &ec2.MemoryInfo{SizeInMiB: aws.Int64(16384)}},
+	}
+	output := &ec2.DescribeInstanceTypesOutput{}
+	for _, instanceType := range input.InstanceTypes {
+		info := catalog[aws.StringValue(instanceType)]
+		info.InstanceType = instanceType
+		info.NetworkInfo = &ec2.NetworkInfo{MaximumNetworkInterfaces: aws.Int64(3), Ipv4AddressesPerInterface: aws.Int64(10)}
+		output.InstanceTypes = append(output.InstanceTypes, info)
+	}
+	fn(output, true)
+	return nil
+}
+
+func TestEstimateAllocatable(t *testing.T) {
+	provider := NewASGCapacityProvider(mockedScalingASGs{}, mockedInstanceTypes{}).(allocatableEstimator)
+	allocatable, err := provider.EstimateAllocatable(context.Background(), []string{"m5.large"})
+	h.Ok(t, err)
+	// 2 vCPUs minus 70m, 8GiB minus 11MiB for each of the 29 pods, 255MiB and the 100MiB eviction threshold
+	h.Equals(t, capacityUnit{cpu: 1930, memory: (8192 - 674) * 1024 * 1024}, capacityUnitOf(allocatable["m5.large"]))
+}
+
+func TestCalculatePreScaleNodesByRequests(t *testing.T) {
+	spot := simNode("spot-1", "us-east-1a", "4", "16Gi")
+	spot.Labels[corev1.LabelInstanceTypeStable] = "m5.xlarge"
+	spot.Spec.ProviderID = "aws:///us-east-1a/i-1"
+	onDemand := simNode("od-1", "us-east-1a", "8", "32Gi")
+	spotPod := simPod("spot-app", "spot-1", "1", nil)
+	displaced := []corev1.Pod{simPod("app-0", "od-1", "1", nil), simPod("app-1", "od-1", "1", nil), simPod("app-2", "od-1", "1", nil)}
+	// DaemonSet pods stay with the node and are not displaced
+	daemon := simPod("agent", "od-1", "2", nil)
+	daemon.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "agent", Controller: aws.Bool(true)}}
+	clientset := fake.NewSimpleClientset(&spot, &onDemand, &spotPod, &displaced[0], &displaced[1], &displaced[2], &daemon)
+
+	asgs := mockedScalingASGs{groups: map[string]*autoscaling.Group{
+		"spot": {
+			DesiredCapacity: aws.Int64(2),
+			Instances: []*autoscaling.Instance{{
+				InstanceId: aws.String("i-1"), InstanceType: aws.String("m5.xlarge"), WeightedCapacity: aws.String("2"),
+				LifecycleState: aws.String(autoscaling.LifecycleStateInService), HealthStatus: aws.String(InstanceHealthy),
+			}},
+			MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{LaunchTemplate: &autoscaling.LaunchTemplate{
+				Overrides: []*autoscaling.LaunchTemplateOverrides{
+					{InstanceType: aws.String("m5.xlarge"), WeightedCapacity: aws.String("2")},
+					{InstanceType: aws.String("m5.large"), WeightedCapacity: aws.String("1")},
+				},
+			}},
+		},
+		"od": {DesiredCapacity: aws.Int64(1)},
+	}}
+	hc := NewHealthChecker(NewASGCapacityProvider(asgs, mockedInstanceTypes{}), clientset, false)
+
+	calc, err := hc.CalculatePreScaleNodes(context.Background(), "spot", "od", "od-1", 90, 70, 0)
+	h.Ok(t, err)
+	h.Equals(t, int64(3000), calc.DisplacedCPU)
+	h.Equals(t, []string{"m5.large", "m5.xlarge"}, calc.InstanceTypes)
+	// The m5.large estimate is smaller than half of the running m5.xlarge
+	h.Equals(t, int64(1930), calc.UnitCPU)
+	// 4 CPUs requested at 70% need 5715m, the m5.xlarge has 4000m: one more unit covers the missing 1715m
+	h.Equals(t, 1, calc.AdditionalSpotNodes)
+	h.Equals(t, 3, calc.NodesNeeded)
+	h.Equals(t, 67.45, math.Round(calc.ExpectedUtilization*100)/100)
+
+	// The safety buffer rounds up
+	calc, err = hc.CalculatePreScaleNodes(context.Background(), "spot", "od", "od-1", 90, 70, 10)
+	h.Ok(t, err)
+	h.Equals(t, 2, calc.AdditionalSpotNodes)
+
+	// Nothing to add when the displaced pods fit below the target
+	calc, err = hc.CalculatePreScaleNodes(context.Background(), "spot", "od", "od-1", 90, 100, 0)
+	h.Ok(t, err)
+	h.Equals(t, 0, calc.AdditionalSpotNodes)
+	h.Equals(t, 2, calc.NodesNeeded)
+
+	// The buffered pre-scale is capped at the maximum size of the spot ASG
+	asgs.groups["spot"].MaxSize = aws.Int64(3)
+	calc, err = hc.CalculatePreScaleNodes(context.Background(), "spot", "od", "od-1", 90, 70, 10)
+	h.Ok(t, err)
+	h.Equals(t, 1, calc.AdditionalSpotNodes)
+	h.Equals(t, 3, calc.NodesNeeded)
+
+	// The single m5.xlarge provides both units of the desired capacity
+	status, err := hc.CheckSpotASGComprehensive(context.Background(), "spot", 0, nil)
+	h.Ok(t, err)
+	h.Equals(t, int64(2), status.InServiceCapacity)
+	h.Assert(t, status.IsHealthy, "weighted instances must cover the desired capacity")
+}
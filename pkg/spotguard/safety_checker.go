@@ -16,20 +16,23 @@ package spotguard
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	corev1 "k8s.io/api/core/v1"
-	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 )
 
 // SafetyChecker performs safety checks before scaling down on-demand nodes
 type SafetyChecker struct {
 	k8sClient      kubernetes.Interface
-	maxUtilization float64 // Exported for pre-scale fallback
+	maxUtilization float64
+	evictionRules  evictionRules
+	// drainTimeout is how long the drain retries refused evictions, 0 when it never gives up
+	drainTimeout time.Duration
 }
 
 // NewSafetyChecker creates a new safety checker
@@ -37,6 +40,76 @@ func NewSafetyChecker(k8sClient kubernetes.Interface, maxUtilization float64) *S
 	return &SafetyChecker{
 		k8sClient:      k8sClient,
 		maxUtilization: maxUtilization,
+		evictionRules:  defaultEvictionRules,
+	}
+}
+
+// ReasonClusterUtilizationTooHigh is returned by CanSafelyDrainNode when the remaining nodes would be too busy
+const ReasonClusterUtilizationTooHigh = "Cluster utilization too high"
+
+// Reasons an on-demand scale-down did not happen or how it ended
+const (
+	ScaleDownReasonMinimumWaitNotMet  = "MinimumWaitNotMet"
+	ScaleDownReasonSpotNotHealthy     = "SpotNotHealthy"
+	ScaleDownReasonSpotNodesNotReady  = "SpotNodesNotReady"
+	ScaleDownReasonSpotNotStable      = "SpotNotStable"
+	ScaleDownReasonSpotInterrupted    = "SpotInterrupted"
+	ScaleDownReasonUtilizationTooHigh = "UtilizationTooHigh"
+	ScaleDownReasonPDBBlocked         = "PDBBlocked"
+	ScaleDownReasonPodsUnschedulable  = "PodsUnschedulable"
+	ScaleDownReasonZonalVolume        = "ZonalVolume"
+	ScaleDownReasonLocalVolume        = "LocalVolume"
+	ScaleDownReasonNotSafeToEvict     = "NotSafeToEvict"
+	ScaleDownReasonScaleDownDisabled  = "ScaleDownDisabled"
+	ScaleDownReasonLocalStorage       = "LocalStorage"
+	ScaleDownReasonNotReplicated      = "NotReplicated"
+	ScaleDownReasonCheckFailed        = "CheckFailed"
+	ScaleDownReasonOutsideWindow      = "OutsideScaleDownWindow"
+	ScaleDownReasonBlackoutWindow     = "BlackoutWindow"
+	ScaleDownReasonConcurrencyLimit   = "ConcurrencyLimit"
+	ScaleDownReasonDrainSpacing       = "DrainSpacing"
+	ScaleDownReasonCompleted          = "Completed"
+	ScaleDownReasonExecutionFailed    = "ExecutionFailed"
+	ScaleDownReasonDraining           = "Draining"
+)
+
+// drainBlockReason maps a CanSafelyDrainNode reason to one of the ScaleDownReason constants
+func drainBlockReason(reason string) string {
+	switch {
+	case reason == ReasonClusterUtilizationTooHigh:
+		return ScaleDownReasonUtilizationTooHigh
+	case strings.Contains(reason, "would violate PDB"):
+		return ScaleDownReasonPDBBlocked
+	case strings.Contains(reason, AnnotationCASafeToEvict+"=false"):
+		return ScaleDownReasonNotSafeToEvict
+	case strings.Contains(reason, AnnotationCAScaleDownDisabled):
+		return ScaleDownReasonScaleDownDisabled
+	case strings.Contains(reason, "uses local storage"):
+		return ScaleDownReasonLocalStorage
+	case strings.Contains(reason, "is not replicated"):
+		return ScaleDownReasonNotReplicated
+	case strings.HasPrefix(reason, "failed to"):
+		return ScaleDownReasonCheckFailed
+	case strings.Contains(reason, "next to its volume"):
+		return ScaleDownReasonZonalVolume
+	case strings.Contains(reason, "is bound to the local volume"):
+		return ScaleDownReasonLocalVolume
+	default:
+		return ScaleDownReasonPodsUnschedulable
+	}
+}
+
+// spotBlockReason returns the ScaleDownReason constant for spot capacity that is not yet restored
+func spotBlockReason(status *SpotASGHealthStatus) string {
+	switch {
+	case !status.IsHealthy:
+		return ScaleDownReasonSpotNotHealthy
+	case !status.NodesReady:
+		return ScaleDownReasonSpotNodesNotReady
+	case len(status.Interruptions) > 0:
+		return ScaleDownReasonSpotInterrupted
+	default:
+		return ScaleDownReasonSpotNotStable
 	}
 }
 
@@ -50,8 +123,15 @@ func (sc *SafetyChecker) CanScaleDownOnDemand(event *FallbackEvent) (bool, strin
 	return true, ""
 }
 
-// CanSafelyDrainNode checks if the on-demand node can be safely drained
-func (sc *SafetyChecker) CanSafelyDrainNode(ctx context.Context, nodeName string) (bool, string) {
+// CanSafelyDrainNode checks if the on-demand node can be safely drained.
+// Pods with zonal volumes only move to spotInstances, the InService instances of the spot pool; nil lets them move to any node.
+func (sc *SafetyChecker) CanSafelyDrainNode(ctx context.Context, nodeName string, spotInstances map[string]bool) (bool, string) {
+	return sc.CanSafelyDrainNodeWithThreshold(ctx, nodeName, spotInstances, sc.maxUtilization)
+}
+
+// CanSafelyDrainNodeWithThreshold checks if the on-demand node can be safely drained, allowing the cluster
+// to reach maxUtilization instead of the configured maximum. The pre-scale fallback uses it for a single drain attempt.
+func (sc *SafetyChecker) CanSafelyDrainNodeWithThreshold(ctx context.Context, nodeName string, spotInstances map[string]bool, maxUtilization float64) (bool, string) {
 	log.Debug().Str("node", nodeName).Msg("Starting pod safety check for node drain")
 
 	// Get the node
@@ -70,6 +150,14 @@ func (sc *SafetyChecker) CanSafelyDrainNode(ctx context.Context, nodeName string
 		Str("zone", node.Labels["topology.kubernetes.io/zone"]).
 		Msg("Retrieved node details")
 
+	// Nodes someone else excluded from Cluster Autoscaler scale-down are kept as well
+	if isScaleDownDisabled(node) {
+		log.Warn().
+			Str("node", nodeName).
+			Msg("Node is annotated scale-down-disabled")
+		return false, fmt.Sprintf("node is annotated %s=true", AnnotationCAScaleDownDisabled)
+	}
+
 	// Get all pods on the node
 	pods, err := sc.k8sClient.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
 		FieldSelector: fmt.Sprintf("spec.nodeName=%s", nodeName),
@@ -87,16 +175,38 @@ func (sc *SafetyChecker) CanSafelyDrainNode(ctx context.Context, nodeName string
 		Int("totalPods", len(pods.Items)).
 		Msg("Checking pod safety for node drain")
 
+	// Snapshot the rest of the cluster once so placements of earlier pods are seen by later ones
+	simulator, err := sc.newPlacementSimulator(ctx, nodeName, pods.Items, spotInstances)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("node", nodeName).
+			Msg("Failed to build placement simulation")
+		return false, err.Error()
+	}
+
+	// Snapshot the PDBs once for all pods of the node
+	budgets, err := sc.newDisruptionBudgets(ctx)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("node", nodeName).
+			Msg("Failed to list PodDisruptionBudgets")
+		return false, err.Error()
+	}
+
 	// Check each pod
 	daemonSetCount := 0
 	terminatingCount := 0
 	checkablePodsCount := 0
+	blockedPods := make([]string, 0)
 
-	for _, pod := range pods.Items {
+	for i := range pods.Items {
+		pod := &pods.Items[i]
 		podInfo := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
 
 		// Skip DaemonSet pods (they're OK, they run on every node)
-		if isDaemonSetPod(&pod) {
+		if isDaemonSetPod(pod) {
 			daemonSetCount++
 			log.Debug().
 				Str("node", nodeName).
@@ -105,6 +215,15 @@ func (sc *SafetyChecker) CanSafelyDrainNode(ctx context.Context, nodeName string
 			continue
 		}
 
+		// Skip static pods, the kubelet owns them and they are never evicted
+		if isMirrorPod(pod) {
+			log.Debug().
+				Str("node", nodeName).
+				Str("pod", podInfo).
+				Msg("Skipping mirror pod")
+			continue
+		}
+
 		// Skip pods that are already terminating
 		if pod.DeletionTimestamp != nil {
 			terminatingCount++
@@ -122,25 +241,63 @@ func (sc *SafetyChecker) CanSafelyDrainNode(ctx context.Context, nodeName string
 			Str("phase", string(pod.Status.Phase)).
 			Msg("Checking if pod can be safely evicted")
 
+		// Apply the Cluster Autoscaler eviction rules
+		if blocked, reason := sc.evictionRules.check(pod); blocked {
+			log.Warn().
+				Str("node", nodeName).
+				Str("pod", podInfo).
+				Str("reason", reason).
+				Msg("Pod is not safe to evict")
+			return false, fmt.Sprintf("pod %s is not safe to evict: %s", podInfo, reason)
+		} else if reason != "" {
+			log.Warn().
+				Str("node", nodeName).
+				Str("pod", podInfo).
+				Str("reason", reason).
+				Msg("Evicting pod anyway, local storage is not configured to block scale-down")
+		}
+
 		// Check if pod can be scheduled elsewhere
-		canSchedule, reason := sc.canPodScheduleElsewhere(ctx, &pod, nodeName)
+		canSchedule, reason := sc.canPodScheduleElsewhere(simulator, pod)
 		if !canSchedule {
+			if local := localVolume(simulator.volumes.pinnedVolumes(pod)); local != nil {
+				// A local volume never leaves its node, no spot node can take the pod over
+				log.Warn().
+					Str("node", nodeName).
+					Str("pod", podInfo).
+					Str("volume", local.Name).
+					Msg("Pod is bound to a local volume of the node")
+				blockedPods = append(blockedPods, fmt.Sprintf("pod %s is bound to the local volume %s of the node", podInfo, local.Name))
+				continue
+			}
+			if pinned := simulator.volumes.pinnedVolumes(pod); len(pinned) > 0 {
+				log.Warn().
+					Str("node", nodeName).
+					Str("pod", podInfo).
+					Str("volume", describePinnedVolume(pinned[0])).
+					Str("reason", reason).
+					Msg("Pod cannot be rescheduled next to its volume, waiting for a ready spot node in its zone")
+				blockedPods = append(blockedPods, fmt.Sprintf("pod %s cannot be rescheduled next to its volume %s: %s",
+					podInfo, describePinnedVolume(pinned[0]), reason))
+				continue
+			}
 			log.Warn().
 				Str("node", nodeName).
 				Str("pod", podInfo).
 				Str("reason", reason).
 				Msg("Pod cannot be rescheduled elsewhere")
-			return false, fmt.Sprintf("pod %s/%s cannot be rescheduled: %s", pod.Namespace, pod.Name, reason)
+			blockedPods = append(blockedPods, fmt.Sprintf("pod %s cannot be rescheduled: %s", podInfo, reason))
+			continue
 		}
 
 		// Check PodDisruptionBudget
-		if violates, reason := sc.wouldViolatePDB(ctx, &pod); violates {
+		if allowed, reason := budgets.evict(pod); !allowed {
 			log.Warn().
 				Str("node", nodeName).
 				Str("pod", podInfo).
 				Str("reason", reason).
 				Msg("Evicting pod would violate PodDisruptionBudget")
-			return false, fmt.Sprintf("pod %s/%s would violate PDB: %s", pod.Namespace, pod.Name, reason)
+			return false, fmt.Sprintf("pod %s would violate PDB: %s", podInfo, reason)
 		}
 
 		log.Debug().
@@ -149,6 +306,15 @@ func (sc *SafetyChecker) CanSafelyDrainNode(ctx context.Context, nodeName string
 			Msg("Pod can be safely evicted")
 	}
 
+	if len(blockedPods) > 0 {
+		log.Warn().
+			Str("node", nodeName).
+			Int("blockedPods", len(blockedPods)).
+			Int("checked", checkablePodsCount).
+			Msg("Pod safety check failed - some pods cannot be rescheduled")
+		return false, strings.Join(blockedPods, "; ")
+	}
+
 	log.Info().
 		Str("node", nodeName).
 		Int("totalPods", len(pods.Items)).
@@ -158,7 +324,7 @@ func (sc *SafetyChecker) CanSafelyDrainNode(ctx context.Context, nodeName string
 		Msg("Pod safety check passed - all pods can be safely rescheduled")
 
 	// Check cluster capacity buffer
-	hasBuffer, reason := sc.hasClusterCapacityBuffer(ctx, node)
+	hasBuffer, reason := sc.hasClusterCapacityBuffer(ctx, node, maxUtilization)
 	if !hasBuffer {
 		return false, reason
 	}
@@ -176,109 +342,95 @@ func isDaemonSetPod(pod *corev1.Pod) bool {
 	return false
 }
 
-// canPodScheduleElsewhere checks if a pod can be scheduled on other nodes
-func (sc *SafetyChecker) canPodScheduleElsewhere(ctx context.Context, pod *corev1.Pod, excludeNode string) (bool, string) {
-	// Get all nodes
+// newPlacementSimulator snapshots every node and running pod except those of the node being drained,
+// and the volumes of nodePods, the pods of that node which are placed on the others
+func (sc *SafetyChecker) newPlacementSimulator(ctx context.Context, excludeNode string, nodePods []corev1.Pod, spotInstances map[string]bool) (*placementSimulator, error) {
 	nodes, err := sc.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return false, fmt.Sprintf("failed to list nodes: %v", err)
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
 	}
 
-	// Check if any node can fit the pod
-	for _, node := range nodes.Items {
-		// Skip the node we're draining
-		if node.Name == excludeNode {
-			continue
-		}
-
-		// Skip cordoned nodes
-		if node.Spec.Unschedulable {
-			continue
-		}
-
-		// Skip nodes that are not ready
-		if !isNodeReady(&node) {
-			continue
-		}
+	pods, err := sc.k8sClient.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
 
-		// Check if pod fits on this node
-		if canFit, _ := sc.podFitsOnNode(pod, &node); canFit {
-			return true, ""
-		}
+	volumes, err := sc.newVolumeTopology(ctx, nodePods)
+	if err != nil {
+		return nil, err
 	}
 
-	return false, "no suitable node found with sufficient resources"
+	simulator := newPlacementSimulator(nodes.Items, pods.Items, excludeNode)
+	simulator.volumes = volumes
+	simulator.spotInstances = spotInstances
+	return simulator, nil
 }
 
-// podFitsOnNode checks if a pod can fit on a specific node
-func (sc *SafetyChecker) podFitsOnNode(pod *corev1.Pod, node *corev1.Node) (bool, string) {
-	// Get pod resource requests
-	podCPU := int64(0)
-	podMemory := int64(0)
+// newVolumeTopology reads the claims mounted by the pods and the volumes bound to them, one at a time
+// rather than every claim and volume of the cluster. Claims that do not exist yet are left out.
+func (sc *SafetyChecker) newVolumeTopology(ctx context.Context, pods []corev1.Pod) (*volumeTopology, error) {
+	claims := make([]corev1.PersistentVolumeClaim, 0)
+	volumes := make([]corev1.PersistentVolume, 0)
+	seen := make(map[string]bool)
+	for i := range pods {
+		pod := &pods[i]
+		for _, claimName := range podClaimNames(pod) {
+			if seen[pod.Namespace+"/"+claimName] {
+				continue
+			}
+			seen[pod.Namespace+"/"+claimName] = true
 
-	for _, container := range pod.Spec.Containers {
-		if cpu := container.Resources.Requests.Cpu(); cpu != nil {
-			podCPU += cpu.MilliValue()
-		}
-		if memory := container.Resources.Requests.Memory(); memory != nil {
-			podMemory += memory.Value()
+			claim, err := sc.k8sClient.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(ctx, claimName, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get persistent volume claim %s/%s: %w", pod.Namespace, claimName, err)
+			}
+			claims = append(claims, *claim)
+			if claim.Spec.VolumeName == "" {
+				continue
+			}
+
+			volume, err := sc.k8sClient.CoreV1().PersistentVolumes().Get(ctx, claim.Spec.VolumeName, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get persistent volume %s: %w", claim.Spec.VolumeName, err)
+			}
+			volumes = append(volumes, *volume)
 		}
 	}
+	return newVolumeTopology(claims, volumes), nil
+}
 
-	// Get node allocatable resources
-	nodeCPU := node.Status.Allocatable.Cpu().MilliValue()
-	nodeMemory := node.Status.Allocatable.Memory().Value()
-
-	// Simple check (in production, you'd want to account for already scheduled pods)
-	if podCPU > nodeCPU || podMemory > nodeMemory {
-		return false, "insufficient resources"
+// canPodScheduleElsewhere checks if the scheduler would place a pod on one of the remaining nodes,
+// taking into account what is already running there and what earlier evicted pods were placed on
+func (sc *SafetyChecker) canPodScheduleElsewhere(simulator *placementSimulator, pod *corev1.Pod) (bool, string) {
+	targetNode, reason := simulator.place(pod)
+	if targetNode == "" {
+		return false, reason
 	}
 
+	log.Debug().
+		Str("pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)).
+		Str("targetNode", targetNode).
+		Msg("Pod would be rescheduled on node")
 	return true, ""
 }
 
-// wouldViolatePDB checks if evicting the pod would violate a PodDisruptionBudget
-func (sc *SafetyChecker) wouldViolatePDB(ctx context.Context, pod *corev1.Pod) (bool, string) {
-	// Get all PDBs in the pod's namespace
-	pdbs, err := sc.k8sClient.PolicyV1().PodDisruptionBudgets(pod.Namespace).List(ctx, metav1.ListOptions{})
+// newDisruptionBudgets snapshots every PodDisruptionBudget of the cluster
+func (sc *SafetyChecker) newDisruptionBudgets(ctx context.Context) (*disruptionBudgets, error) {
+	pdbs, err := sc.k8sClient.PolicyV1().PodDisruptionBudgets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		log.Warn().Err(err).Msg("Failed to list PDBs, assuming no PDB")
-		return false, ""
-	}
-
-	// Find PDB that matches this pod
-	for _, pdb := range pdbs.Items {
-		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
-		if err != nil {
-			continue
-		}
-
-		if selector.Matches(labels.Set(pod.Labels)) {
-			// Found matching PDB
-			if violates := sc.checkPDBViolation(&pdb, pod); violates {
-				return true, fmt.Sprintf("PDB %s would be violated", pdb.Name)
-			}
-		}
-	}
-
-	return false, ""
-}
-
-// checkPDBViolation checks if evicting would violate the PDB
-func (sc *SafetyChecker) checkPDBViolation(pdb *policyv1.PodDisruptionBudget, pod *corev1.Pod) bool {
-	// If DisruptionsAllowed is 0, we cannot evict
-	if pdb.Status.DisruptionsAllowed <= 0 {
-		log.Debug().
-			Str("pdb", pdb.Name).
-			Int32("disruptionsAllowed", pdb.Status.DisruptionsAllowed).
-			Msg("PDB disallows disruptions")
-		return true
+		return nil, fmt.Errorf("failed to list PodDisruptionBudgets: %w", err)
 	}
-	return false
+	return newDisruptionBudgets(pdbs.Items, sc.drainTimeout), nil
 }
 
 // hasClusterCapacityBuffer checks if cluster has sufficient capacity buffer
-func (sc *SafetyChecker) hasClusterCapacityBuffer(ctx context.Context, nodeToRemove *corev1.Node) (bool, string) {
+func (sc *SafetyChecker) hasClusterCapacityBuffer(ctx context.Context, nodeToRemove *corev1.Node, maxAllowed float64) (bool, string) {
 	// Get all nodes
 	nodes, err := sc.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
@@ -296,6 +448,10 @@ func (sc *SafetyChecker) hasClusterCapacityBuffer(ctx context.Context, nodeToRem
 		if !isNodeReady(&node) {
 			continue
 		}
+		// Skip nodes another drain is already emptying, their capacity is going away as well
+		if node.Name != nodeToRemove.Name && isNodeBeingDrained(&node) {
+			continue
+		}
 
 		totalCPU += node.Status.Allocatable.Cpu().MilliValue()
 		totalMemory += node.Status.Allocatable.Memory().Value()
@@ -339,16 +495,25 @@ func (sc *SafetyChecker) hasClusterCapacityBuffer(ctx context.Context, nodeToRem
 	log.Debug().
 		Float64("cpuUtilization", cpuUtilization).
 		Float64("memoryUtilization", memoryUtilization).
-		Float64("maxAllowed", sc.maxUtilization).
+		Float64("maxAllowed", maxAllowed).
 		Msg("Cluster capacity buffer check")
 
-	if maxUtilization > sc.maxUtilization {
-		return false, "Cluster utilization too high"
+	if maxUtilization > maxAllowed {
+		return false, ReasonClusterUtilizationTooHigh
 	}
 
 	return true, ""
 }
 
+// isNodeBeingDrained checks if a node is cordoned or marked for scale-down by Spot Guard
+func isNodeBeingDrained(node *corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return true
+	}
+	_, marked := node.Annotations[AnnotationScaleDownDone]
+	return marked
+}
+
 // isNodeReady checks if a node is in Ready state
 func isNodeReady(node *corev1.Node) bool {
 	for _, condition := range node.Status.Conditions {
@@ -15,39 +15,68 @@ package spotguard
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-node-termination-handler/pkg/node"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
-	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/rs/zerolog/log"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
+// ScaleDownPendingTaint is applied to an on-demand node before it is drained and terminated
+const ScaleDownPendingTaint = "spotguard/scale-down-pending"
+
+const (
+	// terminationVerifyTimeout is how long the drained instance gets to start terminating
+	terminationVerifyTimeout = 2 * time.Minute
+	// terminationCheckInterval is how often the on-demand ASG is described while verifying the termination
+	terminationCheckInterval = 10 * time.Second
+	// restoreNodeTimeout bounds restoring a node after a failed scale-down, which runs even when the drain was cancelled
+	restoreNodeTimeout = 30 * time.Second
+)
+
 // ScaleDownExecutor handles the execution of scaling down on-demand nodes
 type ScaleDownExecutor struct {
-	asgClient          autoscalingiface.AutoScalingAPI
+	provider           CapacityProvider
 	k8sClient          kubernetes.Interface
 	nodeHandler        node.Node
 	podEvictionTimeout time.Duration
+	recorder           observability.K8sEventRecorder
+	dryRun             bool
+	ledger             *CostLedger
+	tracker            *FallbackTracker
+	verifyTimeout      time.Duration
+	verifyInterval     time.Duration
 }
 
 // NewScaleDownExecutor creates a new scale-down executor
 func NewScaleDownExecutor(
-	asgClient autoscalingiface.AutoScalingAPI,
+	provider CapacityProvider,
 	k8sClient kubernetes.Interface,
 	nodeHandler node.Node,
 	podEvictionTimeout time.Duration,
+	recorder observability.K8sEventRecorder,
+	dryRun bool,
+	ledger *CostLedger,
 ) *ScaleDownExecutor {
 	return &ScaleDownExecutor{
-		asgClient:          asgClient,
+		provider:           provider,
 		k8sClient:          k8sClient,
 		nodeHandler:        nodeHandler,
 		podEvictionTimeout: podEvictionTimeout,
+		recorder:           recorder,
+		dryRun:             dryRun,
+		ledger:             ledger,
+		verifyTimeout:      terminationVerifyTimeout,
+		verifyInterval:     terminationCheckInterval,
 	}
 }
 
@@ -64,6 +93,36 @@ func (se *ScaleDownExecutor) ScaleDownOnDemandNode(ctx context.Context, event *F
 		Dur("onDemandRuntime", time.Since(event.Timestamp)).
 		Msg("Starting on-demand node scale-down operation")
 
+	// Resolve the exact instance to terminate before touching the node
+	instanceID, err := se.resolveInstanceID(ctx, event)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("eventID", event.EventID).
+			Str("node", nodeName).
+			Msg("Failed to resolve instance ID of on-demand node")
+		return fmt.Errorf("failed to resolve instance ID: %w", err)
+	}
+
+	if se.dryRun {
+		log.Info().
+			Str("eventID", event.EventID).
+			Str("node", nodeName).
+			Str("instanceID", instanceID).
+			Str("onDemandASG", event.OnDemandASGName).
+			Msg("Node would have been tainted, drained and terminated, but dry-run flag was set")
+		se.recorder.Emit(nodeName, observability.Normal, observability.SpotGuardDryRunReason, observability.SpotGuardDryRunMsgFmt,
+			fmt.Sprintf("drained node and terminated instance %s in ASG %s", instanceID, event.OnDemandASGName))
+		return nil
+	}
+
+	// Remember what is being retired for the cost ledger, the node is gone once it was terminated
+	retired := *event
+	retired.OnDemandInstanceID = instanceID
+	if retired.OnDemandInstanceType == "" {
+		retired.OnDemandInstanceType = se.nodeInstanceType(ctx, nodeName)
+	}
+
 	// Step 1: Taint the node
 	log.Info().Str("eventID", event.EventID).Str("node", nodeName).Msg("Step 1/5: Tainting node")
 	if err := se.taintNode(ctx, nodeName); err != nil {
@@ -72,6 +131,7 @@ func (se *ScaleDownExecutor) ScaleDownOnDemandNode(ctx context.Context, event *F
 			Str("eventID", event.EventID).
 			Str("node", nodeName).
 			Msg("Failed to taint node")
+		se.recorder.Emit(nodeName, observability.Warning, observability.SpotGuardScaleDownErrReason, observability.SpotGuardScaleDownErrMsgFmt, err.Error())
 		return fmt.Errorf("failed to taint node: %w", err)
 	}
 
@@ -83,6 +143,8 @@ func (se *ScaleDownExecutor) ScaleDownOnDemandNode(ctx context.Context, event *F
 			Str("eventID", event.EventID).
 			Str("node", nodeName).
 			Msg("Failed to cordon node")
+		se.recorder.Emit(nodeName, observability.Warning, observability.SpotGuardScaleDownErrReason, observability.SpotGuardScaleDownErrMsgFmt, err.Error())
+		se.restoreNode(nodeName)
 		return fmt.Errorf("failed to cordon node: %w", err)
 	}
 
@@ -94,6 +156,8 @@ func (se *ScaleDownExecutor) ScaleDownOnDemandNode(ctx context.Context, event *F
 			Str("eventID", event.EventID).
 			Str("node", nodeName).
 			Msg("Failed to drain node")
+		se.recorder.Emit(nodeName, observability.Warning, observability.SpotGuardScaleDownErrReason, observability.SpotGuardScaleDownErrMsgFmt, err.Error())
+		se.restoreNode(nodeName)
 		return fmt.Errorf("failed to drain node: %w", err)
 	}
 
@@ -107,25 +171,42 @@ func (se *ScaleDownExecutor) ScaleDownOnDemandNode(ctx context.Context, event *F
 			Msg("Warning: could not verify all pods rescheduled, continuing anyway")
 		// Continue anyway as pods might have been rescheduled
 	}
+	// The spot capacity pre-scaled for this drain now runs its pods and may be consolidated like any other
+	releaseDrainProtection(ctx, se.provider, event.SpotASGName)
 
-	// Step 5: Scale down the on-demand ASG
+	// Step 5: Terminate this exact instance and decrement the on-demand ASG
 	log.Info().
 		Str("eventID", event.EventID).
 		Str("asg", event.OnDemandASGName).
-		Msg("Step 5/5: Scaling down on-demand ASG")
-	if err := se.decreaseASGCapacity(ctx, event.OnDemandASGName); err != nil {
+		Str("instanceID", instanceID).
+		Msg("Step 5/5: Terminating drained instance in on-demand ASG")
+	if err := se.terminateInstance(ctx, event.OnDemandASGName, instanceID); err != nil {
 		log.Error().
 			Err(err).
 			Str("eventID", event.EventID).
 			Str("asg", event.OnDemandASGName).
+			Str("instanceID", instanceID).
 			Msg("Failed to scale down on-demand ASG")
+		if errors.Is(err, ErrTerminationTimeout) {
+			// The termination was accepted, the instance may still be on its way out. The node stays cordoned
+			// and tainted until a later check finds the instance terminating or the scale-down marker stale.
+			se.recorder.Emit(nodeName, observability.Warning, observability.SpotGuardScaleDownTimeoutReason,
+				observability.SpotGuardScaleDownTimeoutMsgFmt, instanceID, event.OnDemandASGName, err.Error())
+		} else {
+			se.restoreNode(nodeName)
+			se.recorder.Emit(nodeName, observability.Warning, observability.SpotGuardScaleDownErrReason, observability.SpotGuardScaleDownErrMsgFmt, err.Error())
+		}
 		return fmt.Errorf("failed to scale down ASG: %w", err)
 	}
+	se.recorder.Emit(nodeName, observability.Normal, observability.SpotGuardScaleDownVerifiedReason,
+		observability.SpotGuardScaleDownVerifiedMsgFmt, instanceID, event.OnDemandASGName)
+	se.ledger.Record(ctx, &retired, time.Now())
+	se.tracker.completeFallback(&retired)
 
 	log.Info().
 		Str("eventID", event.EventID).
 		Str("node", nodeName).
-		Str("instanceID", event.OnDemandInstanceID).
+		Str("instanceID", instanceID).
 		Str("onDemandASG", event.OnDemandASGName).
 		Dur("totalRuntime", time.Since(event.Timestamp)).
 		Msg("Successfully completed on-demand node scale-down operation")
@@ -133,6 +214,21 @@ func (se *ScaleDownExecutor) ScaleDownOnDemandNode(ctx context.Context, event *F
 	return nil
 }
 
+// recordScaleDown records how a scale-down ended and, when it succeeded, how long the on-demand node ran.
+// A dry-run scale-down retired nothing, so it has no runtime.
+func (se *ScaleDownExecutor) recordScaleDown(metrics observability.Metrics, event *FallbackEvent, err error) {
+	if err == nil && se.dryRun {
+		metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeDryRun, ScaleDownReasonCompleted)
+		return
+	}
+	if err != nil {
+		metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeFailure, ScaleDownReasonExecutionFailed)
+		return
+	}
+	metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeSuccess, ScaleDownReasonCompleted)
+	metrics.SpotGuardOnDemandRuntimeRecord(event.OnDemandASGName, time.Since(event.Timestamp))
+}
+
 // taintNode applies a taint to the node
 func (se *ScaleDownExecutor) taintNode(ctx context.Context, nodeName string) error {
 	log.Debug().Str("node", nodeName).Msg("Getting node to apply taint")
@@ -145,7 +241,7 @@ func (se *ScaleDownExecutor) taintNode(ctx context.Context, nodeName string) err
 
 	// Add taint
 	taint := corev1.Taint{
-		Key:    "spotguard/scale-down-pending",
+		Key:    ScaleDownPendingTaint,
 		Value:  "true",
 		Effect: corev1.TaintEffectNoSchedule,
 	}
@@ -167,8 +263,16 @@ func (se *ScaleDownExecutor) taintNode(ctx context.Context, nodeName string) err
 			Str("effect", string(taint.Effect)).
 			Msg("Applying taint to node")
 
-		node.Spec.Taints = append(node.Spec.Taints, taint)
-		_, err = se.k8sClient.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		// A JSON patch appends the taint without rewriting the node; an untainted node must still be
+		// untainted, so a taint added meanwhile by someone else is not replaced
+		patchReqs := []taintPatchRequest{{Op: "add", Path: "/spec/taints/-", Value: taint}}
+		if len(node.Spec.Taints) == 0 {
+			patchReqs = []taintPatchRequest{
+				{Op: "test", Path: "/spec/taints", Value: nil},
+				{Op: "add", Path: "/spec/taints", Value: []corev1.Taint{taint}},
+			}
+		}
+		err = se.patchTaints(ctx, nodeName, patchReqs)
 		if err != nil {
 			log.Error().Err(err).Str("node", nodeName).Msg("Failed to update node with taint")
 			return err
@@ -252,40 +356,48 @@ func (se *ScaleDownExecutor) waitForPodsRescheduled(ctx context.Context, nodeNam
 	}
 }
 
-// decreaseASGCapacity decreases the desired capacity of the ASG by 1
-func (se *ScaleDownExecutor) decreaseASGCapacity(ctx context.Context, asgName string) error {
-	log.Debug().Str("asg", asgName).Msg("Getting current ASG capacity")
-
-	// Get current ASG configuration
-	input := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{aws.String(asgName)},
-	}
+// terminateInstance terminates the given instance and decrements the ASG desired capacity in one call,
+// so the ASG termination policy never gets to pick a different victim
+func (se *ScaleDownExecutor) terminateInstance(ctx context.Context, asgName string, instanceID string) error {
+	log.Debug().Str("asg", asgName).Str("instanceID", instanceID).Msg("Getting current ASG state before termination")
 
-	result, err := se.asgClient.DescribeAutoScalingGroupsWithContext(ctx, input)
+	asg, err := se.provider.DescribePool(ctx, asgName)
 	if err != nil {
 		log.Error().Err(err).Str("asg", asgName).Msg("Failed to describe ASG")
-		return fmt.Errorf("failed to describe ASG: %w", err)
-	}
-
-	if len(result.AutoScalingGroups) == 0 {
-		log.Error().Str("asg", asgName).Msg("ASG not found")
-		return fmt.Errorf("ASG %s not found", asgName)
+		return err
 	}
 
-	asg := result.AutoScalingGroups[0]
-	currentDesired := aws.Int64Value(asg.DesiredCapacity)
-	minSize := aws.Int64Value(asg.MinSize)
-	maxSize := aws.Int64Value(asg.MaxSize)
-	currentInstances := int64(len(asg.Instances))
+	currentDesired := asg.DesiredCapacity
+	minSize := asg.MinSize
+	maxSize := asg.MaxSize
+	statesBefore := instanceLifecycleStates(asg)
 
 	log.Debug().
 		Str("asg", asgName).
 		Int64("currentDesired", currentDesired).
-		Int64("currentInstances", currentInstances).
+		Int("currentInstances", len(asg.Instances)).
 		Int64("min", minSize).
 		Int64("max", maxSize).
 		Msg("Current ASG capacity")
 
+	targetState, inASG := statesBefore[instanceID]
+	if !inASG {
+		log.Error().
+			Str("asg", asgName).
+			Str("instanceID", instanceID).
+			Msg("Instance is not a member of the ASG")
+		return fmt.Errorf("%w: %s not in %s", ErrInstanceNotInASG, instanceID, asgName)
+	}
+
+	if isTerminatingState(targetState) {
+		log.Info().
+			Str("asg", asgName).
+			Str("instanceID", instanceID).
+			Str("lifecycleState", targetState).
+			Msg("Instance is already terminating, nothing to do")
+		return nil
+	}
+
 	newDesired := currentDesired - 1
 	if newDesired < minSize {
 		log.Error().
@@ -298,50 +410,353 @@ func (se *ScaleDownExecutor) decreaseASGCapacity(ctx context.Context, asgName st
 
 	log.Info().
 		Str("asg", asgName).
+		Str("instanceID", instanceID).
 		Int64("oldDesired", currentDesired).
 		Int64("newDesired", newDesired).
-		Msg("Updating ASG desired capacity")
+		Msg("Terminating instance and decrementing ASG desired capacity")
 
-	// Update ASG desired capacity
-	updateInput := &autoscaling.SetDesiredCapacityInput{
-		AutoScalingGroupName: aws.String(asgName),
-		DesiredCapacity:      aws.Int64(newDesired),
-		HonorCooldown:        aws.Bool(false),
-	}
-
-	_, err = se.asgClient.SetDesiredCapacityWithContext(ctx, updateInput)
-	if err != nil {
+	if err := se.provider.TerminateInstance(ctx, asgName, instanceID); err != nil {
 		log.Error().
 			Err(err).
 			Str("asg", asgName).
-			Int64("desiredCapacity", newDesired).
-			Msg("Failed to update ASG capacity")
-		return fmt.Errorf("failed to update ASG capacity: %w", err)
+			Str("instanceID", instanceID).
+			Msg("Failed to terminate instance in ASG")
+		return err
 	}
 
-	// Verify the capacity was actually updated (protection against race conditions)
-	// Wait a short time for AWS to process the update
-	time.Sleep(2 * time.Second)
-
-	verifyResult, err := se.asgClient.DescribeAutoScalingGroupsWithContext(ctx, input)
-	if err != nil {
-		log.Warn().Err(err).Str("asg", asgName).Msg("Failed to verify ASG capacity update, assuming success")
-	} else if len(verifyResult.AutoScalingGroups) > 0 {
-		verifiedDesired := aws.Int64Value(verifyResult.AutoScalingGroups[0].DesiredCapacity)
-		if verifiedDesired != newDesired {
-			log.Warn().
-				Str("asg", asgName).
-				Int64("expectedCapacity", newDesired).
-				Int64("actualCapacity", verifiedDesired).
-				Msg("ASG capacity verification mismatch - possible concurrent modification")
-		}
+	if err := se.verifyTermination(ctx, asgName, instanceID, statesBefore, newDesired); err != nil {
+		return err
 	}
 
 	log.Info().
 		Str("asg", asgName).
+		Str("instanceID", instanceID).
 		Int64("oldCapacity", currentDesired).
 		Int64("newCapacity", newDesired).
 		Msg("Successfully scaled down on-demand ASG")
 
 	return nil
 }
+
+// verifyTermination confirms the drained instance is the one terminating. Any other instance that
+// started terminating in the meantime and was not retired by Spot Guard itself was never drained,
+// so the desired capacity it took with it is restored to let the ASG replace it.
+// Concurrent Spot Guard drains lowered the desired capacity on purpose and are left alone.
+func (se *ScaleDownExecutor) verifyTermination(
+	ctx context.Context,
+	asgName string,
+	instanceID string,
+	statesBefore map[string]string,
+	expectedDesired int64,
+) error {
+	maxWaitTime := se.verifyTimeout
+	startTime := time.Now()
+
+	ticker := time.NewTicker(se.verifyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		asg, err := se.provider.DescribePool(ctx, asgName)
+		if err != nil {
+			log.Warn().Err(err).Str("asg", asgName).Msg("Failed to describe ASG during termination verification")
+			if time.Since(startTime) >= maxWaitTime {
+				return fmt.Errorf("%w: could not describe ASG to verify instance %s: %v", ErrTerminationTimeout, instanceID, err)
+			}
+			continue
+		}
+
+		statesNow := instanceLifecycleStates(asg)
+		targetState, stillInASG := statesNow[instanceID]
+		targetTerminating := !stillInASG || isTerminatingState(targetState)
+		unexpected, concurrent := se.splitSpotGuardTerminations(ctx, unexpectedTerminations(statesBefore, statesNow, instanceID))
+		// Each concurrent Spot Guard drain took one off the desired capacity, which must stay off
+		repairedDesired := expectedDesired - int64(len(concurrent))
+
+		if targetTerminating {
+			log.Info().
+				Str("asg", asgName).
+				Str("instanceID", instanceID).
+				Str("lifecycleState", targetState).
+				Dur("elapsed", time.Since(startTime)).
+				Msg("Confirmed drained instance is terminating")
+
+			if len(unexpected) > 0 {
+				se.repairUnexpectedTerminations(ctx, asg, unexpected, repairedDesired)
+			}
+			return nil
+		}
+
+		if time.Since(startTime) >= maxWaitTime {
+			if len(unexpected) > 0 {
+				// Our instance survived but someone else's did not: give the ASG its capacity back
+				se.repairUnexpectedTerminations(ctx, asg, unexpected, repairedDesired+1)
+				return fmt.Errorf("%w: expected %s, got %v", ErrWrongInstanceTerminated, instanceID, unexpected)
+			}
+			return fmt.Errorf("%w: instance %s (state: %s)", ErrTerminationTimeout, instanceID, targetState)
+		}
+
+		log.Debug().
+			Str("asg", asgName).
+			Str("instanceID", instanceID).
+			Str("lifecycleState", targetState).
+			Dur("elapsed", time.Since(startTime)).
+			Msg("Waiting for drained instance to start terminating")
+	}
+}
+
+// repairUnexpectedTerminations raises the desired capacity back to the expected value so the ASG
+// launches replacements for undrained instances that were terminated alongside (or instead of) ours
+func (se *ScaleDownExecutor) repairUnexpectedTerminations(
+	ctx context.Context,
+	asg *CapacityPool,
+	unexpected []string,
+	expectedDesired int64,
+) {
+	asgName := asg.Name
+	currentDesired := asg.DesiredCapacity
+
+	log.Error().
+		Str("asg", asgName).
+		Strs("instanceIDs", unexpected).
+		Int64("currentDesired", currentDesired).
+		Int64("expectedDesired", expectedDesired).
+		Msg("Undrained instances were terminated during on-demand scale-down")
+
+	if currentDesired >= expectedDesired {
+		log.Info().
+			Str("asg", asgName).
+			Msg("ASG desired capacity already covers the terminated instances, replacements will be launched")
+		return
+	}
+
+	if err := se.provider.SetDesiredCapacity(ctx, asgName, expectedDesired); err != nil {
+		log.Error().
+			Err(err).
+			Str("asg", asgName).
+			Int64("desiredCapacity", expectedDesired).
+			Msg("Failed to restore ASG desired capacity after unexpected termination")
+		return
+	}
+
+	log.Warn().
+		Str("asg", asgName).
+		Int64("oldDesired", currentDesired).
+		Int64("newDesired", expectedDesired).
+		Msg("Restored ASG desired capacity to replace unexpectedly terminated instances")
+}
+
+// splitSpotGuardTerminations splits instances that started terminating into the ones nobody drained
+// and the ones retired by another Spot Guard drain, whose node carries the scale-down marker or taint.
+// When the nodes cannot be listed none are reported unexpected, launching capacity that may not be
+// needed is worse than leaving a replacement to the ASG health checks.
+func (se *ScaleDownExecutor) splitSpotGuardTerminations(ctx context.Context, terminating []string) ([]string, []string) {
+	unexpected := make([]string, 0)
+	concurrent := make([]string, 0)
+	if len(terminating) == 0 {
+		return unexpected, concurrent
+	}
+
+	nodes, err := se.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Strs("instanceIDs", terminating).
+			Msg("Failed to list nodes, not treating other terminating instances as unexpected")
+		return unexpected, concurrent
+	}
+
+	retiredBySpotGuard := make(map[string]bool)
+	for i := range nodes.Items {
+		if isSpotGuardScaleDown(&nodes.Items[i]) {
+			retiredBySpotGuard[extractInstanceIDFromProviderID(nodes.Items[i].Spec.ProviderID)] = true
+		}
+	}
+
+	for _, instanceID := range terminating {
+		if retiredBySpotGuard[instanceID] {
+			concurrent = append(concurrent, instanceID)
+			continue
+		}
+		unexpected = append(unexpected, instanceID)
+	}
+	if len(concurrent) > 0 {
+		log.Info().
+			Strs("instanceIDs", concurrent).
+			Msg("Other instances are terminating for concurrent Spot Guard scale-downs")
+	}
+	return unexpected, concurrent
+}
+
+// isSpotGuardScaleDown returns true for a node Spot Guard started retiring: the controller and the
+// self-monitor mark it before draining, and the executor taints it
+func isSpotGuardScaleDown(node *corev1.Node) bool {
+	if _, marked := node.Annotations[AnnotationScaleDownDone]; marked {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == ScaleDownPendingTaint {
+			return true
+		}
+	}
+	return false
+}
+
+// taintPatchRequest is one operation of a JSON patch of the node taints
+type taintPatchRequest struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// patchTaints applies a JSON patch to the taints of a node
+func (se *ScaleDownExecutor) patchTaints(ctx context.Context, nodeName string, patchReqs []taintPatchRequest) error {
+	patch, err := json.Marshal(patchReqs)
+	if err != nil {
+		return fmt.Errorf("failed to build taint patch: %w", err)
+	}
+	if _, err := se.k8sClient.CoreV1().Nodes().Patch(ctx, nodeName, types.JSONPatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to patch node taints: %w", err)
+	}
+	return nil
+}
+
+// staleScaleDownMarker returns true when a scale-down marker is older than a drain can take: the pod
+// eviction, waiting for the pods to be rescheduled and the termination verification. An unreadable
+// marker is stale as well.
+func (se *ScaleDownExecutor) staleScaleDownMarker(marked string) bool {
+	markedAt, err := time.Parse(time.RFC3339, marked)
+	if err != nil {
+		return true
+	}
+	return time.Since(markedAt) > 2*se.podEvictionTimeout+terminationVerifyTimeout
+}
+
+// instanceTerminating returns true when the instance is terminating or already gone from the pool
+func (se *ScaleDownExecutor) instanceTerminating(ctx context.Context, poolName string, instanceID string) (bool, error) {
+	pool, err := se.provider.DescribePool(ctx, poolName)
+	if err != nil {
+		return false, fmt.Errorf("failed to describe pool %s: %w", poolName, err)
+	}
+	state, exists := instanceLifecycleStates(pool)[instanceID]
+	return !exists || isTerminatingState(state), nil
+}
+
+// restoreNode removes the scale-down taint and uncordons the node after a failed scale-down,
+// so a node that is not going away does not stay unschedulable forever.
+// It does not use the drain context: a drain cancelled by a lost leadership or a shutdown must still restore the node.
+func (se *ScaleDownExecutor) restoreNode(nodeName string) {
+	log.Info().Str("node", nodeName).Msg("Restoring node after failed scale-down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), restoreNodeTimeout)
+	defer cancel()
+
+	node, err := se.k8sClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		log.Error().Err(err).Str("node", nodeName).Msg("Failed to get node for restore")
+		return
+	}
+
+	for i, taint := range node.Spec.Taints {
+		if taint.Key != ScaleDownPendingTaint {
+			continue
+		}
+		// The patch only removes the taint while it is still at the index it was read at
+		path := fmt.Sprintf("/spec/taints/%d", i)
+		patchReqs := []taintPatchRequest{
+			{Op: "test", Path: path + "/key", Value: ScaleDownPendingTaint},
+			{Op: "remove", Path: path},
+		}
+		if err := se.patchTaints(ctx, nodeName, patchReqs); err != nil {
+			log.Error().Err(err).Str("node", nodeName).Msg("Failed to remove scale-down taint from node")
+		}
+		break
+	}
+
+	if err := se.nodeHandler.Uncordon(nodeName); err != nil {
+		log.Error().Err(err).Str("node", nodeName).Msg("Failed to uncordon node")
+		return
+	}
+
+	log.Info().Str("node", nodeName).Msg("Node restored to schedulable state")
+}
+
+// resolveInstanceID returns the instance ID of the event, falling back to the node's providerID
+func (se *ScaleDownExecutor) resolveInstanceID(ctx context.Context, event *FallbackEvent) (string, error) {
+	if event.OnDemandInstanceID != "" {
+		return event.OnDemandInstanceID, nil
+	}
+
+	node, err := se.k8sClient.CoreV1().Nodes().Get(ctx, event.OnDemandNodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get node: %w", err)
+	}
+
+	instanceID := extractInstanceIDFromProviderID(node.Spec.ProviderID)
+	if instanceID == "" {
+		return "", fmt.Errorf("no instance ID in providerID %q of node %s", node.Spec.ProviderID, event.OnDemandNodeName)
+	}
+	return instanceID, nil
+}
+
+// nodeForInstance returns the name of the node running the instance, "" when it has not registered
+func (se *ScaleDownExecutor) nodeForInstance(ctx context.Context, instanceID string) (string, error) {
+	nodes, err := se.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list nodes: %w", err)
+	}
+	for _, node := range nodes.Items {
+		if extractInstanceIDFromProviderID(node.Spec.ProviderID) == instanceID {
+			return node.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// nodeInstanceType returns the instance type label of a node, or "" when it cannot be read
+func (se *ScaleDownExecutor) nodeInstanceType(ctx context.Context, nodeName string) string {
+	node, err := se.k8sClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		log.Warn().Err(err).Str("node", nodeName).Msg("Failed to get instance type of node for the cost ledger")
+		return ""
+	}
+	if instanceType, found := node.Labels[corev1.LabelInstanceTypeStable]; found {
+		return instanceType
+	}
+	return node.Labels[corev1.LabelInstanceType]
+}
+
+// instanceLifecycleStates maps each instance in the ASG to its lifecycle state
+func instanceLifecycleStates(asg *CapacityPool) map[string]string {
+	states := make(map[string]string, len(asg.Instances))
+	for _, instance := range asg.Instances {
+		states[instance.InstanceID] = instance.LifecycleState
+	}
+	return states
+}
+
+// unexpectedTerminations returns instances other than target that started terminating since statesBefore
+func unexpectedTerminations(statesBefore, statesNow map[string]string, target string) []string {
+	unexpected := make([]string, 0)
+	for instanceID, before := range statesBefore {
+		if instanceID == target || isTerminatingState(before) {
+			continue
+		}
+		now, exists := statesNow[instanceID]
+		if !exists || isTerminatingState(now) {
+			unexpected = append(unexpected, instanceID)
+		}
+	}
+	return unexpected
+}
+
+// isTerminatingState returns true for any of the ASG terminating lifecycle states
+func isTerminatingState(lifecycleState string) bool {
+	return strings.HasPrefix(lifecycleState, autoscaling.LifecycleStateTerminating) ||
+		lifecycleState == autoscaling.LifecycleStateTerminated
+}
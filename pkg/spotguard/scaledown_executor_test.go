@@ -0,0 +1,187 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubectl/pkg/drain"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	"github.com/aws/aws-node-termination-handler/pkg/node"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+// terminatingPool is a capacity provider whose pool is described as before until an instance is terminated, then as after
+type terminatingPool struct {
+	before     *CapacityPool
+	after      *CapacityPool
+	terminated []string
+	desired    []int64
+}
+
+func (p *terminatingPool) DescribePool(_ context.Context, _ string) (*CapacityPool, error) {
+	if len(p.terminated) == 0 {
+		return p.before, nil
+	}
+	return p.after, nil
+}
+
+func (p *terminatingPool) SetDesiredCapacity(_ context.Context, _ string, desired int64) error {
+	p.desired = append(p.desired, desired)
+	return nil
+}
+
+func (p *terminatingPool) ScalingFailure(_ context.Context, _ string, _ time.Time) (*ScalingFailureError, error) {
+	return nil, nil
+}
+
+func (p *terminatingPool) TerminateInstance(_ context.Context, _ string, instanceID string) error {
+	p.terminated = append(p.terminated, instanceID)
+	return nil
+}
+
+// onDemandPool returns an on-demand ASG with the instances in the given lifecycle states
+func onDemandPool(desired int64, states map[string]string) *CapacityPool {
+	pool := &CapacityPool{Name: "od-asg", DesiredCapacity: desired, MaxSize: 10}
+	for instanceID, state := range states {
+		pool.Instances = append(pool.Instances, PoolInstance{InstanceID: instanceID, LifecycleState: state, HealthStatus: InstanceHealthy})
+	}
+	return pool
+}
+
+// testNodeHandler returns a node handler that cordons and uncordons the nodes of clientset
+func testNodeHandler(clientset kubernetes.Interface) node.Node {
+	nodeHandler, _ := node.NewWithValues(config.Config{}, &drain.Helper{Ctx: context.Background(), Client: clientset}, nil)
+	return *nodeHandler
+}
+
+func testExecutor(provider CapacityProvider, nodes ...*corev1.Node) *ScaleDownExecutor {
+	clientset := fake.NewSimpleClientset()
+	for _, testNode := range nodes {
+		_ = clientset.Tracker().Add(testNode)
+	}
+	executor := NewScaleDownExecutor(provider, clientset, testNodeHandler(clientset), time.Minute, observability.K8sEventRecorder{}, false, nil)
+	executor.verifyInterval = time.Millisecond
+	executor.verifyTimeout = 50 * time.Millisecond
+	return executor
+}
+
+func TestTerminateInstanceOnlyTarget(t *testing.T) {
+	provider := &terminatingPool{
+		before: onDemandPool(3, map[string]string{"i-1": "InService", "i-2": "InService", "i-3": "InService"}),
+		after:  onDemandPool(2, map[string]string{"i-1": "Terminating", "i-2": "InService", "i-3": "InService"}),
+	}
+	executor := testExecutor(provider, testNode("od-1", "i-1", nil), testNode("od-2", "i-2", nil))
+
+	h.Ok(t, executor.terminateInstance(context.Background(), "od-asg", "i-1"))
+	h.Equals(t, []string{"i-1"}, provider.terminated)
+	h.Equals(t, 0, len(provider.desired))
+}
+
+func TestTerminateInstanceConcurrentSpotGuardDrain(t *testing.T) {
+	provider := &terminatingPool{
+		before: onDemandPool(3, map[string]string{"i-1": "InService", "i-2": "InService", "i-3": "InService"}),
+		after:  onDemandPool(1, map[string]string{"i-1": "Terminating", "i-2": "Terminating:Wait", "i-3": "InService"}),
+	}
+	draining := testNode("od-2", "i-2", map[string]string{AnnotationScaleDownDone: time.Now().Format(time.RFC3339)})
+	executor := testExecutor(provider, testNode("od-1", "i-1", nil), draining)
+
+	h.Ok(t, executor.terminateInstance(context.Background(), "od-asg", "i-1"))
+	// The other drain lowered the desired capacity on purpose, nothing is launched back
+	h.Equals(t, 0, len(provider.desired))
+}
+
+func TestTerminateInstanceRepairsExtraTermination(t *testing.T) {
+	provider := &terminatingPool{
+		before: onDemandPool(4, map[string]string{"i-1": "InService", "i-2": "InService", "i-3": "InService", "i-4": "InService"}),
+		// i-2 is retired by another Spot Guard drain, i-3 was terminated by nobody we know of
+		after: onDemandPool(1, map[string]string{"i-1": "Terminating", "i-2": "Terminating", "i-3": "Terminating", "i-4": "InService"}),
+	}
+	draining := testNode("od-2", "i-2", nil)
+	draining.Spec.Taints = []corev1.Taint{{Key: ScaleDownPendingTaint, Value: "true", Effect: corev1.TaintEffectNoSchedule}}
+	executor := testExecutor(provider, testNode("od-1", "i-1", nil), draining, testNode("od-3", "i-3", nil))
+
+	h.Ok(t, executor.terminateInstance(context.Background(), "od-asg", "i-1"))
+	// 4 minus our drain minus the concurrent one, i-3 gets a replacement
+	h.Equals(t, []int64{2}, provider.desired)
+}
+
+func TestMonitorResolveOnDemandNode(t *testing.T) {
+	tracker := NewFallbackTracker()
+	registered := &FallbackEvent{EventID: "registered", Timestamp: time.Now(), OnDemandInstanceID: "i-1"}
+	pending := &FallbackEvent{EventID: "pending", Timestamp: time.Now(), OnDemandInstanceID: "i-2"}
+	unknown := &FallbackEvent{EventID: "unknown", Timestamp: time.Now()}
+	for _, event := range []*FallbackEvent{registered, pending, unknown} {
+		h.Ok(t, tracker.AddEvent(event))
+	}
+	monitor := &Monitor{tracker: tracker, scaleDownExecutor: testExecutor(&terminatingPool{}, testNode("od-1", "i-1", nil))}
+
+	h.Assert(t, monitor.resolveOnDemandNode(context.Background(), registered), "expected the node of i-1 to be found")
+	h.Equals(t, "od-1", registered.OnDemandNodeName)
+	stored, _ := tracker.GetEvent("registered")
+	h.Equals(t, "od-1", stored.OnDemandNodeName)
+
+	// Instances without a node, and events without an instance, are skipped
+	h.Assert(t, !monitor.resolveOnDemandNode(context.Background(), pending), "i-2 has no node yet")
+	h.Assert(t, !monitor.resolveOnDemandNode(context.Background(), unknown), "the event has no instance")
+}
+
+func TestTaintAndRestoreNodePatchOnlyTheScaleDownTaint(t *testing.T) {
+	other := corev1.Taint{Key: "dedicated", Value: "batch", Effect: corev1.TaintEffectNoSchedule}
+	tainted := testNode("od-1", "i-1", nil)
+	tainted.Spec.Taints = []corev1.Taint{other}
+	executor := testExecutor(&terminatingPool{}, tainted, testNode("od-2", "i-2", nil))
+	clientset := executor.k8sClient.(*fake.Clientset)
+	ctx := context.Background()
+
+	h.Ok(t, executor.taintNode(ctx, "od-1"))
+	h.Ok(t, executor.taintNode(ctx, "od-2"))
+	h.Ok(t, setNodeAnnotations(ctx, clientset, "od-2", map[string]string{AnnotationScaleDownDone: "now"}))
+	node, _ := clientset.CoreV1().Nodes().Get(ctx, "od-1", metav1.GetOptions{})
+	h.Equals(t, []string{"dedicated", ScaleDownPendingTaint}, taintKeys(node))
+	node, _ = clientset.CoreV1().Nodes().Get(ctx, "od-2", metav1.GetOptions{})
+	h.Equals(t, []string{ScaleDownPendingTaint}, taintKeys(node))
+	h.Equals(t, "now", node.Annotations[AnnotationScaleDownDone])
+
+	executor.restoreNode("od-1")
+	h.Ok(t, removeNodeAnnotation(ctx, clientset, "od-2", AnnotationScaleDownDone))
+	// Removing an annotation that is already gone is a no-op
+	h.Ok(t, removeNodeAnnotation(ctx, clientset, "od-2", AnnotationScaleDownDone))
+	node, _ = clientset.CoreV1().Nodes().Get(ctx, "od-1", metav1.GetOptions{})
+	h.Equals(t, []string{"dedicated"}, taintKeys(node))
+	node, _ = clientset.CoreV1().Nodes().Get(ctx, "od-2", metav1.GetOptions{})
+	_, marked := node.Annotations[AnnotationScaleDownDone]
+	h.Assert(t, !marked, "expected the annotation to be removed")
+
+	// Taints and annotations are patched, never written back with the whole node
+	for _, action := range clientset.Actions() {
+		h.Assert(t, action.GetVerb() != "update" || action.GetSubresource() != "", "unexpected node update")
+	}
+}
+
+func taintKeys(node *corev1.Node) []string {
+	keys := []string{}
+	for _, taint := range node.Spec.Taints {
+		keys = append(keys, taint.Key)
+	}
+	return keys
+}
@@ -0,0 +1,220 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// FailureClass groups scale-up failures that need the same response
+type FailureClass string
+
+// Scale-up failure classes
+const (
+	// FailureClassCapacity means EC2 had no capacity for the requested instance types
+	FailureClassCapacity FailureClass = "capacity"
+	// FailureClassQuota means the account vCPU or instance quota was reached
+	FailureClassQuota FailureClass = "quota"
+	// FailureClassLaunchTemplate means the launch template, AMI or its network/storage settings are invalid
+	FailureClassLaunchTemplate FailureClass = "launch-template"
+	// FailureClassIAM means a permission or instance profile problem
+	FailureClassIAM FailureClass = "iam"
+	// FailureClassMaxSize means the ASG is already at its maximum size
+	FailureClassMaxSize FailureClass = "max-size"
+	// FailureClassTimeout means no instance reached InService and no failure was reported in time
+	FailureClassTimeout FailureClass = "timeout"
+)
+
+// FailureAction is the response to a class of scale-up failures
+type FailureAction string
+
+// Scale-up failure actions
+const (
+	// FailureActionFallback moves on to the next spot pool, then to on-demand
+	FailureActionFallback FailureAction = "fallback"
+	// FailureActionAlert logs the failure as an error and then falls back like FailureActionFallback
+	FailureActionAlert FailureAction = "alert"
+	// FailureActionStop ends the scale-up and returns the failure without trying other ASGs
+	FailureActionStop FailureAction = "stop"
+)
+
+// failureKeywords maps each class to the scaling activity messages that identify it.
+// Classes are matched in this order, so configuration errors win over generic capacity wording.
+var failureKeywords = []struct {
+	class    FailureClass
+	keywords []string
+}{
+	{FailureClassIAM, []string{
+		"UnauthorizedOperation",
+		"AccessDenied",
+		"not authorized",
+		"iam:PassRole",
+		"InvalidIamInstanceProfile",
+		// "Client error on launch", EC2 cannot use the EBS KMS key or the service-linked role. Every pool
+		// shares the volume setup, so it fails again elsewhere.
+		"Client.InternalError",
+	}},
+	{FailureClassLaunchTemplate, []string{
+		"InvalidAMIID",
+		"image id",
+		"InvalidLaunchTemplate",
+		"launch template",
+		"InvalidBlockDeviceMapping",
+		"InvalidKeyPair",
+		"InvalidGroup.NotFound",
+		"InvalidSubnet",
+		"InvalidParameter",
+	}},
+	{FailureClassQuota, []string{
+		"VcpuLimitExceeded",
+		"vCPU limit",
+		"InstanceLimitExceeded",
+		"MaxSpotInstanceCountExceeded",
+		"Max spot instance count exceeded",
+	}},
+	{FailureClassCapacity, []string{
+		"InsufficientInstanceCapacity",
+		"Insufficient capacity",
+		"capacity-not-available",
+		"Spot request could not be fulfilled",
+		"UnfulfillableCapacity",
+		"no Spot capacity available",
+		"InsufficientCapacityError",
+	}},
+}
+
+// ScalingFailureError is a classified scale-up failure of one ASG.
+// errors.Is matches it against the sentinel error of its class.
+type ScalingFailureError struct {
+	Class   FailureClass
+	ASGName string
+	Message string
+}
+
+func (e *ScalingFailureError) Error() string {
+	return fmt.Sprintf("%s failure scaling ASG %s: %s", e.Class, e.ASGName, e.Message)
+}
+
+// Unwrap returns the sentinel error of the failure class
+func (e *ScalingFailureError) Unwrap() error {
+	switch e.Class {
+	case FailureClassCapacity:
+		return ErrCapacityUnavailable
+	case FailureClassQuota:
+		return ErrQuotaExceeded
+	case FailureClassLaunchTemplate:
+		return ErrInvalidLaunchTemplate
+	case FailureClassIAM:
+		return ErrPermissionDenied
+	case FailureClassMaxSize:
+		return ErrMaxSizeReached
+	case FailureClassTimeout:
+		return ErrScaleUpTimeout
+	}
+	return nil
+}
+
+// DefaultFailureActions returns the action taken for each failure class when none is configured
+func DefaultFailureActions() map[FailureClass]FailureAction {
+	return map[FailureClass]FailureAction{
+		FailureClassCapacity: FailureActionFallback,
+		// Spot and on-demand vCPU quotas are separate, so on-demand can still work
+		FailureClassQuota: FailureActionAlert,
+		// Spot pools usually have their own launch template, so the next pool can still launch
+		FailureClassLaunchTemplate: FailureActionFallback,
+		// Every pool launches with the same role, so falling back fails again
+		FailureClassIAM:     FailureActionStop,
+		FailureClassMaxSize: FailureActionFallback,
+		FailureClassTimeout: FailureActionFallback,
+	}
+}
+
+// ParseFailureActions parses a comma-separated list of "<class>=<action>" overrides on top of
+// DefaultFailureActions, e.g. "quota=stop,max-size=alert"
+func ParseFailureActions(actionsStr string) (map[FailureClass]FailureAction, error) {
+	actions := DefaultFailureActions()
+	if strings.TrimSpace(actionsStr) == "" {
+		return actions, nil
+	}
+
+	for _, entry := range strings.Split(actionsStr, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		classStr, actionStr, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid failure action %q: expected <class>=<action>", entry)
+		}
+		class := FailureClass(strings.TrimSpace(classStr))
+		if _, known := actions[class]; !known {
+			return nil, fmt.Errorf("invalid failure action %q: unknown failure class %q", entry, class)
+		}
+		action := FailureAction(strings.TrimSpace(actionStr))
+		switch action {
+		case FailureActionFallback, FailureActionAlert, FailureActionStop:
+		default:
+			return nil, fmt.Errorf("invalid failure action %q: action must be one of fallback, alert or stop", entry)
+		}
+		actions[class] = action
+	}
+
+	return actions, nil
+}
+
+// classifyScalingMessage returns the failure class named by a scaling activity or event message
+func classifyScalingMessage(messages ...string) (FailureClass, bool) {
+	for _, entry := range failureKeywords {
+		for _, keyword := range entry.keywords {
+			keywordLower := toLower(keyword)
+			for _, message := range messages {
+				if contains(toLower(message), keywordLower) {
+					return entry.class, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// classifyAPIError turns an Auto Scaling API error into a ScalingFailureError when its class is known
+func classifyAPIError(asgName string, err error) error {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return err
+	}
+	class, found := classifyScalingMessage(awsErr.Code(), awsErr.Message())
+	if !found {
+		return err
+	}
+	return &ScalingFailureError{Class: class, ASGName: asgName, Message: awsErr.Error()}
+}
+
+// failureAction returns the configured action for a scale-up error.
+// Unclassified errors, such as API errors, fall back like before failures were classified.
+func (sg *SpotGuard) failureAction(err error) (FailureClass, FailureAction) {
+	var failure *ScalingFailureError
+	if !errors.As(err, &failure) {
+		return "", FailureActionFallback
+	}
+	if action, found := sg.FailureActions[failure.Class]; found {
+		return failure.Class, action
+	}
+	return failure.Class, DefaultFailureActions()[failure.Class]
+}
@@ -0,0 +1,179 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+type mockedScalingASGs struct {
+	autoscalingiface.AutoScalingAPI
+	groups     map[string]*autoscaling.Group
+	activities []*autoscaling.Activity
+	scaled     *[]string
+}
+
+func (m mockedScalingASGs) DescribeAutoScalingGroupsWithContext(_ aws.Context, input *autoscaling.DescribeAutoScalingGroupsInput, _ ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	group := m.groups[aws.StringValue(input.AutoScalingGroupNames[0])]
+	return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: []*autoscaling.Group{group}}, nil
+}
+
+func (m mockedScalingASGs) SetDesiredCapacityWithContext(_ aws.Context, input *autoscaling.SetDesiredCapacityInput, _ ...request.Option) (*autoscaling.SetDesiredCapacityOutput, error) {
+	*m.scaled = append(*m.scaled, aws.StringValue(input.AutoScalingGroupName))
+	if group, ok := m.groups[aws.StringValue(input.AutoScalingGroupName)]; ok {
+		group.DesiredCapacity = input.DesiredCapacity
+	}
+	return &autoscaling.SetDesiredCapacityOutput{}, nil
+}
+
+func (m mockedScalingASGs) DescribeScalingActivitiesWithContext(_ aws.Context, _ *autoscaling.DescribeScalingActivitiesInput, _ ...request.Option) (*autoscaling.DescribeScalingActivitiesOutput, error) {
+	return &autoscaling.DescribeScalingActivitiesOutput{Activities: m.activities}, nil
+}
+
+func fullASG(name string) *autoscaling.Group {
+	return &autoscaling.Group{AutoScalingGroupName: aws.String(name), DesiredCapacity: aws.Int64(3), MaxSize: aws.Int64(3)}
+}
+
+func TestParseFailureActions(t *testing.T) {
+	actions, err := ParseFailureActions("quota=stop, max-size=alert")
+	h.Ok(t, err)
+	h.Equals(t, FailureActionStop, actions[FailureClassQuota])
+	h.Equals(t, FailureActionAlert, actions[FailureClassMaxSize])
+	h.Equals(t, FailureActionFallback, actions[FailureClassCapacity])
+	h.Equals(t, FailureActionFallback, actions[FailureClassLaunchTemplate])
+	h.Equals(t, FailureActionStop, actions[FailureClassIAM])
+
+	_, err = ParseFailureActions("disk=stop")
+	h.Assert(t, err != nil, "expected an error for an unknown failure class")
+	_, err = ParseFailureActions("quota=ignore")
+	h.Assert(t, err != nil, "expected an error for an unknown failure action")
+	_, err = ParseFailureActions("quota")
+	h.Assert(t, err != nil, "expected an error for a missing action")
+}
+
+func TestClassifyScalingMessage(t *testing.T) {
+	for message, expected := range map[string]FailureClass{
+		"We currently do not have sufficient m5.large capacity. InsufficientInstanceCapacity":                     FailureClassCapacity,
+		"You have requested more vCPU capacity than your current vCPU limit of 32 allows. VcpuLimitExceeded":      FailureClassQuota,
+		"The image id '[ami-0123]' does not exist. Launching EC2 instance failed.":                                FailureClassLaunchTemplate,
+		"You are not authorized to perform this operation. Encoded authorization failure message: ...":            FailureClassIAM,
+		"Client.InternalError: Client error on launch.":                                                           FailureClassIAM,
+		"The specified launch template, with template ID lt-0123, does not exist. Launching EC2 instance failed.": FailureClassLaunchTemplate,
+		"There is no Spot capacity available that matches your request. Launching EC2 instance failed.":           FailureClassCapacity,
+	} {
+		class, found := classifyScalingMessage(message)
+		h.Assert(t, found, "expected %q to be classified", message)
+		h.Equals(t, expected, class)
+	}
+
+	_, found := classifyScalingMessage("Launching a new EC2 instance: i-0123")
+	h.Assert(t, !found, "successful activity should not be classified")
+}
+
+func TestCheckScalingActivitiesClassifiesFailure(t *testing.T) {
+	scaleStartTime := time.Now()
+	provider := NewASGCapacityProvider(mockedScalingASGs{activities: []*autoscaling.Activity{
+		{
+			StartTime:     aws.Time(scaleStartTime.Add(-time.Hour)),
+			StatusCode:    aws.String(autoscaling.ScalingActivityStatusCodeFailed),
+			StatusMessage: aws.String("InsufficientInstanceCapacity"),
+		},
+		{
+			StartTime:     aws.Time(scaleStartTime.Add(time.Second)),
+			StatusCode:    aws.String(autoscaling.ScalingActivityStatusCodeFailed),
+			Description:   aws.String("Launching a new EC2 instance. Status Reason: The image id '[ami-0123]' does not exist."),
+			StatusMessage: aws.String("The image id '[ami-0123]' does not exist. Launching EC2 instance failed."),
+		},
+	}}, nil)
+
+	failure, err := provider.ScalingFailure(context.Background(), "spot-a", scaleStartTime)
+	h.Ok(t, err)
+	h.Assert(t, failure != nil, "expected a classified failure")
+	h.Equals(t, FailureClassLaunchTemplate, failure.Class)
+	h.Assert(t, errors.Is(failure, ErrInvalidLaunchTemplate), "failure should match ErrInvalidLaunchTemplate")
+}
+
+func TestScaleUpWithFallbackStopsOnConfiguredClass(t *testing.T) {
+	scaled := []string{}
+	sg := &SpotGuard{
+		Provider: NewASGCapacityProvider(mockedScalingASGs{
+			groups: map[string]*autoscaling.Group{"spot-a": fullASG("spot-a"), "spot-b": fullASG("spot-b"), "od": fullASG("od")},
+			scaled: &scaled,
+		}, nil),
+		SpotPools:       []SpotPool{{ASGName: "spot-a"}, {ASGName: "spot-b"}},
+		OnDemandAsgName: "od",
+		FailureActions:  map[FailureClass]FailureAction{FailureClassMaxSize: FailureActionStop},
+	}
+
+	err := sg.ScaleUpWithFallback("spot-node")
+	var failure *ScalingFailureError
+	h.Assert(t, errors.As(err, &failure), "expected a *ScalingFailureError, got %v", err)
+	h.Equals(t, "spot-a", failure.ASGName)
+	h.Assert(t, errors.Is(err, ErrMaxSizeReached), "error should match ErrMaxSizeReached")
+}
+
+func TestScaleUpWithFallbackFallsBackByDefault(t *testing.T) {
+	scaled := []string{}
+	sg := &SpotGuard{
+		Provider: NewASGCapacityProvider(mockedScalingASGs{
+			groups: map[string]*autoscaling.Group{"spot-a": fullASG("spot-a"), "od": fullASG("od")},
+			scaled: &scaled,
+		}, nil),
+		SpotPools:       []SpotPool{{ASGName: "spot-a"}},
+		OnDemandAsgName: "od",
+	}
+
+	err := sg.ScaleUpWithFallback("spot-node")
+	var failure *ScalingFailureError
+	h.Assert(t, errors.As(err, &failure), "expected a *ScalingFailureError, got %v", err)
+	// The on-demand attempt is the one reported
+	h.Equals(t, "od", failure.ASGName)
+	h.Equals(t, 0, len(scaled))
+}
+
+func TestScaleUpWithFallbackResetsFailedPools(t *testing.T) {
+	scaled := []string{}
+	groups := map[string]*autoscaling.Group{
+		"spot-a": {AutoScalingGroupName: aws.String("spot-a"), DesiredCapacity: aws.Int64(1), MaxSize: aws.Int64(3)},
+		"spot-b": {AutoScalingGroupName: aws.String("spot-b"), DesiredCapacity: aws.Int64(2), MaxSize: aws.Int64(3)},
+		"od":     fullASG("od"),
+	}
+	sg := &SpotGuard{
+		Provider:            NewASGCapacityProvider(mockedScalingASGs{groups: groups, scaled: &scaled}, nil),
+		SpotPools:           []SpotPool{{ASGName: "spot-a", CapacityCheckTimeout: time.Minute}, {ASGName: "spot-b", CapacityCheckTimeout: time.Minute}},
+		OnDemandAsgName:     "od",
+		LaunchFailureEvents: true,
+	}
+	// Both spot pools report a launch failure right after their scale-up
+	sg.NotifyLaunchFailure(LaunchFailure{ASGName: "spot-a", StatusMessage: "InsufficientInstanceCapacity", Time: time.Now()})
+	sg.NotifyLaunchFailure(LaunchFailure{ASGName: "spot-b", StatusMessage: "InsufficientInstanceCapacity", Time: time.Now()})
+
+	err := sg.ScaleUpWithFallback("spot-node")
+	h.Assert(t, errors.Is(err, ErrMaxSizeReached), "expected the full on-demand ASG to be reported, got %v", err)
+	h.Equals(t, []string{"spot-a", "spot-a", "spot-b", "spot-b"}, scaled)
+	// The failed spot pools are back to their pre-attempt capacity, the full on-demand ASG was never changed
+	h.Equals(t, int64(1), aws.Int64Value(groups["spot-a"].DesiredCapacity))
+	h.Equals(t, int64(2), aws.Int64Value(groups["spot-b"].DesiredCapacity))
+	h.Equals(t, int64(3), aws.Int64Value(groups["od"].DesiredCapacity))
+}
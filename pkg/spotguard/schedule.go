@@ -0,0 +1,136 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	// The image is built from scratch and has no zoneinfo, embed it for the schedule time zone
+	_ "time/tzdata"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// ScheduleWindow is a recurring period that opens on a cron schedule and stays open for Duration
+type ScheduleWindow struct {
+	Spec     string
+	Duration time.Duration
+	schedule cron.Schedule
+}
+
+// contains reports whether t falls inside an occurrence of the window
+func (w ScheduleWindow) contains(t time.Time) bool {
+	// The latest opening at or before t is the first one after t-Duration, if it is not after t
+	opened := w.schedule.Next(t.Add(-w.Duration))
+	return !opened.IsZero() && !opened.After(t)
+}
+
+// ScaleDownSchedule gates when on-demand nodes may be drained.
+// Blackout windows win over allow windows; without allow windows any time outside a blackout is allowed.
+type ScaleDownSchedule struct {
+	Allow    []ScheduleWindow
+	Blackout []ScheduleWindow
+	Location *time.Location
+	err      error // set when the configured schedule could not be parsed
+}
+
+// ParseScheduleWindows parses a semicolon-separated list of "<cron>=<duration>" windows,
+// e.g. "0 22 * * MON-FRI=8h;0 0 * * SAT=48h". The cron expression has the standard five fields.
+func ParseScheduleWindows(windowsStr string) ([]ScheduleWindow, error) {
+	windows := make([]ScheduleWindow, 0)
+	for _, entry := range strings.Split(windowsStr, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		spec, durationStr, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid schedule window %q: expected <cron>=<duration>", entry)
+		}
+		spec = strings.TrimSpace(spec)
+		schedule, err := cron.ParseStandard(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression in schedule window %q: %w", entry, err)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(durationStr))
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid duration in schedule window %q: must be a positive duration such as 30m or 8h", entry)
+		}
+		windows = append(windows, ScheduleWindow{Spec: spec, Duration: duration, schedule: schedule})
+	}
+	return windows, nil
+}
+
+// ParseScaleDownSchedule parses allow and blackout windows evaluated in the given IANA time zone.
+// An empty time zone means UTC.
+func ParseScaleDownSchedule(allowStr string, blackoutStr string, timeZone string) (*ScaleDownSchedule, error) {
+	location := time.UTC
+	if timeZone != "" {
+		var err error
+		location, err = time.LoadLocation(timeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scale-down schedule time zone %q: %w", timeZone, err)
+		}
+	}
+	allow, err := ParseScheduleWindows(allowStr)
+	if err != nil {
+		return nil, err
+	}
+	blackout, err := ParseScheduleWindows(blackoutStr)
+	if err != nil {
+		return nil, err
+	}
+	return &ScaleDownSchedule{Allow: allow, Blackout: blackout, Location: location}, nil
+}
+
+// scaleDownScheduleFromConfig returns the scale-down schedule of the Spot Guard flags.
+// The flags were validated by NewSpotGuard, an invalid schedule still blocks every drain rather than ignoring it.
+func scaleDownScheduleFromConfig(nthConfig config.Config) *ScaleDownSchedule {
+	schedule, err := ParseScaleDownSchedule(nthConfig.SpotGuardScaleDownWindows, nthConfig.SpotGuardScaleDownBlackouts, nthConfig.SpotGuardScheduleTimezone)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid Spot Guard scale-down schedule, on-demand nodes will not be drained")
+		return &ScaleDownSchedule{Location: time.UTC, err: err}
+	}
+	return schedule
+}
+
+// Allows reports whether a drain may start at t. When it may not, it also returns the
+// ScaleDownReason and a description of the window that blocks it.
+func (s *ScaleDownSchedule) Allows(t time.Time) (bool, string, string) {
+	if s == nil {
+		return true, "", ""
+	}
+	if s.err != nil {
+		return false, ScaleDownReasonBlackoutWindow, s.err.Error()
+	}
+	local := t.In(s.Location)
+	for _, window := range s.Blackout {
+		if window.contains(local) {
+			return false, ScaleDownReasonBlackoutWindow, fmt.Sprintf("inside blackout window %q for %v (%s)", window.Spec, window.Duration, s.Location)
+		}
+	}
+	if len(s.Allow) == 0 {
+		return true, "", ""
+	}
+	for _, window := range s.Allow {
+		if window.contains(local) {
+			return true, "", ""
+		}
+	}
+	return false, ScaleDownReasonOutsideWindow, fmt.Sprintf("outside every scale-down window (%s)", s.Location)
+}
@@ -0,0 +1,89 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-node-termination-handler/pkg/config"
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func TestParseScheduleWindows(t *testing.T) {
+	windows, err := ParseScheduleWindows("0 22 * * MON-FRI=8h; 0 0 * * SAT=48h;;")
+	h.Ok(t, err)
+	h.Equals(t, 2, len(windows))
+	h.Equals(t, "0 22 * * MON-FRI", windows[0].Spec)
+	h.Equals(t, 8*time.Hour, windows[0].Duration)
+	h.Equals(t, 48*time.Hour, windows[1].Duration)
+
+	for _, invalid := range []string{"0 22 * * *", "0 22 * *=8h", "0 22 * * *=soon", "0 22 * * *=0s"} {
+		_, err = ParseScheduleWindows(invalid)
+		h.Assert(t, err != nil, "expected an error for %q", invalid)
+	}
+}
+
+func TestScaleDownScheduleAllowWindows(t *testing.T) {
+	// Weeknights from 22:00 to 06:00 in Berlin
+	schedule, err := ParseScaleDownSchedule("0 22 * * MON-FRI=8h", "", "Europe/Berlin")
+	h.Ok(t, err)
+	berlin := schedule.Location
+
+	allowed, _, _ := schedule.Allows(time.Date(2024, 5, 14, 23, 0, 0, 0, berlin))
+	h.Assert(t, allowed, "Tuesday 23:00 is inside the window")
+	allowed, _, _ = schedule.Allows(time.Date(2024, 5, 15, 5, 59, 0, 0, berlin))
+	h.Assert(t, allowed, "Wednesday 05:59 is inside the window opened on Tuesday")
+
+	allowed, reason, _ := schedule.Allows(time.Date(2024, 5, 15, 6, 0, 0, 0, berlin))
+	h.Assert(t, !allowed, "Wednesday 06:00 is after the window closed")
+	h.Equals(t, ScaleDownReasonOutsideWindow, reason)
+
+	// 21:30 UTC is 23:30 in Berlin during summer time
+	allowed, _, _ = schedule.Allows(time.Date(2024, 5, 14, 21, 30, 0, 0, time.UTC))
+	h.Assert(t, allowed, "the window is evaluated in the configured time zone")
+}
+
+func TestScaleDownScheduleBlackoutWins(t *testing.T) {
+	schedule, err := ParseScaleDownSchedule("0 0 * * *=24h", "0 9 * * *=2h", "")
+	h.Ok(t, err)
+
+	allowed, reason, message := schedule.Allows(time.Date(2024, 5, 14, 10, 0, 0, 0, time.UTC))
+	h.Assert(t, !allowed, "10:00 is inside the blackout")
+	h.Equals(t, ScaleDownReasonBlackoutWindow, reason)
+	h.Assert(t, message != "", "expected the blocking window to be described")
+
+	allowed, _, _ = schedule.Allows(time.Date(2024, 5, 14, 11, 0, 0, 0, time.UTC))
+	h.Assert(t, allowed, "11:00 is after the blackout")
+}
+
+func TestScaleDownScheduleWithoutWindows(t *testing.T) {
+	schedule := scaleDownScheduleFromConfig(config.Config{})
+	allowed, _, _ := schedule.Allows(time.Now())
+	h.Assert(t, allowed, "no windows allow draining at any time")
+
+	var unset *ScaleDownSchedule
+	allowed, _, _ = unset.Allows(time.Now())
+	h.Assert(t, allowed, "a nil schedule allows draining at any time")
+}
+
+func TestInvalidScaleDownScheduleBlocksDrains(t *testing.T) {
+	_, err := ParseScaleDownSchedule("", "", "Mars/Olympus_Mons")
+	h.Assert(t, err != nil, "expected an error for an unknown time zone")
+
+	schedule := scaleDownScheduleFromConfig(config.Config{SpotGuardScaleDownWindows: "0 22 * * *"})
+	allowed, reason, _ := schedule.Allows(time.Now())
+	h.Assert(t, !allowed, "an invalid schedule must not allow drains")
+	h.Equals(t, ScaleDownReasonBlackoutWindow, reason)
+}
@@ -15,13 +15,15 @@ package spotguard
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/aws/aws-node-termination-handler/pkg/config"
 	"github.com/aws/aws-node-termination-handler/pkg/node"
-	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
 	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -32,6 +34,9 @@ const (
 	AnnotationSpotASG       = "spot-guard.aws.amazon.com/spot-asg-name"
 	AnnotationOnDemandASG   = "spot-guard.aws.amazon.com/on-demand-asg-name"
 	AnnotationScaleDownDone = "spot-guard.aws.amazon.com/scale-down-completed"
+
+	// LabelOnDemandFallback marks the on-demand nodes with a start time, so spot nodes can watch just them
+	LabelOnDemandFallback = "spot-guard.aws.amazon.com/on-demand-fallback"
 )
 
 // SelfMonitor monitors its own node and scales it down when spot capacity is restored
@@ -40,29 +45,45 @@ type SelfMonitor struct {
 	healthChecker     *HealthChecker
 	safetyChecker     *SafetyChecker
 	scaleDownExecutor *ScaleDownExecutor
+	preScaler         *preScaler
+	schedule          *ScaleDownSchedule
+	drains            *drainSemaphore
 	clientset         kubernetes.Interface
+	metrics           observability.Metrics
+	recorder          observability.K8sEventRecorder
 	startTime         time.Time
 	healthySince      *time.Time
 	nodeName          string
 	spotASGName       string
 	onDemandASGName   string
 	instanceID        string
+	status            *Status
+	condition         *scaleDownCondition
 }
 
 // NewSelfMonitor creates a new self-monitor for the current on-demand node
 func NewSelfMonitor(
-	asgClient autoscalingiface.AutoScalingAPI,
+	provider CapacityProvider,
 	clientset kubernetes.Interface,
 	nodeHandler node.Node,
 	nthConfig config.Config,
+	metrics observability.Metrics,
+	recorder observability.K8sEventRecorder,
+	ledger *CostLedger,
 ) *SelfMonitor {
-	healthChecker := NewHealthChecker(asgClient, clientset)
+	healthChecker := NewHealthChecker(provider, clientset, nthConfig.DryRun)
+	healthChecker.interruptionWindow = interruptionWindowFromConfig(nthConfig)
 	safetyChecker := NewSafetyChecker(clientset, float64(nthConfig.SpotGuardMaxClusterUtilization))
+	safetyChecker.evictionRules = evictionRulesFromConfig(nthConfig)
+	safetyChecker.drainTimeout = time.Duration(nthConfig.NodeTerminationGracePeriod) * time.Second
 	scaleDownExecutor := NewScaleDownExecutor(
-		asgClient,
+		provider,
 		clientset,
 		nodeHandler,
 		time.Duration(nthConfig.SpotGuardPodEvictionTimeout)*time.Second,
+		recorder,
+		nthConfig.DryRun,
+		ledger,
 	)
 
 	sm := &SelfMonitor{
@@ -70,7 +91,13 @@ func NewSelfMonitor(
 		healthChecker:     healthChecker,
 		safetyChecker:     safetyChecker,
 		scaleDownExecutor: scaleDownExecutor,
+		preScaler:         newPreScaler(nthConfig, healthChecker, safetyChecker, metrics, recorder),
+		schedule:          scaleDownScheduleFromConfig(nthConfig),
+		drains:            newDrainSemaphore(clientset, provider, nthConfig),
+		condition:         newScaleDownCondition(clientset, nthConfig.NodeName, nthConfig.DryRun),
 		clientset:         clientset,
+		metrics:           metrics,
+		recorder:          recorder,
 		nodeName:          nthConfig.NodeName,
 		spotASGName:       nthConfig.SpotAsgName,
 		onDemandASGName:   nthConfig.OnDemandAsgName,
@@ -85,6 +112,18 @@ func NewSelfMonitor(
 	return sm
 }
 
+// TrackFallbacks makes the self-monitor close the fallback event of its node once it is retired
+func (sm *SelfMonitor) TrackFallbacks(tracker *FallbackTracker) {
+	sm.scaleDownExecutor.tracker = tracker
+}
+
+// ReportStatus makes the self-monitor record its state in status
+func (sm *SelfMonitor) ReportStatus(status *Status) {
+	sm.status = status
+	sm.preScaler.status = status
+	status.setMonitorStartTime(sm.startTime)
+}
+
 // Start begins monitoring this node for scale-down
 func (sm *SelfMonitor) Start(ctx context.Context) {
 	checkInterval := time.Duration(sm.config.SpotGuardCheckInterval) * time.Second
@@ -124,27 +163,11 @@ func (sm *SelfMonitor) Start(ctx context.Context) {
 
 		case <-ticker.C:
 			if sm.checkAndScaleDown(ctx, minimumWaitDuration) {
-				// Scale-down initiated - now verify THIS node is actually terminating
+				// The executor only returns once THIS instance is confirmed terminating in the ASG
 				log.Info().
 					Str("nodeName", sm.nodeName).
-					Msg("Scale-down initiated, verifying THIS node is being terminated")
-
-				if sm.waitForThisNodeTermination(ctx) {
-					log.Info().
-						Str("nodeName", sm.nodeName).
-						Msg("THIS node is terminating, will keep monitoring until pod dies naturally")
-					// Don't exit! Let node termination kill the pod
-					// Continue loop to keep monitoring
-				} else {
-					// Verification timeout - wrong node was terminated
-					log.Warn().
-						Str("nodeName", sm.nodeName).
-						Msg("Verification timeout: THIS node still running - clearing marker to retry on next cycle")
-
-					if err := sm.clearScaleDownMarker(); err != nil {
-						log.Error().Err(err).Msg("Failed to clear scale-down marker")
-					}
-				}
+					Msg("THIS node is terminating, will keep monitoring until pod dies naturally")
+				// Don't exit! Let node termination kill the pod
 			}
 		}
 	}
@@ -153,9 +176,10 @@ func (sm *SelfMonitor) Start(ctx context.Context) {
 // checkAndScaleDown checks if this node should be scaled down
 // Returns true if scale-down was initiated
 func (sm *SelfMonitor) checkAndScaleDown(ctx context.Context, minimumWaitDuration time.Duration) bool {
-	// Check if scale-down already completed (in case of pod restart after scale-down initiated)
-	if sm.isScaleDownCompleted() {
-		log.Info().Str("nodeName", sm.nodeName).Msg("Scale-down already completed, monitor exiting")
+	// Check if scale-down already started (in case of pod restart after scale-down initiated)
+	if sm.scaleDownInProgress(ctx) {
+		log.Info().Str("nodeName", sm.nodeName).Msg("Scale-down already started, waiting for the node to terminate")
+		sm.condition.set(ctx, corev1.ConditionTrue, ScaleDownReasonDraining, "scale-down of this on-demand node already started")
 		return true
 	}
 
@@ -168,6 +192,9 @@ func (sm *SelfMonitor) checkAndScaleDown(ctx context.Context, minimumWaitDuratio
 			Dur("elapsed", elapsed).
 			Dur("remaining", remaining).
 			Msg("Minimum wait time not met yet")
+		sm.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, ScaleDownReasonMinimumWaitNotMet)
+		sm.condition.keep(ctx, ScaleDownReasonMinimumWaitNotMet, fmt.Sprintf("on-demand node is kept for the minimum wait of %v, until %s",
+			minimumWaitDuration, sm.startTime.Add(minimumWaitDuration).UTC().Format(time.RFC3339)))
 		return false
 	}
 
@@ -179,11 +206,14 @@ func (sm *SelfMonitor) checkAndScaleDown(ctx context.Context, minimumWaitDuratio
 		stabilityDuration,
 		sm.healthySince,
 	)
+	sm.status.setSpotHealth(sm.spotASGName, status, stabilityDuration, err)
 	if err != nil {
 		log.Warn().
 			Err(err).
 			Str("spotASG", sm.spotASGName).
 			Msg("Failed to perform comprehensive spot ASG check")
+		sm.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, ScaleDownReasonCheckFailed)
+		sm.condition.keep(ctx, ScaleDownReasonCheckFailed, fmt.Sprintf("spot ASG check failed: %v", err))
 		return false
 	}
 
@@ -192,6 +222,8 @@ func (sm *SelfMonitor) checkAndScaleDown(ctx context.Context, minimumWaitDuratio
 		log.Debug().
 			Str("spotASG", sm.spotASGName).
 			Msg("Spot ASG not yet healthy")
+		sm.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, spotBlockReason(status))
+		sm.condition.keep(ctx, spotBlockReason(status), spotNotReadyMessage(sm.spotASGName, status, stabilityDuration))
 		return false
 	}
 
@@ -200,6 +232,8 @@ func (sm *SelfMonitor) checkAndScaleDown(ctx context.Context, minimumWaitDuratio
 		log.Debug().
 			Str("spotASG", sm.spotASGName).
 			Msg("Spot nodes not yet ready in Kubernetes")
+		sm.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, spotBlockReason(status))
+		sm.condition.keep(ctx, spotBlockReason(status), spotNotReadyMessage(sm.spotASGName, status, stabilityDuration))
 		return false
 	}
 
@@ -210,21 +244,42 @@ func (sm *SelfMonitor) checkAndScaleDown(ctx context.Context, minimumWaitDuratio
 			Str("spotASG", sm.spotASGName).
 			Dur("requiredStability", stabilityDuration).
 			Msg("Spot capacity not yet stable")
+		sm.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, spotBlockReason(status))
+		sm.condition.keep(ctx, spotBlockReason(status), spotNotReadyMessage(sm.spotASGName, status, stabilityDuration))
 		return false
 	}
 
-	// Step 3: Check if this node can be safely drained
-	canDrain, reason := sm.safetyChecker.CanSafelyDrainNode(ctx, sm.nodeName)
+	// Step 3: Only drain inside the scale-down windows, stability keeps being tracked above meanwhile
+	if allowed, blockReason, message := sm.schedule.Allows(time.Now()); !allowed {
+		log.Debug().
+			Str("nodeName", sm.nodeName).
+			Str("reason", message).
+			Msg("Scale-down not allowed by schedule")
+		sm.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, blockReason)
+		sm.condition.keep(ctx, blockReason, message)
+		return false
+	}
+
+	// Step 4: Check if this node can be safely drained, pods with zonal volumes need a spot node in their zone
+	spotInstances := status.spotInstances()
+	maxUtilization := sm.preScaler.drainThreshold(sm.nodeName)
+	canDrain, reason := sm.safetyChecker.CanSafelyDrainNodeWithThreshold(ctx, sm.nodeName, spotInstances, maxUtilization)
+	sm.status.setDrainCheck(sm.nodeName, canDrain, reason, maxUtilization)
 	if !canDrain {
+		sm.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, drainBlockReason(reason))
+		sm.condition.keep(ctx, drainBlockReason(reason), reason)
+		sm.recorder.Emit(sm.nodeName, observability.Normal, observability.SpotGuardScaleDownBlockedReason,
+			observability.SpotGuardScaleDownBlockedMsgFmt, drainBlockReason(reason), reason)
+
 		// Check if we should attempt pre-scale
-		if sm.config.EnablePreScale && reason == "Cluster utilization too high" {
+		if sm.config.EnablePreScale && reason == ReasonClusterUtilizationTooHigh {
 			log.Info().
 				Str("nodeName", sm.nodeName).
 				Str("reason", reason).
 				Msg("Cluster utilization too high, attempting smart pre-scale")
 
 			// Try pre-scale with 3-level fallback
-			preScaleSuccess := sm.attemptPreScaleWithFallback(ctx)
+			preScaleSuccess := sm.preScaler.attemptPreScaleWithFallback(ctx, sm.nodeName)
 			if preScaleSuccess {
 				log.Info().Msg("Pre-scale successful, will retry drain on next check cycle")
 				return false // Will retry on next cycle
@@ -241,7 +296,7 @@ func (sm *SelfMonitor) checkAndScaleDown(ctx context.Context, minimumWaitDuratio
 		return false
 	}
 
-	// Step 4: All conditions met - scale down THIS node
+	// Step 5: All conditions met - scale down THIS node
 	log.Info().
 		Str("nodeName", sm.nodeName).
 		Str("spotASG", sm.spotASGName).
@@ -264,6 +319,36 @@ func (sm *SelfMonitor) checkAndScaleDown(ctx context.Context, minimumWaitDuratio
 		return false
 	}
 
+	// Take a cluster-wide drain slot so the other on-demand nodes passing their checks now wait their turn
+	release, err := sm.drains.acquire(ctx, sm.nodeName)
+	if err != nil {
+		log.Info().
+			Err(err).
+			Str("nodeName", sm.nodeName).
+			Msg("No Spot Guard drain slot available, will retry on next check cycle")
+		sm.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, semaphoreBlockReason(err))
+		sm.condition.keep(ctx, semaphoreBlockReason(err), err.Error())
+		return false
+	}
+	defer release()
+
+	// Other drains may have completed while waiting, recompute utilization without them
+	if sm.drains != nil {
+		canDrain, reason := sm.safetyChecker.CanSafelyDrainNodeWithThreshold(ctx, sm.nodeName, spotInstances, maxUtilization)
+		sm.status.setDrainCheck(sm.nodeName, canDrain, reason, maxUtilization)
+		if !canDrain {
+			log.Info().
+				Str("nodeName", sm.nodeName).
+				Str("reason", reason).
+				Msg("Node can no longer be safely drained after taking a drain slot")
+			sm.metrics.SpotGuardScaleDownInc(observability.SpotGuardOutcomeBlocked, drainBlockReason(reason))
+			sm.condition.keep(ctx, drainBlockReason(reason), reason)
+			sm.recorder.Emit(sm.nodeName, observability.Normal, observability.SpotGuardScaleDownBlockedReason,
+				observability.SpotGuardScaleDownBlockedMsgFmt, drainBlockReason(reason), reason)
+			return false
+		}
+	}
+
 	// Create a fallback event for this node
 	event := &FallbackEvent{
 		EventID:              fmt.Sprintf("self-monitor-%s-%d", sm.nodeName, time.Now().Unix()),
@@ -276,18 +361,38 @@ func (sm *SelfMonitor) checkAndScaleDown(ctx context.Context, minimumWaitDuratio
 		SpotCapacityRestored: true,
 	}
 
+	drainMessage := "spot capacity is restored, draining and terminating this on-demand node"
+	if sm.config.DryRun {
+		drainMessage = "spot capacity is restored, dry run: this on-demand node would be drained and terminated"
+	}
+	sm.condition.set(ctx, corev1.ConditionTrue, ScaleDownReasonDraining, drainMessage)
+
 	// Mark scale-down as initiated (prevents duplicate attempts if pod restarts during scale-down)
 	if err := sm.markScaleDownInitiated(); err != nil {
 		log.Warn().Err(err).Msg("Failed to mark scale-down as initiated, continuing anyway")
 	}
 
 	// Execute scale-down
-	if err := sm.scaleDownExecutor.ScaleDownOnDemandNode(ctx, event); err != nil {
+	err = sm.scaleDownExecutor.ScaleDownOnDemandNode(ctx, event)
+	sm.scaleDownExecutor.recordScaleDown(sm.metrics, event, err)
+	if err != nil {
 		log.Error().
 			Err(err).
 			Str("nodeName", sm.nodeName).
 			Str("eventID", event.EventID).
 			Msg("Failed to scale down on-demand node")
+
+		sm.condition.keep(ctx, ScaleDownReasonExecutionFailed, fmt.Sprintf("scale-down failed: %v", err))
+
+		// After a termination timeout the node stays cordoned, the next cycles check whether the instance terminates
+		if errors.Is(err, ErrTerminationTimeout) {
+			return false
+		}
+
+		// The executor restored the node, clear the marker so the next cycle can retry
+		if err := sm.clearScaleDownMarker(); err != nil {
+			log.Error().Err(err).Msg("Failed to clear scale-down marker")
+		}
 		return false
 	}
 
@@ -300,90 +405,6 @@ func (sm *SelfMonitor) checkAndScaleDown(ctx context.Context, minimumWaitDuratio
 	return true
 }
 
-// waitForThisNodeTermination waits to verify THIS specific node is being terminated
-// Returns true if this node is confirmed to be terminating, false if timeout or wrong node terminated
-func (sm *SelfMonitor) waitForThisNodeTermination(ctx context.Context) bool {
-	maxWaitTime := 5 * time.Minute // Wait up to 5 minutes to confirm
-	checkInterval := 10 * time.Second
-	startTime := time.Now()
-
-	log.Info().
-		Str("nodeName", sm.nodeName).
-		Str("maxWaitTime", maxWaitTime.String()).
-		Msg("Waiting to confirm THIS node is being terminated...")
-
-	ticker := time.NewTicker(checkInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Info().Msg("Context cancelled during termination verification")
-			return false
-
-		case <-ticker.C:
-			elapsed := time.Since(startTime)
-
-			// Check if this node is being terminated
-			isTerminating, reason := sm.isThisNodeTerminating()
-
-			if isTerminating {
-				log.Info().
-					Str("nodeName", sm.nodeName).
-					Str("reason", reason).
-					Str("elapsed", elapsed.String()).
-					Msg("Confirmed: THIS node is being terminated")
-				return true
-			}
-
-			// Check if we've exceeded max wait time
-			if elapsed >= maxWaitTime {
-				log.Warn().
-					Str("nodeName", sm.nodeName).
-					Str("elapsed", elapsed.String()).
-					Msg("Timeout: THIS node is NOT being terminated - wrong node may have been scaled down")
-				return false
-			}
-
-			log.Debug().
-				Str("nodeName", sm.nodeName).
-				Str("elapsed", elapsed.String()).
-				Str("remaining", (maxWaitTime - elapsed).String()).
-				Msg("Still waiting for THIS node to show termination signs...")
-		}
-	}
-}
-
-// isThisNodeTerminating checks if THIS specific node is being terminated
-// Returns true and reason if node is terminating
-func (sm *SelfMonitor) isThisNodeTerminating() (bool, string) {
-	node, err := sm.clientset.CoreV1().Nodes().Get(context.Background(), sm.nodeName, metav1.GetOptions{})
-	if err != nil {
-		// If node doesn't exist, it was terminated!
-		log.Info().
-			Err(err).
-			Str("nodeName", sm.nodeName).
-			Msg("Node no longer exists - successfully terminated")
-		return true, "node deleted"
-	}
-
-	// Check 1: Node has DeletionTimestamp (Kubernetes is deleting it)
-	if node.DeletionTimestamp != nil {
-		return true, "node has DeletionTimestamp"
-	}
-
-	// NOTE: We do NOT check for cordoned/unschedulable status because:
-	// - WE cordon the node ourselves during scale-down
-	// - ASG might terminate a different node instead
-	// - Our node would stay cordoned but alive (false positive!)
-	// Only DeletionTimestamp is a reliable signal that THIS node is actually terminating
-
-	log.Debug().
-		Str("nodeName", sm.nodeName).
-		Msg("Node shows no signs of termination yet")
-	return false, ""
-}
-
 // getOrCreateStartTime loads the start time from node annotation or creates a new one
 func (sm *SelfMonitor) getOrCreateStartTime() time.Time {
 	node, err := sm.clientset.CoreV1().Nodes().Get(context.Background(), sm.nodeName, metav1.GetOptions{})
@@ -402,6 +423,12 @@ func (sm *SelfMonitor) getOrCreateStartTime() time.Time {
 				Time("startTime", startTime).
 				Str("nodeName", sm.nodeName).
 				Msg("Loaded start time from node annotation (pod restart detected)")
+			if !sm.config.DryRun {
+				// Nodes tracked before the label existed get it now
+				if err := labelOnDemandFallback(context.Background(), sm.clientset, node); err != nil {
+					log.Warn().Err(err).Str("nodeName", sm.nodeName).Msg("Failed to label on-demand node, will retry")
+				}
+			}
 			return startTime
 		}
 		log.Warn().
@@ -410,16 +437,23 @@ func (sm *SelfMonitor) getOrCreateStartTime() time.Time {
 			Msg("Failed to parse start time annotation, creating new one")
 	}
 
+	if sm.config.DryRun {
+		// The annotation is never written in dry-run, so count from when the node joined
+		log.Info().
+			Str("nodeName", sm.nodeName).
+			Msg("Start time annotation would have been created, but dry-run flag was set")
+		return node.CreationTimestamp.Time
+	}
+
 	// Create new start time annotation
 	startTime := time.Now()
-	if node.Annotations == nil {
-		node.Annotations = make(map[string]string)
+	annotations := map[string]string{
+		AnnotationStartTime:   startTime.Format(time.RFC3339),
+		AnnotationSpotASG:     sm.spotASGName,
+		AnnotationOnDemandASG: sm.onDemandASGName,
 	}
-	node.Annotations[AnnotationStartTime] = startTime.Format(time.RFC3339)
-	node.Annotations[AnnotationSpotASG] = sm.spotASGName
-	node.Annotations[AnnotationOnDemandASG] = sm.onDemandASGName
-
-	_, err = sm.clientset.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{})
+	labels := map[string]string{LabelOnDemandFallback: "true"}
+	err = setNodeMetadata(context.Background(), sm.clientset, sm.nodeName, labels, annotations)
 	if err != nil {
 		log.Warn().
 			Err(err).
@@ -438,19 +472,12 @@ func (sm *SelfMonitor) getOrCreateStartTime() time.Time {
 
 // markScaleDownInitiated marks that scale-down has been initiated
 func (sm *SelfMonitor) markScaleDownInitiated() error {
-	node, err := sm.clientset.CoreV1().Nodes().Get(context.Background(), sm.nodeName, metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to get node: %w", err)
+	if sm.config.DryRun {
+		return nil
 	}
-
-	if node.Annotations == nil {
-		node.Annotations = make(map[string]string)
-	}
-	node.Annotations[AnnotationScaleDownDone] = time.Now().Format(time.RFC3339)
-
-	_, err = sm.clientset.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to update node annotation: %w", err)
+	annotations := map[string]string{AnnotationScaleDownDone: time.Now().Format(time.RFC3339)}
+	if err := setNodeAnnotations(context.Background(), sm.clientset, sm.nodeName, annotations); err != nil {
+		return err
 	}
 
 	log.Debug().
@@ -461,18 +488,11 @@ func (sm *SelfMonitor) markScaleDownInitiated() error {
 
 // clearScaleDownMarker removes the scale-down marker to allow retry
 func (sm *SelfMonitor) clearScaleDownMarker() error {
-	node, err := sm.clientset.CoreV1().Nodes().Get(context.Background(), sm.nodeName, metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to get node: %w", err)
+	if sm.config.DryRun {
+		return nil
 	}
-
-	if node.Annotations != nil {
-		delete(node.Annotations, AnnotationScaleDownDone)
-	}
-
-	_, err = sm.clientset.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to clear node annotation: %w", err)
+	if err := removeNodeAnnotation(context.Background(), sm.clientset, sm.nodeName, AnnotationScaleDownDone); err != nil {
+		return err
 	}
 
 	log.Info().
@@ -481,16 +501,43 @@ func (sm *SelfMonitor) clearScaleDownMarker() error {
 	return nil
 }
 
-// isScaleDownCompleted checks if scale-down was already completed
-func (sm *SelfMonitor) isScaleDownCompleted() bool {
-	node, err := sm.clientset.CoreV1().Nodes().Get(context.Background(), sm.nodeName, metav1.GetOptions{})
+// scaleDownInProgress checks if a scale-down of this node was started. A marker that outlived the drain
+// timeout while the instance is not terminating was left by a drain that never finished, for instance
+// because the pod restarted: the node is restored and the marker cleared so monitoring goes on.
+func (sm *SelfMonitor) scaleDownInProgress(ctx context.Context) bool {
+	node, err := sm.clientset.CoreV1().Nodes().Get(ctx, sm.nodeName, metav1.GetOptions{})
 	if err != nil {
-		// If we can't get the node, assume scale-down not completed
+		// If we can't get the node, assume scale-down not started
+		return false
+	}
+
+	marked, exists := node.Annotations[AnnotationScaleDownDone]
+	if !exists {
 		return false
 	}
+	if !sm.scaleDownExecutor.staleScaleDownMarker(marked) {
+		return true
+	}
 
-	_, exists := node.Annotations[AnnotationScaleDownDone]
-	return exists
+	terminating, err := sm.scaleDownExecutor.instanceTerminating(ctx, sm.onDemandASGName, sm.instanceID)
+	if err != nil {
+		log.Warn().Err(err).Str("nodeName", sm.nodeName).Msg("Failed to check the instance of a stale scale-down marker, will retry")
+		return true
+	}
+	if terminating {
+		return true
+	}
+
+	log.Warn().
+		Str("nodeName", sm.nodeName).
+		Str("markedAt", marked).
+		Msg("Scale-down marker outlived the drain timeout, recovering on-demand node")
+	sm.scaleDownExecutor.restoreNode(sm.nodeName)
+	if err := sm.clearScaleDownMarker(); err != nil {
+		log.Error().Err(err).Str("nodeName", sm.nodeName).Msg("Failed to clear stale scale-down marker")
+		return true
+	}
+	return false
 }
 
 // getInstanceID gets the EC2 instance ID for this node
@@ -514,198 +561,3 @@ func (sm *SelfMonitor) getInstanceID() string {
 
 	return ""
 }
-
-// attemptPreScaleWithFallback implements the 3-level safety net for pre-scaling
-func (sm *SelfMonitor) attemptPreScaleWithFallback(ctx context.Context) bool {
-	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	log.Info().Msg("LEVEL 1: Attempting Smart Pre-Scale")
-	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-
-	// Get current cluster utilization
-	currentUtilization := sm.safetyChecker.GetClusterUtilization(ctx)
-
-	log.Info().
-		Float64("currentUtilization", currentUtilization).
-		Float64("targetUtilization", float64(sm.config.PreScaleTargetUtilization)).
-		Msg("Current cluster state")
-
-	// Level 1: Attempt pre-scale
-	calc, err := sm.healthChecker.CalculatePreScaleNodes(
-		ctx,
-		sm.spotASGName,
-		sm.onDemandASGName,
-		currentUtilization,
-		float64(sm.config.PreScaleTargetUtilization),
-		sm.config.PreScaleSafetyBufferPercent,
-	)
-	if err != nil {
-		log.Error().
-			Err(err).
-			Msg("Pre-scale calculation failed")
-		return sm.attemptFallbackLevel2(ctx, currentUtilization)
-	}
-
-	if calc.AdditionalSpotNodes == 0 {
-		log.Info().Msg("No additional nodes needed (already below target)")
-		return true
-	}
-
-	log.Info().
-		Int("additionalNodes", calc.AdditionalSpotNodes).
-		Int("newDesiredCapacity", calc.NodesNeeded).
-		Float64("expectedUtilization", calc.ExpectedUtilization).
-		Msg("Pre-scale plan calculated")
-
-	// Scale up spot ASG
-	if err := sm.healthChecker.ScaleSpotASG(ctx, sm.spotASGName, calc.NodesNeeded); err != nil {
-		log.Error().
-			Err(err).
-			Str("spotASG", sm.spotASGName).
-			Int("desiredCapacity", calc.NodesNeeded).
-			Msg("Failed to scale spot ASG")
-		return sm.attemptFallbackLevel2(ctx, currentUtilization)
-	}
-
-	log.Info().
-		Int("additionalNodes", calc.AdditionalSpotNodes).
-		Int("timeoutSeconds", sm.config.PreScaleTimeoutSeconds).
-		Msg("Waiting for new spot nodes to become ready...")
-
-	// Wait for new nodes to become ready
-	timeout := time.Duration(sm.config.PreScaleTimeoutSeconds) * time.Second
-	success := sm.waitForSpotNodesReady(ctx, calc.NodesNeeded, timeout)
-
-	if success {
-		log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-		log.Info().Msg("LEVEL 1 SUCCESS: Pre-scale completed successfully!")
-		log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-		return true
-	}
-
-	log.Warn().
-		Dur("timeout", timeout).
-		Msg("LEVEL 1 FAILED: Spot nodes not ready within timeout")
-
-	// Spot capacity might not be available - try fallback
-	return sm.attemptFallbackLevel2(ctx, currentUtilization)
-}
-
-// attemptFallbackLevel2 tries to drain with increased threshold
-func (sm *SelfMonitor) attemptFallbackLevel2(ctx context.Context, currentUtilization float64) bool {
-	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	log.Info().Msg(" LEVEL 2: Fallback to Increased Threshold")
-	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-
-	fallbackThreshold := float64(sm.config.PreScaleFallbackThreshold)
-
-	log.Info().
-		Float64("currentUtilization", currentUtilization).
-		Float64("originalThreshold", float64(sm.config.SpotGuardMaxClusterUtilization)).
-		Float64("fallbackThreshold", fallbackThreshold).
-		Msg("Comparing utilization against increased threshold")
-
-	if currentUtilization <= fallbackThreshold {
-		log.Info().
-			Float64("currentUtilization", currentUtilization).
-			Float64("fallbackThreshold", fallbackThreshold).
-			Msg("LEVEL 2 SUCCESS: Current utilization is below fallback threshold")
-		log.Info().Msg("Will proceed with drain on next check cycle")
-		log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-
-		// Temporarily increase the threshold for the safety checker
-		sm.safetyChecker.maxUtilization = fallbackThreshold
-		return true
-	}
-
-	log.Warn().
-		Float64("currentUtilization", currentUtilization).
-		Float64("fallbackThreshold", fallbackThreshold).
-		Msg(" LEVEL 2 FAILED: Still too high even with increased threshold")
-
-	// Still too high - go to Level 3
-	return sm.attemptFallbackLevel3(ctx)
-}
-
-// attemptFallbackLevel3 keeps the on-demand node running
-func (sm *SelfMonitor) attemptFallbackLevel3(ctx context.Context) bool {
-	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	log.Info().Msg("  LEVEL 3: Keep On-Demand Node (Safety First)")
-	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-
-	checkInterval := time.Duration(sm.config.SpotGuardCheckInterval) * time.Second
-
-	log.Warn().Msg("  Cannot safely drain on-demand node:")
-	log.Warn().Msg("   • Spot capacity unavailable or unhealthy")
-	log.Warn().Msg("   • Cluster utilization too high")
-	log.Warn().Msg("   • Draining now would risk workload disruption")
-	log.Warn().Msg("")
-	log.Warn().Msg("  Safety First: Keeping on-demand node running")
-	log.Warn().Msg(" Note: This costs more, but ensures reliability")
-	log.Warn().
-		Dur("retryAfter", checkInterval).
-		Msg("Will retry on next check cycle")
-	log.Info().Msg("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-
-	return false
-}
-
-// waitForSpotNodesReady waits for spot nodes to reach the desired count and be ready
-func (sm *SelfMonitor) waitForSpotNodesReady(ctx context.Context, desiredCount int, timeout time.Duration) bool {
-	startTime := time.Now()
-	checkInterval := 10 * time.Second
-
-	for {
-		elapsed := time.Since(startTime)
-		if elapsed >= timeout {
-			log.Warn().
-				Dur("elapsed", elapsed).
-				Dur("timeout", timeout).
-				Msg("Timeout waiting for spot nodes to be ready")
-			return false
-		}
-
-		// Check spot ASG health
-		status, err := sm.healthChecker.CheckSpotASGComprehensive(
-			ctx,
-			sm.spotASGName,
-			30*time.Second, // Short stability check for pre-scale
-			nil,
-		)
-		if err != nil {
-			log.Warn().
-				Err(err).
-				Msg("Failed to check spot ASG status during pre-scale wait")
-			time.Sleep(checkInterval)
-			continue
-		}
-
-		// Check if we have enough healthy nodes
-		if status.IsHealthy && status.NodesReady {
-			// Count how many nodes we have
-			readyCount := len(status.InstanceIDs)
-
-			log.Debug().
-				Int("readyCount", readyCount).
-				Int("desiredCount", desiredCount).
-				Dur("elapsed", elapsed).
-				Msg("Checking spot node readiness")
-
-			if readyCount >= desiredCount {
-				log.Info().
-					Int("readyCount", readyCount).
-					Int("desiredCount", desiredCount).
-					Dur("elapsed", elapsed).
-					Msg("All spot nodes are ready!")
-				return true
-			}
-		}
-
-		remaining := timeout - elapsed
-		log.Debug().
-			Dur("elapsed", elapsed).
-			Dur("remaining", remaining).
-			Msg("Waiting for spot nodes...")
-
-		time.Sleep(checkInterval)
-	}
-}
@@ -0,0 +1,63 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func testSelfMonitor(provider CapacityProvider, marked time.Time) (*SelfMonitor, *fake.Clientset) {
+	clientset := fake.NewSimpleClientset(drainedNode("od-1", "i-1", map[string]string{AnnotationScaleDownDone: marked.Format(time.RFC3339)}))
+	return &SelfMonitor{
+		clientset:         clientset,
+		nodeName:          "od-1",
+		instanceID:        "i-1",
+		onDemandASGName:   "od-asg",
+		scaleDownExecutor: NewScaleDownExecutor(provider, clientset, testNodeHandler(clientset), time.Minute, observability.K8sEventRecorder{}, false, nil),
+	}, clientset
+}
+
+func TestSelfMonitorRestartWithStaleScaleDownMarker(t *testing.T) {
+	ctx := context.Background()
+	inService := &terminatingPool{before: onDemandPool(1, map[string]string{"i-1": "InService"})}
+
+	// A drain that started recently may still be running
+	sm, _ := testSelfMonitor(inService, time.Now())
+	h.Assert(t, sm.scaleDownInProgress(ctx), "a fresh marker means the scale-down is in progress")
+
+	// A drain interrupted by a restart long ago left the node cordoned while the instance kept running
+	sm, clientset := testSelfMonitor(inService, time.Now().Add(-time.Hour))
+	h.Assert(t, !sm.scaleDownInProgress(ctx), "a stale marker of an InService instance must be recovered")
+	node, err := clientset.CoreV1().Nodes().Get(ctx, "od-1", metav1.GetOptions{})
+	h.Ok(t, err)
+	_, marked := node.Annotations[AnnotationScaleDownDone]
+	h.Assert(t, !marked, "the stale marker must be cleared")
+	h.Assert(t, !node.Spec.Unschedulable && len(node.Spec.Taints) == 0, "the node must be restored: %+v", node.Spec)
+
+	// An instance that is terminating keeps its node cordoned, whatever the age of the marker
+	terminating := &terminatingPool{before: onDemandPool(0, map[string]string{"i-1": "Terminating"})}
+	sm, clientset = testSelfMonitor(terminating, time.Now().Add(-time.Hour))
+	h.Assert(t, sm.scaleDownInProgress(ctx), "the scale-down of a terminating instance is in progress")
+	node, err = clientset.CoreV1().Nodes().Get(ctx, "od-1", metav1.GetOptions{})
+	h.Ok(t, err)
+	h.Assert(t, node.Spec.Unschedulable, "the node of a terminating instance must stay cordoned")
+}
@@ -14,288 +14,553 @@
 package spotguard
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-node-termination-handler/pkg/config"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
-	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/rs/zerolog/log"
 )
 
+// SpotPool is one spot pool in the fallback chain, an ASG or a Karpenter NodePool
+type SpotPool struct {
+	ASGName              string
+	CapacityCheckTimeout time.Duration
+}
+
 // SpotGuard handles scaling operations for spot instances with on-demand fallback
 type SpotGuard struct {
-	ASGClient            autoscalingiface.AutoScalingAPI
+	// Provider scales the spot and on-demand pools
+	Provider             CapacityProvider
 	SpotAsgName          string
+	SpotPools            []SpotPool
 	OnDemandAsgName      string
 	ScaleTimeout         time.Duration
 	CapacityCheckTimeout time.Duration
+	// LaunchFailureEvents is set when launch failures are delivered through NotifyLaunchFailure.
+	// Scaling activities are then only polled as a safety net.
+	LaunchFailureEvents bool
+	// FailureActions is the response to each class of scale-up failure
+	FailureActions map[FailureClass]FailureAction
+	// Metrics records scale-up attempts, fallbacks and time-to-InService
+	Metrics observability.Metrics
+	// Recorder emits fallback events on the node that triggered the scale-up
+	Recorder observability.K8sEventRecorder
+	// DryRun logs the scale-ups that would have been made without changing any ASG
+	DryRun bool
+	// Tracker records each fallback to on-demand until the node it launched is scaled down, nil records nothing
+	Tracker *FallbackTracker
+	// MinimumWaitDuration is how long a recorded fallback keeps its on-demand node
+	MinimumWaitDuration time.Duration
+
+	launchFailures launchFailureNotifier
+
+	// scaleUps holds when each node's background scale-up finished, zero while it runs
+	scaleUps   map[string]time.Time
+	scaleUpsMu sync.Mutex
 }
 
+const (
+	// instanceCheckInterval is how often the ASG is described while waiting for a new instance
+	instanceCheckInterval = 10 * time.Second
+	// eventDrivenActivityCheckTicks is how many instance checks pass between scaling activity
+	// checks when launch failures are delivered as events
+	eventDrivenActivityCheckTicks = 6
+	// releaseDrainProtectionTimeout bounds releasing the capacity added for a drain
+	releaseDrainProtectionTimeout = 30 * time.Second
+	// scaleUpDedupeWindow is how long a finished background scale-up keeps the same node from starting another,
+	// a redelivered rebalance recommendation must not add a second replacement
+	scaleUpDedupeWindow = 15 * time.Minute
+)
+
 // NewSpotGuard creates a new SpotGuard instance
-func NewSpotGuard(asgClient autoscalingiface.AutoScalingAPI, nthConfig *config.Config) *SpotGuard {
+func NewSpotGuard(provider CapacityProvider, nthConfig *config.Config, metrics observability.Metrics, recorder observability.K8sEventRecorder) (*SpotGuard, error) {
+	capacityCheckTimeout := time.Duration(nthConfig.SpotGuardCapacityCheckTimeout) * time.Second
+
+	spotPools, err := ParseSpotPools(nthConfig.SpotGuardSpotPools, capacityCheckTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if len(spotPools) == 0 {
+		spotPools = []SpotPool{{ASGName: nthConfig.SpotAsgName, CapacityCheckTimeout: capacityCheckTimeout}}
+	}
+
+	failureActions, err := ParseFailureActions(nthConfig.SpotGuardFailureActions)
+	if err != nil {
+		return nil, err
+	}
+
+	// The schedule is used by the self-monitor and controller, reject it before either starts
+	if _, err := ParseScaleDownSchedule(nthConfig.SpotGuardScaleDownWindows, nthConfig.SpotGuardScaleDownBlackouts, nthConfig.SpotGuardScheduleTimezone); err != nil {
+		return nil, err
+	}
+
 	return &SpotGuard{
-		ASGClient:            asgClient,
-		SpotAsgName:          nthConfig.SpotAsgName,
+		Provider:             provider,
+		SpotAsgName:          spotPools[0].ASGName,
+		SpotPools:            spotPools,
 		OnDemandAsgName:      nthConfig.OnDemandAsgName,
 		ScaleTimeout:         time.Duration(nthConfig.SpotGuardScaleTimeout) * time.Second,
-		CapacityCheckTimeout: time.Duration(nthConfig.SpotGuardCapacityCheckTimeout) * time.Second,
+		CapacityCheckTimeout: capacityCheckTimeout,
+		FailureActions:       failureActions,
+		Metrics:              metrics,
+		Recorder:             recorder,
+		DryRun:               nthConfig.DryRun,
+		MinimumWaitDuration:  time.Duration(nthConfig.SpotGuardMinimumWaitDuration) * time.Second,
+	}, nil
+}
+
+// ParseSpotPools parses an ordered, comma-separated list of spot ASGs.
+// Each entry is either "<asg-name>" or "<asg-name>:<capacity-check-timeout-seconds>".
+func ParseSpotPools(spotPoolsStr string, defaultTimeout time.Duration) ([]SpotPool, error) {
+	spotPools := make([]SpotPool, 0)
+	if strings.TrimSpace(spotPoolsStr) == "" {
+		return spotPools, nil
+	}
+
+	for _, entry := range strings.Split(spotPoolsStr, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pool := SpotPool{ASGName: entry, CapacityCheckTimeout: defaultTimeout}
+		if name, timeoutStr, found := strings.Cut(entry, ":"); found {
+			timeoutSeconds, err := strconv.Atoi(timeoutStr)
+			if err != nil || timeoutSeconds <= 0 {
+				return nil, fmt.Errorf("invalid capacity check timeout in spot pool %q: must be a positive number of seconds", entry)
+			}
+			pool.ASGName = name
+			pool.CapacityCheckTimeout = time.Duration(timeoutSeconds) * time.Second
+		}
+
+		if pool.ASGName == "" {
+			return nil, fmt.Errorf("invalid spot pool %q: ASG name is required", entry)
+		}
+		spotPools = append(spotPools, pool)
+	}
+
+	return spotPools, nil
+}
+
+// IsSpotPool reports whether an ASG is one of the spot pools managed by Spot Guard
+func (sg *SpotGuard) IsSpotPool(asgName string) bool {
+	for _, pool := range sg.SpotPools {
+		if pool.ASGName == asgName {
+			return true
+		}
+	}
+	return false
+}
+
+// ScaleUpWithFallback walks the spot pool chain in order and falls back to on-demand
+// only when every spot pool failed to deliver an InService instance.
+// A failure whose class is configured to stop ends the walk and is returned as a *ScalingFailureError.
+// Fallback events are emitted on nodeName, the node whose interruption triggered the scale-up.
+func (sg *SpotGuard) ScaleUpWithFallback(nodeName string) error {
+	for i, pool := range sg.SpotPools {
+		log.Info().Msgf("Spot Guard: Attempting to scale up spot ASG: %s (pool %d/%d)", pool.ASGName, i+1, len(sg.SpotPools))
+
+		err := sg.tryScaleUpSpotPool(pool)
+		if err == nil && sg.DryRun {
+			sg.Recorder.Emit(nodeName, observability.Normal, observability.SpotGuardDryRunReason, observability.SpotGuardDryRunMsgFmt,
+				fmt.Sprintf("scaled up spot ASG %s", pool.ASGName))
+			return nil
+		}
+		if err == nil {
+			log.Info().Msgf("Spot Guard: Successfully scaled up spot ASG: %s", pool.ASGName)
+			return nil
+		}
+
+		class, action := sg.failureAction(err)
+		switch action {
+		case FailureActionStop:
+			log.Error().
+				Err(err).
+				Str("asgName", pool.ASGName).
+				Str("failureClass", string(class)).
+				Msg("🛑 Spot Guard: Scale-up failure is configured to stop scaling, not falling back")
+			sg.Recorder.Emit(nodeName, observability.Warning, observability.SpotGuardFallbackErrReason, observability.SpotGuardFallbackErrMsgFmt, err.Error())
+			return err
+		case FailureActionAlert:
+			log.Error().
+				Err(err).
+				Str("asgName", pool.ASGName).
+				Str("failureClass", string(class)).
+				Msg("🚨 Spot Guard: Scale-up failure needs attention, falling back")
+		default:
+			log.Warn().
+				Err(err).
+				Str("asgName", pool.ASGName).
+				Str("failureClass", string(class)).
+				Msg("Spot Guard: Spot scale-up failed")
+		}
+
+		if i < len(sg.SpotPools)-1 {
+			log.Warn().Msgf("Spot Guard: Spot ASG %s could not provide capacity, trying next spot pool: %s", pool.ASGName, sg.SpotPools[i+1].ASGName)
+		}
+	}
+
+	instanceID, err := sg.fallbackToOnDemand()
+	if err != nil {
+		sg.Recorder.Emit(nodeName, observability.Warning, observability.SpotGuardFallbackErrReason, observability.SpotGuardFallbackErrMsgFmt, err.Error())
+		return err
+	}
+	if sg.DryRun {
+		sg.Recorder.Emit(nodeName, observability.Normal, observability.SpotGuardDryRunReason, observability.SpotGuardDryRunMsgFmt,
+			fmt.Sprintf("fallen back to on-demand ASG %s", sg.OnDemandAsgName))
+		return nil
+	}
+	sg.Recorder.Emit(nodeName, observability.Normal, observability.SpotGuardFallbackReason, observability.SpotGuardFallbackMsgFmt,
+		sg.spotPoolNames(), sg.OnDemandAsgName)
+	sg.recordFallback(nodeName, instanceID)
+	return nil
+}
+
+// StartScaleUpWithFallback runs ScaleUpWithFallback for nodeName in the background so the caller
+// can taint the node right away. It returns false without scaling when a scale-up for the node
+// is still running or finished less than scaleUpDedupeWindow ago.
+func (sg *SpotGuard) StartScaleUpWithFallback(nodeName string) bool {
+	sg.scaleUpsMu.Lock()
+	defer sg.scaleUpsMu.Unlock()
+	if sg.scaleUps == nil {
+		sg.scaleUps = make(map[string]time.Time)
+	}
+	for name, finished := range sg.scaleUps {
+		if !finished.IsZero() && time.Since(finished) >= scaleUpDedupeWindow {
+			delete(sg.scaleUps, name)
+		}
 	}
+	if _, ok := sg.scaleUps[nodeName]; ok {
+		return false
+	}
+	sg.scaleUps[nodeName] = time.Time{}
+
+	go func() {
+		if err := sg.ScaleUpWithFallback(nodeName); err != nil {
+			log.Error().Err(err).Str("nodeName", nodeName).Msg("Spot Guard: Failed to scale up replacement capacity")
+		}
+		sg.scaleUpsMu.Lock()
+		sg.scaleUps[nodeName] = time.Now()
+		sg.scaleUpsMu.Unlock()
+	}()
+	return true
 }
 
-// ScaleUpWithFallback attempts to scale up spot instances, with fallback to on-demand
-func (sg *SpotGuard) ScaleUpWithFallback() error {
-	log.Info().Msgf("Spot Guard: Attempting to scale up spot ASG: %s", sg.SpotAsgName)
+// ReleaseDrainProtection lets the capacity provider disrupt the capacity Spot Guard added for a drain again,
+// called once the drain completed. Providers that do not protect new capacity ignore it.
+func (sg *SpotGuard) ReleaseDrainProtection() {
+	if sg.DryRun {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), releaseDrainProtectionTimeout)
+	defer cancel()
+	pools := make([]string, 0, len(sg.SpotPools)+1)
+	for _, pool := range sg.SpotPools {
+		pools = append(pools, pool.ASGName)
+	}
+	releaseDrainProtection(ctx, sg.Provider, append(pools, sg.OnDemandAsgName)...)
+}
 
+// releaseDrainProtection releases the protected capacity of the pools when the provider protects it
+func releaseDrainProtection(ctx context.Context, provider CapacityProvider, pools ...string) {
+	protector, ok := provider.(drainProtector)
+	if !ok {
+		return
+	}
+	for _, pool := range pools {
+		if err := protector.ReleaseDrainProtection(ctx, pool); err != nil {
+			// Karpenter keeps the capacity until the annotation is removed by hand or by the next drain
+			log.Warn().Err(err).Str("pool", pool).Msg("Spot Guard: Failed to release new capacity for disruption")
+		}
+	}
+}
+
+// recordFallback tracks a fallback to on-demand that launched instanceID. The node of the instance may not
+// be registered yet, it is looked up when the event is scaled down. The scale-down that retires the instance
+// closes the event.
+func (sg *SpotGuard) recordFallback(nodeName string, instanceID string) {
+	if sg.Tracker == nil {
+		return
+	}
+	event := &FallbackEvent{
+		EventID:             fmt.Sprintf("fallback-%s-%d", nodeName, time.Now().Unix()),
+		Timestamp:           time.Now(),
+		SpotASGName:         sg.SpotAsgName,
+		OnDemandASGName:     sg.OnDemandAsgName,
+		OnDemandInstanceID:  instanceID,
+		MinimumWaitDuration: sg.MinimumWaitDuration,
+	}
+	if err := sg.Tracker.AddEvent(event); err != nil {
+		log.Error().Err(err).Str("eventID", event.EventID).Msg("Spot Guard: Failed to record fallback to on-demand")
+	}
+}
+
+// spotPoolNames returns the names of the spot pools as a comma-separated list
+func (sg *SpotGuard) spotPoolNames() string {
+	names := make([]string, 0, len(sg.SpotPools))
+	for _, pool := range sg.SpotPools {
+		names = append(names, pool.ASGName)
+	}
+	return strings.Join(names, ", ")
+}
+
+// tryScaleUpSpotPool scales up a single spot pool and waits for the new instance.
+// Returns nil if the new instance reached InService within the pool's timeout. A pool that failed
+// gets its desired capacity back, so it does not launch the instance after the next pool or on-demand did.
+func (sg *SpotGuard) tryScaleUpSpotPool(pool SpotPool) error {
 	// Mark the timestamp BEFORE scaling to detect only new failures
 	scaleStartTime := time.Now()
 	log.Debug().Msgf("Spot Guard: Marking baseline timestamp (%s) to detect only new scaling failures", scaleStartTime.Format(time.RFC3339))
 
 	// Try to scale up spot instance
-	err := sg.scaleUpASG(sg.SpotAsgName)
+	previousDesired, err := sg.scaleUpASG(pool.ASGName)
 	if err != nil {
-		log.Warn().Err(err).Msgf("Spot Guard: Failed to initiate spot ASG scale-up")
-		return sg.fallbackToOnDemand()
+		err = fmt.Errorf("failed to initiate spot ASG scale-up for %s: %w", pool.ASGName, err)
+		sg.recordScaleUp(pool.ASGName, observability.SpotGuardCapacitySpot, scaleStartTime, err)
+		return err
+	}
+	if sg.DryRun {
+		// No instance is launching, so there is nothing to wait for
+		return nil
 	}
 
 	// Wait and check if new instance becomes InService
-	success, err := sg.waitForNewInstance(sg.SpotAsgName, scaleStartTime)
+	_, err = sg.waitForNewInstance(pool.ASGName, scaleStartTime, pool.CapacityCheckTimeout)
+	sg.recordScaleUp(pool.ASGName, observability.SpotGuardCapacitySpot, scaleStartTime, err)
 	if err != nil {
-		log.Warn().Err(err).Msgf("Spot Guard: Error checking spot instance status")
-		return sg.fallbackToOnDemand()
+		sg.resetDesiredCapacity(pool.ASGName, previousDesired)
 	}
+	return err
+}
 
-	if !success {
-		log.Warn().Msgf("Spot Guard: Spot capacity appears unavailable (timeout waiting for InService)")
-		return sg.fallbackToOnDemand()
+// resetDesiredCapacity takes back the unit a failed scale-up added to a pool. Scale-ups started meanwhile
+// by other nodes are kept, so the pool only goes down to previousDesired when nothing else raised it.
+func (sg *SpotGuard) resetDesiredCapacity(asgName string, previousDesired int64) {
+	pool, err := sg.Provider.DescribePool(context.Background(), asgName)
+	if err != nil {
+		log.Error().Err(err).Str("asgName", asgName).Msg("Spot Guard: Failed to describe failed spot ASG, its desired capacity is not reset")
+		return
+	}
+	if pool.DesiredCapacity <= previousDesired {
+		return
 	}
 
-	log.Info().Msgf("Spot Guard: Successfully scaled up spot ASG: %s", sg.SpotAsgName)
-	return nil
+	log.Info().Msgf("Spot Guard: Resetting desired capacity of failed spot ASG %s from %d to %d", asgName, pool.DesiredCapacity, pool.DesiredCapacity-1)
+	if err := sg.Provider.SetDesiredCapacity(context.Background(), asgName, pool.DesiredCapacity-1); err != nil {
+		log.Error().Err(err).Str("asgName", asgName).Msg("Spot Guard: Failed to reset desired capacity of failed spot ASG")
+	}
 }
 
-// scaleUpASG increases the desired capacity of an ASG by 1
-func (sg *SpotGuard) scaleUpASG(asgName string) error {
-	// Get current ASG configuration
-	describeInput := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{aws.String(asgName)},
+// recordScaleUp records the outcome of one ASG scale-up, labelled with its failure class when it failed
+func (sg *SpotGuard) recordScaleUp(asgName string, capacityType string, scaleStartTime time.Time, err error) {
+	if err == nil {
+		sg.Metrics.SpotGuardScaleUpInc(asgName, capacityType, observability.SpotGuardOutcomeSuccess)
+		sg.Metrics.SpotGuardTimeToInServiceRecord(asgName, capacityType, time.Since(scaleStartTime))
+		return
 	}
 
-	describeOutput, err := sg.ASGClient.DescribeAutoScalingGroups(describeInput)
-	if err != nil {
-		return fmt.Errorf("failed to describe ASG %s: %w", asgName, err)
+	outcome := observability.SpotGuardOutcomeFailure
+	var failure *ScalingFailureError
+	if errors.As(err, &failure) {
+		outcome = string(failure.Class)
 	}
+	sg.Metrics.SpotGuardScaleUpInc(asgName, capacityType, outcome)
+}
 
-	if len(describeOutput.AutoScalingGroups) == 0 {
-		return fmt.Errorf("ASG %s not found", asgName)
+// scaleUpASG increases the desired capacity of a pool by 1 and returns the desired capacity it had before
+func (sg *SpotGuard) scaleUpASG(asgName string) (int64, error) {
+	// Get current pool configuration
+	pool, err := sg.Provider.DescribePool(context.Background(), asgName)
+	if err != nil {
+		return 0, err
 	}
 
-	asg := describeOutput.AutoScalingGroups[0]
-	currentDesired := aws.Int64Value(asg.DesiredCapacity)
-	maxSize := aws.Int64Value(asg.MaxSize)
+	currentDesired := pool.DesiredCapacity
+	maxSize := pool.MaxSize
 	newDesired := currentDesired + 1
 
-	if newDesired > maxSize {
-		return fmt.Errorf("cannot scale up ASG %s: would exceed max size (%d)", asgName, maxSize)
+	if maxSize > 0 && newDesired > maxSize {
+		return currentDesired, &ScalingFailureError{
+			Class:   FailureClassMaxSize,
+			ASGName: asgName,
+			Message: fmt.Sprintf("scaling to %d would exceed max size (%d)", newDesired, maxSize),
+		}
+	}
+
+	if sg.DryRun {
+		log.Info().Msgf("Spot Guard: Would have scaled ASG %s from %d to %d instances, but dry-run flag was set", asgName, currentDesired, newDesired)
+		return currentDesired, nil
 	}
 
 	log.Info().Msgf("Spot Guard: Scaling ASG %s from %d to %d instances", asgName, currentDesired, newDesired)
 
 	// Update desired capacity
-	updateInput := &autoscaling.SetDesiredCapacityInput{
-		AutoScalingGroupName: aws.String(asgName),
-		DesiredCapacity:      aws.Int64(newDesired),
-		HonorCooldown:        aws.Bool(false),
-	}
+	return currentDesired, sg.Provider.SetDesiredCapacity(context.Background(), asgName, newDesired)
+}
 
-	_, err = sg.ASGClient.SetDesiredCapacity(updateInput)
-	if err != nil {
-		return fmt.Errorf("failed to set desired capacity for ASG %s: %w", asgName, err)
+// shiftToOnDemand makes a mixed instances ASG launch its pending instance as on-demand
+func (sg *SpotGuard) shiftToOnDemand(shifter onDemandShifter) error {
+	if sg.DryRun {
+		log.Info().Msgf("Spot Guard: Would have shifted ASG %s to on-demand, but dry-run flag was set", sg.OnDemandAsgName)
+		return nil
 	}
 
-	return nil
+	log.Info().Msgf("Spot Guard: Shifting mixed instances ASG %s to on-demand", sg.OnDemandAsgName)
+	return shifter.ShiftToOnDemand(context.Background(), sg.OnDemandAsgName)
 }
 
-// waitForNewInstance waits for a new instance to reach InService state
-func (sg *SpotGuard) waitForNewInstance(asgName string, scaleStartTime time.Time) (bool, error) {
+// waitForNewInstance waits up to timeout for a new instance to reach InService state.
+// It returns the ID of the new instance on success and a *ScalingFailureError when the scale-up failed
+// or timed out, giving up early when a launch failure for the ASG is reported after scaleStartTime.
+func (sg *SpotGuard) waitForNewInstance(asgName string, scaleStartTime time.Time, timeout time.Duration) (string, error) {
 	startTime := time.Now()
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(instanceCheckInterval)
 	defer ticker.Stop()
 
-	// Get initial instance count
-	initialCount, err := sg.getInServiceInstanceCount(asgName)
+	// Get initial instances
+	initial, err := sg.getInServiceInstances(asgName)
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
-	log.Info().Msgf("Spot Guard: Waiting for new instance in ASG %s (initial count: %d)", asgName, initialCount)
+	log.Info().Msgf("Spot Guard: Waiting for new instance in ASG %s (initial count: %d)", asgName, len(initial))
 
-	for range ticker.C {
-		currentCount, err := sg.getInServiceInstanceCount(asgName)
+	// Same clock skew buffer as the scaling activity check
+	cutoffTime := scaleStartTime.Add(-5 * time.Second)
+	ticks := 0
+
+	for {
+		launchFailed := sg.launchFailures.wait()
+		if failure, found := sg.launchFailures.since(asgName, cutoffTime); found {
+			log.Warn().
+				Str("asgName", asgName).
+				Str("activityID", failure.ActivityID).
+				Str("statusMessage", failure.StatusMessage).
+				Msgf("🚨 Spot Guard: Launch unsuccessful event received for ASG %s, not waiting for timeout", asgName)
+			return "", launchFailureError(failure)
+		}
+
+		select {
+		case <-launchFailed:
+			continue
+		case <-ticker.C:
+		}
+		ticks++
+
+		current, err := sg.getInServiceInstances(asgName)
 		if err != nil {
 			log.Warn().Err(err).Msg("Spot Guard: Error checking instance count")
 			continue
 		}
 
-		if currentCount > initialCount {
-			log.Info().Msgf("Spot Guard: New instance reached InService in ASG %s (count: %d)", asgName, currentCount)
-			return true, nil
+		if instanceID := newInstance(initial, current); instanceID != "" {
+			log.Info().Msgf("Spot Guard: New instance %s reached InService in ASG %s (count: %d)", instanceID, asgName, len(current))
+			return instanceID, nil
 		}
 
-		// Check for capacity issues in scaling activities (only those after scaleStartTime)
-		hasCapacityIssue, err := sg.checkForCapacityIssues(asgName, scaleStartTime)
-		if err != nil {
-			log.Warn().Err(err).Msg("Spot Guard: Error checking scaling activities")
-		}
-		if hasCapacityIssue {
-			log.Warn().Msgf("Spot Guard: Detected capacity issue in ASG %s", asgName)
-			return false, nil
+		// Check for failures in scaling activities (only those after scaleStartTime).
+		// With launch failure events this is only a safety net for missed events.
+		if !sg.LaunchFailureEvents || ticks%eventDrivenActivityCheckTicks == 0 {
+			failure, err := sg.Provider.ScalingFailure(context.Background(), asgName, scaleStartTime)
+			if err != nil {
+				log.Warn().Err(err).Msg("Spot Guard: Error checking scaling activities")
+			}
+			if failure != nil {
+				log.Warn().Msgf("Spot Guard: Detected %s failure in ASG %s", failure.Class, asgName)
+				return "", failure
+			}
 		}
 
 		elapsed := time.Since(startTime)
 		log.Debug().Msgf("Spot Guard: Still waiting for instance (elapsed: %v)", elapsed)
 
-		if elapsed >= sg.CapacityCheckTimeout {
+		if elapsed >= timeout {
 			log.Warn().Msgf("Spot Guard: Timeout waiting for instance in ASG %s", asgName)
-			return false, nil
+			return "", &ScalingFailureError{
+				Class:   FailureClassTimeout,
+				ASGName: asgName,
+				Message: fmt.Sprintf("no new InService instance after %v", timeout),
+			}
 		}
 	}
-
-	return false, nil
 }
 
-// getInServiceInstanceCount returns the number of InService instances in an ASG
-func (sg *SpotGuard) getInServiceInstanceCount(asgName string) (int, error) {
-	describeInput := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{aws.String(asgName)},
+// launchFailureError classifies a launch unsuccessful event, treating unknown causes as capacity failures
+func launchFailureError(failure LaunchFailure) *ScalingFailureError {
+	class, found := classifyScalingMessage(failure.StatusMessage)
+	if !found {
+		class = FailureClassCapacity
 	}
+	return &ScalingFailureError{Class: class, ASGName: failure.ASGName, Message: failure.StatusMessage}
+}
 
-	describeOutput, err := sg.ASGClient.DescribeAutoScalingGroups(describeInput)
+// getInServiceInstances returns the InService instances of a pool as a set
+func (sg *SpotGuard) getInServiceInstances(asgName string) (map[string]bool, error) {
+	pool, err := sg.Provider.DescribePool(context.Background(), asgName)
 	if err != nil {
-		return 0, fmt.Errorf("failed to describe ASG %s: %w", asgName, err)
-	}
-
-	if len(describeOutput.AutoScalingGroups) == 0 {
-		return 0, fmt.Errorf("ASG %s not found", asgName)
+		return nil, err
 	}
 
-	asg := describeOutput.AutoScalingGroups[0]
-	inServiceCount := 0
-
-	for _, instance := range asg.Instances {
-		if aws.StringValue(instance.LifecycleState) == "InService" {
-			inServiceCount++
+	inService := make(map[string]bool)
+	for _, instance := range pool.Instances {
+		if instance.LifecycleState == autoscaling.LifecycleStateInService {
+			inService[instance.InstanceID] = true
 		}
 	}
 
-	return inServiceCount, nil
+	return inService, nil
 }
 
-// checkForCapacityIssues checks recent scaling activities for capacity-related errors
-// Only checks activities that started after scaleStartTime to avoid false positives
-func (sg *SpotGuard) checkForCapacityIssues(asgName string, scaleStartTime time.Time) (bool, error) {
-	input := &autoscaling.DescribeScalingActivitiesInput{
-		AutoScalingGroupName: aws.String(asgName),
-		MaxRecords:           aws.Int64(50), // Increased from 10 to catch more activities
-	}
-
-	output, err := sg.ASGClient.DescribeScalingActivities(input)
-	if err != nil {
-		// Check if it's a throttling error
-		if contains(err.Error(), "Throttling") || contains(err.Error(), "Rate exceeded") {
-			log.Warn().
-				Err(err).
-				Str("asgName", asgName).
-				Msg("⚠️ AWS API throttled (DescribeScalingActivities), will retry on next check cycle")
-			return false, nil // Don't fail, just skip this check
+// newInstance returns an instance of current that is not in initial, "" when there is none
+func newInstance(initial map[string]bool, current map[string]bool) string {
+	for instanceID := range current {
+		if !initial[instanceID] {
+			return instanceID
 		}
-		return false, fmt.Errorf("failed to describe scaling activities for ASG %s: %w", asgName, err)
 	}
-
-	// Only check activities that started AFTER our scale-up request
-	// Add 5-second buffer to account for clock skew and API recording delays
-	cutoffTime := scaleStartTime.Add(-5 * time.Second)
-
-	log.Debug().Msgf("Spot Guard: Checking scaling activities for %s (cutoff: %s, total activities: %d)",
-		asgName, cutoffTime.Format(time.RFC3339), len(output.Activities))
-
-	relevantActivitiesChecked := 0
-
-	// Check recent activities for capacity issues
-	for _, activity := range output.Activities {
-		// Only check activities that started after we initiated scale-up
-		activityTime := aws.TimeValue(activity.StartTime)
-		if activityTime.Before(cutoffTime) {
-			// This activity is too old, skip it
-			continue
-		}
-
-		relevantActivitiesChecked++
-
-		statusCode := aws.StringValue(activity.StatusCode)
-		description := aws.StringValue(activity.Description)
-		cause := aws.StringValue(activity.Cause)
-
-		// Look for capacity-related failures
-		if statusCode == "Failed" || statusCode == "Cancelled" {
-			log.Debug().Msgf("Spot Guard: Found failed/cancelled activity - Status: %s, Time: %s, Description: %s",
-				statusCode, activityTime.Format(time.RFC3339), description)
-
-			// Check for common capacity issue indicators
-			capacityKeywords := []string{
-				"InsufficientInstanceCapacity",
-				"Insufficient capacity",
-				"capacity-not-available",
-				"Max spot instance count exceeded",
-				"Spot request could not be fulfilled",
-				"UnfulfillableCapacity ",
-			}
-
-			descLower := toLower(description)
-			causeLower := toLower(cause)
-
-			for _, keyword := range capacityKeywords {
-				keywordLower := toLower(keyword)
-				if contains(descLower, keywordLower) || contains(causeLower, keywordLower) {
-					log.Warn().Msgf("🚨 Spot Guard: Detected capacity issue (keyword: '%s') in recent activity at %s: %s",
-						keyword, activityTime.Format(time.RFC3339), description)
-					return true, nil
-				}
-			}
-		}
-	}
-
-	log.Debug().Msgf("Spot Guard: No capacity issues detected (checked %d relevant activities out of %d total)",
-		relevantActivitiesChecked, len(output.Activities))
-
-	return false, nil
+	return ""
 }
 
-// fallbackToOnDemand scales up the on-demand ASG
-func (sg *SpotGuard) fallbackToOnDemand() error {
+// fallbackToOnDemand scales up the on-demand ASG and returns the instance it launched, "" in dry-run
+func (sg *SpotGuard) fallbackToOnDemand() (string, error) {
 	log.Warn().Msgf("Spot Guard: Falling back to on-demand ASG: %s", sg.OnDemandAsgName)
 
 	// Mark timestamp for on-demand scaling
 	onDemandScaleStartTime := time.Now()
 
-	err := sg.scaleUpASG(sg.OnDemandAsgName)
+	var err error
+	if shifter, ok := sg.Provider.(onDemandShifter); ok {
+		err = sg.shiftToOnDemand(shifter)
+	} else {
+		_, err = sg.scaleUpASG(sg.OnDemandAsgName)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to scale up on-demand ASG: %w", err)
+		sg.recordScaleUp(sg.OnDemandAsgName, observability.SpotGuardCapacityOnDemand, onDemandScaleStartTime, err)
+		sg.Metrics.SpotGuardFallbackInc(sg.OnDemandAsgName, observability.SpotGuardOutcomeFailure)
+		return "", fmt.Errorf("failed to scale up on-demand ASG: %w", err)
+	}
+	if sg.DryRun {
+		return "", nil
 	}
 
 	// Wait for on-demand instance
-	success, err := sg.waitForNewInstance(sg.OnDemandAsgName, onDemandScaleStartTime)
+	instanceID, err := sg.waitForNewInstance(sg.OnDemandAsgName, onDemandScaleStartTime, sg.CapacityCheckTimeout)
+	sg.recordScaleUp(sg.OnDemandAsgName, observability.SpotGuardCapacityOnDemand, onDemandScaleStartTime, err)
 	if err != nil {
-		return fmt.Errorf("error waiting for on-demand instance: %w", err)
-	}
-
-	if !success {
-		return fmt.Errorf("timeout waiting for on-demand instance to reach InService")
+		sg.Metrics.SpotGuardFallbackInc(sg.OnDemandAsgName, observability.SpotGuardOutcomeFailure)
+		return "", fmt.Errorf("error waiting for on-demand instance: %w", err)
 	}
+	sg.Metrics.SpotGuardFallbackInc(sg.OnDemandAsgName, observability.SpotGuardOutcomeSuccess)
 
 	log.Info().Msgf("Spot Guard: Successfully scaled up on-demand ASG: %s", sg.OnDemandAsgName)
-	return nil
+	return instanceID, nil
 }
 
 // toLower converts a string to lowercase for case-insensitive comparison
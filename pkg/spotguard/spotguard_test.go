@@ -0,0 +1,158 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+type mockedASGInstances struct {
+	autoscalingiface.AutoScalingAPI
+	group *autoscaling.Group
+}
+
+func (m mockedASGInstances) DescribeAutoScalingGroupsWithContext(_ aws.Context, _ *autoscaling.DescribeAutoScalingGroupsInput, _ ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: []*autoscaling.Group{m.group}}, nil
+}
+
+func TestParseSpotPools(t *testing.T) {
+	pools, err := ParseSpotPools("pool-a, pool-b:300,,pool-c", 120*time.Second)
+	h.Ok(t, err)
+	h.Equals(t, []SpotPool{
+		{ASGName: "pool-a", CapacityCheckTimeout: 120 * time.Second},
+		{ASGName: "pool-b", CapacityCheckTimeout: 300 * time.Second},
+		{ASGName: "pool-c", CapacityCheckTimeout: 120 * time.Second},
+	}, pools)
+}
+
+func TestParseSpotPoolsEmpty(t *testing.T) {
+	pools, err := ParseSpotPools("", 120*time.Second)
+	h.Ok(t, err)
+	h.Equals(t, 0, len(pools))
+}
+
+func TestParseSpotPoolsInvalid(t *testing.T) {
+	_, err := ParseSpotPools("pool-a:abc", 120*time.Second)
+	h.Assert(t, err != nil, "Expected error for non-numeric timeout")
+
+	_, err = ParseSpotPools("pool-a:0", 120*time.Second)
+	h.Assert(t, err != nil, "Expected error for zero timeout")
+
+	_, err = ParseSpotPools(":60", 120*time.Second)
+	h.Assert(t, err != nil, "Expected error for missing ASG name")
+}
+
+func TestWaitForNewInstanceStopsOnLaunchFailure(t *testing.T) {
+	sg := &SpotGuard{
+		Provider: NewASGCapacityProvider(mockedASGInstances{group: &autoscaling.Group{
+			AutoScalingGroupName: aws.String("spot-a"),
+			Instances:            []*autoscaling.Instance{asgInstance("i-1", "InService")},
+		}}, nil),
+		LaunchFailureEvents: true,
+	}
+	scaleStartTime := time.Now()
+
+	// A failure from before the scale-up and one for another ASG must not end the wait
+	sg.NotifyLaunchFailure(LaunchFailure{ASGName: "spot-a", Time: scaleStartTime.Add(-time.Minute)})
+	sg.NotifyLaunchFailure(LaunchFailure{ASGName: "spot-b", Time: scaleStartTime})
+
+	result := make(chan bool, 1)
+	go func() {
+		_, err := sg.waitForNewInstance("spot-a", scaleStartTime, time.Minute)
+		result <- err == nil
+	}()
+
+	select {
+	case <-result:
+		t.Fatal("wait ended before a relevant launch failure was reported")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	sg.NotifyLaunchFailure(LaunchFailure{ASGName: "spot-a", ActivityID: "activity-1", Time: time.Now()})
+
+	select {
+	case success := <-result:
+		h.Assert(t, !success, "wait should report failure after a launch unsuccessful event")
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait did not end after a launch unsuccessful event")
+	}
+
+	failure, found := sg.LatestLaunchFailure("spot-a")
+	h.Assert(t, found, "expected the latest launch failure to be recorded")
+	h.Equals(t, "activity-1", failure.ActivityID)
+}
+
+func TestScaleUpWithFallbackDryRun(t *testing.T) {
+	scaled := []string{}
+	sg := &SpotGuard{
+		Provider: NewASGCapacityProvider(mockedScalingASGs{
+			groups: map[string]*autoscaling.Group{"spot-a": {
+				AutoScalingGroupName: aws.String("spot-a"),
+				DesiredCapacity:      aws.Int64(1),
+				MaxSize:              aws.Int64(3),
+			}},
+			scaled: &scaled,
+		}, nil),
+		SpotPools:       []SpotPool{{ASGName: "spot-a", CapacityCheckTimeout: time.Minute}},
+		OnDemandAsgName: "od",
+		DryRun:          true,
+	}
+
+	h.Ok(t, sg.ScaleUpWithFallback("spot-node"))
+	h.Equals(t, 0, len(scaled))
+}
+
+func TestStartScaleUpWithFallbackOncePerNode(t *testing.T) {
+	sg := &SpotGuard{
+		Provider: NewASGCapacityProvider(mockedScalingASGs{
+			groups: map[string]*autoscaling.Group{"spot-a": {
+				AutoScalingGroupName: aws.String("spot-a"),
+				DesiredCapacity:      aws.Int64(1),
+				MaxSize:              aws.Int64(3),
+			}},
+		}, nil),
+		SpotPools:       []SpotPool{{ASGName: "spot-a", CapacityCheckTimeout: time.Minute}},
+		OnDemandAsgName: "od",
+		DryRun:          true,
+	}
+	finished := func() bool {
+		sg.scaleUpsMu.Lock()
+		defer sg.scaleUpsMu.Unlock()
+		return !sg.scaleUps["spot-node"].IsZero()
+	}
+
+	h.Assert(t, sg.StartScaleUpWithFallback("spot-node"), "first scale-up must start")
+	h.Assert(t, !sg.StartScaleUpWithFallback("spot-node"), "redelivered event must not scale up again")
+	h.Assert(t, sg.StartScaleUpWithFallback("other-node"), "other nodes scale up on their own")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !finished() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.Assert(t, finished(), "scale-up did not finish")
+	h.Assert(t, !sg.StartScaleUpWithFallback("spot-node"), "finished scale-up must still dedupe within the window")
+
+	sg.scaleUpsMu.Lock()
+	sg.scaleUps["spot-node"] = time.Now().Add(-scaleUpDedupeWindow)
+	sg.scaleUpsMu.Unlock()
+	h.Assert(t, sg.StartScaleUpWithFallback("spot-node"), "scale-up must start again after the window")
+}
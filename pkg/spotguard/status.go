@@ -0,0 +1,246 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// StatusEndpoint is the path the live Spot Guard state is served on, next to the metrics and probes
+const StatusEndpoint = "/spotguard/status"
+
+// maxPreScaleHistory bounds the pre-scale levels kept in the status
+const maxPreScaleHistory = 50
+
+// Node roles reported in the status
+const (
+	NodeRoleOnDemand = "on-demand"
+	NodeRoleSpot     = "spot"
+	NodeRoleUnknown  = "unknown"
+)
+
+// SpotHealthStatus is the last spot ASG check of a Spot Guard loop
+type SpotHealthStatus struct {
+	CheckedAt   time.Time `json:"checkedAt"`
+	Error       string    `json:"error,omitempty"`
+	IsHealthy   bool      `json:"isHealthy"`
+	NodesReady  bool      `json:"nodesReady"`
+	IsStable    bool      `json:"isStable"`
+	InstanceIDs []string  `json:"instanceIDs,omitempty"`
+	// Interruptions are the taints and recent interruption events of the spot nodes
+	Interruptions []string `json:"interruptions,omitempty"`
+	// HealthySince starts the stability timer, StableAt is when it reaches the spot stability duration
+	HealthySince      *time.Time `json:"healthySince,omitempty"`
+	StableAt          *time.Time `json:"stableAt,omitempty"`
+	StabilityDuration string     `json:"stabilityDuration"`
+}
+
+// DrainCheckStatus is the verdict of the last CanSafelyDrainNode call
+type DrainCheckStatus struct {
+	NodeName       string    `json:"nodeName"`
+	CheckedAt      time.Time `json:"checkedAt"`
+	CanDrain       bool      `json:"canDrain"`
+	Reason         string    `json:"reason,omitempty"`
+	BlockReason    string    `json:"blockReason,omitempty"`
+	MaxUtilization float64   `json:"maxUtilization"`
+}
+
+// PreScaleStatus is one pre-scale level reached
+type PreScaleStatus struct {
+	NodeName string    `json:"nodeName"`
+	Time     time.Time `json:"time"`
+	Level    int       `json:"level"`
+	Outcome  string    `json:"outcome"`
+	Message  string    `json:"message"`
+}
+
+// StatusReport is the Spot Guard state as served on StatusEndpoint
+type StatusReport struct {
+	NodeName         string     `json:"nodeName"`
+	NodeRole         string     `json:"nodeRole"`
+	NodeRoleError    string     `json:"nodeRoleError,omitempty"`
+	MonitorStartTime *time.Time `json:"monitorStartTime,omitempty"`
+	// SpotHealth is keyed by spot ASG name, the controller runs one check per ASG pair
+	SpotHealth     map[string]SpotHealthStatus `json:"spotHealth"`
+	LastDrainCheck *DrainCheckStatus           `json:"lastDrainCheck,omitempty"`
+	// PreScaleHistory holds the latest pre-scale levels, oldest first
+	PreScaleHistory []PreScaleStatus `json:"preScaleHistory"`
+	FallbackEvents  []FallbackEvent  `json:"fallbackEvents"`
+}
+
+// Status collects the live Spot Guard state of this replica and serves it as JSON.
+// A nil *Status records nothing, so the Spot Guard loops report to it unconditionally.
+type Status struct {
+	mutex   sync.RWMutex
+	report  StatusReport
+	tracker *FallbackTracker
+}
+
+// NewStatus creates the status of the Spot Guard loops running for nodeName
+func NewStatus(nodeName string) *Status {
+	return &Status{report: StatusReport{
+		NodeName:        nodeName,
+		NodeRole:        NodeRoleUnknown,
+		SpotHealth:      make(map[string]SpotHealthStatus),
+		PreScaleHistory: make([]PreScaleStatus, 0),
+	}}
+}
+
+// SetNodeRole records the role detected by NodeDetector
+func (s *Status) SetNodeRole(isOnDemand bool, err error) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case err != nil:
+		s.report.NodeRole = NodeRoleUnknown
+		s.report.NodeRoleError = err.Error()
+	case isOnDemand:
+		s.report.NodeRole = NodeRoleOnDemand
+		s.report.NodeRoleError = ""
+	default:
+		s.report.NodeRole = NodeRoleSpot
+		s.report.NodeRoleError = ""
+	}
+}
+
+// TrackFallbacks serves the events of a fallback tracker
+func (s *Status) TrackFallbacks(tracker *FallbackTracker) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tracker = tracker
+}
+
+// setMonitorStartTime records when the self-monitor started counting the minimum wait
+func (s *Status) setMonitorStartTime(startTime time.Time) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.report.MonitorStartTime = &startTime
+}
+
+// setSpotHealth records the result of a spot ASG check
+func (s *Status) setSpotHealth(spotASGName string, status *SpotASGHealthStatus, stabilityDuration time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	health := SpotHealthStatus{CheckedAt: time.Now(), StabilityDuration: stabilityDuration.String()}
+	if err != nil {
+		health.Error = err.Error()
+	} else if status != nil {
+		health.IsHealthy = status.IsHealthy
+		health.NodesReady = status.NodesReady
+		health.IsStable = status.IsStable
+		health.InstanceIDs = append([]string{}, status.InstanceIDs...)
+		health.Interruptions = append([]string{}, status.Interruptions...)
+		if status.HealthySince != nil {
+			healthySince, stableAt := *status.HealthySince, status.HealthySince.Add(stabilityDuration)
+			health.HealthySince, health.StableAt = &healthySince, &stableAt
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.report.SpotHealth[spotASGName] = health
+}
+
+// setDrainCheck records the verdict of a CanSafelyDrainNode call
+func (s *Status) setDrainCheck(nodeName string, canDrain bool, reason string, maxUtilization float64) {
+	if s == nil {
+		return
+	}
+	check := &DrainCheckStatus{
+		NodeName:       nodeName,
+		CheckedAt:      time.Now(),
+		CanDrain:       canDrain,
+		Reason:         reason,
+		MaxUtilization: maxUtilization,
+	}
+	if !canDrain {
+		check.BlockReason = drainBlockReason(reason)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.report.LastDrainCheck = check
+}
+
+// addPreScale records a pre-scale level reached, dropping the oldest when full
+func (s *Status) addPreScale(nodeName string, level int, outcome string, message string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.report.PreScaleHistory = append(s.report.PreScaleHistory, PreScaleStatus{
+		NodeName: nodeName,
+		Time:     time.Now(),
+		Level:    level,
+		Outcome:  outcome,
+		Message:  message,
+	})
+	if len(s.report.PreScaleHistory) > maxPreScaleHistory {
+		s.report.PreScaleHistory = s.report.PreScaleHistory[len(s.report.PreScaleHistory)-maxPreScaleHistory:]
+	}
+}
+
+// Report returns a copy of the current state
+func (s *Status) Report() StatusReport {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	report := s.report
+	report.SpotHealth = make(map[string]SpotHealthStatus, len(s.report.SpotHealth))
+	for asgName, health := range s.report.SpotHealth {
+		report.SpotHealth[asgName] = health
+	}
+	report.PreScaleHistory = append([]PreScaleStatus{}, s.report.PreScaleHistory...)
+	report.FallbackEvents = make([]FallbackEvent, 0)
+	if s.tracker != nil {
+		report.FallbackEvents = s.tracker.snapshot()
+	}
+	return report
+}
+
+// ServeHTTP serves the status as JSON
+func (s *Status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := json.Marshal(s.Report())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if _, err := w.Write(body); err != nil {
+		log.Warn().Err(err).Msg("Unable to write Spot Guard status response")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-node-termination-handler/pkg/observability"
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+func TestStatusServesLiveState(t *testing.T) {
+	status := NewStatus("od-1")
+	status.SetNodeRole(true, nil)
+	startTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	status.setMonitorStartTime(startTime)
+
+	healthySince := time.Now().Add(-time.Minute).Truncate(time.Second)
+	status.setSpotHealth("spot", &SpotASGHealthStatus{IsHealthy: true, NodesReady: true, InstanceIDs: []string{"i-1"}, HealthySince: &healthySince}, 5*time.Minute, nil)
+	status.setDrainCheck("od-1", false, ReasonClusterUtilizationTooHigh, 80)
+	for level := 1; level <= maxPreScaleHistory+2; level++ {
+		status.addPreScale("od-1", level, observability.SpotGuardOutcomeFailure, "spot nodes not ready")
+	}
+
+	tracker := NewFallbackTracker()
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{EventID: "second", Timestamp: startTime, OnDemandNodeName: "od-1"}))
+	h.Ok(t, tracker.AddEvent(&FallbackEvent{EventID: "first", Timestamp: startTime.Add(-time.Minute), ScaleDownInitiated: true}))
+	status.TrackFallbacks(tracker)
+
+	recorder := httptest.NewRecorder()
+	status.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, StatusEndpoint, nil))
+	h.Equals(t, http.StatusOK, recorder.Code)
+
+	served := StatusReport{}
+	h.Ok(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+	h.Equals(t, NodeRoleOnDemand, served.NodeRole)
+	h.Assert(t, served.MonitorStartTime.Equal(startTime), "unexpected monitor start time %v", served.MonitorStartTime)
+
+	spot := served.SpotHealth["spot"]
+	h.Assert(t, spot.IsHealthy && spot.NodesReady && !spot.IsStable, "unexpected spot health %+v", spot)
+	h.Assert(t, spot.StableAt.Equal(healthySince.Add(5*time.Minute)), "unexpected stable at %v", spot.StableAt)
+
+	h.Equals(t, "od-1", served.LastDrainCheck.NodeName)
+	h.Equals(t, ScaleDownReasonUtilizationTooHigh, served.LastDrainCheck.BlockReason)
+	h.Equals(t, maxPreScaleHistory, len(served.PreScaleHistory))
+	h.Equals(t, 3, served.PreScaleHistory[0].Level)
+	h.Equals(t, []string{"first", "second"}, []string{served.FallbackEvents[0].EventID, served.FallbackEvents[1].EventID})
+
+	recorder = httptest.NewRecorder()
+	status.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, StatusEndpoint, nil))
+	h.Equals(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestStatusNodeRoleAndNilStatus(t *testing.T) {
+	status := NewStatus("node")
+	status.SetNodeRole(false, errors.New("instance not found"))
+	h.Equals(t, NodeRoleUnknown, status.Report().NodeRole)
+	h.Equals(t, "instance not found", status.Report().NodeRoleError)
+	status.SetNodeRole(false, nil)
+	h.Equals(t, NodeRoleSpot, status.Report().NodeRole)
+	h.Equals(t, "", status.Report().NodeRoleError)
+
+	var disabled *Status
+	disabled.SetNodeRole(true, nil)
+	disabled.setSpotHealth("spot", nil, time.Minute, errors.New("throttled"))
+	disabled.setDrainCheck("od-1", true, "", 80)
+	disabled.addPreScale("od-1", 1, observability.SpotGuardOutcomeSuccess, "")
+}
@@ -0,0 +1,142 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ebsZoneLabel is the zone label the EBS CSI driver pins its volumes to
+const ebsZoneLabel = "topology.ebs.csi.aws.com/zone"
+
+// zoneLabels are the node labels a zonal volume's node affinity refers to
+var zoneLabels = map[string]bool{
+	corev1.LabelTopologyZone:          true,
+	corev1.LabelFailureDomainBetaZone: true,
+	ebsZoneLabel:                      true,
+}
+
+// volumeTopology is a snapshot of the PersistentVolumeClaims of the drained pods and their PersistentVolumes,
+// used to find the volumes that pin a pod to the nodes of one zone
+type volumeTopology struct {
+	claims  map[string]*corev1.PersistentVolumeClaim // by namespace/name
+	volumes map[string]*corev1.PersistentVolume
+}
+
+// newVolumeTopology indexes the claims and volumes
+func newVolumeTopology(claims []corev1.PersistentVolumeClaim, volumes []corev1.PersistentVolume) *volumeTopology {
+	vt := &volumeTopology{
+		claims:  make(map[string]*corev1.PersistentVolumeClaim, len(claims)),
+		volumes: make(map[string]*corev1.PersistentVolume, len(volumes)),
+	}
+	for i := range claims {
+		vt.claims[claims[i].Namespace+"/"+claims[i].Name] = &claims[i]
+	}
+	for i := range volumes {
+		vt.volumes[volumes[i].Name] = &volumes[i]
+	}
+	return vt
+}
+
+// pinnedVolumes returns the bound volumes of the pod with a required node affinity.
+// Unbound claims are left out, the scheduler provisions them next to wherever the pod lands.
+func (vt *volumeTopology) pinnedVolumes(pod *corev1.Pod) []*corev1.PersistentVolume {
+	if vt == nil {
+		return nil
+	}
+	pinned := make([]*corev1.PersistentVolume, 0)
+	for _, claimName := range podClaimNames(pod) {
+		claim, ok := vt.claims[pod.Namespace+"/"+claimName]
+		if !ok || claim.Spec.VolumeName == "" {
+			continue
+		}
+		pv, ok := vt.volumes[claim.Spec.VolumeName]
+		if !ok || pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+			continue
+		}
+		pinned = append(pinned, pv)
+	}
+	return pinned
+}
+
+// podClaimNames returns the names of the PersistentVolumeClaims the pod mounts
+func podClaimNames(pod *corev1.Pod) []string {
+	names := make([]string, 0)
+	for _, volume := range pod.Spec.Volumes {
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			names = append(names, volume.PersistentVolumeClaim.ClaimName)
+		case volume.Ephemeral != nil:
+			// Generic ephemeral volumes get a claim named after the pod and the volume
+			names = append(names, pod.Name+"-"+volume.Name)
+		}
+	}
+	return names
+}
+
+// localVolume returns the first of the volumes that lives on a single node: a local volume, or one
+// whose node affinity names a hostname. nil when none does.
+func localVolume(volumes []*corev1.PersistentVolume) *corev1.PersistentVolume {
+	for _, pv := range volumes {
+		if pv.Spec.Local != nil {
+			return pv
+		}
+		for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+			for _, requirement := range term.MatchExpressions {
+				if requirement.Key == corev1.LabelHostname {
+					return pv
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// volumeNodeAffinityMatches checks the required node affinity of a volume, whose terms are ORed
+func volumeNodeAffinityMatches(pv *corev1.PersistentVolume, node *corev1.Node) bool {
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		if nodeSelectorTermMatches(term, node) {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeZones returns the zones a volume's node affinity allows, empty when it is not zonal
+func volumeZones(pv *corev1.PersistentVolume) []string {
+	zones := make([]string, 0)
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, requirement := range term.MatchExpressions {
+			if zoneLabels[requirement.Key] && requirement.Operator == corev1.NodeSelectorOpIn {
+				for _, zone := range requirement.Values {
+					if !containsString(zones, zone) {
+						zones = append(zones, zone)
+					}
+				}
+			}
+		}
+	}
+	return zones
+}
+
+// describePinnedVolume names a volume and where it lives for drain block reasons
+func describePinnedVolume(pv *corev1.PersistentVolume) string {
+	if zones := volumeZones(pv); len(zones) > 0 {
+		return fmt.Sprintf("%s in %s", pv.Name, strings.Join(zones, ","))
+	}
+	return pv.Name
+}
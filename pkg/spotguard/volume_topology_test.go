@@ -0,0 +1,135 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package spotguard
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	h "github.com/aws/aws-node-termination-handler/pkg/test"
+)
+
+// zonalVolume returns a claim bound to an EBS volume pinned to zone
+func zonalVolume(claimName string, zone string) (corev1.PersistentVolumeClaim, corev1.PersistentVolume) {
+	claim := corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: claimName, Namespace: "default"},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-" + claimName},
+	}
+	pv := corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-" + claimName},
+		Spec: corev1.PersistentVolumeSpec{NodeAffinity: &corev1.VolumeNodeAffinity{Required: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+				{Key: ebsZoneLabel, Operator: corev1.NodeSelectorOpIn, Values: []string{zone}},
+			}}},
+		}}},
+	}
+	return claim, pv
+}
+
+// withClaim mounts a claim in the pod
+func withClaim(pod corev1.Pod, claimName string) corev1.Pod {
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         "data",
+		VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName}},
+	})
+	return pod
+}
+
+// zonalNode returns a ready node in zone with the EBS zone label
+func zonalNode(name string, instanceID string, zone string) corev1.Node {
+	node := simNode(name, zone, "4", "8Gi")
+	node.Labels[ebsZoneLabel] = zone
+	node.Spec.ProviderID = "aws:///" + zone + "/" + instanceID
+	return node
+}
+
+func TestPlacementKeepsPodsNextToZonalVolumes(t *testing.T) {
+	claim, pv := zonalVolume("data-db-0", "us-east-1a")
+	nodes := []corev1.Node{
+		zonalNode("od-1", "i-od1", "us-east-1a"),
+		zonalNode("od-2", "i-od2", "us-east-1a"),
+		zonalNode("spot-b", "i-spotb", "us-east-1b"),
+	}
+	sim := newPlacementSimulator(nodes, nil, "od-1")
+	sim.volumes = newVolumeTopology([]corev1.PersistentVolumeClaim{claim}, []corev1.PersistentVolume{pv})
+	sim.spotInstances = map[string]bool{"i-spotb": true}
+
+	pod := withClaim(simPod("db-0", "od-1", "100m", nil), "data-db-0")
+	target, reason := sim.place(&pod)
+	h.Equals(t, "", target)
+	h.Assert(t, strings.Contains(reason, "1 "+reasonVolumeNodeAffinity), reason)
+	h.Assert(t, strings.Contains(reason, "1 "+reasonNotSpotNode), reason)
+
+	// Pods without volumes still move to the other zone
+	stateless := simPod("web-0", "od-1", "100m", nil)
+	target, _ = sim.place(&stateless)
+	h.Assert(t, target != "", "stateless pod must be placed")
+
+	// Without a known spot pool the other on-demand node in the zone is enough
+	sim.spotInstances = nil
+	target, _ = sim.place(&pod)
+	h.Equals(t, "od-2", target)
+}
+
+func TestCanSafelyDrainNodeWaitsForSpotNodeInVolumeZone(t *testing.T) {
+	ctx := context.Background()
+	claim, pv := zonalVolume("data-db-0", "us-east-1a")
+	drained := zonalNode("od-1", "i-od1", "us-east-1a")
+	spotB := zonalNode("spot-b", "i-spotb", "us-east-1b")
+	pod := withClaim(readyPod("db-0", "od-1", "db", true), "data-db-0")
+
+	clientset := fake.NewSimpleClientset(&drained, &spotB, &pod, &claim, &pv)
+	checker := NewSafetyChecker(clientset, 100)
+
+	canDrain, reason := checker.CanSafelyDrainNode(ctx, "od-1", map[string]bool{"i-spotb": true})
+	h.Assert(t, !canDrain, "pod with a volume in us-east-1a must keep the node while no spot node runs there")
+	h.Equals(t, ScaleDownReasonZonalVolume, drainBlockReason(reason))
+	h.Assert(t, strings.Contains(reason, "pv-data-db-0 in us-east-1a"), reason)
+
+	spotA := zonalNode("spot-a", "i-spota", "us-east-1a")
+	_, err := clientset.CoreV1().Nodes().Create(ctx, &spotA, metav1.CreateOptions{})
+	h.Ok(t, err)
+
+	canDrain, reason = checker.CanSafelyDrainNode(ctx, "od-1", map[string]bool{"i-spota": true, "i-spotb": true})
+	h.Assert(t, canDrain, "unexpected block: %s", reason)
+
+	// Only the claims of the drained pods are read, never every claim and volume of the cluster
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "persistentvolumeclaims" || action.GetResource().Resource == "persistentvolumes" {
+			h.Equals(t, "get", action.GetVerb())
+		}
+	}
+}
+
+func TestCanSafelyDrainNodeBlocksLocalVolumes(t *testing.T) {
+	claim, pv := zonalVolume("data-db-0", "us-east-1a")
+	pv.Spec.Local = &corev1.LocalVolumeSource{Path: "/mnt/disks/ssd0"}
+	pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions = []corev1.NodeSelectorRequirement{
+		{Key: corev1.LabelHostname, Operator: corev1.NodeSelectorOpIn, Values: []string{"od-1"}},
+	}
+	drained := zonalNode("od-1", "i-od1", "us-east-1a")
+	spotA := zonalNode("spot-a", "i-spota", "us-east-1a")
+	pod := withClaim(readyPod("db-0", "od-1", "db", true), "data-db-0")
+
+	checker := NewSafetyChecker(fake.NewSimpleClientset(&drained, &spotA, &pod, &claim, &pv), 100)
+	canDrain, reason := checker.CanSafelyDrainNode(context.Background(), "od-1", map[string]bool{"i-spota": true})
+	h.Assert(t, !canDrain, "pod with a local volume must keep the node")
+	h.Equals(t, ScaleDownReasonLocalVolume, drainBlockReason(reason))
+	h.Assert(t, strings.Contains(reason, "local volume pv-data-db-0"), reason)
+}